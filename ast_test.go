@@ -6,6 +6,7 @@ import (
 	"testing"
 
 	"github.com/chtisgit/go-flows/flows"
+	"github.com/chtisgit/go-flows/spec"
 	_ "github.com/chtisgit/go-flows/modules/features/nta"
 	ipfix "github.com/CN-TU/go-ipfix"
 )
@@ -146,6 +147,26 @@ func TestAST(t *testing.T) {
 			},
 			1,
 		},
+		{
+			[]interface{}{
+				[]interface{}{"__testResolve", "sourceIPAddress"},
+			},
+			1,
+		},
+		{
+			[]interface{}{
+				[]interface{}{"regex_match", "__NTAFlowID", spec.String("GET")},
+				[]interface{}{"cast", spec.String("string"), "__NTAPorts"},
+			},
+			-1,
+		},
+		{
+			[]interface{}{
+				[]interface{}{"regex_match", "__NTAFlowID", spec.String("GET")},
+				[]interface{}{"cast", "string", "__NTAPorts"},
+			},
+			2,
+		},
 		{
 			[]interface{}{
 				"sourceIPAddress",
@@ -156,6 +177,12 @@ func TestAST(t *testing.T) {
 			},
 			-1,
 		},
+		{
+			[]interface{}{
+				[]interface{}{"apply", []interface{}{"max", "ipTotalLength"}, []interface{}{"select", []interface{}{"or", []interface{}{"less", "ipTotalLength", 80}, []interface{}{"greater", "ipTotalLength", 1400}}}},
+			},
+			-1,
+		},
 	} {
 		rl := flows.RecordListMaker{}
 		err := rl.AppendRecord(test.def, nil, nil, &flows.ExportPipeline{}, testing.Verbose())
@@ -174,3 +201,61 @@ func TestAST(t *testing.T) {
 		}
 	}
 }
+
+func TestCheckFeatures(t *testing.T) {
+	_, errs := flows.CheckFeatures([]interface{}{
+		"__nonexistent__",
+		"sourceIPAddress",
+		[]interface{}{"mean", "__nonexistent__"},
+		"mean",
+	}, nil, []string{"__nonexistent__"})
+	var ids []int
+	for _, err := range errs {
+		if e, ok := err.(flows.FeatureError); ok {
+			ids = append(ids, e.ID())
+		} else {
+			ids = append(ids, 0)
+		}
+	}
+	if fmt.Sprint(ids) != "[1 3 4 0]" {
+		t.Errorf("Expected errors in features 1, 3, 4, and the filter, but got %v", errs)
+	}
+
+	if _, errs := flows.CheckFeatures([]interface{}{"sourceIPAddress", "sourceIPAddress"}, nil, nil); len(errs) != 1 {
+		t.Errorf("Expected one error for duplicate export, but got %v", errs)
+	}
+
+	columns, errs := flows.CheckFeatures([]interface{}{
+		"sourceIPAddress",
+		[]interface{}{"mean", "octetTotalCount"},
+	}, nil, nil)
+	if errs != nil {
+		t.Fatal(errs)
+	}
+	if len(columns) != 2 {
+		t.Fatalf("Expected 2 columns, but got %d", len(columns))
+	}
+	if columns[0].Name != "sourceIPAddress" || len(columns[0].Types) != 2 || columns[0].Variants == "" {
+		t.Errorf("Unexpected column %+v", columns[0])
+	}
+	if columns[1].Name != "mean(octetTotalCount)" || len(columns[1].Types) != 1 ||
+		columns[1].Types[0].Type != ipfix.Float64Type || columns[1].FeatureType != flows.FlowFeature || columns[1].Variants != "" {
+		t.Errorf("Unexpected column %+v", columns[1])
+	}
+
+	// constants keep their type in the name, and resolvers get their values
+	columns, errs = flows.CheckFeatures([]interface{}{
+		[]interface{}{"if", true, 1.0, int64(2)},
+		[]interface{}{"if", true, int64(1), int64(2)},
+		[]interface{}{"cast", spec.String("unsigned16"), 1.0},
+	}, nil, nil)
+	if errs != nil {
+		t.Fatal(errs)
+	}
+	if columns[0].Name != "if(true,1.0,2)" || columns[1].Name != "if(true,1,2)" {
+		t.Errorf("Unexpected column names %s and %s", columns[0].Name, columns[1].Name)
+	}
+	if columns[2].Types[0].Type != ipfix.Unsigned16Type {
+		t.Errorf("Expected cast to unsigned16, but got %s", columns[2].Types[0].Type)
+	}
+}
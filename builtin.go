@@ -4,8 +4,10 @@ import (
 	_ "github.com/chtisgit/go-flows/modules/exporters/csv"
 	_ "github.com/chtisgit/go-flows/modules/exporters/ipfix"
 	_ "github.com/chtisgit/go-flows/modules/exporters/null"
+	_ "github.com/chtisgit/go-flows/modules/exporters/pcap"
 	_ "github.com/chtisgit/go-flows/modules/exporters/sql"
 	_ "github.com/chtisgit/go-flows/modules/features/custom"
+	_ "github.com/chtisgit/go-flows/modules/features/hosts"
 	_ "github.com/chtisgit/go-flows/modules/features/iana"
 	_ "github.com/chtisgit/go-flows/modules/features/nta"
 	_ "github.com/chtisgit/go-flows/modules/features/operations"
@@ -14,5 +16,7 @@ import (
 	_ "github.com/chtisgit/go-flows/modules/keys/header"
 	_ "github.com/chtisgit/go-flows/modules/keys/time"
 	_ "github.com/chtisgit/go-flows/modules/labels/csv"
+	_ "github.com/chtisgit/go-flows/modules/labels/ids"
+	_ "github.com/chtisgit/go-flows/modules/labels/rules"
 	_ "github.com/chtisgit/go-flows/modules/sources/libpcap"
 )
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/chtisgit/go-flows/flows"
+	"github.com/chtisgit/go-flows/packet"
+	"github.com/chtisgit/go-flows/spec"
+)
+
+func init() {
+	addCommand("check", "Check flow specifications and print the resulting columns", checkSpecs)
+}
+
+func checkSpecs(cmd string, args []string) {
+	set := flag.NewFlagSet("check", flag.ExitOnError)
+	set.Usage = func() {
+		cmdString(fmt.Sprintf("%s [args] spec.json [spec.json ...]", cmd))
+		fmt.Fprint(os.Stderr, `
+Checks the given flow specifications without reading any input. All the
+errors found in a specification are reported. For valid specifications,
+every exported column is listed with its ipfix type, the feature type, and
+the variants the type depends on.
+
+Args:
+`)
+		set.PrintDefaults()
+	}
+	v2 := set.Bool("v2", false, "Force v2 format")
+	simple := set.Bool("simple", false, "Treat files as if they only contain the flow specification")
+	selection := set.Int("select", -1, "Only check nth flow selection (default: check all)")
+	passiveDNS := set.Bool("passiveDNS", false, "Check for runs with -passiveDNS (needed for sourceHostnameFromDNS and destinationHostnameFromDNS)")
+	set.Parse(args)
+	packet.SetPassiveDNSEnabled(*passiveDNS)
+	if *v2 && *simple {
+		log.Fatalf("Only one of -v2, or -simple can be chosen\n")
+	}
+	if set.NArg() == 0 {
+		set.Usage()
+		os.Exit(-1)
+	}
+
+	format := spec.FormatAuto
+	switch {
+	case *v2:
+		format = spec.FormatV2
+	case *simple:
+		format = spec.FormatSimple
+	}
+
+	ok := true
+	for _, name := range set.Args() {
+		if !checkFile(name, format, *selection) {
+			ok = false
+		}
+	}
+	if !ok {
+		os.Exit(1)
+	}
+}
+
+func checkFile(name string, format spec.Format, selection int) bool {
+	file, warnings, err := spec.Load(name, format)
+	for _, warning := range warnings {
+		fmt.Printf("warning: %s\n", warning)
+	}
+	if err != nil {
+		if list, ok := err.(spec.ErrorList); ok {
+			for _, e := range list {
+				fmt.Println(e)
+			}
+		} else {
+			fmt.Printf("%s: %s\n", name, err)
+		}
+		return false
+	}
+
+	ok := true
+	for i := range file.Flows {
+		if selection >= 0 && i != selection {
+			continue
+		}
+		if !checkFlow(fmt.Sprintf("%s (%d)", name, i), &file.Flows[i]) {
+			ok = false
+		}
+	}
+	if selection >= len(file.Flows) {
+		fmt.Printf("%s: only %d flows in the file\n", name, len(file.Flows))
+		ok = false
+	}
+	return ok
+}
+
+func checkFlow(name string, flow *spec.Flow) bool {
+	var errs []error
+	if _, err := packet.NewDynamicKeySelector(flow.KeyFeatures, flow.Bidirectional, flow.AllowZero); err != nil {
+		errs = append(errs, fmt.Errorf("key_features: %s", err))
+	}
+	features, control, filter, err := flow.Expand()
+	if err != nil {
+		errs = append(errs, err)
+	}
+	var columns []flows.Column
+	if err == nil {
+		var featureErrs []error
+		columns, featureErrs = flows.CheckFeatures(features, control, filter)
+		errs = append(errs, featureErrs...)
+	}
+	if len(errs) > 0 {
+		for _, err := range errs {
+			fmt.Printf("%s: %s\n", name, err)
+		}
+		fmt.Printf("%s: %d errors\n", name, len(errs))
+		return false
+	}
+
+	fmt.Printf("%s: OK\n", name)
+	t := tabwriter.NewWriter(os.Stdout, 0, 1, 2, ' ', 0)
+	fmt.Fprintln(t, "  #\tName\tType\tIE\tFeatureType\tVariants")
+	for _, column := range columns {
+		types := make([]string, len(column.Types))
+		ies := make([]string, len(column.Types))
+		for i, ie := range column.Types {
+			types[i] = ie.Type.String()
+			ies[i] = fmt.Sprintf("%d/%d", ie.Pen, ie.ID)
+		}
+		variants := column.Variants
+		if variants == "" {
+			variants = "-"
+		}
+		fmt.Fprintf(t, "  %d\t%s\t%s\t%s\t%s\t%s\n", column.ID, column.Name, strings.Join(types, "|"), strings.Join(ies, "|"), column.FeatureType, variants)
+	}
+	t.Flush()
+	return true
+}
@@ -6,6 +6,12 @@ Building
 For building either "go build", "go install", or the program provided in go-flows-build can be used.
 The latter allows for customizing builtin modules and can help with building modules as plugins or combined binaries.
 
+Plugins (go-flows*.defs.so) are loaded with "-defs directory". go-flows-build adds a manifest (GoFlowsManifest, see
+util.PluginManifest) with the plugin API version, the contained modules, and build information. Before loading a
+plugin, its Go version and module versions are compared to the ones of go-flows, and every mismatch is reported.
+Plugins must be built with the same Go version and from the same sources as go-flows. The list commands (e.g.
+"go-flows features" or "go-flows exporters") show which plugin provided an entry.
+
 Overview
 
 This flow exporter can convert network packets into flows, extract features, and export those via
@@ -33,7 +39,23 @@ For examples look at modules/filters.
 parse is a fixed step that parses the packet with gopacket.
 
 label is an optional step, that can provide an arbitrary label for every packet. For examples look at
-modules/labels.
+modules/labels. The rules label source assigns ground-truth labels from rules on addresses, ports, protocol, and
+time, which keeps working if the capture gets filtered or merged. The per-packet labels (__label) can be aggregated
+per flow with flowLabel (e.g. {"flowLabel": ["__label", "'majority'"]}). Several label dimensions (e.g. attack class
+and device type) can be used at once with named labels (label -name attack ...), which are all evaluated for every
+packet and accessed with __label("attack"). The ids label source labels packets with the alerts of Suricata (EVE
+json) or zeek (notice logs) matched by Community ID or 5-tuple and time.
+
+With -passiveDNS, the answers of DNS responses are remembered for their TTL (plus -passiveDNSGrace) before the packets
+are distributed over the flow tables. This allows features like destinationHostnameFromDNS to return the name a client
+looked up before connecting to an address. Without -passiveDNS, specifications using these features are rejected.
+
+With -hostWindow, aggregates of every host over all flows are computed in the same step for a sliding window (split
+into -hostSlots slots; 1 slot results in tumbling windows), e.g. the number of distinct destination ports a source
+contacted in the last 60 seconds. Hosts are identified by -hostKey (address, mac, and/or vlan) with addresses
+truncated to -hostPrefix4 and -hostPrefix6. Distinct counts are estimated with HyperLogLog. The features of the hosts
+module (e.g. sourceHostDestinationPorts, destinationHostSources, sourceHostConnectionRate) return the aggregates of
+the endpoints at flow start or with the suffix AtEnd at the last packet of the flow.
 
 key is a fixed step that calculates the flow key. Key parameters can be configured via the specification.
 
@@ -43,17 +65,40 @@ merge merges the output from every table into one record stream.
 
 The feature step calculates the actual feature values. Features can be provided via modules, and the
 selection of which features to calculate must be provided via the specification. Features are described
-in more detail in the flows package.
+in more detail in the flows package. The geoip features (e.g. sourceCountryCode, destinationASN) need MaxMind DB
+files, which are loaded with -geoip (e.g. -geoip GeoLite2-Country.mmdb -geoip GeoLite2-ASN.mmdb).
+
+Addresses can be anonymized prefix-preserving (Crypto-PAn) with the key given by -anonymizeKey: either selected
+features with anonymize (e.g. anonymize(sourceIPAddress)), or every IP and MAC address in every exporter with the
+features option -anonymize (and ports with -anonymizePorts). The same key always results in the same mapping.
 
 If a flow ends (e.g. because of timeout, or tcp-rst) it gets exported via the exported, which must
 be provided as a module and configured via the command line. For examples look at modules/exporters.
+The pcap exporter writes the packets of the exported flows (collected by the feature __flowPackets) to pcap or
+pcapng files, e.g. only the flows selected by a condition in _control_features. The packets are anonymized by the
+-anonymize option of the features command (like the packets written by -capture) or by the exporter option
+-anonymize.
+The features option -capture file.pcapng writes the packets of a flow to a pcapng file as soon as a packet passes the
+filters and the control expressions are true (e.g. "running_sum(ipTotalLength) > 100000" in _control_features),
+including the last packets before, which are kept in a ring buffer per flow (-capturePackets, -captureBytes). The
+memory of all ring buffers is limited by -captureMemory.
+
+Exported flows can be aggregated again in the same run (e.g. host profiles per hour): with -from n, a features
+group processes the records exported by the nth group (counting from 0) instead of packets. Key features, timeouts,
+and features of its specification then apply to these records, whose fields are accessed with field (e.g.
+"sum(field('octetTotalCount'))" with key_features ["sourceIPAddress"]); a feature name alone refers to the field of
+the first record. Features reading packets are rejected. Records without every key field are dropped, and
+bidirectional flows, filters, checkpoints, and exporters shared with other groups aren't supported.
 
 
 The whole pipeline is executed concurrently with the following four subpipelines running concurrently:
 
 	source -> filter
 
-	parse -> label -> key
+	m times:
+		parse -> key
+
+	label
 
 	n times:
 		table -> flow -> record -> feature
@@ -63,12 +108,32 @@ The whole pipeline is executed concurrently with the following four subpipelines
 
 	export
 
-The "table"-pipeline exists n times, where n can be configured on the command line. Packets are divided
-onto the different "table"-pipelines according to flow-key.
+The "parse"-pipeline exists m times, and the "table"-pipeline exists n times, where both can be configured on
+the command line (-decoders and -n). Batches of packets are handed out to the "parse"-pipelines round robin and
+collected in the same order, so labels and tables still see the packets in the original order.
+Packets are divided onto the different "table"-pipelines according to flow-key.
 
 WARNING: Due to this concurrent processing flow output is neither order nor deterministic (without sorting)!
 To ensure deterministic output, flow output can be order by start time, stop time (default), or export time.
 
+Checkpoints
+
+With -checkpoint file, go-flows writes the state of every active flow (feature state, timers, flow ids) and the
+position of the sampler to the given file upon SIGTERM instead of exporting those flows. With -checkpointInterval the
+checkpoint is additionally written periodically (in packet time). A later run with -resume file continues these flows, e.g. for the next pcap
+of a rotated capture. The flow specification and the number of tables (-n) must be the same for both runs.
+Every feature in the specification must support checkpoints; unsupported features are reported on startup.
+Flows that were exported, but not yet written by the exporters, are not part of the checkpoint.
+
+Sampling
+
+With -sampling mode:N[:seed] only a sample of the packets that passed the filters is processed. "count" processes
+every Nth packet, "random" every packet with a probability of 1/N, and "flow" every packet of 1/N of the flows,
+where flows are selected with a hash of the flow key. Counters are not scaled automatically; use the scale_sampling
+operation, e.g. ["scale_sampling", "octetTotalCount"], to multiply a value with N. Exporters that support it
+(currently ipfix) write the sampling parameters (RFC 5477) as options record with the time of the first packet before
+the flow records; for every other exporter, a warning is shown.
+
 Specification
 
 Specification files are JSON files based on the NTARC format (https://nta-meta-analysis.readthedocs.io/en/latest/).
@@ -87,7 +152,8 @@ Simpleformat specification:
 		"_filter_features": [...],
 		"_per_packet": <bool>,
 		"_allow_zero": <bool>,
-		"_expire_TCP": <bool>
+		"_expire_TCP": <bool>,
+		"_tcp_linger": <Number>
 	}
 
 V2-formated file:
@@ -112,13 +178,70 @@ Only single pass operations can ever be supported due to design restrictions in
 In addition to the features specified in the nta-meta-analysis, two addional types of features are present:
 Filter features which can exclude packets from a whole flow, and control features which can change flow
 behaviour like exporting the flow before the end, restarting the flow, or discarding the flow.
+Both lists can also contain boolean expressions: A filter expression (e.g. "in_subnet(sourceIPAddress, 10.0.0.0/8)")
+is evaluated for every packet as if the packet were a flow of its own, and only matching packets are passed to the
+flow. A control expression (e.g. "startswith(__httpRequestHost, 'www.')") is evaluated over the whole flow, and the
+flow is only exported if it is true.
 _per_packet allows exporting one flow per packet. If _allow_zero is true, then packets are accepted, where
 one of the parts of the flow key would be zero (e.g. non-IP packets for flow keys that contain IP-Addresses).
 If _expire_TCP is set to false, no TCP-based expiry is carried out (e.g. RST packets). TCP expiry is
-only carried out if at least the five-tuple is part of the flow key.
+only carried out if at least the five-tuple is part of the flow key. With TCP expiry, the state of every TCP
+connection is tracked (handshake, established, half-closed, closed). A connection ends with a RST or after both
+FINs were acknowledged; in the latter case it is kept for _tcp_linger seconds (default 0) to catch the last
+retransmissions, like TIME_WAIT. A new SYN during that time starts a new flow. The source of a tracked flow is
+the client, even if the first packet seen is the SYN+ACK of the server. The state is available to
+features (e.g. _tcpConnectionState, _tcpHistory).
+
+Instead of nested calls, features can also be written as expressions, e.g.
+"(octetTotalCount + 1) / flowDurationMilliseconds" instead of
+{"divide": [{"add": ["octetTotalCount", 1]}, "flowDurationMilliseconds"]}. Expressions support calls
+(mean(ipTotalLength)), numbers, strings ('text'), IP addresses and networks (10.0.0.0/8), lists of constants
+([6, 17]), and the operators || && == <= >= < > + - * / with the usual precedence. Operators map to the operations
+or, and, equal, leq, geq, less, greater, add, subtract, multiply, and divide. Plain feature names stay plain strings.
+Strings don't need quotes in the arguments of JSON calls, if they could never be a feature: {"regex_match": ["x",
+"GET /"]} is the same as "regex_match(x, 'GET /')". Names (e.g. "majority") are always features, and strings with
+parentheses or quotes are expressions, so such constants must be quoted (e.g. {"flowLabel": ["__label",
+"'majority'"]}).
+equal also compares IP addresses and strings; in_subnet(addr, 10.0.0.0/8), in_set(x, [80, 443]),
+startswith(x, 'prefix'), and regex_match(x, 'pattern') test addresses and strings.
+if(condition, a, b), coalesce(a, b, ...), default(x, value), and is_null(x) handle missing values (e.g.
+tcpSequenceNumber of a UDP flow) and convert the chosen value to the common type of the values (e.g. signed64 for
+default(tcpSequenceNumber, 0)); cast('float64', x) converts to the given type.
+bitand, bitor, bitxor, shl, shr, and popcount work on integers (e.g. "shr(ipClassOfService, 2)" is the DSCP).
+byte_at(x, 2), bytes_slice(x, 0, 4), hex(x), u16be(x, 0), and u32be(x, 0) read octet arrays like _payload; they
+return nothing if x is too short.
+
+"feature as name" ({"as": [feature, "name"]}) exports a feature under a different name. Repeated subexpressions can
+be named in "definitions" (e.g. "definitions": {"bytesPerPacket": "octetTotalCount / packetTotalCount"}) and used
+like features; a definition used directly in the feature list is exported with the definition name. "include" lists
+files (relative to the including file) with shared definitions and features. Included files may only contain
+features, _control_features, _filter_features, definitions, and include; their features come first, and local
+definitions override included ones. See examples/definitions.json.
+
+Specification files are parsed by the spec package. All the errors in a file are reported with line, column, and
+JSON path (e.g. "spec.json:12:9: features[3]: only one key allowed in calls (got 2)"). Unknown keys in a flow
+specification (e.g. misspelled options like _expire_tcp) result in a warning; their values are still available to
+features.
 
 A list of supported features can be queried with "./go-flows features"
 
+Scripted features
+
+Features can also be written in Starlark (https://github.com/google/starlark-go) and loaded without rebuilding
+with "-script file.star" (can be given multiple times). A script defines a feature named after the file with its
+ipfix type (type = "float64"), and start(state), event(state, packet), and stop(state) callbacks. state holds the per
+flow state; packet provides read-only access to the decoded packet (time, forward, length, proto, source_ip,
+source_port, tcp_flags, payload, ...). Scripts can also take packet feature arguments or emit values per packet.
+See modules/features/script for the details and examples/payloadPrintable.star for an example.
+
+Scripted features are meant for prototyping: Every callback runs in an interpreter and allocates, which makes them
+much slower (often by one or two orders of magnitude) than features written in Go. Features that prove useful should
+be ported to Go.
+
+A specification can be checked without reading any input with "./go-flows check spec.json". This reports all the
+errors in the specification, or lists every exported column with its ipfix type, feature type, and the variants the
+type depends on.
+
 Example usage
 
 The examples directory contains several example flow specifications that can be used. The general
@@ -163,6 +286,7 @@ Less commonly used functions
 
   * Variant() gets called to determine which variant to use, if a feature can different types depending on data (see sourceIPAddress for an example)
   * SetArguments(args []int, all []Feature) gets called during instantiation if a feature expects constant arguments (args contains indizes of all; see select_slice for an example)
+  * CheckpointState() []interface{} must return pointers to every field holding per flow state (e.g. counters), if the feature holds more than its value. This is needed for checkpoints (see packetTotalCount for an example)
 
 See also documentation of subpackage flows for more details about which base to choose.
 
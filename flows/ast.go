@@ -4,9 +4,11 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"net"
 	"strings"
 
 	ipfix "github.com/CN-TU/go-ipfix"
+	"github.com/chtisgit/go-flows/spec"
 )
 
 // FeatureError gets returned from the feature parser and specifies the error message and includes information about feature number
@@ -30,7 +32,7 @@ AST is built using following steps:
 1. parsing
 	this splits the feature specification into fragments:
 	- astCall: used for all features (features without input get an astRawPacket as input)
-	- astConstant: for numbers
+	- astConstant: for constants (numbers, booleans, strings, ip addresses, networks, and lists)
 2. composite expansion
 	replaces astCalls that are found in the composite table with the expanded version
 3. building
@@ -39,7 +41,7 @@ AST is built using following steps:
 	if multiple variants are possible, every variant is tried out (not possible ones are pruned as soon as the failure is encountered)
 	   until one that fulfills return types is found
 4. expand select
-	select astCall get additional astRawPacket as last argument
+	select astCall and input functions get additional astRawPacket as last argument
 5. lower map/apply
 	astRawPacket in the first argumet of map/apply is replace with second argument
 	map(X(RawPacket), selection(..., RawPacket)) -> X(selection(..., RawPacket))
@@ -85,6 +87,8 @@ func makeASTFragment(feature interface{}, input FeatureType, id int) (astFragmen
 		return f, nil
 	case string:
 		return makeASTFeature(f, input, id)
+	case spec.Alias:
+		return nil, fmt.Errorf("alias %s is only allowed for exported features", f.Name)
 	case []interface{}:
 		name, ok := f[0].(string)
 		if !ok {
@@ -96,6 +100,25 @@ func makeASTFragment(feature interface{}, input FeatureType, id int) (astFragmen
 	}
 }
 
+// makeExportedASTFragment creates an exported fragment for an element of the feature list
+func makeExportedASTFragment(feature interface{}, input FeatureType, id int) (astFragment, error) {
+	alias, ok := feature.(spec.Alias)
+	if !ok {
+		fragment, err := makeASTFragment(feature, input, id)
+		if err != nil {
+			return nil, err
+		}
+		fragment.SetExport(fragment.MakeExportName())
+		return fragment, nil
+	}
+	fragment, err := makeASTFragment(alias.Feature, input, id)
+	if err != nil {
+		return nil, err
+	}
+	fragment.SetExport(alias.Name)
+	return fragment, nil
+}
+
 type astBase struct {
 	id         int
 	name       string
@@ -387,10 +410,13 @@ func (a *astConstant) Data() interface{} {
 }
 
 func (a *astConstant) String() string {
-	return fmt.Sprintf("<Const>%v", a.value)
+	return fmt.Sprintf("<Const>%s", a.name)
 }
 
 func (a *astConstant) build(ret FeatureType) (err error) {
+	if ret == RawPacket || ret == RawFlow {
+		return errInput
+	}
 	a.feature, err = newConstantMetaFeature(a.value)
 	return
 }
@@ -414,14 +440,14 @@ func (a *astConstant) Copy() astFragment {
 
 func makeASTConstant(value interface{}, id int) (astFragment, error) {
 	switch value.(type) {
-	case bool, float64, int64, uint64, int:
+	case bool, float64, int64, uint64, int, spec.String, net.IP, *net.IPNet, spec.List:
 	default:
 		return nil, fmt.Errorf("can't use type %T of value '%s' as feature", value, value)
 	}
 	return &astConstant{
 		astBase: astBase{
 			id:   id,
-			name: fmt.Sprint(value),
+			name: constantName(value),
 		},
 		value: value,
 	}, nil
@@ -430,6 +456,7 @@ func makeASTConstant(value interface{}, id int) (astFragment, error) {
 type astCall struct {
 	astBase
 	args []astFragment
+	bare bool // feature name without arguments in the specification
 }
 
 func (a *astCall) Arguments() []astFragment {
@@ -440,6 +467,7 @@ func (a *astCall) Copy() astFragment {
 	r := &astCall{
 		astBase: a.astBase,
 		args:    make([]astFragment, len(a.args)),
+		bare:    a.bare,
 	}
 	for i := range r.args {
 		r.args[i] = a.args[i].Copy()
@@ -495,18 +523,18 @@ func (a *astCall) build(ret FeatureType) error {
 CANDIDATES:
 	for _, candidate := range candidates {
 		argtypes := make([]FeatureType, len(a.args))
-		for i := range candidate.arguments {
-			argtypes[i] = candidate.arguments[i]
+		n := len(candidate.arguments)
+		variadic := candidate.arguments[n-1] == Ellipsis
+		for i := range argtypes {
+			if variadic && i >= n-2 {
+				argtypes[i] = candidate.arguments[n-2]
+			} else {
+				argtypes[i] = candidate.arguments[i]
+			}
 			if argtypes[i] == MatchType {
 				argtypes[i] = ret
 			}
 		}
-		if argtypes[len(candidate.arguments)-1] == Ellipsis {
-			a := argtypes[len(candidate.arguments)-2]
-			for i := len(candidate.arguments) - 1; i < len(argtypes); i++ {
-				argtypes[i] = a
-			}
-		}
 		for i := range a.args {
 			err = a.args[i].build(argtypes[i])
 			if err != nil {
@@ -543,7 +571,15 @@ func (a *astCall) MakeExportName() string {
 func (a *astCall) resolve() error {
 	// already resolved (e.g. was a composite feature)
 	if a.resolved {
-		return nil
+		if a.composite == "" {
+			return nil
+		}
+		// composite features keep their type, but the definition must resolve as well (resolvers might fail)
+		variants := a.variants
+		a.resolved = false
+		err := a.resolve()
+		a.SetVariants(variants)
+		return err
 	}
 
 	// resolve arguments
@@ -563,12 +599,29 @@ func (a *astCall) resolve() error {
 
 	if a.feature.function {
 		// resolved function
-		if a.feature.resolver != nil {
+		if a.feature.resolver != nil || a.feature.constants != nil {
+			resolver := a.feature.resolver
+			if a.feature.constants != nil {
+				constants := make([]interface{}, len(a.args))
+				for i, arg := range a.args {
+					if c, ok := arg.(*astConstant); ok {
+						constants[i] = constantValue(c.value)
+					}
+				}
+				resolver = func(args []ipfix.InformationElement) (ipfix.InformationElement, error) {
+					return a.feature.constants(args, constants)
+				}
+			}
 			variants := make([]maybeASTVariant, len(a.args))
 			for i := range variants {
+				if a.args[i].IsRaw() {
+					// raw input of input functions has no type
+					variants[i] = toVariant(ipfix.InformationElement{}, nil)
+					continue
+				}
 				variants[i] = a.args[i].Variants()
 			}
-			variant, err := resolveASTVariants(a.feature.resolver, variants...)
+			variant, err := resolveASTVariants(resolver, variants...)
 			if err != nil {
 				return err
 			}
@@ -629,7 +682,12 @@ func makeASTFeature(name string, input FeatureType, id int) (astFragment, error)
 	if err != nil {
 		return nil, err
 	}
-	return makeASTCall(name, []interface{}{source}, input, id)
+	ret, err := makeASTCall(name, []interface{}{source}, input, id)
+	if err != nil {
+		return nil, err
+	}
+	ret.(*astCall).bare = true
+	return ret, nil
 }
 
 func (a *astCall) String() string {
@@ -650,7 +708,29 @@ type ast struct {
 	filter         []string
 	filterFeatures []MakeFeature
 	fragments      []astFragment
+	conditions     []int // indices of the control expressions in fragments; registers after simplify
 	exporter       []Exporter
+	fields         RecordFields // fields of the processed records; nil for packets
+}
+
+// makeControlASTFragment creates a fragment for an element of the control list. Feature names are control features,
+// while expressions (e.g. "in_subnet(sourceIPAddress, 10.0.0.0/8)") are conditions that must be true for a flow to
+// be exported. Conditions returning packet features (e.g. "running_sum(ipTotalLength) > 100") are evaluated for every
+// packet; the value of the last packet decides the export.
+func makeControlASTFragment(feature string, input FeatureType, id int) (astFragment, error) {
+	expr, err := spec.ParseExpression(feature)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := expr.(string); !ok {
+		return makeASTFragment(expr, input, id)
+	}
+	frag, err := makeASTFragment(feature, input, id)
+	if err != nil {
+		return nil, err
+	}
+	frag.SetControl(true)
+	return frag, nil
 }
 
 // makeAST builds a basic ast for the given feature specification (no verification done yet)
@@ -665,17 +745,18 @@ func makeAST(features []interface{}, control, filter []string, exporter []Export
 	r.fragments = make([]astFragment, len(features))
 	var err error
 	for i := range features {
-		r.fragments[i], err = makeASTFragment(features[i], input, i+1)
+		r.fragments[i], err = makeExportedASTFragment(features[i], input, i+1)
 		if err != nil {
-			return r, FeatureError{r.fragments[i].ID(), fmt.Sprint(r.fragments[i]), err}
+			return r, FeatureError{i + 1, fmt.Sprint(features[i]), err}
 		}
-		r.fragments[i].SetExport(r.fragments[i].MakeExportName())
 	}
 	for i, feature := range control {
-		frag, err := makeASTFragment(feature, input, i+1)
-		frag.SetControl(true)
+		frag, err := makeControlASTFragment(feature, input, i+1)
 		if err != nil {
-			return r, FeatureError{frag.ID(), fmt.Sprint(frag), err}
+			return r, FeatureError{i + 1, feature, err}
+		}
+		if !frag.Control() {
+			r.conditions = append(r.conditions, len(r.fragments))
 		}
 		r.fragments = append(r.fragments, frag)
 	}
@@ -727,19 +808,31 @@ func makeFeatureError(fragment astFragment, err error) error {
 
 // build inserts actual feature definitions in the call chain
 func (a *ast) build() error {
-	for _, fragment := range a.fragments {
-		if err := fragment.build(a.ret); err != nil {
+	conditions := make(map[int]bool, len(a.conditions))
+	for _, condition := range a.conditions {
+		conditions[condition] = true
+	}
+	for i, fragment := range a.fragments {
+		err := fragment.build(a.ret)
+		if err != nil && conditions[i] && fragment.build(PacketFeature) == nil {
+			err = nil
+		}
+		if err != nil {
 			return makeExpandedError(fragment, err)
 		}
+		if a.fields != nil {
+			if a.fragments[i], err = a.fields.bindFields(fragment); err != nil {
+				return makeExpandedError(fragment, err)
+			}
+		}
 	}
 	if len(a.filter) > 0 {
 		a.filterFeatures = make([]MakeFeature, len(a.filter))
 		for i, filter := range a.filter {
-			candidates := getFeatures(filter, RawPacket, 1)
-			if len(candidates) == 0 {
-				return fmt.Errorf("couldn't find filter feature '%s'", filter)
+			var err error
+			if a.filterFeatures[i], err = makeFilterFeature(filter); err != nil {
+				return err
 			}
-			a.filterFeatures[i] = candidates[0].make
 		}
 	}
 	return nil
@@ -811,7 +904,7 @@ func expandSelectASTFragment(f astFragment, input FeatureType) (astFragment, err
 			return f, err
 		}
 	}
-	if c.name == "select" || c.name == "select_slice" {
+	if c.name == "select" || c.name == "select_slice" || c.feature.input {
 		source, err := makeASTRaw(input)
 		if err != nil {
 			return f, err
@@ -821,7 +914,7 @@ func expandSelectASTFragment(f astFragment, input FeatureType) (astFragment, err
 	return f, nil
 }
 
-// expandSelect expands select* functions (= add input as last argument)
+// expandSelect expands select* and input functions (= add input as last argument)
 func (a *ast) expandSelect() error {
 	var err error
 	for i := range a.fragments {
@@ -894,6 +987,12 @@ func (a *ast) resolve() error {
 			return makeExpandedError(fragment, err)
 		}
 	}
+	for _, condition := range a.conditions {
+		fragment := a.fragments[condition]
+		if v, ok := fragment.Variants().(*singleVariant); ok && v.ie.Type != ipfix.BooleanType {
+			return FeatureError{fragment.ID(), fragment.MakeExportName(), fmt.Errorf("control expression must be boolean, but returns %s", v.ie.Type)}
+		}
+	}
 	return nil
 }
 
@@ -951,6 +1050,9 @@ func (a *ast) simplify() error {
 			return makeExpandedError(fragment, err)
 		}
 	}
+	for i, condition := range a.conditions {
+		a.conditions[i] = a.fragments[condition].Register()
+	}
 	a.fragments = out
 	return nil
 }
@@ -1010,10 +1112,11 @@ func (a *ast) compile(verbose bool) error {
 }
 
 // convert builds MakeFeature lists, used to instantiate features upon flow creation, from an ast
-func (a *ast) convert() (features []MakeFeature, filters []MakeFeature, args [][]int, tocall [][]int, ctrl *control) {
+func (a *ast) convert() (features []MakeFeature, filters []MakeFeature, args [][]int, tocall [][]int, types []ipfix.Type, ctrl *control) {
 	ctrl = &control{}
 	args = make([][]int, len(a.fragments))
 	tocall = make([][]int, len(a.fragments))
+	types = make([]ipfix.Type, len(a.fragments))
 	for _, fragment := range a.fragments {
 		fm := fragment.FeatureMaker()
 		features = append(features, fm.make)
@@ -1021,6 +1124,7 @@ func (a *ast) convert() (features []MakeFeature, filters []MakeFeature, args [][
 			ctrl.control = append(ctrl.control, fragment.Register())
 			continue
 		}
+		types[fragment.Register()] = commonType(fragment.Variants())
 
 		for _, arg := range fragment.Arguments() {
 			if arg.IsRaw() {
@@ -1039,6 +1143,7 @@ func (a *ast) convert() (features []MakeFeature, filters []MakeFeature, args [][
 			ctrl.variant = append(ctrl.variant, fragment.Register())
 		}
 	}
+	ctrl.condition = a.conditions
 	filters = a.filterFeatures
 	return
 }
@@ -0,0 +1,138 @@
+package flows
+
+import (
+	"fmt"
+	"strings"
+
+	ipfix "github.com/CN-TU/go-ipfix"
+)
+
+// Column describes an exported column of a checked feature specification
+type Column struct {
+	// ID is the number of the feature in the specification (starting with 1)
+	ID int
+	// Name is the export name
+	Name string
+	// Expression is the expanded call chain of the feature
+	Expression string
+	// FeatureType is the type the feature returns (e.g. FlowFeature)
+	FeatureType FeatureType
+	// Types holds the possible information elements. More than one element means that the type depends on a variant.
+	Types []ipfix.InformationElement
+	// Variants describes the variant choices, which determine the type (empty if there are no variants)
+	Variants string
+}
+
+// checkFragment compiles a single feature in its own ast
+func checkFragment(feature interface{}, id int, control bool) error {
+	var fragment astFragment
+	var err error
+	if control {
+		fragment, err = makeControlASTFragment(feature.(string), RawPacket, id)
+	} else {
+		fragment, err = makeExportedASTFragment(feature, RawPacket, id)
+	}
+	if err != nil {
+		return FeatureError{id, fmt.Sprint(feature), err}
+	}
+	tree := &ast{
+		ret:       FlowFeature,
+		input:     RawPacket,
+		fragments: []astFragment{fragment},
+	}
+	if control && !fragment.Control() {
+		tree.conditions = []int{0}
+	}
+	return tree.compile(false)
+}
+
+// CheckFeatures compiles a feature specification like RecordListMaker.AppendRecord does, but without creating
+// records or needing exporters. Instead of stopping at the first error, every feature is checked, and all the errors
+// found are returned. If there are no errors, the exported columns are returned.
+func CheckFeatures(features []interface{}, control, filter []string) ([]Column, []error) {
+	var errs []error
+	for i, feature := range features {
+		if err := checkFragment(feature, i+1, false); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for i, feature := range control {
+		if err := checkFragment(feature, i+1, true); err != nil {
+			errs = append(errs, fmt.Errorf("control: %s", err))
+		}
+	}
+	for _, feature := range filter {
+		if _, err := makeFilterFeature(feature); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	// errors spanning multiple features (e.g. duplicate exports) are only found in the complete ast
+	tree, err := makeAST(features, control, filter, nil, RawPacket, FlowFeature)
+	if err != nil {
+		return nil, []error{err}
+	}
+	if err := tree.compile(false); err != nil {
+		return nil, []error{err}
+	}
+
+	sources := make(map[int]string)
+	for _, fragment := range tree.fragments {
+		sources[fragment.Register()] = fragment.MakeExportName()
+	}
+	var columns []Column
+	for _, fragment := range tree.fragments {
+		if !fragment.Export() {
+			continue
+		}
+		column := Column{
+			ID:          fragment.ID(),
+			Name:        fragment.ExportName(),
+			Expression:  fragment.String(),
+			FeatureType: fragment.Returns(),
+		}
+		if t := fragment.Type(); t != nil {
+			column.Types = variantIEs(t, nil)
+			if v, ok := t.(*variant); ok {
+				column.Variants = describeVariant(v, sources)
+			}
+		}
+		columns = append(columns, column)
+	}
+	return columns, nil
+}
+
+// variantIEs returns all the distinct information elements of a variant
+func variantIEs(v maybeVariant, ret []ipfix.InformationElement) []ipfix.InformationElement {
+	switch v := v.(type) {
+	case *variant:
+		for _, ie := range v.ies {
+			ret = variantIEs(ie, ret)
+		}
+	case *singleVariant:
+		for _, ie := range ret {
+			if ie == v.ie {
+				return ret
+			}
+		}
+		ret = append(ret, v.ie)
+	}
+	return ret
+}
+
+func describeVariant(v maybeVariant, sources map[int]string) string {
+	switch v := v.(type) {
+	case *variant:
+		choices := make([]string, len(v.ies))
+		for i, ie := range v.ies {
+			choices[i] = describeVariant(ie, sources)
+		}
+		return fmt.Sprintf("%s ? {%s}", sources[v.source], strings.Join(choices, " | "))
+	case *singleVariant:
+		return v.ie.Type.String()
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,456 @@
+package flows
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+)
+
+/*
+Checkpoints contain the state of every active flow of a flow table (key, flow id, timers, and the state of the flow
+and of every feature). They are written with FlowTable.Checkpoint and restored with FlowTable.Restore into a flow table
+with the same flow specification.
+
+Per table the checkpoint is a gob stream consisting of:
+	tableCheckpoint
+	for every flow:
+		flowCheckpoint
+		values of FlowWithState.CheckpointState
+		for every record:
+			recordCheckpoint
+			for every non constant feature and filter:
+				checkpointValue (the value of the feature)
+				values of FeatureWithState.CheckpointState
+
+Records that were already exported, but are still waiting for being sorted, are not part of the checkpoint.
+*/
+
+// FeatureWithState must be implemented by features that hold per flow state besides the feature value (e.g., counters)
+// to support checkpoints. Features that consist only of one of the base features (e.g., BaseFeature) don't need this.
+type FeatureWithState interface {
+	// CheckpointState returns pointers to every field holding per flow state. The pointed to values must be encodable
+	// with encoding/gob.
+	CheckpointState() []interface{}
+}
+
+// FlowWithState must be implemented by flows to support checkpoints.
+type FlowWithState interface {
+	// CheckpointKind returns the kind of the flow, which is handed to the FlowRestorer upon restoring the flow.
+	CheckpointKind() string
+	// CheckpointState returns pointers to every field holding flow state. The pointed to values must be encodable
+	// with encoding/gob.
+	CheckpointState() []interface{}
+}
+
+// TimerRestorer can be implemented by flows that use timers besides the idle and active timer. RestoreTimer must add
+// the timer with the given id again and return false for unknown timers.
+type TimerRestorer interface {
+	RestoreTimer(id TimerID, expires DateTimeNanoseconds) bool
+}
+
+// FlowRestorer must return a new, uninitialized flow of the given kind (see FlowWithState). Returns nil for unknown kinds.
+type FlowRestorer func(kind string) Flow
+
+// checkpointVersion must be increased, if the checkpoint format changes
+const checkpointVersion = 1
+
+type tableCheckpoint struct {
+	Version int
+	Fields  [][]string
+	When    DateTimeNanoseconds
+	FlowID  uint64
+	Flows   int
+}
+
+type flowCheckpoint struct {
+	Kind    string
+	Key     string
+	ID      uint64
+	Forward bool
+	Timers  []DateTimeNanoseconds
+}
+
+type recordCheckpoint struct {
+	Active   bool
+	Alive    bool
+	PacketID uint64
+}
+
+// checkpointValue wraps feature values, since gob can't handle nil interfaces
+type checkpointValue struct {
+	Value interface{}
+}
+
+func init() {
+	// types used as feature values besides the builtin types
+	gob.Register(net.IP{})
+	gob.Register(DateTimeNanoseconds(0))
+	gob.Register(FlowEndReason(0))
+}
+
+// valueRestorer is implemented by every feature that embeds BaseFeature
+type valueRestorer interface {
+	restoreValue(interface{})
+}
+
+func (f *BaseFeature) restoreValue(value interface{}) { f.value = value }
+
+// baseFeatures contains the base features, which don't hold state besides the value
+var baseFeatures = map[reflect.Type]bool{
+	reflect.TypeOf(NoopFeature{}):            true,
+	reflect.TypeOf(EmptyBaseFeature{}):       true,
+	reflect.TypeOf(BaseFeature{}):            true,
+	reflect.TypeOf(MultiBasePacketFeature{}): true,
+	reflect.TypeOf(MultiBaseFlowFeature{}):   true,
+}
+
+// checkpointable returns true if the state of the given feature can be saved in a checkpoint
+func checkpointable(feature Feature) bool {
+	if feature.IsConstant() {
+		return true
+	}
+	if _, ok := feature.(FeatureWithState); ok {
+		return true
+	}
+	t := reflect.TypeOf(feature)
+	if t.Kind() != reflect.Ptr || t.Elem().Kind() != reflect.Struct {
+		return false
+	}
+	t = t.Elem()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.Anonymous || !baseFeatures[field.Type] {
+			return false
+		}
+	}
+	return true
+}
+
+// CheckpointUnsupported returns the names of the features and filters that don't support checkpoints. Must be
+// called before Clean.
+func (rl RecordListMaker) CheckpointUnsupported() []string {
+	names := make(map[string]bool)
+	for _, rm := range rl.list {
+		r := rm.make()
+		for i, feature := range r.features {
+			if !checkpointable(feature) {
+				names[rm.ast.fragments[i].Name()] = true
+			}
+		}
+		for i, feature := range r.filter {
+			if !checkpointable(feature) {
+				names[rm.ast.filter[i]] = true
+			}
+		}
+	}
+	ret := make([]string, 0, len(names))
+	for name := range names {
+		ret = append(ret, name)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// recordsOf returns the list of records held by a flow
+func recordsOf(r Record) []*record {
+	switch r := r.(type) {
+	case *record:
+		return []*record{r}
+	case recordList:
+		return r
+	}
+	return nil
+}
+
+func (tab *FlowTable) checkpointFields() [][]string {
+	ret := make([][]string, len(tab.records.list))
+	for i, record := range tab.records.list {
+		ret[i] = record.fields
+	}
+	return ret
+}
+
+// sameFields compares the fields of two flow specifications. gob doesn't differentiate between nil and empty slices.
+func sameFields(a, b [][]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if len(a[i]) != len(b[i]) {
+			return false
+		}
+		for j := range a[i] {
+			if a[i][j] != b[i][j] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Checkpoint writes the state of every flow in the table to enc. Must not be called concurrently with Event or Expire.
+func (tab *FlowTable) Checkpoint(enc *gob.Encoder) error {
+	var flows []Flow
+	for _, flow := range tab.flowlist {
+		if flow != nil && flow.Active() {
+			flows = append(flows, flow)
+		}
+	}
+	if err := enc.Encode(tableCheckpoint{
+		Version: checkpointVersion,
+		Fields:  tab.checkpointFields(),
+		When:    tab.context.when,
+		FlowID:  tab.flowID,
+		Flows:   len(flows),
+	}); err != nil {
+		return err
+	}
+	for _, flow := range flows {
+		if err := checkpointFlow(enc, flow); err != nil {
+			return fmt.Errorf("flow %d: %s", flow.ID(), err)
+		}
+	}
+	return nil
+}
+
+func checkpointFlow(enc *gob.Encoder, flow Flow) error {
+	stateful, ok := flow.(FlowWithState)
+	if !ok {
+		return fmt.Errorf("flow type %T doesn't support checkpoints", flow)
+	}
+	base, ok := flow.(baseFlower)
+	if !ok {
+		return fmt.Errorf("flow type %T doesn't embed BaseFlow", flow)
+	}
+	bf := base.baseFlow()
+	timers := make([]DateTimeNanoseconds, len(bf.timers))
+	for i, timer := range bf.timers {
+		timers[i] = timer.expires
+		if timer.expires != 0 && TimerID(i) != TimerIdle && TimerID(i) != TimerActive {
+			if _, ok := flow.(TimerRestorer); !ok {
+				return errors.New("only idle and active timers are supported in checkpoints")
+			}
+		}
+	}
+	if err := enc.Encode(flowCheckpoint{
+		Kind:    stateful.CheckpointKind(),
+		Key:     bf.key,
+		ID:      bf.id,
+		Forward: bf.firstForward,
+		Timers:  timers,
+	}); err != nil {
+		return err
+	}
+	for _, state := range stateful.CheckpointState() {
+		if err := enc.Encode(state); err != nil {
+			return err
+		}
+	}
+	for _, r := range recordsOf(bf.records) {
+		rc := recordCheckpoint{
+			Active: r.active,
+			Alive:  r.alive,
+		}
+		if r.export != nil {
+			rc.PacketID = r.export.packetID
+		}
+		if err := enc.Encode(rc); err != nil {
+			return err
+		}
+		if err := checkpointFeatures(enc, r.features); err != nil {
+			return err
+		}
+		if err := checkpointFeatures(enc, r.filter); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkpointFeatures(enc *gob.Encoder, features []Feature) error {
+	for _, feature := range features {
+		if feature.IsConstant() {
+			continue
+		}
+		if !checkpointable(feature) {
+			return fmt.Errorf("feature %T doesn't support checkpoints", feature)
+		}
+		if err := enc.Encode(checkpointValue{feature.Value()}); err != nil {
+			return fmt.Errorf("feature %T: %s", feature, err)
+		}
+		if stateful, ok := feature.(FeatureWithState); ok {
+			for _, state := range stateful.CheckpointState() {
+				if err := enc.Encode(state); err != nil {
+					return fmt.Errorf("feature %T: %s", feature, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Restore reads flows from a checkpoint written by Checkpoint and adds them to the table. restore is used for creating
+// the flows. The table must use the same flow specification as the one the checkpoint was written from.
+func (tab *FlowTable) Restore(dec *gob.Decoder, restore FlowRestorer) error {
+	var tc tableCheckpoint
+	if err := dec.Decode(&tc); err != nil {
+		return err
+	}
+	if tc.Version != checkpointVersion {
+		return fmt.Errorf("unsupported checkpoint version %d", tc.Version)
+	}
+	if !sameFields(tc.Fields, tab.checkpointFields()) {
+		return errors.New("checkpoint was written with a different flow specification")
+	}
+	if tc.When > tab.context.when {
+		tab.context.when = tc.When
+	}
+	if tc.FlowID > tab.flowID {
+		tab.flowID = tc.FlowID
+	}
+	var exports [][]*exportRecord
+	if tab.SortOutput != SortTypeNone {
+		exports = make([][]*exportRecord, len(tab.exports))
+	}
+	for i := 0; i < tc.Flows; i++ {
+		flow, err := tab.restoreFlow(dec, restore, exports)
+		if err != nil {
+			return fmt.Errorf("flow %d: %s", i, err)
+		}
+		key := flow.Key()
+		index := len(tab.flowlist)
+		tab.flowlist = append(tab.flowlist, flow)
+		tab.flows.put(hashKeyString(key), key, index)
+	}
+	nflows := uint64(tab.flows.len())
+	if nflows > tab.Stats.Maxflows {
+		tab.Stats.Maxflows = nflows
+	}
+	// restored records sort before every new record, but keep their order
+	for recordID, list := range exports {
+		sort.Slice(list, func(i, j int) bool { return list[i].lessPacket(list[j]) })
+		for _, e := range list {
+			e.packetID = 0
+			e.insert(tab.exports[recordID])
+		}
+	}
+	return nil
+}
+
+func (tab *FlowTable) restoreFlow(dec *gob.Decoder, restore FlowRestorer, exports [][]*exportRecord) (Flow, error) {
+	var fc flowCheckpoint
+	if err := dec.Decode(&fc); err != nil {
+		return nil, err
+	}
+	flow := restore(fc.Kind)
+	if flow == nil {
+		return nil, fmt.Errorf("unknown flow kind '%s'", fc.Kind)
+	}
+	base, ok := flow.(baseFlower)
+	if !ok {
+		return nil, fmt.Errorf("flow type %T doesn't embed BaseFlow", flow)
+	}
+	bf := base.baseFlow()
+	bf.self = flow
+	bf.key = fc.Key
+	bf.table = tab
+	bf.id = fc.ID
+	bf.firstForward = fc.Forward
+	bf.active = true
+	if tab.ActiveTimeout+tab.IdleTimeout != 0 {
+		bf.timers = makeFuncEntries()
+	}
+	for i, expires := range fc.Timers {
+		switch {
+		case expires == 0:
+		case TimerID(i) == TimerIdle:
+			bf.AddTimer(TimerIdle, bf.idleEvent, expires)
+		case TimerID(i) == TimerActive:
+			bf.AddTimer(TimerActive, bf.activeEvent, expires)
+		default:
+			if restorer, ok := flow.(TimerRestorer); !ok || !restorer.RestoreTimer(TimerID(i), expires) {
+				return nil, fmt.Errorf("unsupported timer %d", i)
+			}
+		}
+	}
+	if stateful, ok := flow.(FlowWithState); ok {
+		for _, state := range stateful.CheckpointState() {
+			if err := dec.Decode(state); err != nil {
+				return nil, err
+			}
+		}
+	}
+	bf.records = tab.records.make()
+	for recordID, r := range recordsOf(bf.records) {
+		var rc recordCheckpoint
+		if err := dec.Decode(&rc); err != nil {
+			return nil, err
+		}
+		r.active = rc.Active
+		r.alive = rc.Alive
+		if err := restoreFeatures(dec, r.features); err != nil {
+			return nil, err
+		}
+		if err := restoreFeatures(dec, r.filter); err != nil {
+			return nil, err
+		}
+		if exports != nil && r.active {
+			r.export = &exportRecord{
+				exportKey: exportKey{
+					packetID: rc.PacketID,
+					recordID: recordID,
+				},
+			}
+			exports[recordID] = append(exports[recordID], r.export)
+		}
+	}
+	return flow, nil
+}
+
+func restoreFeatures(dec *gob.Decoder, features []Feature) error {
+	for _, feature := range features {
+		if feature.IsConstant() {
+			continue
+		}
+		var value checkpointValue
+		if err := dec.Decode(&value); err != nil {
+			return fmt.Errorf("feature %T: %s", feature, err)
+		}
+		if restorer, ok := feature.(valueRestorer); ok {
+			restorer.restoreValue(value.Value)
+		}
+		if stateful, ok := feature.(FeatureWithState); ok {
+			for _, state := range stateful.CheckpointState() {
+				if err := dec.Decode(state); err != nil {
+					return fmt.Errorf("feature %T: %s", feature, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Drop removes every flow from the table without exporting it (e.g., after the flows were written to a checkpoint).
+// Already exported records, which are still waiting for being sorted, are forwarded to the exporters.
+func (tab *FlowTable) Drop() {
+	for _, flow := range tab.flowlist {
+		if flow == nil {
+			continue
+		}
+		if base, ok := flow.(baseFlower); ok {
+			base.baseFlow().records.Destroy()
+		}
+		if f, ok := flow.(FlowWithDestroy); ok {
+			f.Destroy()
+		}
+	}
+	tab.flows = makeFlowMap()
+	tab.flowlist = nil
+	tab.freelist = nil
+	if tab.SortOutput != SortTypeNone {
+		tab.flushAllExports()
+	}
+}
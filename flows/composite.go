@@ -10,6 +10,7 @@ type compositeFeatureMaker struct {
 	definition  []interface{}
 	ie          ipfix.InformationElement
 	description string
+	provider    string
 	iana        bool
 }
 
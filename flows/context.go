@@ -2,18 +2,19 @@ package flows
 
 // EventContext holds additional data for an event (e.g. time) and allows features to modify flow behaviour
 type EventContext struct {
-	when    DateTimeNanoseconds
-	flow    Flow
-	reason  FlowEndReason
-	event   func(interface{}, *EventContext, interface{})
-	record  *record
-	now     bool
-	stop    bool
-	export  bool
-	restart bool
-	keep    bool
-	hard    bool
-	forward bool
+	when     DateTimeNanoseconds
+	flow     Flow
+	reason   FlowEndReason
+	event    func(interface{}, *EventContext, interface{})
+	record   *record
+	now      bool
+	stop     bool
+	export   bool
+	restart  bool
+	keep     bool
+	hard     bool
+	forward  bool
+	selected bool
 }
 
 // initFlow sets the flow. This must be called in a flow before passing the context to features.
@@ -29,7 +30,7 @@ func (ec *EventContext) clear() {
 	ec.restart = false
 	ec.keep = false
 	ec.hard = false
-	// ec.forward doesn't need reset
+	// ec.forward doesn't need reset; ec.selected is reset by the flow for every event
 }
 
 // When returns the time, the event happened, or the current time
@@ -79,3 +80,9 @@ func (ec *EventContext) Flow() Flow {
 func (ec *EventContext) Forward() bool {
 	return ec.forward
 }
+
+// Selected returns true, if the current event passed the filters of a record and every control expression of the
+// record is true afterwards (i.e. the record would be exported now). Only valid in FlowWithEventEnd.EventEnd.
+func (ec *EventContext) Selected() bool {
+	return ec.selected
+}
@@ -24,7 +24,7 @@ The following FeatureTypes can be used:
  * FlowFeature: A per-flow feature (return or argument)
  * MatchType: Return type matches the argument type (Must be used as argument and return)
  * Selection: RawPacket - but filtered
- * Ellipsis: Can only be used as the last argument and means the previous argument type as often a needed
+ * Ellipsis: Can only be used as the last argument and means the previous argument type as often a needed (at least once)
 
 The following structs are available as base for new features:
 
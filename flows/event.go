@@ -4,8 +4,10 @@ package flows
 type Event interface {
 	// Timestamp returns the timestamp of the event.
 	Timestamp() DateTimeNanoseconds
-	// Key returns a flow key.
-	Key() string
+	// Key returns a flow key. The returned slice might be reused after the event was handled.
+	Key() []byte
+	// KeyHash returns the hash of the flow key as computed by HashKey.
+	KeyHash() uint64
 	// LowToHigh returns if the direction is from the lower key to the higher key for bidirectional
 	LowToHigh() bool
 	// SetWindow sets the window id. Must be unique for every window, and packets belong to the same window must be consecutive.
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"sync"
 
+	"github.com/CN-TU/go-ipfix"
 	"github.com/chtisgit/go-flows/util"
 )
 
@@ -621,6 +622,13 @@ type Exporter interface {
 	Finish()
 }
 
+// MetadataExporter can be implemented by exporters that are able to export information about the measurement process
+// (e.g. sampling parameters). ExportMetadata gets called after Init and before the first record is exported with the
+// time of the first packet.
+type MetadataExporter interface {
+	ExportMetadata(ies []ipfix.InformationElement, values []interface{}, when DateTimeNanoseconds)
+}
+
 // RegisterExporter registers an exporter (see module system in util)
 func RegisterExporter(name, desc string, new util.ModuleCreator, help util.ModuleHelp) {
 	util.RegisterModule(exporterName, name, desc, new, help)
@@ -1,5 +1,7 @@
 package flows
 
+import ipfix "github.com/CN-TU/go-ipfix"
+
 // Feature interfaces, which all features need to implement
 type Feature interface {
 	// Event gets called for every event. Data is provided via the first argument and a context providing addional information/control via the second argument.
@@ -30,6 +32,20 @@ type FeatureWithArguments interface {
 	SetArguments(arguments []int, features []Feature)
 }
 
+// FeatureWithType can be implemented by features that need their resolved type (e.g. to convert the values of their
+// arguments). SetType gets called during Feature initialization after SetArguments. If the type depends on the
+// variants of the arguments, t is ipfix.IllegalType.
+type FeatureWithType interface {
+	SetType(t ipfix.Type)
+}
+
+// FeatureWithEventEnd must be implemented by features that need to act after every feature processed the current
+// event, but before FinishEvent (e.g. to emit a value even if some arguments didn't emit one). EventEnd is called in
+// the order of the call chain, so values emitted in EventEnd still reach the dependent features.
+type FeatureWithEventEnd interface {
+	EventEnd(*EventContext)
+}
+
 // NoVariant represents the value returned from Variant if this Feature has only a single type.
 const NoVariant = -1
 
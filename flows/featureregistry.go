@@ -10,11 +10,16 @@ import (
 	"text/tabwriter"
 
 	"github.com/CN-TU/go-ipfix"
+	"github.com/chtisgit/go-flows/util"
 )
 
 // TypeResolver is a resolution function. It must return an ipfix information element for a givent list of feature argument types.
 type TypeResolver func([]ipfix.InformationElement) (ipfix.InformationElement, error)
 
+// ConstantResolver is a resolution function for functions depending on the values of constant arguments (e.g. cast).
+// In addition to the argument types, it gets the values of the constant arguments (nil for other arguments).
+type ConstantResolver func(args []ipfix.InformationElement, constants []interface{}) (ipfix.InformationElement, error)
+
 // MakeFeature is a function that returns an instantiated Feature
 type MakeFeature func() Feature
 
@@ -26,9 +31,12 @@ type featureMaker struct {
 	ie          ipfix.InformationElement
 	variants    []ipfix.InformationElement
 	resolver    TypeResolver
+	constants   ConstantResolver
 	description string
+	provider    string
 	iana        bool
 	function    bool
+	input       bool // gets the raw input as additional last argument (e.g. select)
 }
 
 func (f featureMaker) String() string {
@@ -45,17 +53,24 @@ func init() {
 	ipfix.LoadIANASpec() //for RegisterStandardFeature
 }
 
+// registerFeature adds a feature to the registry (postponed for plugins, see util.Register)
+func registerFeature(ret FeatureType, name string, feature featureMaker) {
+	util.Register(func() {
+		featureRegistry[ret][name] = append(featureRegistry[ret][name], feature)
+	})
+}
+
 // RegisterFeature registers a new feature with the given IE.
 func RegisterFeature(ie ipfix.InformationElement, description string, ret FeatureType, make MakeFeature, arguments ...FeatureType) {
-	featureRegistry[ret][ie.Name] = append(featureRegistry[ret][ie.Name],
-		featureMaker{
-			ret:         ret,
-			make:        make,
-			arguments:   arguments,
-			ie:          ie,
-			iana:        ie.ID != 0 && (ie.Pen == 0 || ie.Pen == 29305),
-			description: description,
-		})
+	registerFeature(ret, ie.Name, featureMaker{
+		ret:         ret,
+		make:        make,
+		arguments:   arguments,
+		ie:          ie,
+		iana:        ie.ID != 0 && (ie.Pen == 0 || ie.Pen == 29305),
+		description: description,
+		provider:    util.Provider(),
+	})
 }
 
 // RegisterFunction registers a function (feature with arguments - e.g. min()), whose data type can
@@ -66,73 +81,134 @@ func RegisterFeature(ie ipfix.InformationElement, description string, ret Featur
 // arguments (e.g. add)
 func RegisterFunction(name string, description string, ret FeatureType, make MakeFeature, arguments ...FeatureType) {
 	ie := ipfix.InformationElement{Type: ipfix.IllegalType}
-	featureRegistry[ret][name] = append(featureRegistry[ret][name],
-		featureMaker{
-			ret:         ret,
-			make:        make,
-			arguments:   arguments,
-			ie:          ie,
-			function:    true,
-			description: description,
-		})
+	registerFeature(ret, name, featureMaker{
+		ret:         ret,
+		make:        make,
+		arguments:   arguments,
+		ie:          ie,
+		function:    true,
+		description: description,
+		provider:    util.Provider(),
+	})
 }
 
 // RegisterTypedFunction registers a function that has a specific return type.
 func RegisterTypedFunction(name string, description string, t ipfix.Type, tl uint16, ret FeatureType, make MakeFeature, arguments ...FeatureType) {
 	ie := ipfix.NewInformationElement("", 0, 0, t, tl)
-	featureRegistry[ret][name] = append(featureRegistry[ret][name],
-		featureMaker{
-			ret:         ret,
-			make:        make,
-			arguments:   arguments,
-			ie:          ie,
-			function:    true,
-			description: description,
-		})
+	registerFeature(ret, name, featureMaker{
+		ret:         ret,
+		make:        make,
+		arguments:   arguments,
+		ie:          ie,
+		function:    true,
+		description: description,
+		provider:    util.Provider(),
+	})
+}
+
+// RegisterTypedInputFunction registers a function with a specific return type, which additionally gets the raw input
+// (e.g. packets) like features without arguments. This allows features with constant arguments (e.g. __label("attack")).
+func RegisterTypedInputFunction(name string, description string, t ipfix.Type, tl uint16, ret FeatureType, make MakeFeature, arguments ...FeatureType) {
+	ie := ipfix.NewInformationElement("", 0, 0, t, tl)
+	registerFeature(ret, name, featureMaker{
+		ret:         ret,
+		make:        make,
+		arguments:   arguments,
+		ie:          ie,
+		function:    true,
+		input:       true,
+		description: description,
+		provider:    util.Provider(),
+	})
 }
 
 // RegisterCustomFunction registers a function that needs custom type resolution to get the return type.
 func RegisterCustomFunction(name string, description string, resolver TypeResolver, ret FeatureType, make MakeFeature, arguments ...FeatureType) {
 	ie := ipfix.InformationElement{Type: ipfix.IllegalType}
-	featureRegistry[ret][name] = append(featureRegistry[ret][name],
-		featureMaker{
-			ret:         ret,
-			make:        make,
-			arguments:   arguments,
-			resolver:    resolver,
-			ie:          ie,
-			function:    true,
-			description: description,
-		})
+	registerFeature(ret, name, featureMaker{
+		ret:         ret,
+		make:        make,
+		arguments:   arguments,
+		resolver:    resolver,
+		ie:          ie,
+		function:    true,
+		description: description,
+		provider:    util.Provider(),
+	})
+}
+
+// RegisterConstantFunction registers a function that needs the values of its constant arguments for type resolution
+// (see ConstantResolver).
+func RegisterConstantFunction(name string, description string, resolver ConstantResolver, ret FeatureType, make MakeFeature, arguments ...FeatureType) {
+	registerConstantFunction(name, description, resolver, false, ret, make, arguments...)
+}
+
+// RegisterConstantInputFunction registers a function like RegisterConstantFunction, which additionally gets the raw
+// input like RegisterTypedInputFunction (e.g. __label("attack")).
+func RegisterConstantInputFunction(name string, description string, resolver ConstantResolver, ret FeatureType, make MakeFeature, arguments ...FeatureType) {
+	registerConstantFunction(name, description, resolver, true, ret, make, arguments...)
+}
+
+func registerConstantFunction(name string, description string, resolver ConstantResolver, input bool, ret FeatureType, make MakeFeature, arguments ...FeatureType) {
+	ie := ipfix.InformationElement{Type: ipfix.IllegalType}
+	registerFeature(ret, name, featureMaker{
+		ret:         ret,
+		make:        make,
+		arguments:   arguments,
+		constants:   resolver,
+		ie:          ie,
+		function:    true,
+		input:       input,
+		description: description,
+		provider:    util.Provider(),
+	})
+}
+
+// RegisterCustomInputFunction registers a function that needs custom type resolution to get the return type (see
+// RegisterCustomFunction), which additionally gets the raw input (see RegisterTypedInputFunction). The resolver gets
+// an empty information element for the raw input.
+func RegisterCustomInputFunction(name string, description string, resolver TypeResolver, ret FeatureType, make MakeFeature, arguments ...FeatureType) {
+	ie := ipfix.InformationElement{Type: ipfix.IllegalType}
+	registerFeature(ret, name, featureMaker{
+		ret:         ret,
+		make:        make,
+		arguments:   arguments,
+		resolver:    resolver,
+		ie:          ie,
+		function:    true,
+		input:       true,
+		description: description,
+		provider:    util.Provider(),
+	})
 }
 
 // RegisterVariantFeature registers a feature that represents more than one information element depending on the data.
 func RegisterVariantFeature(name string, description string, ies []ipfix.InformationElement, ret FeatureType, make MakeFeature, arguments ...FeatureType) {
 	ie := ipfix.InformationElement{Type: ipfix.IllegalType}
-	featureRegistry[ret][name] = append(featureRegistry[ret][name],
-		featureMaker{
-			ret:         ret,
-			make:        make,
-			arguments:   arguments,
-			ie:          ie,
-			variants:    ies,
-			description: description,
-		})
+	registerFeature(ret, name, featureMaker{
+		ret:         ret,
+		make:        make,
+		arguments:   arguments,
+		ie:          ie,
+		variants:    ies,
+		description: description,
+		provider:    util.Provider(),
+	})
 }
 
 // RegisterStandardVariantFeature registers a feature that represents more than one information element depending on the data and is part of the iana ipfix list (e.g. sourceIpv4Address/sourceIpv6Address)
 func RegisterStandardVariantFeature(name string, description string, ies []ipfix.InformationElement, ret FeatureType, make MakeFeature, arguments ...FeatureType) {
 	ie := ipfix.InformationElement{Type: ipfix.IllegalType}
-	featureRegistry[ret][name] = append(featureRegistry[ret][name],
-		featureMaker{
-			ret:         ret,
-			make:        make,
-			arguments:   arguments,
-			ie:          ie,
-			variants:    ies,
-			iana:        true,
-			description: description,
-		})
+	registerFeature(ret, name, featureMaker{
+		ret:         ret,
+		make:        make,
+		arguments:   arguments,
+		ie:          ie,
+		variants:    ies,
+		iana:        true,
+		description: description,
+		provider:    util.Provider(),
+	})
 }
 
 // RegisterStandardFeature registers a feature from the iana ipfix list
@@ -176,12 +252,16 @@ func RegisterCompositeFeature(ie ipfix.InformationElement, description string, d
 	if _, ok := compositeFeatures[ie.Name]; ok {
 		panic(fmt.Sprintf("Feature %s already registered", ie.Name))
 	}
-	compositeFeatures[ie.Name] = compositeFeatureMaker{
+	feature := compositeFeatureMaker{
 		definition:  definition,
 		ie:          ie,
 		iana:        ie.Pen == 0 && ie.ID != 0,
 		description: description,
+		provider:    util.Provider(),
 	}
+	util.Register(func() {
+		compositeFeatures[ie.Name] = feature
+	})
 }
 
 // RegisterStandardCompositeFeature registers a composite feature (see RegisterCompositeFeature) that is part of the iana ipfix list
@@ -206,7 +286,9 @@ func getFeatures(feature string, ret FeatureType, nargs int) []featureMaker {
 	for _, t := range []FeatureType{ret, MatchType} {
 		for _, f := range featureRegistry[t][feature] {
 			if len(f.arguments) >= 1 && f.arguments[len(f.arguments)-1] == Ellipsis {
-				variadic = append(variadic, f)
+				if nargs >= len(f.arguments) {
+					variadic = append(variadic, f)
+				}
 			} else if len(f.arguments) == nargs {
 				candidates = append(candidates, f)
 			}
@@ -227,7 +309,7 @@ func ListFeatures(w io.Writer) {
 	for ret, features := range featureRegistry {
 		for name, featurelist := range features {
 			for _, feature := range featurelist {
-				desc[name] = feature.description
+				desc[name] = util.DescribeProvider(feature.description, feature.provider)
 				if feature.ret == ControlFeature {
 					control = append(control, name)
 				} else if feature.ret == RawPacket || feature.ret == RawFlow {
@@ -293,7 +375,7 @@ func ListFeatures(w io.Writer) {
 	}
 	for name, feature := range compositeFeatures {
 		impl[name] = fmt.Sprint(" = ", strings.Join(compositeToCall(feature.definition), ""))
-		desc[name] = feature.description
+		desc[name] = util.DescribeProvider(feature.description, feature.provider)
 		fun := feature.definition[0].(string)
 		if _, ok := featureRegistry[FlowFeature][fun]; ok {
 			ff[name] = "X"
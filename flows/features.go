@@ -2,10 +2,15 @@ package flows
 
 import (
 	"fmt"
+	"net"
 
 	"github.com/CN-TU/go-ipfix"
+	"github.com/chtisgit/go-flows/spec"
 )
 
+// constantPrefix starts the names of constant information elements (e.g. _const{1})
+const constantPrefix = "_const{"
+
 type constantFeature struct {
 	value interface{}
 }
@@ -23,9 +28,32 @@ func (f *constantFeature) IsConstant() bool                                 { re
 
 var _ Feature = (*constantFeature)(nil)
 
+// constantValue converts constants from the specification into feature values (e.g. spec.String to string)
+func constantValue(value interface{}) interface{} {
+	switch cv := value.(type) {
+	case spec.String:
+		return string(cv)
+	case spec.List:
+		ret := make([]interface{}, len(cv))
+		for i, element := range cv {
+			ret[i] = constantValue(element)
+		}
+		return ret
+	}
+	return value
+}
+
+// constantName returns the name of a constant in expression syntax. Strings are quoted to distinguish them from
+// features, and floats always contain a decimal point or exponent to distinguish them from integers.
+func constantName(value interface{}) string {
+	return spec.FormatConstant(value)
+}
+
 // newConstantMetaFeature creates a new constant feature, which holds the given value
 func newConstantMetaFeature(value interface{}) (featureMaker, error) {
 	var t ipfix.Type
+	name := constantName(value)
+	value = constantValue(value)
 	switch cv := value.(type) {
 	case bool:
 		t = ipfix.BooleanType
@@ -41,6 +69,19 @@ func newConstantMetaFeature(value interface{}) (featureMaker, error) {
 		t = ipfix.Unsigned64Type
 	case uint64:
 		t = ipfix.Unsigned64Type
+	case string:
+		t = ipfix.StringType
+	case net.IP:
+		if len(cv) == net.IPv4len {
+			t = ipfix.Ipv4AddressType
+		} else {
+			t = ipfix.Ipv6AddressType
+		}
+	case *net.IPNet:
+		// networks can only be used as arguments; there is no ipfix type for them
+		t = ipfix.StringType
+	case []interface{}:
+		t = ipfix.BasicListType
 	default:
 		return featureMaker{}, fmt.Errorf("can't create constant of type %T", value)
 	}
@@ -48,7 +89,7 @@ func newConstantMetaFeature(value interface{}) (featureMaker, error) {
 	return featureMaker{
 		ret:  Const,
 		make: func() Feature { return feature },
-		ie:   ipfix.NewInformationElement(fmt.Sprintf("_const{%v}", value), 0, 0, t, 0),
+		ie:   ipfix.NewInformationElement(constantPrefix+name+"}", 0, 0, t, 0),
 	}, nil
 }
 
@@ -67,6 +108,9 @@ type selectF struct {
 
 func (f *selectF) Start(*EventContext) { f.sel = false }
 
+// CheckpointState returns nothing, since sel only holds state during a single event
+func (f *selectF) CheckpointState() []interface{} { return nil }
+
 func (f *selectF) Event(new interface{}, context *EventContext, src interface{}) {
 	/* If src is not nil we got an event from the argument -> Store the boolean value (This always happens before events from the flow)
 	   otherwise we have an event from the flow -> forward it in case we should and reset sel
@@ -92,6 +136,8 @@ func (f *selectS) SetArguments(arguments []int, features []Feature) {
 }
 func (f *selectS) Start(*EventContext) { f.current = 0 }
 
+func (f *selectS) CheckpointState() []interface{} { return []interface{}{&f.current} }
+
 func (f *selectS) Event(new interface{}, context *EventContext, src interface{}) {
 	if f.current >= f.start && f.current < f.stop {
 		f.Emit(new, context, nil)
@@ -104,3 +150,73 @@ func init() {
 	RegisterFunction("select_slice", "selects a slice from the first value to the second value, with Python-like indexing (if a <selection is not provided, default to selecting everything)", Selection, func() Feature { return &selectS{} }, Const, Const)
 	RegisterFunction("select_slice", "selects a slice from the first value to the second value, with Python-like indexing (if a <selection is not provided, default to selecting everything)", Selection, func() Feature { return &selectS{} }, Const, Const, Selection)
 }
+
+// expression filters; used for expressions in the filter list
+
+// expressionFilter only forwards events for which the expression is true. The expression is evaluated as if every
+// event were a flow of its own.
+type expressionFilter struct {
+	NoopFeature
+	record *record
+	value  int
+}
+
+// CheckpointState returns nothing, since the expression is only evaluated during a single event
+func (f *expressionFilter) CheckpointState() []interface{} { return nil }
+
+func (f *expressionFilter) Event(new interface{}, context *EventContext, src interface{}) {
+	parent := context.record
+	context.record = f.record
+	for _, feature := range f.record.features {
+		feature.Start(context)
+	}
+	for _, feature := range f.record.control.event {
+		f.record.features[feature].Event(new, context, nil)
+	}
+	for _, feature := range f.record.eventEnd {
+		feature.EventEnd(context)
+	}
+	for _, feature := range f.record.control.event {
+		f.record.features[feature].FinishEvent(context)
+	}
+	for _, feature := range f.record.features {
+		feature.Stop(FlowEndReasonEnd, context)
+	}
+	context.record = parent
+	if f.record.features[f.value].Value() == true {
+		context.Event(new, context, src)
+	}
+}
+
+// makeFilterFeature returns the filter feature for an element of the filter list. This is either the name of a
+// filter feature (e.g. tcpReorder) or a boolean expression (e.g. "in_subnet(sourceIPAddress, 10.0.0.0/8)").
+func makeFilterFeature(filter string) (MakeFeature, error) {
+	expr, err := spec.ParseExpression(filter)
+	if err != nil {
+		return nil, fmt.Errorf("filter '%s': %s", filter, err)
+	}
+	if _, ok := expr.(string); ok {
+		candidates := getFeatures(filter, RawPacket, 1)
+		if len(candidates) == 0 {
+			return nil, fmt.Errorf("couldn't find filter feature '%s'", filter)
+		}
+		return candidates[0].make, nil
+	}
+
+	tree, err := makeAST([]interface{}{expr}, nil, nil, nil, RawPacket, FlowFeature)
+	if err == nil {
+		err = tree.compile(false)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("filter '%s': %s", filter, err)
+	}
+	exported := tree.fragments[len(tree.fragments)-1]
+	if commonType(exported.Variants()) != ipfix.BooleanType {
+		return nil, fmt.Errorf("filter '%s' must be boolean", filter)
+	}
+	makeRecord := newRecordMaker(tree)
+	value := exported.Register()
+	return func() Feature {
+		return &expressionFilter{record: makeRecord(), value: value}
+	}, nil
+}
@@ -64,6 +64,18 @@ type Flow interface {
 	firstLowToHigh() bool
 }
 
+// FlowWithEventEnd can be implemented by flows that need to act after the records processed an event, but before the
+// flow is eventually removed (e.g. depending on EventContext.Selected).
+type FlowWithEventEnd interface {
+	EventEnd(Event, *EventContext)
+}
+
+// FlowWithDestroy can be implemented by flows that hold resources besides the records. Destroy gets called whenever the
+// flow is removed from the table (exported, stopped, or dropped).
+type FlowWithDestroy interface {
+	Destroy()
+}
+
 //FlowOptions applying to each flow
 type FlowOptions struct {
 	// ActiveTimeout is the active timeout in nanoseconds
@@ -76,8 +88,12 @@ type FlowOptions struct {
 	PerPacket bool
 	// TCPExpiry specifies if tcp expiry is wanted (only works if the key contains at least the five tuple)
 	TCPExpiry bool
+	// TCPLinger is the time in nanoseconds a closed TCP connection is kept before it is exported (like TIME_WAIT)
+	TCPLinger DateTimeNanoseconds
 	// SortOutput specifies how the output should be sorted
 	SortOutput SortType
+	// SamplingRate is N, if only 1 in N packets or flows is processed (0 or 1 means no sampling)
+	SamplingRate uint64
 	// CustomSettings contains a map with all the settings read from the flow specification
 	CustomSettings map[string]interface{}
 }
@@ -92,11 +108,30 @@ type BaseFlow struct {
 	id           uint64
 	active       bool
 	firstForward bool
+	self         Flow
+}
+
+// baseFlower is implemented by every flow that embeds BaseFlow
+type baseFlower interface {
+	baseFlow() *BaseFlow
+}
+
+func (flow *BaseFlow) baseFlow() *BaseFlow { return flow }
+
+// outer returns the flow embedding this BaseFlow, which is handed to the features via the EventContext
+func (flow *BaseFlow) outer() Flow {
+	if flow.self != nil {
+		return flow.self
+	}
+	return flow
 }
 
 // Stop destroys the resources associated with this flow. Call this to cancel the flow without exporting it or notifying the features.
 func (flow *BaseFlow) Stop() {
 	flow.records.Destroy()
+	if f, ok := flow.outer().(FlowWithDestroy); ok {
+		f.Destroy()
+	}
 	flow.table.remove(flow)
 	flow.active = false
 }
@@ -148,12 +183,35 @@ func (flow *BaseFlow) Export(reason FlowEndReason, context *EventContext, now Da
 	flow.Stop()
 }
 
+// Restart exports the features of the flow with reason as FlowEndReason, at time when, with current time now, but keeps
+// the flow in the table. event becomes the first event of the restarted flow (e.g. a new connection reusing the flow
+// key) and needs to be forwarded to Event afterwards.
+func (flow *BaseFlow) Restart(reason FlowEndReason, event Event, context *EventContext, now DateTimeNanoseconds) {
+	if !flow.active {
+		return
+	}
+	context.hard = true
+	flow.records.Export(reason, context, now, flow.table, 0)
+	flow.firstForward = event.LowToHigh()
+	context.forward = true
+	if flow.table.ActiveTimeout != 0 {
+		flow.AddTimer(TimerActive, flow.activeEvent, context.when+flow.table.ActiveTimeout)
+	}
+}
+
+// Reverse swaps the direction of the flow, i.e. the first event becomes a backward event. This must be called from
+// Event before the first event is forwarded to BaseFlow.Event (e.g. if the first packet was sent by the responder).
+func (flow *BaseFlow) Reverse(context *EventContext) {
+	flow.firstForward = !flow.firstForward
+	context.forward = !context.forward
+}
+
 // ExportWithoutContext exports the features of the flow (see Export). This function can be used, when no context is available.
 func (flow *BaseFlow) ExportWithoutContext(reason FlowEndReason, expire, now DateTimeNanoseconds) {
 	context := &EventContext{
 		when: expire,
 	}
-	context.initFlow(flow)
+	context.initFlow(flow.outer())
 	flow.Export(reason, context, now)
 }
 
@@ -171,11 +229,15 @@ func (flow *BaseFlow) EOF(context *EventContext) {
 
 // Event handles the given event and the active and idle timers.
 func (flow *BaseFlow) Event(event Event, context *EventContext) {
-	context.initFlow(flow)
+	context.initFlow(flow.outer())
 	if flow.table.IdleTimeout != 0 {
 		flow.AddTimer(TimerIdle, flow.idleEvent, context.when+flow.table.IdleTimeout)
 	}
+	context.selected = false
 	flow.records.Event(event, context, flow.table, 0)
+	if f, ok := flow.outer().(FlowWithEventEnd); ok {
+		f.EventEnd(event, context)
+	}
 	if !flow.records.Active() {
 		flow.Stop()
 		return
@@ -201,7 +263,7 @@ func (flow *BaseFlow) Init(table *FlowTable, key string, forward bool, context *
 	flow.firstForward = forward
 	flow.records = table.records.make()
 	flow.id = id
-	context.initFlow(flow)
+	context.initFlow(flow.outer())
 	if flow.table.ActiveTimeout != 0 {
 		flow.AddTimer(TimerActive, flow.activeEvent, context.when+flow.table.ActiveTimeout)
 	}
@@ -0,0 +1,144 @@
+package flows
+
+// flowMap is an open addressing hash table (linear probing) mapping flow keys to indizes into the flow list.
+//
+// Lookups use a precomputed hash (see HashKey) and compare the key bytes against the key stored inline in
+// the slot, which means no string needs to be allocated for finding an existing flow. Deletion uses
+// backward shifting, so no tombstones are needed.
+type flowMap struct {
+	slots []flowSlot
+	shift uint
+	count int
+}
+
+type flowSlot struct {
+	hash  uint64
+	key   string
+	index int
+	used  bool
+}
+
+const (
+	// flowMapInitialBits is the log2 of the initial number of slots
+	flowMapInitialBits = 10
+	// fibonacciMultiplier spreads the hash bits; needed since the parallel tables already partition by hash
+	fibonacciMultiplier = 11400714819323198485
+)
+
+func makeFlowMap() flowMap {
+	return flowMap{
+		slots: make([]flowSlot, 1<<flowMapInitialBits),
+		shift: 64 - flowMapInitialBits,
+	}
+}
+
+// HashKey returns the hash of a flow key. Events must return this hash for their key in KeyHash.
+func HashKey(key []byte) (h uint64) {
+	h = fnvBasis
+	for i := 0; i < len(key); i++ {
+		h ^= uint64(key[i])
+		h *= fnvPrime
+	}
+	return
+}
+
+// hashKeyString returns the same as HashKey for a key given as string
+func hashKeyString(key string) (h uint64) {
+	h = fnvBasis
+	for i := 0; i < len(key); i++ {
+		h ^= uint64(key[i])
+		h *= fnvPrime
+	}
+	return
+}
+
+const fnvBasis = 14695981039346656037
+const fnvPrime = 1099511628211
+
+func (m *flowMap) position(hash uint64) uint64 {
+	return (hash * fibonacciMultiplier) >> m.shift
+}
+
+// len returns the number of stored flows
+func (m *flowMap) len() int {
+	return m.count
+}
+
+// get returns the index stored for key and true, or false if key is not in the map
+func (m *flowMap) get(hash uint64, key []byte) (int, bool) {
+	mask := uint64(len(m.slots) - 1)
+	for i := m.position(hash); ; i = (i + 1) & mask {
+		slot := &m.slots[i]
+		if !slot.used {
+			return 0, false
+		}
+		if slot.hash == hash && slot.key == string(key) {
+			return slot.index, true
+		}
+	}
+}
+
+// put stores index for the given key. The key must not be in the map already.
+func (m *flowMap) put(hash uint64, key string, index int) {
+	if (m.count+1)*10 > len(m.slots)*7 {
+		m.grow()
+	}
+	m.insert(flowSlot{hash: hash, key: key, index: index, used: true})
+	m.count++
+}
+
+func (m *flowMap) insert(slot flowSlot) {
+	mask := uint64(len(m.slots) - 1)
+	i := m.position(slot.hash)
+	for m.slots[i].used {
+		i = (i + 1) & mask
+	}
+	m.slots[i] = slot
+}
+
+func (m *flowMap) grow() {
+	old := m.slots
+	m.slots = make([]flowSlot, len(old)*2)
+	m.shift--
+	for _, slot := range old {
+		if slot.used {
+			m.insert(slot)
+		}
+	}
+}
+
+// remove deletes key from the map and returns the stored index and true, or false if key was not found
+func (m *flowMap) remove(hash uint64, key string) (int, bool) {
+	mask := uint64(len(m.slots) - 1)
+	i := m.position(hash)
+	for {
+		slot := &m.slots[i]
+		if !slot.used {
+			return 0, false
+		}
+		if slot.hash == hash && slot.key == key {
+			break
+		}
+		i = (i + 1) & mask
+	}
+	index := m.slots[i].index
+	// shift following entries back, that would not be found anymore with the hole at i
+	for j := (i + 1) & mask; m.slots[j].used; j = (j + 1) & mask {
+		home := m.position(m.slots[j].hash)
+		if (j-home)&mask >= (j-i)&mask {
+			m.slots[i] = m.slots[j]
+			i = j
+		}
+	}
+	m.slots[i] = flowSlot{}
+	m.count--
+	return index, true
+}
+
+// clear removes every entry from the map, but keeps the allocated slots
+func (m *flowMap) clear() {
+	for i := range m.slots {
+		m.slots[i] = flowSlot{}
+	}
+	m.count = 0
+}
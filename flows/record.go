@@ -1,11 +1,14 @@
 package flows
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"strings"
 	"text/template"
+
+	ipfix "github.com/CN-TU/go-ipfix"
 )
 
 // Record holds multiple features that belong to a single record
@@ -21,14 +24,16 @@ type Record interface {
 }
 
 type control struct {
-	control []int
-	event   []int
-	export  []int
-	variant []int
+	control   []int
+	event     []int
+	export    []int
+	variant   []int
+	condition []int
 }
 
 type record struct {
 	features []Feature
+	eventEnd []FeatureWithEventEnd
 	filter   []Feature
 	control  *control
 	export   *exportRecord
@@ -119,6 +124,12 @@ RESTART:
 	for _, feature := range r.control.event {
 		r.features[feature].Event(data, context, nil) //Events trickle down the tree
 	}
+	for _, feature := range r.eventEnd {
+		feature.EventEnd(context)
+	}
+	if r.selected() {
+		context.selected = true
+	}
 	for _, feature := range r.control.event {
 		r.features[feature].FinishEvent(context) //Same for finishevents
 	}
@@ -136,6 +147,16 @@ RESTART:
 	}
 }
 
+// selected returns true, if every control expression of the record is true
+func (r *record) selected() bool {
+	for _, condition := range r.control.condition {
+		if r.features[condition].Value() != true {
+			return false
+		}
+	}
+	return true
+}
+
 func (r *record) Event(data Event, context *EventContext, table *FlowTable, recordID int) {
 	nfilter := len(r.filter)
 	context.record = r
@@ -171,6 +192,10 @@ func (r *record) Export(reason FlowEndReason, context *EventContext, now DateTim
 		r.alive = false
 		return
 	}
+	if !r.selected() {
+		r.alive = false
+		return
+	}
 
 	record := table.records.list[recordID]
 	template := record.template
@@ -278,11 +303,12 @@ func (rl RecordListMaker) Flush() {
 
 // RecordMaker holds metadata for instantiating a record
 type RecordMaker struct {
-	export   *ExportPipeline
-	template Template
-	fields   []string
-	ast      *ast
-	make     func() *record
+	export    *ExportPipeline
+	template  Template
+	templates [2]int // range of the ids of the templates of this record
+	fields    []string
+	ast       *ast
+	make      func() *record
 }
 
 // Init must be called after a Record was instantiated
@@ -293,63 +319,121 @@ func (rm *RecordMaker) Init() {
 // AppendRecord creates a internal representation needed for instantiating records from a feature
 // specification, a list of exporters and a needed base (only FlowFeature supported so far)
 func (rl *RecordListMaker) AppendRecord(features []interface{}, control, filter []string, exporter *ExportPipeline, verbose bool) error {
+	return rl.appendRecord(features, control, filter, nil, exporter, verbose)
+}
+
+// AppendRecordOfRecords is like AppendRecord, but the record processes records (see RecordEvent) with the given fields
+// instead of packets. Filters aren't supported.
+func (rl *RecordListMaker) AppendRecordOfRecords(features []interface{}, control, filter []string, fields RecordFields, exporter *ExportPipeline, verbose bool) error {
+	if len(filter) > 0 {
+		return errors.New("filters aren't supported for records")
+	}
+	if fields == nil {
+		fields = make(RecordFields)
+	}
+	return rl.appendRecord(features, control, filter, fields, exporter, verbose)
+}
+
+func (rl *RecordListMaker) appendRecord(features []interface{}, control, filter []string, recordFields RecordFields, exporter *ExportPipeline, verbose bool) error {
 	tree, err := makeAST(features, control, filter, exporter.exporter, RawPacket, FlowFeature) // only packets -> flows for now
 	if err != nil {
 		return err
 	}
+	tree.fields = recordFields
 	if err := tree.compile(verbose); err != nil {
 		return err
 	}
 
+	first := rl.templates
 	template, fields := tree.template(&rl.templates)
 	if verbose {
 		log.Println("Fields: ", strings.Join(fields, ", "))
 		log.Println("Template(s): ", template)
 	}
 
-	featureMakers, filterMakers, args, tocall, ctrl := tree.convert()
+	rl.list = append(rl.list, RecordMaker{
+		export:    exporter,
+		template:  template,
+		templates: [2]int{first, rl.templates},
+		fields:    fields,
+		ast:       tree,
+		make:      newRecordMaker(tree),
+	})
+	return nil
+}
 
-	hasfilter := len(filterMakers) > 0
+// Fields returns the names of the exported fields of the record with the given template (see Template.ID) or nil if
+// the template doesn't belong to this list
+func (rl RecordListMaker) Fields(template int) []string {
+	for _, record := range rl.list {
+		if template >= record.templates[0] && template < record.templates[1] {
+			return record.fields
+		}
+	}
+	return nil
+}
 
-	rl.list = append(rl.list, RecordMaker{
-		exporter,
-		template,
-		fields,
-		tree,
-		func() *record {
-			features := make([]Feature, len(featureMakers))
-			features = features[:len(featureMakers)] //BCE
-			for i, maker := range featureMakers {
-				features[i] = maker()
-			}
-			for i, arg := range args {
-				if len(arg) > 0 {
-					if f, ok := features[i].(FeatureWithArguments); ok {
-						f.SetArguments(arg, features)
-					}
+// FieldInformationElements calls f for every exported field of every record and every variant of the record with the
+// name of the field and the information element
+func (rl RecordListMaker) FieldInformationElements(f func(name string, ie ipfix.InformationElement)) {
+	for _, record := range rl.list {
+		for _, leaf := range leafTemplates(record.template, nil) {
+			for i, name := range record.fields {
+				if i < len(leaf.ies) {
+					f(name, leaf.ies[i])
 				}
 			}
-			for i, tocall := range tocall {
-				features[i].setDependent(tocall)
+		}
+	}
+}
+
+// newRecordMaker returns a function that instantiates records for a compiled ast
+func newRecordMaker(tree *ast) func() *record {
+	featureMakers, filterMakers, args, tocall, types, ctrl := tree.convert()
+
+	hasfilter := len(filterMakers) > 0
+
+	return func() *record {
+		features := make([]Feature, len(featureMakers))
+		features = features[:len(featureMakers)] //BCE
+		var eventEnd []FeatureWithEventEnd
+		for i, maker := range featureMakers {
+			features[i] = maker()
+			if f, ok := features[i].(FeatureWithEventEnd); ok {
+				eventEnd = append(eventEnd, f)
 			}
-			var filter []Feature
-			if hasfilter {
-				filter = make([]Feature, len(filterMakers))
-				filter = filter[:len(filterMakers)] //BCE
-				for i, feature := range filterMakers {
-					filter[i] = feature()
+		}
+		for i, arg := range args {
+			if len(arg) > 0 {
+				if f, ok := features[i].(FeatureWithArguments); ok {
+					f.SetArguments(arg, features)
 				}
 			}
-
-			return &record{
-				features: features,
-				filter:   filter,
-				control:  ctrl,
+		}
+		for i, t := range types {
+			if f, ok := features[i].(FeatureWithType); ok {
+				f.SetType(t)
+			}
+		}
+		for i, tocall := range tocall {
+			features[i].setDependent(tocall)
+		}
+		var filter []Feature
+		if hasfilter {
+			filter = make([]Feature, len(filterMakers))
+			filter = filter[:len(filterMakers)] //BCE
+			for i, feature := range filterMakers {
+				filter[i] = feature()
 			}
+		}
 
-		},
-	})
-	return nil
+		return &record{
+			features: features,
+			eventEnd: eventEnd,
+			filter:   filter,
+			control:  ctrl,
+		}
+	}
 }
 
 var graphTemplate = template.Must(template.New("callgraph").Parse(`digraph callgraph {
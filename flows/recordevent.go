@@ -0,0 +1,185 @@
+package flows
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	ipfix "github.com/CN-TU/go-ipfix"
+	"github.com/chtisgit/go-flows/spec"
+)
+
+// RecordEvent is an Event consisting of a record exported by another group of features. This allows to aggregate
+// flows again (e.g. host profiles per hour), where the key, the timeouts, and the features apply to records instead of
+// packets. The fields of the record are accessed by features with field (e.g. sum(field("octetTotalCount"))).
+type RecordEvent struct {
+	template Template
+	features []interface{}
+	fields   map[string]int
+	key      []byte
+	hash     uint64
+	when     DateTimeNanoseconds
+	window   uint64
+	nr       uint64
+}
+
+// NewRecordEvent returns the event for the exported features with the given template. fields maps the names of the
+// fields to the index in features. key is the flow key; the event happens at when and is the nrth event.
+func NewRecordEvent(template Template, features []interface{}, fields map[string]int, key []byte, when DateTimeNanoseconds, nr uint64) *RecordEvent {
+	return &RecordEvent{
+		template: template,
+		features: features,
+		fields:   fields,
+		key:      key,
+		hash:     HashKey(key),
+		when:     when,
+		nr:       nr,
+	}
+}
+
+func (r *RecordEvent) Timestamp() DateTimeNanoseconds { return r.when }
+func (r *RecordEvent) Key() []byte                    { return r.key }
+func (r *RecordEvent) KeyHash() uint64                { return r.hash }
+func (r *RecordEvent) LowToHigh() bool                { return true }
+func (r *RecordEvent) SetWindow(window uint64)        { r.window = window }
+func (r *RecordEvent) Window() uint64                 { return r.window }
+func (r *RecordEvent) EventNr() uint64                { return r.nr }
+
+// Template returns the template of the record
+func (r *RecordEvent) Template() Template { return r.template }
+
+// Features returns the exported features of the record
+func (r *RecordEvent) Features() []interface{} { return r.features }
+
+// Field returns the value of the field with the given name or nil if the record doesn't contain the field
+func (r *RecordEvent) Field(name string) interface{} {
+	if i, ok := r.fields[name]; ok && i < len(r.features) {
+		return r.features[i]
+	}
+	return nil
+}
+
+// RecordFields holds the information elements of the fields of processed records by name (see MakeRecordFields)
+type RecordFields map[string]ipfix.InformationElement
+
+// MakeRecordFields returns the fields exported by records, which are available to field in the feature group
+// processing these records; field takes the type from the record. If fields with the same name have different types
+// (e.g. because of variants), the first one is used; a different type can be chosen with cast.
+func MakeRecordFields(records RecordListMaker) RecordFields {
+	fields := make(RecordFields)
+	records.FieldInformationElements(func(name string, ie ipfix.InformationElement) {
+		if _, ok := fields[name]; !ok {
+			fields[name] = ipfix.NewInformationElement("", 0, 0, ie.Type, ie.Length)
+		}
+	})
+	return fields
+}
+
+func fieldName(value interface{}) (string, error) {
+	if value == nil {
+		return "", errors.New("field: name must be a constant")
+	}
+	name, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("field: name must be a string, but is %v", value)
+	}
+	return name, nil
+}
+
+// resolveField only gets called for feature groups processing packets, since the fields of records are resolved by
+// bindFields
+func resolveField(args []ipfix.InformationElement, constants []interface{}) (ipfix.InformationElement, error) {
+	return ipfix.InformationElement{}, errors.New("field: only feature groups processing records have fields (see features -from)")
+}
+
+// bindFields resolves the calls of field in fragment with the information elements of the fields. Packet features
+// with the name of a field (e.g. sourceIPAddress) are replaced with the field, while other packet features are
+// rejected, since records aren't packets.
+func (fields RecordFields) bindFields(fragment astFragment) (astFragment, error) {
+	c, ok := fragment.(*astCall)
+	if !ok || c.control {
+		return fragment, nil
+	}
+	if c.name == "field" && c.feature.function {
+		constant, ok := c.args[0].(*astConstant)
+		if !ok {
+			return nil, errors.New("field: name must be a constant")
+		}
+		name, err := fieldName(constantValue(constant.value))
+		if err != nil {
+			return nil, err
+		}
+		ie, ok := fields[name]
+		if !ok {
+			return nil, fmt.Errorf("field: the processed records have no field named '%s'", name)
+		}
+		variant := toVariant(ie, c)
+		variant.SetName(c.exportName)
+		c.SetVariants(variant)
+		return c, nil
+	}
+	for i, arg := range c.args {
+		if !arg.IsRaw() {
+			var err error
+			if c.args[i], err = fields.bindFields(arg); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if _, ok := fields[c.name]; !ok || !c.bare {
+			return nil, fmt.Errorf("feature '%s' needs packets, but the feature group processes records (fields are accessed with field)", c.name)
+		}
+		name, err := makeASTConstant(spec.String(c.name), c.id)
+		if err != nil {
+			return nil, err
+		}
+		ret := &astCall{
+			astBase: astBase{
+				id:   c.id,
+				name: "field",
+			},
+			args: []astFragment{name},
+		}
+		ret.assign(c)
+		if err := ret.build(c.ret); err != nil {
+			return nil, err
+		}
+		return fields.bindFields(ret)
+	}
+	return c, nil
+}
+
+// field returns the value of a field of the record; the flow variant returns the value of the first record
+type field struct {
+	BaseFeature
+	name string
+	flow bool
+}
+
+func (f *field) SetArguments(arguments []int, features []Feature) {
+	name, ok := features[arguments[0]].Value().(string)
+	if !ok {
+		log.Fatalf("field: name must be a string, but is %v", features[arguments[0]].Value())
+	}
+	f.name = name
+}
+
+// CheckpointState returns nothing, since the name is fixed
+func (f *field) CheckpointState() []interface{} { return nil }
+
+func (f *field) Event(new interface{}, context *EventContext, src interface{}) {
+	record, ok := new.(*RecordEvent)
+	if !ok || (f.flow && f.Value() != nil) {
+		return
+	}
+	value := record.Field(f.name)
+	if f.flow && value == nil {
+		return
+	}
+	f.SetValue(value, context, f)
+}
+
+func init() {
+	registerConstantFunction("field", "value of the field with name a of the record exported by another feature group (see features -from)", resolveField, true, PacketFeature, func() Feature { return &field{} }, Const)
+	registerConstantFunction("field", "value of the field with name a of the first record of the flow exported by another feature group (see features -from)", resolveField, true, FlowFeature, func() Feature { return &field{flow: true} }, Const)
+}
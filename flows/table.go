@@ -18,7 +18,7 @@ type TableStats struct {
 // FlowTable holds flows assigned to flow keys and handles expiry, events, and flow creation.
 type FlowTable struct {
 	FlowOptions
-	flows     map[string]int
+	flows     flowMap
 	flowlist  []Flow
 	freelist  []int
 	newflow   FlowCreator
@@ -44,7 +44,7 @@ func NewFlowTable(records RecordListMaker, newflow FlowCreator, options FlowOpti
 		}
 	}
 	ret := &FlowTable{
-		flows:       make(map[string]int),
+		flows:       makeFlowMap(),
 		newflow:     newflow,
 		FlowOptions: options,
 		records:     records,
@@ -95,6 +95,7 @@ func (tab *FlowTable) Event(event Event) {
 	tab.Stats.Packets++
 	when := event.Timestamp()
 	key := event.Key()
+	hash := event.KeyHash()
 	lowToHigh := event.LowToHigh()
 
 	tab.context.when = when
@@ -107,7 +108,7 @@ func (tab *FlowTable) Event(event Event) {
 		}
 	}
 
-	elem, ok := tab.flows[key]
+	elem, ok := tab.flows.get(hash, key)
 	if ok {
 		elem := tab.flowlist[elem]
 		if elem != nil {
@@ -124,7 +125,11 @@ func (tab *FlowTable) Event(event Event) {
 		}
 	}
 	if !ok {
-		elem := tab.newflow(event, tab, key, lowToHigh, tab.context, tab.flowID)
+		flowKey := string(key)
+		elem := tab.newflow(event, tab, flowKey, lowToHigh, tab.context, tab.flowID)
+		if base, ok := elem.(baseFlower); ok {
+			base.baseFlow().self = elem
+		}
 		tab.flowID++
 		tab.Stats.Flows++
 		var new int
@@ -136,8 +141,8 @@ func (tab *FlowTable) Event(event Event) {
 			new, tab.freelist = tab.freelist[freelen-1], tab.freelist[:freelen-1]
 			tab.flowlist[new] = elem
 		}
-		tab.flows[key] = new
-		nflows := uint64(len(tab.flows))
+		tab.flows.put(hash, flowKey, new)
+		nflows := uint64(tab.flows.len())
 		if nflows > tab.Stats.Maxflows {
 			tab.Stats.Maxflows = nflows
 		}
@@ -212,10 +217,11 @@ func (tab *FlowTable) flushAllExports() {
 
 func (tab *FlowTable) remove(entry Flow) {
 	if !tab.eof {
-		old := tab.flows[entry.Key()]
-		tab.flowlist[old] = nil
-		tab.freelist = append(tab.freelist, old)
-		delete(tab.flows, entry.Key())
+		key := entry.Key()
+		if old, ok := tab.flows.remove(hashKeyString(key), key); ok {
+			tab.flowlist[old] = nil
+			tab.freelist = append(tab.freelist, old)
+		}
 	}
 }
 
@@ -236,7 +242,7 @@ func (tab *FlowTable) EOF(now DateTimeNanoseconds) {
 			v.EOF(context)
 		}
 	}
-	tab.flows = make(map[string]int)
+	tab.flows = makeFlowMap()
 	tab.flowlist = nil
 	tab.freelist = nil
 	tab.expiring = false
@@ -264,9 +270,7 @@ func (tab *FlowTable) expireWindow() {
 			v.Export(FlowEndReasonEnd, context, now)
 		}
 	}
-	for k := range tab.flows {
-		delete(tab.flows, k)
-	}
+	tab.flows.clear()
 	for i := range tab.flowlist {
 		tab.flowlist[i] = nil
 	}
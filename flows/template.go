@@ -71,6 +71,19 @@ func (e *emptyTemplate) String() string {
 	return "<illegal>"
 }
 
+// leafTemplates appends every leaf template of the template tree to list
+func leafTemplates(t Template, list []*leafTemplate) []*leafTemplate {
+	switch t := t.(type) {
+	case *leafTemplate:
+		list = append(list, t)
+	case *multiTemplate:
+		for _, sub := range t.templates {
+			list = leafTemplates(sub, list)
+		}
+	}
+	return list
+}
+
 func makeLeafTemplate(ies []ipfix.InformationElement, id *int) Template {
 	ret := &leafTemplate{id: *id, ies: ies}
 	*id++
@@ -283,6 +283,28 @@ func (v *astVariant) SetName(name string) {
 	}
 }
 
+// commonType returns the type of every variant, or ipfix.IllegalType if the variants have different types
+func commonType(v maybeASTVariant) ipfix.Type {
+	switch v := v.(type) {
+	case *singleVariant:
+		return v.ie.Type
+	case *astVariant:
+		var t ipfix.Type = ipfix.IllegalType
+		for _, sub := range v.ies {
+			if _, ok := sub.(*noVariant); ok {
+				continue
+			}
+			subType := commonType(sub)
+			if subType == ipfix.IllegalType || (t != ipfix.IllegalType && t != subType) {
+				return ipfix.IllegalType
+			}
+			t = subType
+		}
+		return t
+	}
+	return ipfix.IllegalType
+}
+
 type singleVariant struct {
 	ie ipfix.InformationElement
 }
@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"go/parser"
 	"go/token"
-	"html/template"
 	"io/ioutil"
 	"os"
 	"os/exec"
@@ -13,6 +12,8 @@ import (
 	"runtime"
 	"strings"
 	"syscall"
+	"text/template"
+	"time"
 )
 
 var thisPath string
@@ -176,6 +177,37 @@ import (
 func main() {}
 `))
 
+var manifestTemplate = template.Must(template.New("manifest").Parse(`package main
+
+import "github.com/chtisgit/go-flows/util"
+
+// GoFlowsManifest describes this plugin
+var GoFlowsManifest = util.PluginManifest{
+	APIVersion: util.PluginAPIVersion,
+	Modules:    []string{ {{- range $i, $m := .Modules}}{{if $i}}, {{end}}{{printf "%q" $m}}{{end -}} },
+	Build:      {{printf "%q" .Build}},
+}
+`))
+
+// writeManifest writes the plugin manifest into the given directory and returns the file name
+func writeManifest(dir string, modules []string, flags []string) string {
+	f := filepath.Join(dir, "goFlowsManifest.go")
+	manifestfile, err := os.Create(f)
+	if err != nil {
+		panic(fmt.Sprint("Error creating temporary file for plugin manifest: ", err))
+	}
+	defer manifestfile.Close()
+	build := fmt.Sprintf("go-flows-build at %s", time.Now().UTC().Format(time.RFC3339))
+	if len(flags) != 0 {
+		build += fmt.Sprintf(" with flags %s", strings.Join(flags, " "))
+	}
+	manifestTemplate.Execute(manifestfile, struct {
+		Modules []string
+		Build   string
+	}{modules, build})
+	return f
+}
+
 func plugin(flags []string, files []string, builtin modules, tempdir string) {
 	if len(files) == 0 {
 		usage()
@@ -199,7 +231,10 @@ func plugin(flags []string, files []string, builtin modules, tempdir string) {
 				pluginTemplate.Execute(pluginfile, m.imp)
 				pluginfile.Close()
 
-				files[0] = f
+				manifest := writeManifest(tempdir, []string{m.imp}, flags)
+				defer os.Remove(manifest)
+
+				files = []string{f, manifest}
 				goto FOUND
 			}
 		}
@@ -222,6 +257,10 @@ func plugin(flags []string, files []string, builtin modules, tempdir string) {
 		name = name[:len(name)-len(ext)]
 	}
 
+	// files of a package must be in the same directory
+	files = append(files, writeManifest(filepath.Dir(files[0]), []string{name}, flags))
+	defer os.Remove(files[len(files)-1])
+
 FOUND:
 
 	name = fmt.Sprintf("go-flows.%s.defs.so", name)
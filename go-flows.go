@@ -7,13 +7,17 @@ import (
 	"log"
 	"os"
 	"path/filepath"
-	"plugin"
 	"runtime"
 	"runtime/pprof"
 	"runtime/trace"
 	"sort"
 	"strings"
 	"text/tabwriter"
+
+	"github.com/chtisgit/go-flows/modules/features/anonymize"
+	"github.com/chtisgit/go-flows/modules/features/geoip"
+	"github.com/chtisgit/go-flows/modules/features/script"
+	"github.com/chtisgit/go-flows/util"
 )
 
 var (
@@ -84,6 +88,11 @@ func main() {
 	flag.CommandLine.Usage = usage
 	var defs plugins
 	flag.Var(&defs, "defs", "Load definitions from directory. Definition files must follow the name scheme go-flows*.defs.so.")
+	var scripts plugins
+	flag.Var(&scripts, "script", "Load feature script (Starlark). Can be given multiple times.")
+	var geoipDBs plugins
+	flag.Var(&geoipDBs, "geoip", "Load MaxMind DB (.mmdb) for the geoip features. Can be given multiple times.")
+	anonymizeKey := flag.String("anonymizeKey", "", "Load the key (32 bytes raw or hex encoded) for anonymize and -anonymize")
 	flag.Parse()
 	if *memprofilerate != 0 {
 		runtime.MemProfileRate = *memprofilerate
@@ -141,14 +150,36 @@ func main() {
 				log.Fatalf("Couldn't find definitions: %s\n", err)
 			} else {
 				for _, def := range defs {
-					if _, err := plugin.Open(def); err != nil {
+					manifest, err := util.LoadPlugin(def)
+					if err != nil {
 						log.Fatalf("Couldn't load '%s' because of: %s", def, err)
 					}
+					if manifest == nil {
+						log.Printf("Warning: plugin '%s' has no manifest (not built with go-flows-build?)\n", def)
+					}
 				}
 			}
 		}
 	}
 
+	for _, file := range scripts {
+		if err := script.Load(file); err != nil {
+			log.Fatalf("Couldn't load script: %s", err)
+		}
+	}
+
+	for _, file := range geoipDBs {
+		if err := geoip.Load(file); err != nil {
+			log.Fatalf("Couldn't load geoip database: %s", err)
+		}
+	}
+
+	if *anonymizeKey != "" {
+		if err := anonymize.Load(*anonymizeKey); err != nil {
+			log.Fatalf("Couldn't load anonymization key: %s", err)
+		}
+	}
+
 	for _, command := range commands {
 		if flag.Arg(0) == command.cmd {
 			command.run(command.cmd, flag.Args()[1:])
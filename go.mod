@@ -1,9 +1,16 @@
 module github.com/chtisgit/go-flows
 
-go 1.12
+go 1.18
 
 require (
-	github.com/CN-TU/go-flows v0.0.0-20191011100928-68b64ace54e2 // indirect
 	github.com/CN-TU/go-ipfix v0.0.0-20190607191022-b148a3a1167d
 	github.com/google/gopacket v1.1.17
+	github.com/oschwald/maxminddb-golang v1.3.1
+	go.starlark.net v0.0.0-20190702223751-32f345186213
+)
+
+require (
+	github.com/CN-TU/go-flows v0.0.0-20191011100928-68b64ace54e2 // indirect
+	golang.org/x/net v0.0.0-20190404232315-eb5bcb51f2a3 // indirect
+	golang.org/x/sys v0.0.0-20190405154228-4b34438f7a67 // indirect
 )
@@ -63,7 +63,7 @@ func init() {
 				t := tabwriter.NewWriter(os.Stderr, 3, 4, 5, ' ', 0)
 				for _, desc := range descs {
 					line := new(bytes.Buffer)
-					fmt.Fprintf(line, "%s\t%s\n", desc.Name(), desc.Description())
+					fmt.Fprintf(line, "%s\t%s\n", desc.Name(), util.DescribeProvider(desc.Description(), desc.Provider()))
 					t.Write(line.Bytes())
 				}
 				t.Flush()
@@ -1,6 +1,8 @@
 package ipfix
 
 import (
+	"bytes"
+	"encoding/binary"
 	"errors"
 	"flag"
 	"fmt"
@@ -17,6 +19,10 @@ import (
 const pen uint32 = 1234
 const tmpBase uint16 = 0x7000
 
+// metadataTemplate is the id of the options template of the metadata, which must not collide with the template ids
+// allocated by the message stream (counting up from 256)
+const metadataTemplate uint16 = 0xffff
+
 type ipfixExporter struct {
 	id        string
 	outfile   string
@@ -24,6 +30,7 @@ type ipfixExporter struct {
 	out       io.WriteCloser
 	spec      io.WriteCloser
 	writer    *ipfix.MessageStream
+	sequence  *sequenceWriter
 	allocated map[string]ipfix.InformationElement
 	templates []int
 	now       flows.DateTimeNanoseconds
@@ -51,6 +58,77 @@ func (pe *ipfixExporter) Export(template flows.Template, features []interface{},
 	pe.now = when
 }
 
+// ExportMetadata writes the given information as options record (RFC 7011 Section 3.4.2.2) with the selection sequence
+// as scope (RFC 5477) in its own message. This must happen before the message stream writes the first message.
+func (pe *ipfixExporter) ExportMetadata(ies []ipfix.InformationElement, values []interface{}, when flows.DateTimeNanoseconds) {
+	scope, err := ipfix.GetInformationElement("selectionSequenceId")
+	if err != nil {
+		log.Panic(err)
+	}
+	msg, err := optionsMessage(scope, uint64(1), ies, values, when)
+	if err != nil {
+		log.Panic(err)
+	}
+	binary.BigEndian.PutUint32(msg[8:12], pe.sequence.offset)
+	if _, err := pe.out.Write(msg); err != nil {
+		log.Panic(err)
+	}
+	// the sequence number counts data records
+	pe.sequence.offset++
+	pe.now = when
+}
+
+// optionsMessage returns a message consisting of an options template set with the given scope and a data set with the
+// values. go-ipfix has no support for options templates, which means the message is serialized with an ordinary
+// template that is converted afterwards.
+func optionsMessage(scope ipfix.InformationElement, scopeValue interface{}, ies []ipfix.InformationElement, values []interface{}, when flows.DateTimeNanoseconds) ([]byte, error) {
+	var buf bytes.Buffer
+	stream, err := ipfix.MakeMessageStream(&buf, 65535, 0)
+	if err != nil {
+		return nil, err
+	}
+	id, err := stream.AddTemplate(when, append([]ipfix.InformationElement{scope}, ies...)...)
+	if err != nil {
+		return nil, err
+	}
+	if err := stream.SendData(when, id, append([]interface{}{scopeValue}, values...)...); err != nil {
+		return nil, err
+	}
+	if err := stream.Flush(when); err != nil {
+		return nil, err
+	}
+	// message header (16 bytes), template set (set header, template id, field count, fields), data set
+	msg := buf.Bytes()
+	templateSet := int(binary.BigEndian.Uint16(msg[18:20]))
+	ret := make([]byte, 0, len(msg)+2)
+	ret = append(ret, msg[:16]...)
+	ret = append(ret, 0, 3, 0, 0, 0, 0)
+	binary.BigEndian.PutUint16(ret[18:20], uint16(templateSet+2))
+	binary.BigEndian.PutUint16(ret[20:22], metadataTemplate)
+	ret = append(ret, msg[22:24]...)
+	ret = append(ret, 0, 1) // scope field count
+	ret = append(ret, msg[24:16+templateSet]...)
+	data := len(ret)
+	ret = append(ret, msg[16+templateSet:]...)
+	binary.BigEndian.PutUint16(ret[data:], metadataTemplate)
+	binary.BigEndian.PutUint16(ret[2:4], uint16(len(ret)))
+	return ret, nil
+}
+
+// sequenceWriter adds offset to the sequence number of every message written by the message stream, which doesn't know
+// about the metadata records
+type sequenceWriter struct {
+	w      io.Writer
+	offset uint32
+}
+
+func (s *sequenceWriter) Write(msg []byte) (int, error) {
+	if len(msg) >= 16 {
+		binary.BigEndian.PutUint32(msg[8:12], binary.BigEndian.Uint32(msg[8:12])+s.offset)
+	}
+	return s.w.Write(msg)
+}
+
 //Finish Write outstanding data and wait for completion
 func (pe *ipfixExporter) Finish() {
 	pe.writer.Flush(pe.now)
@@ -126,7 +204,8 @@ func (pe *ipfixExporter) Init() {
 			log.Fatal("Couldn't open file ", pe.specfile, err)
 		}
 	}
-	pe.writer, err = ipfix.MakeMessageStream(pe.out, 65535, 0)
+	pe.sequence = &sequenceWriter{w: pe.out}
+	pe.writer, err = ipfix.MakeMessageStream(pe.sequence, 65535, 0)
 	if err != nil {
 		log.Fatal("Couldn't create ipfix message stream: ", err)
 	}
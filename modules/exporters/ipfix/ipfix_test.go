@@ -0,0 +1,61 @@
+package ipfix
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/CN-TU/go-ipfix"
+)
+
+func TestExportMetadata(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ipfix")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ipfix.LoadIANASpec()
+	name := filepath.Join(dir, "out.ipfix")
+	pe := &ipfixExporter{outfile: name}
+	pe.Init()
+	algorithm, err := ipfix.GetInformationElement("selectorAlgorithm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pe.ExportMetadata([]ipfix.InformationElement{algorithm}, []interface{}{uint16(1)}, 1e9)
+	octets, err := ipfix.GetInformationElement("octetTotalCount")
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := pe.writer.AddTemplate(pe.now, octets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pe.writer.SendData(pe.now, id, uint64(1))
+	pe.Finish()
+
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	length := int(binary.BigEndian.Uint16(data[2:4]))
+	metadata, rest := data[:length], data[length:]
+	if set := binary.BigEndian.Uint16(metadata[16:18]); set != 3 {
+		t.Errorf("Expected an options template set, but got set %d", set)
+	}
+	if template, scope := binary.BigEndian.Uint16(metadata[20:22]), binary.BigEndian.Uint16(metadata[24:26]); template != metadataTemplate || scope != 1 {
+		t.Errorf("Expected options template %d with 1 scope field, but got %d with %d", metadataTemplate, template, scope)
+	}
+	templateSet := int(binary.BigEndian.Uint16(metadata[18:20]))
+	if set := binary.BigEndian.Uint16(metadata[16+templateSet:]); set != metadataTemplate {
+		t.Errorf("Expected data set %d, but got %d", metadataTemplate, set)
+	}
+	if binary.BigEndian.Uint32(metadata[4:8]) != 1 {
+		t.Error("Expected the export time of the metadata in the header")
+	}
+	if len(rest) < 16 || binary.BigEndian.Uint32(rest[8:12]) != 1 {
+		t.Error("Expected the sequence number of the next message to count the metadata record")
+	}
+}
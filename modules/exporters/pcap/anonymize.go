@@ -0,0 +1,102 @@
+package pcap
+
+import (
+	"encoding/binary"
+	"net"
+
+	"github.com/chtisgit/go-flows/modules/features/anonymize"
+	"github.com/google/gopacket/layers"
+)
+
+// checksumDelta adds the one's complement difference between old and new to sum (RFC 1624). old and new must have the
+// same even length.
+func checksumDelta(sum uint32, old, new []byte) uint32 {
+	for i := 0; i+1 < len(old); i += 2 {
+		sum += uint32(^binary.BigEndian.Uint16(old[i:]))
+		sum += uint32(binary.BigEndian.Uint16(new[i:]))
+	}
+	return sum
+}
+
+// updateChecksum applies the delta computed by checksumDelta to the checksum in field
+func updateChecksum(field []byte, delta uint32) {
+	sum := uint32(^binary.BigEndian.Uint16(field)) + delta
+	for sum>>16 != 0 {
+		sum = sum&0xffff + sum>>16
+	}
+	binary.BigEndian.PutUint16(field, ^uint16(sum))
+}
+
+// replaceAddress overwrites the address in field with the anonymized one and returns the updated checksum delta
+func replaceAddress(key *anonymize.CryptoPAn, field []byte, delta uint32) uint32 {
+	old := append([]byte(nil), field...)
+	copy(field, key.IP(net.IP(field)))
+	return checksumDelta(delta, old, field)
+}
+
+// replacePort overwrites the port in field with the anonymized one and returns the updated checksum delta
+func replacePort(key *anonymize.CryptoPAn, field []byte, delta uint32) uint32 {
+	old := append([]byte(nil), field...)
+	binary.BigEndian.PutUint16(field, key.Port(binary.BigEndian.Uint16(field)))
+	return checksumDelta(delta, old, field)
+}
+
+// anonymizePacket anonymizes the MAC and IP addresses (and the TCP and UDP ports, if ports is true) of data in place
+// and fixes the IPv4 header, TCP, UDP, and ICMPv6 checksums. Addresses inside of payloads (e.g. ARP, ICMP errors) and
+// inside of headers, which are cut off by the capture, are not changed.
+func anonymizePacket(key *anonymize.CryptoPAn, data []byte, linkType layers.LinkType, ports bool) {
+	offset := 0
+	var delta uint32 // pseudo header change of the innermost IP layer
+	for _, layer := range decode(data, linkType).Layers() {
+		header := data[offset:]
+		switch layer.(type) {
+		case *layers.Ethernet:
+			copy(header[0:6], key.MAC(net.HardwareAddr(header[0:6])))
+			copy(header[6:12], key.MAC(net.HardwareAddr(header[6:12])))
+		case *layers.LinuxSLL:
+			if binary.BigEndian.Uint16(header[4:6]) == 6 {
+				copy(header[6:12], key.MAC(net.HardwareAddr(header[6:12])))
+			}
+		case *layers.IPv4:
+			delta = replaceAddress(key, header[12:16], 0)
+			delta = replaceAddress(key, header[16:20], delta)
+			updateChecksum(header[10:12], delta)
+		case *layers.IPv6:
+			delta = replaceAddress(key, header[8:24], 0)
+			delta = replaceAddress(key, header[24:40], delta)
+		case *layers.TCP:
+			if ports {
+				delta = replacePort(key, header[0:2], delta)
+				delta = replacePort(key, header[2:4], delta)
+			}
+			updateChecksum(header[16:18], delta)
+		case *layers.UDP:
+			if ports {
+				delta = replacePort(key, header[0:2], delta)
+				delta = replacePort(key, header[2:4], delta)
+			}
+			if binary.BigEndian.Uint16(header[6:8]) != 0 {
+				updateChecksum(header[6:8], delta)
+				if binary.BigEndian.Uint16(header[6:8]) == 0 {
+					binary.BigEndian.PutUint16(header[6:8], 0xffff)
+				}
+			}
+		case *layers.ICMPv6:
+			updateChecksum(header[2:4], delta)
+		}
+		offset += len(layer.LayerContents())
+	}
+}
+
+// Anonymize returns a copy of the flow with anonymized packets (see anonymize.Anonymizer). This is used by the
+// -anonymize option of the features command; the packets are not anonymized again by the -anonymize option of the
+// exporter.
+func (f *Flow) Anonymize(key *anonymize.CryptoPAn, ports bool) interface{} {
+	ret := &Flow{ID: f.ID, Packets: make([]Packet, len(f.Packets)), anonymized: true}
+	for i, packet := range f.Packets {
+		packet.Data = append([]byte(nil), packet.Data...)
+		anonymizePacket(key, packet.Data, packet.LinkType, ports)
+		ret.Packets[i] = packet
+	}
+	return ret
+}
@@ -0,0 +1,59 @@
+package pcap
+
+import (
+	"bufio"
+	"os"
+
+	"github.com/chtisgit/go-flows/modules/features/anonymize"
+	"github.com/chtisgit/go-flows/packet"
+)
+
+// captureWriter writes the packets of captured flows to a pcapng file
+type captureWriter struct {
+	file    *os.File
+	writer  *bufio.Writer
+	packets *ngWriter
+	key     *anonymize.CryptoPAn
+	ports   bool
+}
+
+// NewCaptureWriter creates the pcapng file with the given name and returns a writer for the packets of captured flows
+// (see packet.Capture). If key is not nil, the packets are anonymized with it like the -anonymize option of the
+// exporter does, and the TCP and UDP ports, too, if ports is true.
+func NewCaptureWriter(name string, key *anonymize.CryptoPAn, ports bool) (packet.CaptureWriter, error) {
+	f, err := os.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	writer := bufio.NewWriterSize(f, writeBufferSize)
+	return &captureWriter{
+		file:    f,
+		writer:  writer,
+		packets: newNgWriter(writer, 262144),
+		key:     key,
+		ports:   ports,
+	}, nil
+}
+
+func (c *captureWriter) WritePackets(id uint64, packets []packet.CapturedPacket) error {
+	for i := range packets {
+		p := &packets[i]
+		data := p.Data
+		if c.key != nil {
+			data = append([]byte(nil), data...)
+			anonymizePacket(c.key, data, p.LinkType, c.ports)
+		}
+		if err := c.packets.writePacket(p.CaptureInfo, data, p.LinkType, packetComment(id, p.Label)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *captureWriter) Close() error {
+	if err := c.writer.Flush(); err != nil {
+		c.file.Close()
+		return err
+	}
+	return c.file.Close()
+}
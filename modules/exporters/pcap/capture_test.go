@@ -0,0 +1,146 @@
+package pcap
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"testing"
+
+	"github.com/chtisgit/go-flows/flows"
+	"github.com/chtisgit/go-flows/modules/features/anonymize"
+	_ "github.com/chtisgit/go-flows/modules/features/iana"
+	_ "github.com/chtisgit/go-flows/modules/features/operations"
+	"github.com/chtisgit/go-flows/packet"
+	"github.com/chtisgit/go-flows/packet_test"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+)
+
+// readComments returns the number of packets in the pcapng file and the comments in the file
+func readComments(t *testing.T, name string) (int, []string) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := pcapgo.NewNgReader(bytes.NewReader(data), pcapgo.NgReaderOptions{WantMixedLinkType: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for {
+		if _, _, err := r.ReadPacketData(); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		n++
+	}
+	// pcapgo doesn't return the comments of packets
+	var comments []string
+	for _, comment := range regexp.MustCompile(`flow=\d+ label=\d+`).FindAll(data, -1) {
+		comments = append(comments, string(comment))
+	}
+	return n, comments
+}
+
+// makeCapture returns a capture writing to the pcapng file with the given name
+func makeCapture(t *testing.T, table *packet_test.TestTable, name string, config packet.CaptureConfig) *packet.Capture {
+	writer, err := NewCaptureWriter(name, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Writer = writer
+	return table.EnableCapture(config)
+}
+
+func TestCapture(t *testing.T) {
+	dir, err := ioutil.TempDir("", "capture")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "capture.pcapng")
+
+	table := packet_test.MakeFilteredFeatureTest(t, []interface{}{"flowId"}, []string{"running_sum(ipTotalLength) > 100"}, nil, flows.FlowOptions{})
+	capture := makeCapture(t, &table, name, packet.CaptureConfig{Packets: 1, Bytes: 1000})
+	for i := 1; i <= 5; i++ {
+		table.EventLabeledLayers(flows.DateTimeNanoseconds(i), i, &layers.IPv4{SrcIP: net.IP{10, 0, 0, 1}, DstIP: net.IP{10, 0, 0, 2}, Protocol: layers.IPProtocolUDP, Length: 40}, &layers.UDP{SrcPort: 1000, DstPort: 53})
+		// never exceeds the threshold
+		table.EventLabeledLayers(flows.DateTimeNanoseconds(i), i+10, &layers.IPv4{SrcIP: net.IP{10, 0, 0, 3}, DstIP: net.IP{10, 0, 0, 2}, Protocol: layers.IPProtocolUDP, Length: 20}, &layers.UDP{SrcPort: 1000, DstPort: 53})
+	}
+	table.Finish(6)
+	if n := capture.Buffered(); n != 0 {
+		t.Errorf("expected empty ring buffers, but %d bytes are buffered", n)
+	}
+	if err := capture.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	table.AssertFeatureList([]packet_test.FeatureLine{
+		{When: 6, Features: []packet_test.FeatureResult{{Name: "flowId", Value: uint64(0)}}},
+	})
+	n, comments := readComments(t, name)
+	expected := []string{"flow=0 label=2", "flow=0 label=3", "flow=0 label=4", "flow=0 label=5"}
+	if n != len(expected) || !reflect.DeepEqual(comments, expected) {
+		t.Errorf("expected packets %v, but got %d packets with %v", expected, n, comments)
+	}
+}
+
+func TestCaptureAnonymization(t *testing.T) {
+	dir, err := ioutil.TempDir("", "capture")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "capture.pcapng")
+
+	key, err := anonymize.NewCryptoPAn(bytes.Repeat([]byte{1}, anonymize.KeySize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	flow := testFlow(t)
+	original := append([]byte(nil), flow.Packets[0].Data...)
+	writer, err := NewCaptureWriter(name, key, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	packets := make([]packet.CapturedPacket, len(flow.Packets))
+	for i, p := range flow.Packets {
+		packets[i] = packet.CapturedPacket(p)
+	}
+	if err := writer.WritePackets(1, packets); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := pcapgo.NewNgReader(f, pcapgo.NgReaderOptions{WantMixedLinkType: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, p := range flow.Packets {
+		data, _, err := r.ReadPacketData()
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := append([]byte(nil), p.Data...)
+		anonymizePacket(key, expected, p.LinkType, true)
+		if !bytes.Equal(data, expected) {
+			t.Errorf("packet %d wasn't anonymized", i)
+		}
+	}
+	if !bytes.Equal(flow.Packets[0].Data, original) {
+		t.Error("the captured packet was modified")
+	}
+}
@@ -0,0 +1,117 @@
+package pcap
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	ipfix "github.com/CN-TU/go-ipfix"
+	"github.com/chtisgit/go-flows/flows"
+	"github.com/chtisgit/go-flows/packet"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// Packet is a copy of a captured packet
+type Packet struct {
+	CaptureInfo gopacket.CaptureInfo
+	Data        []byte
+	LinkType    layers.LinkType
+	Label       interface{}
+}
+
+// Flow holds the packets of a flow. This is the value of the __flowPackets feature.
+type Flow struct {
+	// ID is the same as the value of the flowId feature
+	ID      uint64
+	Packets []Packet
+	// anonymized is true, if the packets were already anonymized
+	anonymized bool
+}
+
+func (f *Flow) String() string {
+	return fmt.Sprintf("flow %d (%d packets)", f.ID, len(f.Packets))
+}
+
+// comment returns the pcapng comment of the i-th packet
+func (f *Flow) comment(i int) string {
+	return packetComment(f.ID, f.Packets[i].Label)
+}
+
+// packetComment returns the pcapng comment for a packet of the given flow with the given label
+func packetComment(id uint64, label interface{}) string {
+	ret := fmt.Sprintf("flow=%d", id)
+	switch label := label.(type) {
+	case nil:
+	case packet.NamedLabel:
+		names := make([]string, 0, len(label))
+		for name := range label {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			ret += fmt.Sprintf(" label.%s=%v", name, label[name])
+		}
+	default:
+		ret += fmt.Sprintf(" label=%v", label)
+	}
+	return strings.Replace(ret, "\n", " ", -1)
+}
+
+// makePacket returns a Packet referencing the data of buffer; the data must be copied to keep the packet around
+func makePacket(buffer packet.Buffer) Packet {
+	ci := buffer.Metadata().CaptureInfo
+	ci.CaptureLength = len(buffer.Data())
+	return Packet{
+		CaptureInfo: ci,
+		Data:        buffer.Data(),
+		LinkType:    buffer.LinkType(),
+		Label:       buffer.Label(),
+	}
+}
+
+// copyPacket returns a copy of buffer
+func copyPacket(buffer packet.Buffer) Packet {
+	ret := makePacket(buffer)
+	ret.Data = append([]byte(nil), ret.Data...)
+	return ret
+}
+
+// flowID returns the same value as the flowId feature
+func flowID(context *flows.EventContext) uint64 {
+	flow := context.Flow()
+	id := flow.ID() & 0x00FFFFFFFFFFFFFF
+	return id | uint64(flow.Table().ID())<<56
+}
+
+// decode decodes data without copying it. gopacket has no decoders for the IPv4 and IPv6 link types, which are
+// decoded like raw packets.
+func decode(data []byte, linkType layers.LinkType) gopacket.Packet {
+	if linkType == layers.LinkTypeIPv4 || linkType == layers.LinkTypeIPv6 {
+		linkType = layers.LinkTypeRaw
+	}
+	return gopacket.NewPacket(data, linkType, gopacket.DecodeOptions{NoCopy: true})
+}
+
+type flowPackets struct {
+	flows.BaseFeature
+	packets []Packet
+}
+
+func (f *flowPackets) Start(context *flows.EventContext) {
+	f.BaseFeature.Start(context)
+	f.packets = nil
+}
+
+func (f *flowPackets) Event(new interface{}, context *flows.EventContext, src interface{}) {
+	f.packets = append(f.packets, copyPacket(new.(packet.Buffer)))
+}
+
+func (f *flowPackets) Stop(reason flows.FlowEndReason, context *flows.EventContext) {
+	f.SetValue(&Flow{ID: flowID(context), Packets: f.packets}, context, f)
+	f.packets = nil
+}
+
+func init() {
+	flows.RegisterTemporaryFeature("__flowPackets", "copies of the packets of the flow for the pcap exporter", ipfix.OctetArrayType, 0, flows.FlowFeature, func() flows.Feature { return &flowPackets{} }, flows.RawPacket)
+}
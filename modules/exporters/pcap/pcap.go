@@ -0,0 +1,264 @@
+// Package pcap contains an exporter, which writes the packets of the exported flows to pcap or pcapng files.
+//
+// The packets are collected by the feature __flowPackets, which must be part of the exported features. Flows are
+// selected with conditions in _control_features, e.g.:
+//
+//	"features": ["flowId", "__flowPackets"],
+//	"_control_features": ["in_subnet(sourceIPAddress, 10.0.0.0/8)"]
+//
+// Every packet of a flow is kept in memory until the flow is exported, regardless of the selection.
+//
+// To keep only the packets of selected flows without holding every packet in memory, the features command writes the
+// packets with the capture of the pipeline instead (see packet.Capture and NewCaptureWriter): the last packets of
+// every flow are kept in a ring buffer, which is written together with every following packet of the flow to a pcapng
+// file as soon as a packet passes the filters and the conditions in _control_features are true, e.g.:
+//
+//	"_control_features": ["running_sum(ipTotalLength) > 100000"]
+package pcap
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/chtisgit/go-flows/flows"
+	"github.com/chtisgit/go-flows/modules/features/anonymize"
+	"github.com/chtisgit/go-flows/util"
+	"github.com/google/gopacket"
+)
+
+const writeBufferSize = 64 * 1024
+
+type pcapExporter struct {
+	id        string
+	outfile   string
+	ng        bool
+	snaplen   int
+	payload   int
+	split     int
+	anonymize bool
+	key       *anonymize.CryptoPAn
+	file      *os.File
+	writer    *bufio.Writer
+	packets   packetWriter
+	files     int
+	written   int
+	warned    map[string]bool
+}
+
+func (pe *pcapExporter) Fields([]string) {}
+
+// warn logs message once
+func (pe *pcapExporter) warn(message string) {
+	if !pe.warned[message] {
+		pe.warned[message] = true
+		log.Printf("%s: %s", pe.id, message)
+	}
+}
+
+// filename returns the name of the n-th file. If files are split, a counter is inserted before the extension.
+func (pe *pcapExporter) filename(n int) string {
+	if pe.split == 0 {
+		return pe.outfile
+	}
+	ext := filepath.Ext(pe.outfile)
+	return fmt.Sprintf("%s.%05d%s", strings.TrimSuffix(pe.outfile, ext), n, ext)
+}
+
+func (pe *pcapExporter) open() {
+	name := pe.filename(pe.files)
+	f, err := os.Create(name)
+	if err != nil {
+		log.Fatal("Couldn't open file ", name, err)
+	}
+	pe.file = f
+	pe.writer = bufio.NewWriterSize(f, writeBufferSize)
+	snaplen := uint32(262144)
+	if pe.snaplen > 0 {
+		snaplen = uint32(pe.snaplen)
+	}
+	if pe.ng {
+		pe.packets = newNgWriter(pe.writer, snaplen)
+	} else {
+		pe.packets = newPcapWriter(pe.writer, snaplen)
+	}
+	pe.files++
+	pe.written = 0
+}
+
+func (pe *pcapExporter) close() {
+	if err := pe.writer.Flush(); err != nil {
+		log.Fatal("Couldn't write file ", pe.file.Name(), err)
+	}
+	if err := pe.file.Close(); err != nil {
+		log.Fatal("Couldn't close file ", pe.file.Name(), err)
+	}
+}
+
+// truncatePayload returns data with at most n bytes after the transport header (or the last decodable header)
+func truncatePayload(data []byte, p gopacket.Packet, n int) []byte {
+	headers := 0
+	for _, layer := range p.Layers() {
+		if _, ok := layer.(gopacket.ApplicationLayer); ok {
+			break
+		}
+		if _, ok := layer.(gopacket.ErrorLayer); ok {
+			break
+		}
+		headers += len(layer.LayerContents())
+		if _, ok := layer.(gopacket.TransportLayer); ok {
+			break
+		}
+	}
+	if headers+n < len(data) {
+		return data[:headers+n]
+	}
+	return data
+}
+
+// prepare returns the data to write for the given packet, which is anonymized and truncated as configured
+func (pe *pcapExporter) prepare(packet *Packet, anonymized bool) []byte {
+	data := packet.Data
+	if pe.anonymize && !anonymized {
+		data = append([]byte(nil), data...)
+		anonymizePacket(pe.key, data, packet.LinkType, false)
+	}
+	if pe.payload >= 0 {
+		data = truncatePayload(data, decode(data, packet.LinkType), pe.payload)
+	}
+	if pe.snaplen > 0 && len(data) > pe.snaplen {
+		data = data[:pe.snaplen]
+	}
+	return data
+}
+
+func (pe *pcapExporter) writeFlow(flow *Flow) {
+	if pe.split > 0 && pe.written >= pe.split {
+		pe.close()
+		pe.open()
+	}
+	for i := range flow.Packets {
+		packet := &flow.Packets[i]
+		data := pe.prepare(packet, flow.anonymized)
+		ci := packet.CaptureInfo
+		ci.CaptureLength = len(data)
+		err := pe.packets.writePacket(ci, data, packet.LinkType, flow.comment(i))
+		if err == errLinkType {
+			pe.warn(fmt.Sprintf("skipping packets with link type %s: %s", packet.LinkType, err))
+			continue
+		}
+		if err != nil {
+			log.Fatal("Couldn't write file ", pe.file.Name(), err)
+		}
+		pe.written++
+	}
+}
+
+// Export export given features
+func (pe *pcapExporter) Export(template flows.Template, features []interface{}, when flows.DateTimeNanoseconds) {
+	for _, feature := range features {
+		if flow, ok := feature.(*Flow); ok {
+			pe.writeFlow(flow)
+			return
+		}
+	}
+	pe.warn("skipping records without __flowPackets")
+}
+
+// Finish Write outstanding data and wait for completion
+func (pe *pcapExporter) Finish() {
+	pe.close()
+}
+
+func (pe *pcapExporter) ID() string {
+	return pe.id
+}
+
+func (pe *pcapExporter) Init() {
+	if pe.anonymize {
+		pe.key = anonymize.Key()
+	}
+	pe.warned = make(map[string]bool)
+	pe.open()
+}
+
+func newPcapExporter(args []string) (arguments []string, ret util.Module, err error) {
+	set := flag.NewFlagSet("pcap", flag.ExitOnError)
+	set.Usage = func() { pcaphelp("pcap") }
+
+	ng := set.Bool("ng", false, "Write pcapng")
+	snaplen := set.Int("snaplen", 0, "Truncate packets to the given length")
+	payload := set.Int("payload", -1, "Truncate the payload to the given length")
+	split := set.Int("split", 0, "Start a new file after the given number of packets")
+	anon := set.Bool("anonymize", false, "Anonymize addresses")
+
+	set.Parse(args)
+
+	arguments = set.Args()
+
+	if len(arguments) < 1 {
+		return nil, nil, errors.New("pcap exporter needs a filename as argument")
+	}
+	outfile := arguments[0]
+	arguments = arguments[1:]
+
+	if *split < 0 {
+		return nil, nil, errors.New("pcap exporter: -split must not be negative")
+	}
+
+	ret = &pcapExporter{
+		id:        "PCAP|" + outfile,
+		outfile:   outfile,
+		ng:        *ng || strings.HasSuffix(outfile, ".pcapng"),
+		snaplen:   *snaplen,
+		payload:   *payload,
+		split:     *split,
+		anonymize: *anon,
+	}
+	return
+}
+
+func pcaphelp(name string) {
+	fmt.Fprintf(os.Stderr, `
+The %s exporter writes the packets of every exported flow to a pcap or pcapng
+file. The packets are collected by the feature __flowPackets, which must be
+part of the exported features. Flows can be selected with conditions in
+_control_features. Timestamps have nanosecond resolution.
+
+pcapng files store the flow id and the label of every packet as packet comment
+and can hold packets with different link types. pcap files can only hold
+packets with the link type of the first packet; other packets are skipped.
+
+As argument, the output file is needed. Files ending in .pcapng are written as
+pcapng.
+
+Usage:
+  export %s [-ng] [-snaplen n] [-payload n] [-split n] [-anonymize] file.pcap
+
+Flags:
+-ng
+	  Write pcapng instead of pcap (default off).
+-snaplen n
+	  Truncate packets to n bytes.
+-payload n
+	  Truncate the payload after the transport header to n bytes.
+-split n
+	  Start a new file, once the current one holds at least n packets. The
+	  packets of a flow are never split. A counter is inserted into the
+	  filename (e.g. file.00000.pcap).
+-anonymize
+	  Anonymize MAC and IP addresses of the packet headers with Crypto-PAn
+	  and fix the checksums (needs -anonymizeKey). The -anonymize option of
+	  the features command anonymizes the packets as well (and the ports with
+	  -anonymizePorts).
+`, name, name)
+}
+
+func init() {
+	flows.RegisterExporter("pcap", "Exports the packets of flows to a pcap or pcapng file.", newPcapExporter, pcaphelp)
+}
@@ -0,0 +1,283 @@
+package pcap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/chtisgit/go-flows/flows"
+	"github.com/chtisgit/go-flows/modules/features/anonymize"
+	"github.com/chtisgit/go-flows/packet"
+	"github.com/chtisgit/go-flows/packet_test"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+)
+
+func TestFlowPackets(t *testing.T) {
+	table := packet_test.MakeFlowFeatureTest(t, "__flowPackets")
+	label := packet.NamedLabel{"ids": "scan", "csv": 1}
+	table.EventLabeledLayers(0, label, &layers.IPv4{SrcIP: net.IP{10, 0, 0, 1}, DstIP: net.IP{10, 0, 0, 2}, Protocol: layers.IPProtocolUDP}, &layers.UDP{SrcPort: 1000, DstPort: 53})
+	table.EventLayers(1, &layers.IPv4{SrcIP: net.IP{10, 0, 0, 2}, DstIP: net.IP{10, 0, 0, 1}, Protocol: layers.IPProtocolUDP}, &layers.UDP{SrcPort: 53, DstPort: 1000})
+	table.Finish(2)
+	expected := &Flow{Packets: []Packet{
+		{LinkType: layers.LinkTypeIPv4, Label: label},
+		{LinkType: layers.LinkTypeIPv4},
+	}}
+	table.AssertFeatureValue("__flowPackets", expected)
+	if comment := expected.comment(0); comment != "flow=0 label.csv=1 label.ids=scan" {
+		t.Errorf("unexpected comment %q", comment)
+	}
+	if comment := (&Flow{ID: 1, Packets: []Packet{{Label: "a\nb"}}}).comment(0); comment != "flow=1 label=a b" {
+		t.Errorf("unexpected comment %q", comment)
+	}
+}
+
+func serialize(t *testing.T, layerList ...gopacket.SerializableLayer) []byte {
+	buf := gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}, layerList...); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// testFlow returns a flow with an ethernet/IPv4/TCP and a raw IPv6/UDP packet, each with 10 bytes of payload
+func testFlow(t *testing.T) *Flow {
+	payload := gopacket.Payload("0123456789")
+	ip4 := &layers.IPv4{Version: 4, TTL: 64, SrcIP: net.IP{10, 0, 0, 1}, DstIP: net.IP{10, 0, 0, 2}, Protocol: layers.IPProtocolTCP}
+	tcp := &layers.TCP{SrcPort: 1000, DstPort: 80, Seq: 1, ACK: true, Window: 1024}
+	tcp.SetNetworkLayerForChecksum(ip4)
+	ip6 := &layers.IPv6{Version: 6, HopLimit: 64, SrcIP: net.ParseIP("2001:db8::1"), DstIP: net.ParseIP("2001:db8::2"), NextHeader: layers.IPProtocolUDP}
+	udp := &layers.UDP{SrcPort: 1000, DstPort: 9999}
+	udp.SetNetworkLayerForChecksum(ip6)
+
+	packets := []Packet{
+		{Data: serialize(t, &layers.Ethernet{SrcMAC: net.HardwareAddr{0, 1, 2, 3, 4, 5}, DstMAC: net.HardwareAddr{0, 1, 2, 3, 4, 6}, EthernetType: layers.EthernetTypeIPv4}, ip4, tcp, payload), LinkType: layers.LinkTypeEthernet, Label: "attack"},
+		{Data: serialize(t, ip6, udp, payload), LinkType: layers.LinkTypeRaw},
+	}
+	for i := range packets {
+		packets[i].CaptureInfo = gopacket.CaptureInfo{Timestamp: time.Unix(1, int64(i)+5), CaptureLength: len(packets[i].Data), Length: len(packets[i].Data)}
+	}
+	return &Flow{ID: 42, Packets: packets}
+}
+
+func makeExporter(t *testing.T, args ...string) *pcapExporter {
+	_, module, err := newPcapExporter(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := module.(*pcapExporter)
+	e.Init()
+	return e
+}
+
+func TestPcapng(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pcap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	flow := testFlow(t)
+	name := filepath.Join(dir, "out.pcapng")
+	e := makeExporter(t, "-payload", "2", name)
+	e.Export(nil, []interface{}{uint64(42), flow}, 0)
+	e.Finish()
+
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, comment := range []string{"flow=42 label=attack", "flow=42"} {
+		if !bytes.Contains(data, []byte(comment)) {
+			t.Errorf("comment %q is missing", comment)
+		}
+	}
+	r, err := pcapgo.NewNgReader(bytes.NewReader(data), pcapgo.NgReaderOptions{WantMixedLinkType: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, expected := range flow.Packets {
+		data, ci, err := r.ReadPacketData()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if linkType := ci.AncillaryData[0].(layers.LinkType); linkType != expected.LinkType {
+			t.Errorf("packet %d: expected link type %s, but got %s", i, expected.LinkType, linkType)
+		}
+		if !ci.Timestamp.Equal(expected.CaptureInfo.Timestamp) {
+			t.Errorf("packet %d: expected timestamp %s, but got %s", i, expected.CaptureInfo.Timestamp, ci.Timestamp)
+		}
+		if len(data) != len(expected.Data)-8 || ci.Length != len(expected.Data) || !bytes.Equal(data, expected.Data[:len(data)]) {
+			t.Errorf("packet %d: expected the payload to be truncated to 2 bytes (got %d of %d bytes)", i, len(data), ci.Length)
+		}
+	}
+	if _, _, err := r.ReadPacketData(); err != io.EOF {
+		t.Errorf("expected EOF, but got %v", err)
+	}
+}
+
+func TestPcapSplit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pcap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	flow := testFlow(t)
+	flow.Packets[1].LinkType = layers.LinkTypeEthernet
+	flow.Packets[1].Data = flow.Packets[0].Data
+	flow.Packets[1].CaptureInfo.Length = len(flow.Packets[0].Data)
+	e := makeExporter(t, "-split", "1", "-snaplen", "20", filepath.Join(dir, "out.pcap"))
+	e.Export(nil, []interface{}{flow}, 0)
+	e.Export(nil, []interface{}{flow}, 0)
+	e.Export(nil, []interface{}{uint64(1)}, 0)
+	e.Finish()
+
+	for _, name := range []string{"out.00000.pcap", "out.00001.pcap"} {
+		f, err := os.Open(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := pcapgo.NewReader(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.LinkType() != layers.LinkTypeEthernet {
+			t.Errorf("%s: expected link type %s, but got %s", name, layers.LinkTypeEthernet, r.LinkType())
+		}
+		for i := 0; i < 2; i++ {
+			data, ci, err := r.ReadPacketData()
+			if err != nil {
+				t.Fatalf("%s: %s", name, err)
+			}
+			if !bytes.Equal(data, flow.Packets[0].Data[:20]) || ci.Length != len(flow.Packets[0].Data) || !ci.Timestamp.Equal(flow.Packets[i].CaptureInfo.Timestamp) {
+				t.Errorf("%s: packet %d wasn't truncated to 20 bytes", name, i)
+			}
+		}
+		if _, _, err := r.ReadPacketData(); err != io.EOF {
+			t.Errorf("%s: expected EOF, but got %v", name, err)
+		}
+		f.Close()
+	}
+	if _, err := os.Stat(filepath.Join(dir, "out.00002.pcap")); err == nil {
+		t.Error("unexpected third file")
+	}
+}
+
+// checkChecksums fails if the checksums of data differ from freshly computed ones
+func checkChecksums(t *testing.T, data []byte, linkType layers.LinkType) {
+	p := decode(data, linkType)
+	var list []gopacket.SerializableLayer
+	var network gopacket.NetworkLayer
+	for _, layer := range p.Layers() {
+		switch l := layer.(type) {
+		case *layers.IPv4:
+			network = l
+		case *layers.IPv6:
+			network = l
+		case *layers.TCP:
+			l.SetNetworkLayerForChecksum(network)
+		case *layers.UDP:
+			l.SetNetworkLayerForChecksum(network)
+		}
+		if l, ok := layer.(gopacket.SerializableLayer); ok {
+			list = append(list, l)
+		}
+	}
+	if expected := serialize(t, list...); !bytes.Equal(data, expected) {
+		t.Errorf("wrong checksums:\n%x\nexpected:\n%x", data, expected)
+	}
+}
+
+func TestAnonymize(t *testing.T) {
+	key, err := anonymize.NewCryptoPAn(bytes.Repeat([]byte{1}, anonymize.KeySize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	flow := testFlow(t)
+	for _, ports := range []bool{false, true} {
+		for i, p := range flow.Packets {
+			data := append([]byte(nil), p.Data...)
+			anonymizePacket(key, data, p.LinkType, ports)
+			checkChecksums(t, data, p.LinkType)
+
+			original, anonymized := decode(p.Data, p.LinkType), decode(data, p.LinkType)
+			src, dst := original.NetworkLayer().NetworkFlow().Endpoints()
+			asrc, adst := anonymized.NetworkLayer().NetworkFlow().Endpoints()
+			if !net.IP(asrc.Raw()).Equal(key.IP(src.Raw())) || !net.IP(adst.Raw()).Equal(key.IP(dst.Raw())) {
+				t.Errorf("packet %d: expected addresses %s -> %s, but got %s -> %s", i, key.IP(src.Raw()), key.IP(dst.Raw()), asrc, adst)
+			}
+			if link := original.LinkLayer(); link != nil {
+				src, dst := link.LinkFlow().Endpoints()
+				asrc, adst := anonymized.LinkLayer().LinkFlow().Endpoints()
+				if !reflect.DeepEqual(asrc.Raw(), []byte(key.MAC(src.Raw()))) || !reflect.DeepEqual(adst.Raw(), []byte(key.MAC(dst.Raw()))) {
+					t.Errorf("packet %d: MAC addresses weren't anonymized", i)
+				}
+			}
+			sport, dport := original.TransportLayer().TransportFlow().Endpoints()
+			asport, adport := anonymized.TransportLayer().TransportFlow().Endpoints()
+			expectedSport, expectedDport := binary.BigEndian.Uint16(sport.Raw()), binary.BigEndian.Uint16(dport.Raw())
+			if ports {
+				expectedSport, expectedDport = key.Port(expectedSport), key.Port(expectedDport)
+			}
+			if binary.BigEndian.Uint16(asport.Raw()) != expectedSport || binary.BigEndian.Uint16(adport.Raw()) != expectedDport {
+				t.Errorf("packet %d (ports %t): expected ports %d -> %d, but got %s -> %s", i, ports, expectedSport, expectedDport, asport, adport)
+			}
+			if !bytes.Equal(anonymized.TransportLayer().LayerPayload(), []byte("0123456789")) {
+				t.Errorf("packet %d: the payload was changed", i)
+			}
+		}
+	}
+}
+
+func TestAnonymizeFlow(t *testing.T) {
+	key, err := anonymize.NewCryptoPAn(bytes.Repeat([]byte{1}, anonymize.KeySize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	flow := testFlow(t)
+	original := append([]byte(nil), flow.Packets[0].Data...)
+	anonymized, ok := anonymize.Anonymizer(flow).Anonymize(key, true).(*Flow)
+	if !ok || !anonymized.anonymized || len(anonymized.Packets) != len(flow.Packets) {
+		t.Fatalf("unexpected anonymized flow %v", anonymized)
+	}
+	expected := append([]byte(nil), original...)
+	anonymizePacket(key, expected, flow.Packets[0].LinkType, true)
+	if !bytes.Equal(anonymized.Packets[0].Data, expected) {
+		t.Error("the packets weren't anonymized")
+	}
+	if !bytes.Equal(flow.Packets[0].Data, original) {
+		t.Error("the original flow was modified")
+	}
+	// the exporter doesn't anonymize the packets again
+	pe := &pcapExporter{anonymize: true, key: key, payload: -1}
+	if data := pe.prepare(&anonymized.Packets[0], anonymized.anonymized); !bytes.Equal(data, expected) {
+		t.Error("already anonymized packets were changed by the exporter")
+	}
+}
+
+func TestTruncatePayload(t *testing.T) {
+	flow := testFlow(t)
+	data := flow.Packets[0].Data
+	for _, test := range []struct {
+		n        int
+		expected int
+	}{{0, len(data) - 10}, {5, len(data) - 5}, {100, len(data)}} {
+		if result := truncatePayload(data, decode(data, layers.LinkTypeEthernet), test.n); len(result) != test.expected {
+			t.Errorf("%d: expected %d bytes, but got %d", test.n, test.expected, len(result))
+		}
+	}
+	if result := truncatePayload([]byte{1, 2, 3}, decode([]byte{1, 2, 3}, layers.LinkTypeEthernet), 0); len(result) != 0 {
+		t.Errorf("expected undecodable data to be dropped, but got %x", result)
+	}
+}
+
+var _ flows.Exporter = (*pcapExporter)(nil)
@@ -0,0 +1,171 @@
+package pcap
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// errLinkType is returned by the pcap writer for packets with a different link type than the first packet
+var errLinkType = errors.New("pcap files can only hold a single link type (use pcapng)")
+
+// packetWriter writes packets to a capture file
+type packetWriter interface {
+	writePacket(ci gopacket.CaptureInfo, data []byte, linkType layers.LinkType, comment string) error
+}
+
+// pcapWriter writes pcap files with nanosecond timestamps. The file header is written together with the first packet,
+// since the header contains the link type. Comments are not supported by the format and get dropped.
+type pcapWriter struct {
+	w        io.Writer
+	snaplen  uint32
+	linkType layers.LinkType
+	started  bool
+	buf      [24]byte
+}
+
+func newPcapWriter(w io.Writer, snaplen uint32) *pcapWriter {
+	return &pcapWriter{w: w, snaplen: snaplen}
+}
+
+func (p *pcapWriter) writePacket(ci gopacket.CaptureInfo, data []byte, linkType layers.LinkType, comment string) error {
+	if !p.started {
+		binary.LittleEndian.PutUint32(p.buf[0:], 0xa1b23c4d) // magic for nanosecond resolution
+		binary.LittleEndian.PutUint16(p.buf[4:], 2)
+		binary.LittleEndian.PutUint16(p.buf[6:], 4)
+		binary.LittleEndian.PutUint32(p.buf[8:], 0)  // thiszone
+		binary.LittleEndian.PutUint32(p.buf[12:], 0) // sigfigs
+		binary.LittleEndian.PutUint32(p.buf[16:], p.snaplen)
+		binary.LittleEndian.PutUint32(p.buf[20:], uint32(linkType))
+		if _, err := p.w.Write(p.buf[:24]); err != nil {
+			return err
+		}
+		p.linkType = linkType
+		p.started = true
+	}
+	if linkType != p.linkType {
+		return errLinkType
+	}
+	ts := ci.Timestamp.UnixNano()
+	binary.LittleEndian.PutUint32(p.buf[0:], uint32(ts/1e9))
+	binary.LittleEndian.PutUint32(p.buf[4:], uint32(ts%1e9))
+	binary.LittleEndian.PutUint32(p.buf[8:], uint32(len(data)))
+	binary.LittleEndian.PutUint32(p.buf[12:], uint32(ci.Length))
+	if _, err := p.w.Write(p.buf[:16]); err != nil {
+		return err
+	}
+	_, err := p.w.Write(data)
+	return err
+}
+
+const (
+	ngBlockSectionHeader       = 0x0A0D0D0A
+	ngBlockInterfaceDescriptor = 0x00000001
+	ngBlockEnhancedPacket      = 0x00000006
+	ngOptionEnd                = 0
+	ngOptionComment            = 1
+	ngOptionTsresol            = 9
+)
+
+// ngWriter writes pcapng files with a single section. Every link type gets its own interface with nanosecond
+// resolution, which is added before the first packet with this link type. Comments are stored in the opt_comment
+// option of the enhanced packet block.
+type ngWriter struct {
+	w          io.Writer
+	snaplen    uint32
+	interfaces map[layers.LinkType]uint32
+	started    bool
+	buf        []byte
+}
+
+func newNgWriter(w io.Writer, snaplen uint32) *ngWriter {
+	return &ngWriter{w: w, snaplen: snaplen, interfaces: make(map[layers.LinkType]uint32)}
+}
+
+func pad4(n int) int {
+	return (4 - n&3) & 3
+}
+
+func (n *ngWriter) put16(v uint16) {
+	n.buf = append(n.buf, byte(v), byte(v>>8))
+}
+
+func (n *ngWriter) put32(v uint32) {
+	n.buf = append(n.buf, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+}
+
+func (n *ngWriter) putPadded(data []byte) {
+	n.buf = append(n.buf, data...)
+	for i := pad4(len(data)); i > 0; i-- {
+		n.buf = append(n.buf, 0)
+	}
+}
+
+func (n *ngWriter) putOption(code uint16, value []byte) {
+	n.put16(code)
+	n.put16(uint16(len(value)))
+	n.putPadded(value)
+}
+
+// startBlock starts a new block in buf; the length gets filled in by writeBlock
+func (n *ngWriter) startBlock(blockType uint32) {
+	n.buf = n.buf[:0]
+	n.put32(blockType)
+	n.put32(0)
+}
+
+func (n *ngWriter) writeBlock() error {
+	length := uint32(len(n.buf) + 4)
+	binary.LittleEndian.PutUint32(n.buf[4:], length)
+	n.put32(length)
+	_, err := n.w.Write(n.buf)
+	return err
+}
+
+func (n *ngWriter) writePacket(ci gopacket.CaptureInfo, data []byte, linkType layers.LinkType, comment string) error {
+	if !n.started {
+		n.startBlock(ngBlockSectionHeader)
+		n.put32(0x1A2B3C4D) // byte order magic
+		n.put16(1)
+		n.put16(0)
+		n.put32(0xffffffff) // unknown section length
+		n.put32(0xffffffff)
+		if err := n.writeBlock(); err != nil {
+			return err
+		}
+		n.started = true
+	}
+	id, ok := n.interfaces[linkType]
+	if !ok {
+		id = uint32(len(n.interfaces))
+		n.startBlock(ngBlockInterfaceDescriptor)
+		n.put16(uint16(linkType))
+		n.put16(0)
+		n.put32(n.snaplen)
+		n.putOption(ngOptionTsresol, []byte{9})
+		n.putOption(ngOptionEnd, nil)
+		if err := n.writeBlock(); err != nil {
+			return err
+		}
+		n.interfaces[linkType] = id
+	}
+	ts := uint64(ci.Timestamp.UnixNano())
+	n.startBlock(ngBlockEnhancedPacket)
+	n.put32(id)
+	n.put32(uint32(ts >> 32))
+	n.put32(uint32(ts))
+	n.put32(uint32(len(data)))
+	n.put32(uint32(ci.Length))
+	n.putPadded(data)
+	if comment != "" {
+		if len(comment) > 0xffff {
+			comment = comment[:0xffff]
+		}
+		n.putOption(ngOptionComment, []byte(comment))
+		n.putOption(ngOptionEnd, nil)
+	}
+	return n.writeBlock()
+}
@@ -0,0 +1,95 @@
+// Package anonymize contains prefix-preserving anonymization (Crypto-PAn) of IPv4, IPv6, and MAC addresses, and ports.
+// The key must be loaded with Load before processing (go-flows -anonymizeKey file). Anonymization is available as the
+// operation anonymize (e.g. anonymize(sourceIPAddress)) and as an exporter wrapper (see WrapExporter), which rewrites
+// every address in the exported records.
+package anonymize
+
+import (
+	"log"
+	"net"
+
+	ipfix "github.com/CN-TU/go-ipfix"
+	"github.com/chtisgit/go-flows/flows"
+)
+
+var key *CryptoPAn
+
+// Load reads the key used for anonymization from the given file (see ReadKey)
+func Load(filename string) error {
+	c, err := ReadKey(filename)
+	if err != nil {
+		return err
+	}
+	key = c
+	return nil
+}
+
+// Loaded returns true if a key was loaded
+func Loaded() bool {
+	return key != nil
+}
+
+// Key returns the loaded key. It exits if no key was loaded.
+func Key() *CryptoPAn {
+	return getKey()
+}
+
+func getKey() *CryptoPAn {
+	if key == nil {
+		log.Fatalln("anonymize: no key loaded (use -anonymizeKey file)")
+	}
+	return key
+}
+
+// anonymizeValue returns the anonymized value or nil if the value can't be anonymized. Unsigned integers are treated as
+// ports.
+func anonymizeValue(c *CryptoPAn, value interface{}) interface{} {
+	switch value := value.(type) {
+	case net.IP:
+		if ret := c.IP(value); ret != nil {
+			return ret
+		}
+	case net.HardwareAddr:
+		return c.MAC(value)
+	case uint8, uint16, uint32, uint64:
+		if port := flows.ToUInt(value); port <= 0xffff {
+			return c.Port(uint16(port))
+		}
+	}
+	return nil
+}
+
+func resolveAnonymize(args []ipfix.InformationElement) (ipfix.InformationElement, error) {
+	switch args[0].Type {
+	case ipfix.Ipv4AddressType, ipfix.Ipv6AddressType, ipfix.MacAddressType, ipfix.Unsigned16Type:
+		return ipfix.InformationElement{Type: args[0].Type, Length: args[0].Length}, nil
+	}
+	return ipfix.InformationElement{}, flows.MakeIncompatibleVariantError("anonymize: argument must be an address or a port, but is %s", args[0].Type)
+}
+
+type anonymizePacket struct {
+	flows.BaseFeature
+}
+
+func (f *anonymizePacket) Event(new interface{}, context *flows.EventContext, src interface{}) {
+	if value := anonymizeValue(getKey(), new); value != nil {
+		f.SetValue(value, context, f)
+	}
+}
+
+type anonymizeFlow struct {
+	flows.BaseFeature
+}
+
+func (f *anonymizeFlow) Event(new interface{}, context *flows.EventContext, src interface{}) {
+	if f.Value() == nil {
+		if value := anonymizeValue(getKey(), new); value != nil {
+			f.SetValue(value, context, f)
+		}
+	}
+}
+
+func init() {
+	flows.RegisterCustomFunction("anonymize", "prefix-preserving anonymization (Crypto-PAn) of the address or port a (needs -anonymizeKey)", resolveAnonymize, flows.PacketFeature, func() flows.Feature { return &anonymizePacket{} }, flows.PacketFeature)
+	flows.RegisterCustomFunction("anonymize", "prefix-preserving anonymization (Crypto-PAn) of the address or port a (needs -anonymizeKey)", resolveAnonymize, flows.FlowFeature, func() flows.Feature { return &anonymizeFlow{} }, flows.FlowFeature)
+}
@@ -0,0 +1,203 @@
+package anonymize
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	ipfix "github.com/CN-TU/go-ipfix"
+	"github.com/chtisgit/go-flows/flows"
+	_ "github.com/chtisgit/go-flows/modules/features/iana"
+	"github.com/chtisgit/go-flows/packet_test"
+	"github.com/chtisgit/go-flows/spec"
+	"github.com/google/gopacket/layers"
+)
+
+// testKey is the key of the Crypto-PAn reference implementation
+var testKey = []byte{21, 34, 23, 141, 51, 164, 207, 128, 19, 10, 91, 22, 73, 144, 125, 16, 216, 152, 143, 131, 121, 121, 101, 39, 98, 87, 76, 45, 42, 132, 34, 2}
+
+func makeCryptoPAn(t *testing.T) *CryptoPAn {
+	c, err := NewCryptoPAn(testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestReference(t *testing.T) {
+	c := makeCryptoPAn(t)
+	for _, test := range [][2]string{
+		{"128.11.68.132", "135.242.180.132"},
+		{"129.118.74.4", "134.136.186.123"},
+		{"130.132.252.244", "133.68.164.234"},
+		{"141.223.7.43", "141.167.8.160"},
+		{"192.102.249.13", "252.138.62.131"},
+	} {
+		for _, ip := range []net.IP{net.ParseIP(test[0]).To4(), net.ParseIP(test[0])} {
+			result := c.IP(ip)
+			if !result.Equal(net.ParseIP(test[1])) || len(result) != len(ip) {
+				t.Errorf("%s: expected %s, but got %s", test[0], test[1], result)
+			}
+		}
+	}
+}
+
+// commonPrefix returns the number of leading bits a and b have in common
+func commonPrefix(a, b []byte) int {
+	for i := range a {
+		if x := a[i] ^ b[i]; x != 0 {
+			n := 0
+			for x&0x80 == 0 {
+				x <<= 1
+				n++
+			}
+			return i*8 + n
+		}
+	}
+	return len(a) * 8
+}
+
+func TestPrefixPreserving(t *testing.T) {
+	c := makeCryptoPAn(t)
+	pairs := [][2][]byte{
+		{net.ParseIP("2001:db8:1::1"), net.ParseIP("2001:db8:1::2")},
+		{net.ParseIP("2001:db8:1::1"), net.ParseIP("2001:db8:8000::1")},
+		{net.ParseIP("2001:db8::1"), net.ParseIP("fe80::1")},
+		{net.ParseIP("10.1.2.3").To4(), net.ParseIP("10.1.200.3").To4()},
+		{[]byte{0, 0x11, 0x22, 0x33, 0x44, 0x55}, []byte{0, 0x11, 0x22, 0x99, 0x44, 0x55}},
+	}
+	for _, pair := range pairs {
+		var a, b []byte
+		if len(pair[0]) == 6 {
+			a, b = c.MAC(pair[0]), c.MAC(pair[1])
+		} else {
+			a, b = c.IP(pair[0]), c.IP(pair[1])
+		}
+		if bytes.Equal(a, pair[0]) {
+			t.Errorf("%x: value wasn't changed", pair[0])
+		}
+		if expected, got := commonPrefix(pair[0], pair[1]), commonPrefix(a, b); expected != got {
+			t.Errorf("%x/%x: expected common prefix of %d bits, but got %d bits", pair[0], pair[1], expected, got)
+		}
+	}
+
+	seen := make(map[uint16]bool)
+	for port := 0; port < 1<<16; port++ {
+		seen[c.Port(uint16(port))] = true
+	}
+	if len(seen) != 1<<16 {
+		t.Errorf("port anonymization isn't a permutation (%d distinct ports)", len(seen))
+	}
+}
+
+func TestReadKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "anonymize")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string][]byte{
+		"raw": testKey,
+		"hex": []byte(hex.EncodeToString(testKey) + "\n"),
+		"bad": []byte("foo"),
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), content, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ip := net.ParseIP("128.11.68.132")
+	for _, name := range []string{"raw", "hex"} {
+		c, err := ReadKey(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if result := c.IP(ip); !result.Equal(net.ParseIP("135.242.180.132")) {
+			t.Errorf("%s: unexpected mapping %s", name, result)
+		}
+	}
+	for _, name := range []string{"bad", "missing"} {
+		if _, err := ReadKey(filepath.Join(dir, name)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestAnonymizeFeature(t *testing.T) {
+	key = makeCryptoPAn(t)
+	var features []interface{}
+	for _, expr := range []string{"anonymize(sourceIPAddress)", "anonymize(destinationTransportPort)"} {
+		feature, err := spec.ParseExpression(expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		features = append(features, feature)
+	}
+	table := packet_test.MakeFilteredFeatureTest(t, features, nil, nil, flows.FlowOptions{})
+	table.EventLayers(0, &layers.IPv4{SrcIP: net.IP{128, 11, 68, 132}, DstIP: net.IP{10, 0, 0, 1}, Protocol: layers.IPProtocolUDP}, &layers.UDP{SrcPort: 1000, DstPort: 53})
+	table.EventLayers(1, &layers.IPv6{SrcIP: net.ParseIP("2001:db8::1"), DstIP: net.ParseIP("2001:db8::2"), NextHeader: layers.IPProtocolUDP}, &layers.UDP{SrcPort: 1000, DstPort: 53})
+	table.Finish(2)
+	table.AssertFeatureList([]packet_test.FeatureLine{
+		{When: 2, Features: []packet_test.FeatureResult{
+			{Name: "anonymize(sourceIPAddress)", Value: net.IP{135, 242, 180, 132}},
+			{Name: "anonymize(destinationTransportPort)", Value: key.Port(53)},
+		}},
+		{When: 2, Features: []packet_test.FeatureResult{
+			{Name: "anonymize(sourceIPAddress)", Value: key.IP(net.ParseIP("2001:db8::1"))},
+			{Name: "anonymize(destinationTransportPort)", Value: key.Port(53)},
+		}},
+	})
+
+	var rl flows.RecordListMaker
+	pipe, _ := flows.MakeExportPipeline(nil, flows.SortTypeNone, 1)
+	feature, _ := spec.ParseExpression("anonymize(octetDeltaCount)")
+	if err := rl.AppendRecord([]interface{}{feature}, nil, nil, pipe, false); err == nil {
+		t.Error("expected an error for anonymize(octetDeltaCount)")
+	}
+}
+
+func TestExporter(t *testing.T) {
+	c := makeCryptoPAn(t)
+	src, _ := ipfix.GetInformationElement("sourceIPv4Address")
+	port, _ := ipfix.GetInformationElement("sourceTransportPort")
+	mac, _ := ipfix.GetInformationElement("sourceMacAddress")
+	count, _ := ipfix.GetInformationElement("packetDeltaCount")
+	list := ipfix.NewBasicList("list", ipfix.NewInformationElement("", 0, 0, ipfix.Ipv6AddressType, 0), 0)
+	ies := []ipfix.InformationElement{src, port, mac, count, list, src}
+
+	ip6 := net.ParseIP("2001:db8::1")
+	features := []interface{}{net.IP{128, 11, 68, 132}, uint16(80), net.HardwareAddr{0, 1, 2, 3, 4, 5}, uint64(80), []interface{}{ip6}, nil}
+
+	for _, ports := range []bool{false, true} {
+		e := &exporter{key: c, ports: ports}
+		expectedPort := uint16(80)
+		if ports {
+			expectedPort = c.Port(80)
+		}
+		expected := []interface{}{net.IP{135, 242, 180, 132}, expectedPort, c.MAC(net.HardwareAddr{0, 1, 2, 3, 4, 5}), uint64(80), []interface{}{c.IP(ip6)}, nil}
+		if result := e.rewriteRecord(ies, features); !reflect.DeepEqual(result, expected) {
+			t.Errorf("ports=%v: expected %v, but got %v", ports, expected, result)
+		}
+	}
+	if !reflect.DeepEqual(features[0], net.IP{128, 11, 68, 132}) {
+		t.Error("the original record was modified")
+	}
+
+	e := &exporter{key: c, ports: true}
+	if result := e.rewrite(count, testAnonymizer("a")); result != testAnonymizer("a anonymized ports=true") {
+		t.Errorf("expected the value to anonymize itself, but got %v", result)
+	}
+}
+
+type testAnonymizer string
+
+func (a testAnonymizer) Anonymize(key *CryptoPAn, ports bool) interface{} {
+	return testAnonymizer(fmt.Sprintf("%s anonymized ports=%t", a, ports))
+}
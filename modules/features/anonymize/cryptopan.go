@@ -0,0 +1,128 @@
+package anonymize
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"sync"
+)
+
+// KeySize is the size of a Crypto-PAn key (16 bytes AES key + 16 bytes pad)
+const KeySize = 32
+
+// cacheSize is the maximum number of cached values; the cache is cleared if it is full
+const cacheSize = 1 << 16
+
+// CryptoPAn is a prefix-preserving anonymizer as described in "Prefix-Preserving IP Address Anonymization" (Xu et al.).
+// Two values sharing a prefix of n bits are mapped to anonymized values sharing a prefix of n bits. The same key always
+// results in the same mapping. Results are cached.
+type CryptoPAn struct {
+	block cipher.Block
+	pad   [aes.BlockSize]byte
+	mutex sync.RWMutex
+	cache map[string][]byte
+}
+
+// NewCryptoPAn returns an anonymizer for the given key, which must be KeySize bytes long
+func NewCryptoPAn(key []byte) (*CryptoPAn, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("key must be %d bytes long, but is %d bytes", KeySize, len(key))
+	}
+	block, err := aes.NewCipher(key[:16])
+	if err != nil {
+		return nil, err
+	}
+	ret := &CryptoPAn{
+		block: block,
+		cache: make(map[string][]byte),
+	}
+	block.Encrypt(ret.pad[:], key[16:])
+	return ret, nil
+}
+
+// ReadKey returns an anonymizer with the key from the given file. The file must contain the key either as KeySize raw
+// bytes or hex encoded.
+func ReadKey(filename string) (*CryptoPAn, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) != KeySize {
+		key, err := hex.DecodeString(string(bytes.TrimSpace(data)))
+		if err != nil {
+			return nil, fmt.Errorf("%s: key must be %d raw bytes or hex encoded", filename, KeySize)
+		}
+		data = key
+	}
+	ret, err := NewCryptoPAn(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", filename, err)
+	}
+	return ret, nil
+}
+
+// anonymize returns the anonymized first bits of value. kind separates the cache entries of different types of values.
+func (c *CryptoPAn) anonymize(kind byte, value []byte, bits int) []byte {
+	key := string(append([]byte{kind}, value...))
+	c.mutex.RLock()
+	ret, ok := c.cache[key]
+	c.mutex.RUnlock()
+	if ok {
+		return ret
+	}
+
+	ret = make([]byte, len(value))
+	copy(ret, value)
+	var input, output [aes.BlockSize]byte
+	for pos := 0; pos < bits; pos++ {
+		// input: first pos bits from value, the rest from pad
+		input = c.pad
+		for i := 0; i < pos/8; i++ {
+			input[i] = value[i]
+		}
+		if rest := uint(pos % 8); rest != 0 {
+			mask := byte(0xff) << (8 - rest)
+			input[pos/8] = value[pos/8]&mask | c.pad[pos/8]&^mask
+		}
+		c.block.Encrypt(output[:], input[:])
+		ret[pos/8] ^= (output[0] >> 7) << uint(7-pos%8)
+	}
+
+	c.mutex.Lock()
+	if len(c.cache) >= cacheSize {
+		c.cache = make(map[string][]byte)
+	}
+	c.cache[key] = ret
+	c.mutex.Unlock()
+	return ret
+}
+
+// IP returns the anonymized address. IPv4 addresses (also in IPv6 representation) are mapped to IPv4 addresses.
+func (c *CryptoPAn) IP(ip net.IP) net.IP {
+	if ip4 := ip.To4(); ip4 != nil {
+		ret := net.IP(c.anonymize(4, ip4, 32))
+		if len(ip) == net.IPv6len {
+			return ret.To16()
+		}
+		return ret
+	}
+	if len(ip) != net.IPv6len {
+		return nil
+	}
+	return net.IP(c.anonymize(6, ip, 128))
+}
+
+// MAC returns the anonymized hardware address
+func (c *CryptoPAn) MAC(mac net.HardwareAddr) net.HardwareAddr {
+	return net.HardwareAddr(c.anonymize('m', mac, len(mac)*8))
+}
+
+// Port returns the anonymized port
+func (c *CryptoPAn) Port(port uint16) uint16 {
+	ret := c.anonymize('p', []byte{byte(port >> 8), byte(port)}, 16)
+	return uint16(ret[0])<<8 | uint16(ret[1])
+}
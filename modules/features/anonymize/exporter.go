@@ -0,0 +1,92 @@
+package anonymize
+
+import (
+	"net"
+	"strings"
+
+	ipfix "github.com/CN-TU/go-ipfix"
+	"github.com/chtisgit/go-flows/flows"
+)
+
+// exporter anonymizes the records before forwarding them to the wrapped exporter
+type exporter struct {
+	flows.Exporter
+	key   *CryptoPAn
+	ports bool
+}
+
+// Anonymizer is implemented by values, which hold addresses the exporter can't see (e.g. the packets of the pcap
+// exporter). Anonymize must return an anonymized copy of the value.
+type Anonymizer interface {
+	Anonymize(key *CryptoPAn, ports bool) interface{}
+}
+
+// WrapExporter returns an exporter, which anonymizes every IPv4, IPv6, and MAC address (also inside lists) of the
+// exported records with the loaded key before forwarding them to e. If ports is true, transport ports are anonymized,
+// too. Values implementing Anonymizer anonymize themselves; addresses inside other values (e.g. strings) are not
+// changed.
+func WrapExporter(e flows.Exporter, ports bool) flows.Exporter {
+	ret := &exporter{
+		Exporter: e,
+		key:      getKey(),
+		ports:    ports,
+	}
+	if _, ok := e.(flows.MetadataExporter); ok {
+		return &metadataExporter{ret}
+	}
+	return ret
+}
+
+// isPort returns true for information elements holding transport ports (e.g. sourceTransportPort, udpDestinationPort)
+func isPort(ie ipfix.InformationElement) bool {
+	name := strings.TrimPrefix(ie.Name, "reverse")
+	return strings.HasSuffix(name, "TransportPort") || strings.HasSuffix(name, "SourcePort") || strings.HasSuffix(name, "DestinationPort")
+}
+
+func (e *exporter) rewrite(ie ipfix.InformationElement, value interface{}) interface{} {
+	switch v := value.(type) {
+	case net.IP, net.HardwareAddr:
+		if ret := anonymizeValue(e.key, v); ret != nil {
+			return ret
+		}
+	case []interface{}:
+		element, _ := ie.ListElement()
+		ret := make([]interface{}, len(v))
+		for i := range v {
+			ret[i] = e.rewrite(element, v[i])
+		}
+		return ret
+	case uint8, uint16, uint32, uint64:
+		if e.ports && isPort(ie) {
+			if ret := anonymizeValue(e.key, v); ret != nil {
+				return ret
+			}
+		}
+	case Anonymizer:
+		return v.Anonymize(e.key, e.ports)
+	}
+	return value
+}
+
+// rewriteRecord returns an anonymized copy of the record, since the features are shared by all exporters
+func (e *exporter) rewriteRecord(ies []ipfix.InformationElement, features []interface{}) []interface{} {
+	ret := make([]interface{}, len(features))
+	for i, value := range features {
+		ret[i] = e.rewrite(ies[i], value)
+	}
+	return ret
+}
+
+func (e *exporter) Export(template flows.Template, features []interface{}, when flows.DateTimeNanoseconds) {
+	e.Exporter.Export(template, e.rewriteRecord(template.InformationElements(), features), when)
+}
+
+// metadataExporter is an exporter wrapping an exporter, which supports metadata (see flows.MetadataExporter)
+type metadataExporter struct {
+	*exporter
+}
+
+// ExportMetadata forwards the metadata to the wrapped exporter
+func (e *metadataExporter) ExportMetadata(ies []ipfix.InformationElement, values []interface{}, when flows.DateTimeNanoseconds) {
+	e.Exporter.(flows.MetadataExporter).ExportMetadata(ies, values, when)
+}
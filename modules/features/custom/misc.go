@@ -4,10 +4,9 @@ import (
 	"bytes"
 	"fmt"
 	"io/ioutil"
+	"log"
 	"os"
 
-	"github.com/google/gopacket/layers"
-
 	"github.com/google/gopacket/pcapgo"
 
 	"github.com/chtisgit/go-flows/flows"
@@ -22,13 +21,20 @@ type exportPackets struct {
 }
 
 func (e *exportPackets) Start(context *flows.EventContext) {
-	e.w = pcapgo.NewWriter(&e.b)
-	e.w.WriteFileHeader(65535, layers.LinkTypeEthernet)
+	e.b.Reset()
+	e.w = nil
 }
 
 func (e *exportPackets) Event(new interface{}, context *flows.EventContext, src interface{}) {
 	buf := new.(packet.Buffer)
-	e.w.WritePacket(buf.Metadata().CaptureInfo, buf.Data())
+	if e.w == nil {
+		// the file header needs the link type of the packets
+		e.w = pcapgo.NewWriter(&e.b)
+		e.w.WriteFileHeader(65535, buf.LinkType())
+	}
+	ci := buf.Metadata().CaptureInfo
+	ci.CaptureLength = len(buf.Data())
+	e.w.WritePacket(ci, buf.Data())
 }
 
 func (e *exportPackets) Stop(reason flows.FlowEndReason, context *flows.EventContext) {
@@ -36,11 +42,16 @@ func (e *exportPackets) Stop(reason flows.FlowEndReason, context *flows.EventCon
 	id := flow.ID()
 	tid := flow.Table().ID()
 	p := fmt.Sprintf("%d/%d/%d", tid, id/1000000, id/1000%1000)
-	os.MkdirAll(p, 0755)
+	if err := os.MkdirAll(p, 0755); err != nil {
+		log.Println("__exportPackets:", err)
+		return
+	}
 	p += fmt.Sprintf("/%d.pcap", id%1000)
-	ioutil.WriteFile(p, e.b.Bytes(), 0644)
+	if err := ioutil.WriteFile(p, e.b.Bytes(), 0644); err != nil {
+		log.Println("__exportPackets:", err)
+	}
 }
 
 func init() {
-	flows.RegisterTemporaryFeature("__exportPackets", "Writes one pcap per flow containing the flow's packets", ipfix.Unsigned8Type, 0, flows.FlowFeature, func() flows.Feature { return &exportPackets{} }, flows.RawPacket)
+	flows.RegisterTemporaryFeature("__exportPackets", "Writes one pcap per flow containing the flow's packets (see the pcap exporter for a configurable alternative)", ipfix.Unsigned8Type, 0, flows.FlowFeature, func() flows.Feature { return &exportPackets{} }, flows.RawPacket)
 }
@@ -10,6 +10,10 @@ type _interPacketTimeNanoseconds struct {
 	time flows.DateTimeNanoseconds
 }
 
+func (f *_interPacketTimeNanoseconds) CheckpointState() []interface{} {
+	return []interface{}{&f.time}
+}
+
 func (f *_interPacketTimeNanoseconds) Start(context *flows.EventContext) {
 	f.BaseFeature.Start(context)
 	f.time = 0
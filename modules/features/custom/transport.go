@@ -15,6 +15,8 @@ type _tcpEceTotalCountFlow struct {
 	count uint64
 }
 
+func (f *_tcpEceTotalCountFlow) CheckpointState() []interface{} { return []interface{}{&f.count} }
+
 func (f *_tcpEceTotalCountFlow) Start(context *flows.EventContext) {
 	f.BaseFeature.Start(context)
 	f.count = 0
@@ -61,6 +63,8 @@ type _tcpCwrTotalCountFlow struct {
 	count uint64
 }
 
+func (f *_tcpCwrTotalCountFlow) CheckpointState() []interface{} { return []interface{}{&f.count} }
+
 func (f *_tcpCwrTotalCountFlow) Start(context *flows.EventContext) {
 	f.BaseFeature.Start(context)
 	f.count = 0
@@ -107,6 +111,8 @@ type _tcpNsTotalCountFlow struct {
 	count uint64
 }
 
+func (f *_tcpNsTotalCountFlow) CheckpointState() []interface{} { return []interface{}{&f.count} }
+
 func (f *_tcpNsTotalCountFlow) Start(context *flows.EventContext) {
 	f.BaseFeature.Start(context)
 	f.count = 0
@@ -390,3 +396,67 @@ func init() {
 }
 
 ////////////////////////////////////////////////////////////////////////////////
+
+func tcpConnection(context *flows.EventContext) packet.TCPConnection {
+	conn, _ := context.Flow().(packet.TCPConnection)
+	return conn
+}
+
+type _tcpConnectionStateFlow struct {
+	flows.BaseFeature
+}
+
+func (f *_tcpConnectionStateFlow) Stop(reason flows.FlowEndReason, context *flows.EventContext) {
+	if conn := tcpConnection(context); conn != nil {
+		f.SetValue(conn.TCPState().String(), context, f)
+	}
+}
+
+type _tcpConnectionStatePacket struct {
+	flows.BaseFeature
+}
+
+func (f *_tcpConnectionStatePacket) Event(new interface{}, context *flows.EventContext, src interface{}) {
+	if conn := tcpConnection(context); conn != nil {
+		f.SetValue(conn.TCPState().String(), context, f)
+	}
+}
+
+func init() {
+	flows.RegisterTemporaryFeature("_tcpConnectionState", "returns the state of the TCP connection (e.g. ESTABLISHED, TIME_WAIT); needs TCP expiry", ipfix.StringType, 0, flows.FlowFeature, func() flows.Feature { return &_tcpConnectionStateFlow{} }, flows.RawPacket)
+	flows.RegisterTemporaryFeature("_tcpConnectionState", "returns the state of the TCP connection after this packet; needs TCP expiry", ipfix.StringType, 0, flows.PacketFeature, func() flows.Feature { return &_tcpConnectionStatePacket{} }, flows.RawPacket)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+type _tcpHistory struct {
+	flows.BaseFeature
+}
+
+func (f *_tcpHistory) Stop(reason flows.FlowEndReason, context *flows.EventContext) {
+	if conn := tcpConnection(context); conn != nil {
+		f.SetValue(conn.TCPHistory(), context, f)
+	}
+}
+
+func init() {
+	flows.RegisterTemporaryFeature("_tcpHistory", "returns the history of the TCP connection like Zeek (e.g. ShADadFf); needs TCP expiry", ipfix.StringType, 0, flows.FlowFeature, func() flows.Feature { return &_tcpHistory{} }, flows.RawPacket)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+type _tcpClientIsSource struct {
+	flows.BaseFeature
+}
+
+func (f *_tcpClientIsSource) Stop(reason flows.FlowEndReason, context *flows.EventContext) {
+	if conn := tcpConnection(context); conn != nil {
+		f.SetValue(conn.TCPClientIsSource(), context, f)
+	}
+}
+
+func init() {
+	flows.RegisterTemporaryFeature("_tcpClientIsSource", "returns true, if the TCP client (sender of the SYN) is the source of the flow, which is always the case for tracked connections; needs TCP expiry", ipfix.BooleanType, 0, flows.FlowFeature, func() flows.Feature { return &_tcpClientIsSource{} }, flows.RawPacket)
+}
+
+////////////////////////////////////////////////////////////////////////////////
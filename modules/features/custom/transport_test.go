@@ -0,0 +1,68 @@
+package custom
+
+import (
+	"testing"
+
+	"github.com/chtisgit/go-flows/flows"
+	_ "github.com/chtisgit/go-flows/modules/features/iana"
+	"github.com/chtisgit/go-flows/packet_test"
+	"github.com/google/gopacket/layers"
+)
+
+func TestTCPConnectionTracking(t *testing.T) {
+	table := packet_test.MakeFeatureTest(t, []string{"_tcpConnectionState", "_tcpHistory", "_tcpClientIsSource", "sourceTransportPort", "flowEndReason"}, flows.FlowFeature,
+		flows.FlowOptions{TCPLinger: 10 * flows.SecondsInNanoseconds})
+	client := []byte{10, 0, 0, 1}
+	server := []byte{10, 0, 0, 2}
+	toServer := func(when flows.DateTimeNanoseconds, port layers.TCPPort, tcp layers.TCP) {
+		tcp.SrcPort, tcp.DstPort = port, 80
+		table.EventLayers(when*flows.SecondsInNanoseconds, &layers.IPv4{SrcIP: client, DstIP: server, Protocol: layers.IPProtocolTCP}, &tcp)
+	}
+	toClient := func(when flows.DateTimeNanoseconds, port layers.TCPPort, tcp layers.TCP) {
+		tcp.SrcPort, tcp.DstPort = 80, port
+		table.EventLayers(when*flows.SecondsInNanoseconds, &layers.IPv4{SrcIP: server, DstIP: client, Protocol: layers.IPProtocolTCP}, &tcp)
+	}
+
+	// handshake and close; the final ACK starts the linger time
+	toServer(1, 1000, layers.TCP{SYN: true, Seq: 0})
+	toClient(2, 1000, layers.TCP{SYN: true, ACK: true, Seq: 100, Ack: 1})
+	toServer(3, 1000, layers.TCP{ACK: true, Seq: 1, Ack: 101})
+	toServer(4, 1000, layers.TCP{FIN: true, ACK: true, Seq: 1, Ack: 101})
+	toClient(5, 1000, layers.TCP{FIN: true, ACK: true, Seq: 101, Ack: 2})
+	toServer(6, 1000, layers.TCP{ACK: true, Seq: 2, Ack: 102})
+	// a new connection during the linger time restarts the flow, which is then reset
+	toServer(7, 1000, layers.TCP{SYN: true, Seq: 5000})
+	toClient(8, 1000, layers.TCP{RST: true, ACK: true, Ack: 5001})
+
+	// handshake, close, linger time runs out (the flow is exported with the next packet)
+	toServer(20, 1001, layers.TCP{SYN: true, Seq: 0})
+	toClient(20, 1001, layers.TCP{SYN: true, ACK: true, Seq: 100, Ack: 1})
+	toServer(20, 1001, layers.TCP{FIN: true, ACK: true, Seq: 1, Ack: 101})
+	toClient(21, 1001, layers.TCP{ACK: true, Seq: 101, Ack: 2})
+	toClient(22, 1001, layers.TCP{FIN: true, ACK: true, Seq: 101, Ack: 2})
+	toServer(23, 1001, layers.TCP{ACK: true, Seq: 2, Ack: 102})
+	table.Resume()
+	toServer(40, 1001, layers.TCP{ACK: true, Seq: 2, Ack: 102})
+
+	// missed the SYN; the flow is still oriented by the client
+	toClient(50, 2000, layers.TCP{SYN: true, ACK: true, Seq: 100, Ack: 1})
+
+	table.Finish(60 * flows.SecondsInNanoseconds)
+
+	line := func(when flows.DateTimeNanoseconds, state, history string, clientIsSource bool, port uint16, reason flows.FlowEndReason) packet_test.FeatureLine {
+		return packet_test.FeatureLine{When: when * flows.SecondsInNanoseconds, Features: []packet_test.FeatureResult{
+			{Name: "_tcpConnectionState", Value: state},
+			{Name: "_tcpHistory", Value: history},
+			{Name: "_tcpClientIsSource", Value: clientIsSource},
+			{Name: "sourceTransportPort", Value: port},
+			{Name: "flowEndReason", Value: uint16(reason)},
+		}}
+	}
+	table.AssertFeatureList([]packet_test.FeatureLine{
+		line(7, "TIME_WAIT", "ShAFf", true, 1000, flows.FlowEndReasonEnd),
+		line(8, "CLOSED", "Sr", true, 1000, flows.FlowEndReasonEnd),
+		line(40, "TIME_WAIT", "ShFafA", true, 1001, flows.FlowEndReasonEnd),
+		line(60, "ESTABLISHED", "A", true, 1001, flows.FlowEndReasonForcedEnd),
+		line(60, "SYN_RECEIVED", "^h", true, 2000, flows.FlowEndReasonForcedEnd),
+	})
+}
@@ -0,0 +1,206 @@
+// Package geoip contains features, which enrich addresses with the country and the autonomous system from MaxMind DB
+// files (e.g. GeoLite2-Country.mmdb and GeoLite2-ASN.mmdb). The databases must be loaded with Load before the flow
+// specification is parsed (go-flows -geoip file.mmdb); using the features without database is an error.
+//
+// Lookups are cached per address. Private, loopback, link-local, documentation, and other special-purpose addresses,
+// as well as addresses whose lookup fails, always result in no value.
+package geoip
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+
+	ipfix "github.com/CN-TU/go-ipfix"
+	"github.com/chtisgit/go-flows/flows"
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// cacheSize is the maximum number of cached addresses; the cache is cleared if it is full
+const cacheSize = 1 << 16
+
+// record holds the used fields of country, city, and asn databases
+type record struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	RegisteredCountry struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"registered_country"`
+	AutonomousSystemNumber       uint32 `maxminddb:"autonomous_system_number"`
+	AutonomousSystemOrganization string `maxminddb:"autonomous_system_organization"`
+}
+
+// info is the information about an address from all databases
+type info struct {
+	country      string
+	asn          uint32
+	organization string
+}
+
+var databases []*maxminddb.Reader
+
+var cache = struct {
+	sync.RWMutex
+	entries map[string]*info
+}{entries: make(map[string]*info)}
+
+// Load opens the given MaxMind DB file. Information from multiple databases is combined (e.g. country and asn); if
+// several databases contain a field, the first one wins.
+func Load(filename string) error {
+	db, err := maxminddb.Open(filename)
+	if err != nil {
+		return fmt.Errorf("couldn't open %s: %s", filename, err)
+	}
+	databases = append(databases, db)
+	cache.Lock()
+	cache.entries = make(map[string]*info)
+	cache.Unlock()
+	return nil
+}
+
+var specialNetworks []*net.IPNet
+
+func init() {
+	for _, network := range []string{
+		"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8", "169.254.0.0/16", "172.16.0.0/12", "192.0.0.0/24",
+		"192.0.2.0/24", "192.168.0.0/16", "198.18.0.0/15", "198.51.100.0/24", "203.0.113.0/24", "224.0.0.0/4",
+		"240.0.0.0/4", "255.255.255.255/32",
+		"::/128", "::1/128", "64:ff9b::/96", "2001:db8::/32", "fc00::/7", "fe80::/10", "ff00::/8",
+	} {
+		_, n, err := net.ParseCIDR(network)
+		if err != nil {
+			log.Panic(err)
+		}
+		specialNetworks = append(specialNetworks, n)
+	}
+}
+
+// isSpecial returns true for private and other special-purpose addresses, which don't have a location
+func isSpecial(ip net.IP) bool {
+	for _, network := range specialNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// lookup returns the information about the given address or nil if there is none
+func lookup(ip net.IP) *info {
+	if len(databases) == 0 {
+		return nil
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	if isSpecial(ip) {
+		return nil
+	}
+	key := string(ip)
+	cache.RLock()
+	ret, ok := cache.entries[key]
+	cache.RUnlock()
+	if ok {
+		return ret
+	}
+
+	ret = &info{}
+	for _, db := range databases {
+		var r record
+		if err := db.Lookup(ip, &r); err != nil {
+			// broken database entry
+			ret = &info{}
+			break
+		}
+		if ret.country == "" {
+			ret.country = r.Country.ISOCode
+			if ret.country == "" {
+				ret.country = r.RegisteredCountry.ISOCode
+			}
+		}
+		if ret.asn == 0 {
+			ret.asn = r.AutonomousSystemNumber
+		}
+		if ret.organization == "" {
+			ret.organization = r.AutonomousSystemOrganization
+		}
+	}
+	if *ret == (info{}) {
+		ret = nil
+	}
+
+	cache.Lock()
+	if len(cache.entries) >= cacheSize {
+		cache.entries = make(map[string]*info)
+	}
+	cache.entries[key] = ret
+	cache.Unlock()
+	return ret
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// geoFeature looks up an address and returns one field of the information
+type geoFeature struct {
+	flows.BaseFeature
+	field func(*info) interface{}
+}
+
+// CheckpointState returns nothing, since the field is fixed
+func (f *geoFeature) CheckpointState() []interface{} { return nil }
+
+func (f *geoFeature) Event(new interface{}, context *flows.EventContext, src interface{}) {
+	ip, ok := new.(net.IP)
+	if !ok {
+		return
+	}
+	if i := lookup(ip); i != nil {
+		if value := f.field(i); value != nil {
+			f.SetValue(value, context, f)
+		}
+	}
+}
+
+// resolveGeo returns a resolver for features of type t, which fails if no database is loaded
+func resolveGeo(t ipfix.Type) flows.TypeResolver {
+	return func([]ipfix.InformationElement) (ipfix.InformationElement, error) {
+		if len(databases) == 0 {
+			return ipfix.InformationElement{}, errors.New("geoip: no database loaded (use -geoip file.mmdb)")
+		}
+		return ipfix.NewInformationElement("", 0, 0, t, 0), nil
+	}
+}
+
+func registerGeoFeature(name, description string, t ipfix.Type, field func(*info) interface{}) {
+	for _, ft := range []flows.FeatureType{flows.PacketFeature, flows.FlowFeature} {
+		flows.RegisterCustomFunction(name, description+" of address a", resolveGeo(t), ft, func() flows.Feature { return &geoFeature{field: field} }, ft)
+	}
+	for _, direction := range []string{"source", "destination"} {
+		composite := direction + name[len("geo"):]
+		flows.RegisterCompositeFeature(ipfix.NewInformationElement(composite, 0, 0, t, 0), fmt.Sprintf("%s of the %s address", description, direction), name, direction+"IPAddress")
+	}
+}
+
+func init() {
+	registerGeoFeature("geoCountryCode", "ISO 3166 country code", ipfix.StringType, func(i *info) interface{} {
+		if i.country == "" {
+			return nil
+		}
+		return i.country
+	})
+	registerGeoFeature("geoASN", "autonomous system number", ipfix.Unsigned32Type, func(i *info) interface{} {
+		if i.asn == 0 {
+			return nil
+		}
+		return i.asn
+	})
+	registerGeoFeature("geoASOrganization", "autonomous system organization", ipfix.StringType, func(i *info) interface{} {
+		if i.organization == "" {
+			return nil
+		}
+		return i.organization
+	})
+}
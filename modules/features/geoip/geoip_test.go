@@ -0,0 +1,229 @@
+package geoip
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/chtisgit/go-flows/flows"
+	_ "github.com/chtisgit/go-flows/modules/features/iana"
+	"github.com/chtisgit/go-flows/packet_test"
+	"github.com/google/gopacket/layers"
+)
+
+// mmdb is a minimal MaxMind DB writer (ipv6 tree, 24 bit records) for generating test databases
+type mmdb struct {
+	nodes [][2]int // >= 0: node, -1: empty, < -1: -(data index)-2
+	data  [][]byte
+}
+
+func (m *mmdb) insert(t *testing.T, network string, value map[string]interface{}) {
+	_, n, err := net.ParseCIDR(network)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ip := n.IP
+	ones, bits := n.Mask.Size()
+	if bits == 32 {
+		// ipv4 addresses are stored in ::/96
+		ip = append(make(net.IP, 12), ip.To4()...)
+		ones += 96
+	}
+	if len(m.nodes) == 0 {
+		m.nodes = append(m.nodes, [2]int{-1, -1})
+	}
+	m.data = append(m.data, encode(value))
+	node := 0
+	for i := 0; i < ones; i++ {
+		bit := int(ip[i/8]>>uint(7-i%8)) & 1
+		if i == ones-1 {
+			m.nodes[node][bit] = -len(m.data) - 1
+			break
+		}
+		if m.nodes[node][bit] < 0 {
+			m.nodes = append(m.nodes, [2]int{-1, -1})
+			m.nodes[node][bit] = len(m.nodes) - 1
+		}
+		node = m.nodes[node][bit]
+	}
+}
+
+func (m *mmdb) write(t *testing.T, filename string) {
+	var buf bytes.Buffer
+	count := len(m.nodes)
+	var offsets []int
+	offset := 0
+	for _, d := range m.data {
+		offsets = append(offsets, offset)
+		offset += len(d)
+	}
+	for _, node := range m.nodes {
+		for _, record := range node {
+			var value int
+			switch {
+			case record >= 0:
+				value = record
+			case record == -1:
+				value = count
+			default:
+				value = count + 16 + offsets[-record-2]
+			}
+			buf.Write([]byte{byte(value >> 16), byte(value >> 8), byte(value)})
+		}
+	}
+	buf.Write(make([]byte, 16))
+	for _, d := range m.data {
+		buf.Write(d)
+	}
+	buf.WriteString("\xAB\xCD\xEFMaxMind.com")
+	buf.Write(encode(map[string]interface{}{
+		"node_count":                  uint32(count),
+		"record_size":                 uint16(24),
+		"ip_version":                  uint16(6),
+		"database_type":               "Test",
+		"binary_format_major_version": uint16(2),
+		"binary_format_minor_version": uint16(0),
+	}))
+	if err := ioutil.WriteFile(filename, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func encode(value interface{}) []byte {
+	control := func(t byte, size int) []byte {
+		if size >= 29 {
+			return []byte{t<<5 | 29, byte(size - 29)}
+		}
+		return []byte{t<<5 | byte(size)}
+	}
+	switch value := value.(type) {
+	case string:
+		return append(control(2, len(value)), value...)
+	case uint16:
+		return append(control(5, 2), byte(value>>8), byte(value))
+	case uint32:
+		return append(control(6, 4), byte(value>>24), byte(value>>16), byte(value>>8), byte(value))
+	case map[string]interface{}:
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		ret := control(7, len(value))
+		for _, key := range keys {
+			ret = append(ret, encode(key)...)
+			ret = append(ret, encode(value[key])...)
+		}
+		return ret
+	}
+	panic("type not supported")
+}
+
+func country(code string) map[string]interface{} {
+	return map[string]interface{}{"country": map[string]interface{}{"iso_code": code}}
+}
+
+var loadOnce sync.Once
+
+func loadDatabases(t *testing.T) {
+	loadOnce.Do(func() {
+		dir, err := ioutil.TempDir("", "geoip")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		var countries mmdb
+		countries.insert(t, "1.2.3.0/24", country("AT"))
+		countries.insert(t, "5.6.7.0/24", map[string]interface{}{"registered_country": map[string]interface{}{"iso_code": "FR"}})
+		countries.insert(t, "10.0.0.0/8", country("XX"))
+		countries.insert(t, "192.0.2.0/24", country("XX"))
+		countries.insert(t, "2001:db8::/32", country("XX"))
+		countries.insert(t, "2a00:1::/32", country("DE"))
+		countries.write(t, filepath.Join(dir, "country.mmdb"))
+
+		var asns mmdb
+		asns.insert(t, "1.2.3.0/24", map[string]interface{}{"autonomous_system_number": uint32(1234), "autonomous_system_organization": "Test AS"})
+		asns.insert(t, "2a00:1::/32", map[string]interface{}{"autonomous_system_number": uint32(200000), "autonomous_system_organization": "Test AS6"})
+		asns.write(t, filepath.Join(dir, "asn.mmdb"))
+
+		for _, file := range []string{"country.mmdb", "asn.mmdb"} {
+			if err := Load(filepath.Join(dir, file)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestLookup(t *testing.T) {
+	loadDatabases(t)
+	for _, test := range []struct {
+		ip     string
+		result *info
+	}{
+		{"1.2.3.4", &info{"AT", 1234, "Test AS"}},
+		{"::ffff:1.2.3.4", &info{"AT", 1234, "Test AS"}},
+		{"5.6.7.8", &info{"FR", 0, ""}},
+		{"2a00:1:2::1", &info{"DE", 200000, "Test AS6"}},
+		{"2a00:2::1", nil},
+		{"8.8.8.8", nil},
+		{"10.1.2.3", nil},
+		{"192.168.0.1", nil},
+		{"fe80::1", nil},
+		{"fd00::1", nil},
+		{"192.0.2.1", nil},
+		{"255.255.255.255", nil},
+		{"2001:db8::1", nil},
+		{"64:ff9b::102:304", nil},
+	} {
+		for i := 0; i < 2; i++ { // second round is cached
+			result := lookup(net.ParseIP(test.ip))
+			if (result == nil) != (test.result == nil) || (result != nil && *result != *test.result) {
+				t.Errorf("%s: expected %v, but got %v", test.ip, test.result, result)
+			}
+		}
+	}
+}
+
+func TestNoDatabase(t *testing.T) {
+	loaded := databases
+	databases = nil
+	defer func() { databases = loaded }()
+
+	if lookup(net.IP{1, 2, 3, 4}) != nil {
+		t.Error("lookup without database should return nil")
+	}
+	pipe, _ := flows.MakeExportPipeline(nil, flows.SortTypeNone, 1)
+	var rl flows.RecordListMaker
+	if err := rl.AppendRecord([]interface{}{"sourceCountryCode"}, nil, nil, pipe, false); err == nil {
+		t.Error("expected an error for geoip features without database")
+	}
+}
+
+func TestFeatures(t *testing.T) {
+	loadDatabases(t)
+	features := []interface{}{"sourceCountryCode", "destinationCountryCode", "sourceASN", "destinationASOrganization"}
+	table := packet_test.MakeFilteredFeatureTest(t, features, nil, nil, flows.FlowOptions{})
+	table.EventLayers(0, &layers.IPv4{SrcIP: net.IP{1, 2, 3, 4}, DstIP: net.IP{10, 0, 0, 1}, Protocol: layers.IPProtocolUDP}, &layers.UDP{SrcPort: 1000, DstPort: 53})
+	table.EventLayers(1, &layers.IPv6{SrcIP: net.ParseIP("2a00:2::1"), DstIP: net.ParseIP("2a00:1::1"), NextHeader: layers.IPProtocolUDP}, &layers.UDP{SrcPort: 1000, DstPort: 53})
+	table.Finish(2)
+	table.AssertFeatureList([]packet_test.FeatureLine{
+		{When: 2, Features: []packet_test.FeatureResult{
+			{Name: "sourceCountryCode", Value: "AT"},
+			{Name: "destinationCountryCode", Value: nil},
+			{Name: "sourceASN", Value: uint32(1234)},
+			{Name: "destinationASOrganization", Value: nil},
+		}},
+		{When: 2, Features: []packet_test.FeatureResult{
+			{Name: "sourceCountryCode", Value: nil},
+			{Name: "destinationCountryCode", Value: "DE"},
+			{Name: "sourceASN", Value: nil},
+			{Name: "destinationASOrganization", Value: "Test AS6"},
+		}},
+	})
+}
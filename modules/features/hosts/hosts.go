@@ -0,0 +1,73 @@
+// Package hosts contains features, which return aggregates of the source or destination host over all flows in a
+// sliding time window (e.g. the number of distinct destination ports a source contacted in the last 60 seconds). The
+// aggregates must be enabled before processing (go-flows -hostWindow 60); otherwise the features have no value.
+//
+// Every feature exists as packet feature with the aggregates including the current packet and as flow feature with the
+// aggregates of the host of the flow at flow start. The features ending in AtEnd return the aggregates at the last
+// packet of the flow instead, which is the latest state known at export time.
+package hosts
+
+import (
+	ipfix "github.com/CN-TU/go-ipfix"
+	"github.com/chtisgit/go-flows/flows"
+	"github.com/chtisgit/go-flows/packet"
+)
+
+// hostFeature returns one field of the aggregates of the source or destination host
+type hostFeature struct {
+	flows.BaseFeature
+	field       func(*packet.HostStats) interface{}
+	destination bool
+	flow        bool
+	end         bool
+}
+
+// CheckpointState returns nothing, since the field and the direction are fixed
+func (f *hostFeature) CheckpointState() []interface{} { return nil }
+
+func (f *hostFeature) Event(new interface{}, context *flows.EventContext, src interface{}) {
+	if f.flow && !f.end && f.Value() != nil {
+		return
+	}
+	source, destination := new.(packet.Buffer).HostStats()
+	if source == nil {
+		return
+	}
+	if f.flow && !context.Forward() {
+		source, destination = destination, source
+	}
+	stats := source
+	if f.destination {
+		stats = destination
+	}
+	f.SetValue(f.field(stats), context, f)
+}
+
+func registerHostFeature(name, description string, t ipfix.Type, field func(*packet.HostStats) interface{}) {
+	for _, direction := range []string{"source", "destination"} {
+		destination := direction == "destination"
+		constructor := func(flow, end bool) func() flows.Feature {
+			return func() flows.Feature {
+				return &hostFeature{field: field, destination: destination, flow: flow, end: end}
+			}
+		}
+		ie := ipfix.NewInformationElement(direction+"Host"+name, 0, 0, t, 0)
+		desc := description + " of the " + direction + " host in the host window (needs -hostWindow)"
+		flows.RegisterFeature(ie, desc, flows.PacketFeature, constructor(false, false), flows.RawPacket)
+		flows.RegisterFeature(ie, desc+"; at flow start", flows.FlowFeature, constructor(true, false), flows.RawPacket)
+		ie = ipfix.NewInformationElement(direction+"Host"+name+"AtEnd", 0, 0, t, 0)
+		flows.RegisterFeature(ie, desc+"; at the last packet of the flow", flows.FlowFeature, constructor(true, true), flows.RawPacket)
+	}
+}
+
+func init() {
+	registerHostFeature("PacketsSent", "number of packets sent", ipfix.Unsigned64Type, func(s *packet.HostStats) interface{} { return s.PacketsSent })
+	registerHostFeature("PacketsReceived", "number of packets received", ipfix.Unsigned64Type, func(s *packet.HostStats) interface{} { return s.PacketsReceived })
+	registerHostFeature("OctetsSent", "number of bytes (IP total length) sent", ipfix.Unsigned64Type, func(s *packet.HostStats) interface{} { return s.OctetsSent })
+	registerHostFeature("OctetsReceived", "number of bytes (IP total length) received", ipfix.Unsigned64Type, func(s *packet.HostStats) interface{} { return s.OctetsReceived })
+	registerHostFeature("DestinationPorts", "estimated number of distinct TCP and UDP destination ports contacted", ipfix.Unsigned64Type, func(s *packet.HostStats) interface{} { return s.DestinationPorts })
+	registerHostFeature("Destinations", "estimated number of distinct destination addresses contacted", ipfix.Unsigned64Type, func(s *packet.HostStats) interface{} { return s.Destinations })
+	registerHostFeature("Sources", "estimated number of distinct source addresses received from", ipfix.Unsigned64Type, func(s *packet.HostStats) interface{} { return s.Sources })
+	registerHostFeature("Connections", "estimated number of distinct connections initiated (protocol, addresses, and ports; TCP only counts SYN without ACK, other protocols count every packet)", ipfix.Unsigned64Type, func(s *packet.HostStats) interface{} { return s.Connections })
+	registerHostFeature("ConnectionRate", "connections initiated per second", ipfix.Float64Type, func(s *packet.HostStats) interface{} { return s.ConnectionRate })
+}
@@ -0,0 +1,39 @@
+package hosts
+
+import (
+	"net"
+	"testing"
+
+	"github.com/chtisgit/go-flows/flows"
+	"github.com/chtisgit/go-flows/packet"
+	"github.com/chtisgit/go-flows/packet_test"
+	"github.com/google/gopacket/layers"
+)
+
+func TestHostFeatures(t *testing.T) {
+	features := []string{"sourceHostDestinationPorts", "sourceHostDestinationPortsAtEnd", "destinationHostSources"}
+	table := packet_test.MakeFeatureTest(t, features, flows.FlowFeature, flows.FlowOptions{})
+	table.EnableHostAggregates(packet.HostAggregatesConfig{Window: 60 * flows.SecondsInNanoseconds})
+	for i := 0; i < 3; i++ {
+		table.EventLayers(flows.DateTimeNanoseconds(i), &layers.IPv4{SrcIP: net.IP{10, 0, 0, 1}, DstIP: net.IP{10, 0, 0, 2}, Protocol: layers.IPProtocolTCP}, &layers.TCP{SrcPort: 1000, DstPort: layers.TCPPort(80 + i), SYN: true})
+	}
+	// the reply belongs to the last flow; the flow source is still 10.0.0.1
+	table.EventLayers(3, &layers.IPv4{SrcIP: net.IP{10, 0, 0, 2}, DstIP: net.IP{10, 0, 0, 1}, Protocol: layers.IPProtocolTCP}, &layers.TCP{SrcPort: 82, DstPort: 1000, SYN: true, ACK: true})
+	table.EventLayers(4, &layers.IPv4{SrcIP: net.IP{10, 0, 0, 3}, DstIP: net.IP{10, 0, 0, 1}, Protocol: layers.IPProtocolTCP}, &layers.TCP{SrcPort: 1000, DstPort: 82, SYN: true})
+	table.EventLayers(5, &layers.IPv4{SrcIP: net.IP{10, 0, 0, 1}, DstIP: net.IP{10, 0, 0, 2}, Protocol: layers.IPProtocolTCP}, &layers.TCP{SrcPort: 1000, DstPort: 82})
+	table.Finish(6)
+
+	table.AssertFeatureList([]packet_test.FeatureLine{
+		{When: 6, Features: []packet_test.FeatureResult{{Name: features[0], Value: uint64(1)}, {Name: features[1], Value: uint64(1)}, {Name: features[2], Value: uint64(1)}}},
+		{When: 6, Features: []packet_test.FeatureResult{{Name: features[0], Value: uint64(2)}, {Name: features[1], Value: uint64(2)}, {Name: features[2], Value: uint64(1)}}},
+		{When: 6, Features: []packet_test.FeatureResult{{Name: features[0], Value: uint64(3)}, {Name: features[1], Value: uint64(3)}, {Name: features[2], Value: uint64(1)}}},
+		{When: 6, Features: []packet_test.FeatureResult{{Name: features[0], Value: uint64(1)}, {Name: features[1], Value: uint64(1)}, {Name: features[2], Value: uint64(2)}}},
+	})
+}
+
+func TestHostFeaturesDisabled(t *testing.T) {
+	table := packet_test.MakePacketFeatureTest(t, "sourceHostConnectionRate")
+	table.EventLayers(0, &layers.IPv4{SrcIP: net.IP{10, 0, 0, 1}, DstIP: net.IP{10, 0, 0, 2}, Protocol: layers.IPProtocolUDP}, &layers.UDP{SrcPort: 1000, DstPort: 53})
+	table.Finish(1)
+	table.AssertFeatureValue("sourceHostConnectionRate", nil)
+}
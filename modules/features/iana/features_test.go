@@ -4,6 +4,7 @@ import (
 	"net"
 	"testing"
 
+	"github.com/chtisgit/go-flows/flows"
 	"github.com/chtisgit/go-flows/packet"
 	"github.com/chtisgit/go-flows/packet_test"
 	"github.com/google/gopacket/layers"
@@ -95,3 +96,20 @@ func TestDestinationIPAddress(t *testing.T) {
 		{When: 0, Features: []packet_test.FeatureResult{{Name: "destinationIPv6Address", Value: net.IP{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 2}}}},
 	})
 }
+
+func TestCheckpoint(t *testing.T) {
+	table := packet_test.MakeFeatureTest(t, []string{"sourceIPAddress", "packetTotalCount", "flowStartNanoseconds", "flowEndNanoseconds"}, flows.FlowFeature, flows.FlowOptions{})
+	table.EventLayers(1, &layers.IPv4{SrcIP: []byte{1, 2, 3, 4}, DstIP: []byte{1, 2, 3, 5}}, &layers.UDP{SrcPort: 80, DstPort: 81})
+	table.EventLayers(2, &layers.IPv4{SrcIP: []byte{1, 2, 3, 5}, DstIP: []byte{1, 2, 3, 4}}, &layers.UDP{SrcPort: 81, DstPort: 80})
+	table.Resume()
+	table.EventLayers(3, &layers.IPv4{SrcIP: []byte{1, 2, 3, 4}, DstIP: []byte{1, 2, 3, 5}}, &layers.UDP{SrcPort: 80, DstPort: 81})
+	table.Finish(4)
+	table.AssertFeatureList([]packet_test.FeatureLine{
+		{When: 4, Features: []packet_test.FeatureResult{
+			{Name: "sourceIPv4Address", Value: net.IP{1, 2, 3, 4}},
+			{Name: "packetTotalCount", Value: uint64(3)},
+			{Name: "flowStartNanoseconds", Value: flows.DateTimeNanoseconds(1)},
+			{Name: "flowEndNanoseconds", Value: flows.DateTimeNanoseconds(3)},
+		}},
+	})
+}
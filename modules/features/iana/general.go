@@ -25,6 +25,8 @@ type flowEndNanoseconds struct {
 	lastTime flows.DateTimeNanoseconds
 }
 
+func (f *flowEndNanoseconds) CheckpointState() []interface{} { return []interface{}{&f.lastTime} }
+
 func (f *flowEndNanoseconds) Event(new interface{}, context *flows.EventContext, src interface{}) {
 	f.lastTime = context.When()
 }
@@ -110,6 +112,8 @@ type packetTotalCount struct {
 	count uint64
 }
 
+func (f *packetTotalCount) CheckpointState() []interface{} { return []interface{}{&f.count} }
+
 func (f *packetTotalCount) Start(context *flows.EventContext) {
 	f.BaseFeature.Start(context)
 	f.count = 0
@@ -135,6 +139,10 @@ type flowDurationNanoseconds struct {
 	lastTime flows.DateTimeNanoseconds
 }
 
+func (f *flowDurationNanoseconds) CheckpointState() []interface{} {
+	return []interface{}{&f.start, &f.lastTime}
+}
+
 func (f *flowDurationNanoseconds) Start(context *flows.EventContext) {
 	f.BaseFeature.Start(context)
 	f.start = context.When()
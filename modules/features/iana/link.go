@@ -26,6 +26,10 @@ type layer2OctetTotalCountFlow struct {
 	total uint64
 }
 
+func (f *layer2OctetTotalCountFlow) CheckpointState() []interface{} {
+	return []interface{}{&f.total}
+}
+
 func (f *layer2OctetTotalCountFlow) Start(context *flows.EventContext) {
 	f.BaseFeature.Start(context)
 	f.total = 0
@@ -71,7 +75,11 @@ func (f *sourceMacAddress) Event(new interface{}, context *flows.EventContext, s
 	if f.Value() == nil {
 		link, ok := new.(packet.Buffer).LinkLayer().(*layers.Ethernet)
 		if ok {
-			f.SetValue(append(net.HardwareAddr(nil), link.SrcMAC...), context, f)
+			mac := link.SrcMAC
+			if !context.Forward() {
+				mac = link.DstMAC
+			}
+			f.SetValue(append(net.HardwareAddr(nil), mac...), context, f)
 		}
 	}
 }
@@ -90,7 +98,11 @@ func (f *destinationMacAddress) Event(new interface{}, context *flows.EventConte
 	if f.Value() == nil {
 		link, ok := new.(packet.Buffer).LinkLayer().(*layers.Ethernet)
 		if ok {
-			f.SetValue(append(net.HardwareAddr(nil), link.DstMAC...), context, f)
+			mac := link.DstMAC
+			if !context.Forward() {
+				mac = link.SrcMAC
+			}
+			f.SetValue(append(net.HardwareAddr(nil), mac...), context, f)
 		}
 	}
 }
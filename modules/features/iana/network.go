@@ -22,7 +22,11 @@ func (f *sourceIPAddressFlow) Event(new interface{}, context *flows.EventContext
 	if f.Value() == nil {
 		network := new.(packet.Buffer).NetworkLayer()
 		if network != nil {
-			ipaddr := network.NetworkFlow().Src().Raw() // this makes a copy of the ip
+			endpoints := network.NetworkFlow()
+			ipaddr := endpoints.Src().Raw() // this makes a copy of the ip
+			if !context.Forward() {
+				ipaddr = endpoints.Dst().Raw()
+			}
 			if ipaddr != nil {
 				fin := net.IP(ipaddr)
 				f.SetValue(fin, context, f)
@@ -104,7 +108,11 @@ func (f *destinationIPAddressFlow) Event(new interface{}, context *flows.EventCo
 	if f.Value() == nil {
 		network := new.(packet.Buffer).NetworkLayer()
 		if network != nil {
-			ipaddr := network.NetworkFlow().Dst().Raw() // this makes a copy of the ip
+			endpoints := network.NetworkFlow()
+			ipaddr := endpoints.Dst().Raw() // this makes a copy of the ip
+			if !context.Forward() {
+				ipaddr = endpoints.Src().Raw()
+			}
 			if ipaddr != nil {
 				fin := net.IP(ipaddr)
 				f.SetValue(fin, context, f)
@@ -227,6 +235,8 @@ type octetTotalCountFlow struct {
 	total uint64
 }
 
+func (f *octetTotalCountFlow) CheckpointState() []interface{} { return []interface{}{&f.total} }
+
 func (f *octetTotalCountFlow) Start(context *flows.EventContext) {
 	f.BaseFeature.Start(context)
 	f.total = 0
@@ -286,6 +296,8 @@ type ipTotalLengthFlow struct {
 	total uint64
 }
 
+func (f *ipTotalLengthFlow) CheckpointState() []interface{} { return []interface{}{&f.total} }
+
 func (f *ipTotalLengthFlow) Start(context *flows.EventContext) {
 	f.BaseFeature.Start(context)
 	f.total = 0
@@ -16,7 +16,11 @@ func (f *sourceTransportPortFlow) Event(new interface{}, context *flows.EventCon
 	if f.Value() == nil {
 		transport := new.(packet.Buffer).TransportLayer()
 		if transport != nil {
-			srcp := transport.TransportFlow().Src().Raw()
+			endpoints := transport.TransportFlow()
+			srcp := endpoints.Src().Raw()
+			if !context.Forward() {
+				srcp = endpoints.Dst().Raw()
+			}
 			if srcp != nil {
 				fin := binary.BigEndian.Uint16(srcp)
 				f.SetValue(fin, context, f)
@@ -60,7 +64,11 @@ func (f *destinationTransportPortFlow) Event(new interface{}, context *flows.Eve
 	if f.Value() == nil {
 		transport := new.(packet.Buffer).TransportLayer()
 		if transport != nil {
-			dstp := transport.TransportFlow().Dst().Raw()
+			endpoints := transport.TransportFlow()
+			dstp := endpoints.Dst().Raw()
+			if !context.Forward() {
+				dstp = endpoints.Src().Raw()
+			}
 			if dstp != nil {
 				fin := binary.BigEndian.Uint16(dstp)
 				f.SetValue(fin, context, f)
@@ -147,6 +155,8 @@ type tcpSynTotalCountFlow struct {
 	count uint64
 }
 
+func (f *tcpSynTotalCountFlow) CheckpointState() []interface{} { return []interface{}{&f.count} }
+
 func (f *tcpSynTotalCountFlow) Start(context *flows.EventContext) {
 	f.BaseFeature.Start(context)
 	f.count = 0
@@ -193,6 +203,8 @@ type tcpFinTotalCountFlow struct {
 	count uint64
 }
 
+func (f *tcpFinTotalCountFlow) CheckpointState() []interface{} { return []interface{}{&f.count} }
+
 func (f *tcpFinTotalCountFlow) Start(context *flows.EventContext) {
 	f.BaseFeature.Start(context)
 	f.count = 0
@@ -239,6 +251,8 @@ type tcpRstTotalCountFlow struct {
 	count uint64
 }
 
+func (f *tcpRstTotalCountFlow) CheckpointState() []interface{} { return []interface{}{&f.count} }
+
 func (f *tcpRstTotalCountFlow) Start(context *flows.EventContext) {
 	f.BaseFeature.Start(context)
 	f.count = 0
@@ -285,6 +299,8 @@ type tcpPshTotalCountFlow struct {
 	count uint64
 }
 
+func (f *tcpPshTotalCountFlow) CheckpointState() []interface{} { return []interface{}{&f.count} }
+
 func (f *tcpPshTotalCountFlow) Start(context *flows.EventContext) {
 	f.BaseFeature.Start(context)
 	f.count = 0
@@ -331,6 +347,8 @@ type tcpAckTotalCountFlow struct {
 	count uint64
 }
 
+func (f *tcpAckTotalCountFlow) CheckpointState() []interface{} { return []interface{}{&f.count} }
+
 func (f *tcpAckTotalCountFlow) Start(context *flows.EventContext) {
 	f.BaseFeature.Start(context)
 	f.count = 0
@@ -376,6 +394,8 @@ type tcpUrgTotalCountFlow struct {
 	count uint64
 }
 
+func (f *tcpUrgTotalCountFlow) CheckpointState() []interface{} { return []interface{}{&f.count} }
+
 func (f *tcpUrgTotalCountFlow) Start(context *flows.EventContext) {
 	f.BaseFeature.Start(context)
 	f.count = 0
@@ -423,6 +443,10 @@ type tcpSequenceNumber struct {
 	cutoff bool
 }
 
+func (f *tcpSequenceNumber) CheckpointState() []interface{} {
+	return []interface{}{&f.isn, &f.cutoff}
+}
+
 func (f *tcpSequenceNumber) Start(context *flows.EventContext) {
 	f.BaseFeature.Start(context)
 	f.isn = features.InvalidSequence
@@ -467,6 +491,10 @@ type reverseTCPSequenceNumber struct {
 	cutoff bool
 }
 
+func (f *reverseTCPSequenceNumber) CheckpointState() []interface{} {
+	return []interface{}{&f.isn, &f.cutoff}
+}
+
 func (f *reverseTCPSequenceNumber) Start(context *flows.EventContext) {
 	f.BaseFeature.Start(context)
 	f.isn = features.InvalidSequence
@@ -21,6 +21,9 @@ func (f *flowID) Event(new interface{}, context *flows.EventContext, src interfa
 			return
 		}
 		flow := nl.NetworkFlow()
+		if !context.Forward() {
+			flow = flow.Reverse()
+		}
 		f.SetValue(fmt.Sprintf("%s > %s", flow.Src(), flow.Dst()), context, f)
 	}
 }
@@ -65,6 +68,10 @@ type ports struct {
 	initProto bool
 }
 
+func (f *ports) CheckpointState() []interface{} {
+	return []interface{}{&f.src, &f.dst, &f.proto, &f.initPort, &f.initProto}
+}
+
 func (f *ports) Start(context *flows.EventContext) {
 	f.BaseFeature.Start(context)
 	f.src = 0
@@ -131,6 +138,8 @@ type tdata struct {
 	bytes uint64
 }
 
+func (f *tdata) CheckpointState() []interface{} { return []interface{}{&f.bytes} }
+
 func (f *tdata) Start(context *flows.EventContext) {
 	f.BaseFeature.Start(context)
 	f.bytes = 0
@@ -166,6 +175,8 @@ type secwindow struct {
 	tsec uint32
 }
 
+func (f *secwindow) CheckpointState() []interface{} { return []interface{}{&f.tsec} }
+
 func (f *secwindow) Start(context *flows.EventContext) {
 	f.tsec = 0
 }
@@ -190,6 +201,8 @@ type ton struct {
 	ton  uint32
 }
 
+func (f *ton) CheckpointState() []interface{} { return []interface{}{&f.last, &f.ton} }
+
 func (f *ton) Start(context *flows.EventContext) {
 	f.BaseFeature.Start(context)
 	f.last = 0
@@ -230,6 +243,8 @@ type toff struct {
 	last uint32
 }
 
+func (f *toff) CheckpointState() []interface{} { return []interface{}{&f.last} }
+
 func (f *toff) Start(context *flows.EventContext) {
 	f.BaseFeature.Start(context)
 	f.last = 0
@@ -266,6 +281,8 @@ type milliSecwindow struct {
 	tsec uint32
 }
 
+func (f *milliSecwindow) CheckpointState() []interface{} { return []interface{}{&f.tsec} }
+
 func (f *milliSecwindow) Start(context *flows.EventContext) {
 	f.tsec = 0
 }
@@ -0,0 +1,36 @@
+package operations
+
+import (
+	ipfix "github.com/CN-TU/go-ipfix"
+	"github.com/chtisgit/go-flows/flows"
+)
+
+// isInteger returns true for signed and unsigned integer types
+func isInteger(t ipfix.Type) bool {
+	switch t {
+	case ipfix.Unsigned8Type, ipfix.Unsigned16Type, ipfix.Unsigned32Type, ipfix.Unsigned64Type,
+		ipfix.Signed8Type, ipfix.Signed16Type, ipfix.Signed32Type, ipfix.Signed64Type:
+		return true
+	}
+	return false
+}
+
+// resolveIntegers returns the up-converted type of two integer arguments (see gen_bits.go)
+func resolveIntegers(args []ipfix.InformationElement) (ipfix.InformationElement, error) {
+	if len(args) != 2 {
+		return ipfix.InformationElement{}, flows.MakeIncompatibleVariantError("bitwise operations need exactly two arguments")
+	}
+	for _, arg := range args {
+		if !isInteger(arg.Type) {
+			return ipfix.InformationElement{}, flows.MakeIncompatibleVariantError("bitwise operations need integer arguments, but %s is %s", arg.Name, arg.Type)
+		}
+	}
+	return ipfix.InformationElement{Type: flows.UpConvertTypes(args[0].Type, args[1].Type)}, nil
+}
+
+func resolvePopcount(args []ipfix.InformationElement) (ipfix.InformationElement, error) {
+	if len(args) != 1 || !isInteger(args[0].Type) {
+		return ipfix.InformationElement{}, flows.MakeIncompatibleVariantError("popcount needs one integer argument")
+	}
+	return ipfix.InformationElement{Type: ipfix.Unsigned8Type}, nil
+}
@@ -0,0 +1,253 @@
+package operations
+
+// Created by gen_bits.go, don't edit manually!
+// Generated at 2026-10-19 09:03:52.902902059 +0000 UTC m=+0.000242085
+
+import (
+	"math/bits"
+
+	"github.com/chtisgit/go-flows/flows"
+)
+
+type popcountPacket struct {
+	flows.BaseFeature
+}
+
+func (f *popcountPacket) Event(new interface{}, context *flows.EventContext, src interface{}) {
+	f.SetValue(uint8(bits.OnesCount64(flows.ToUInt(new))), context, f)
+}
+
+type popcountFlow struct {
+	flows.BaseFeature
+}
+
+func (f *popcountFlow) Event(new interface{}, context *flows.EventContext, src interface{}) {
+	if f.Value() == nil {
+		f.SetValue(uint8(bits.OnesCount64(flows.ToUInt(new))), context, f)
+	}
+}
+
+func init() {
+	flows.RegisterCustomFunction("popcount", "returns the number of bits set in a", resolvePopcount, flows.PacketFeature, func() flows.Feature { return &popcountPacket{} }, flows.PacketFeature)
+	flows.RegisterCustomFunction("popcount", "returns the number of bits set in a", resolvePopcount, flows.FlowFeature, func() flows.Feature { return &popcountFlow{} }, flows.FlowFeature)
+}
+
+type bitandPacket struct {
+	flows.MultiBasePacketFeature
+}
+
+func (f *bitandPacket) Event(new interface{}, context *flows.EventContext, src interface{}) {
+	values := f.EventResult(new, src)
+	if values == nil {
+		return
+	}
+
+	dst, fl, a, b := flows.UpConvert(values[0], values[1])
+	var result interface{}
+	switch fl {
+	case flows.UIntType:
+		result = a.(uint64) & b.(uint64)
+	case flows.IntType:
+		result = a.(int64) & b.(int64)
+	}
+	f.SetValue(flows.FixType(result, dst), context, f)
+}
+
+type bitandFlow struct {
+	flows.MultiBaseFlowFeature
+}
+
+func (f *bitandFlow) Stop(reason flows.FlowEndReason, context *flows.EventContext) {
+	values := f.GetValues(context)
+
+	dst, fl, a, b := flows.UpConvert(values[0], values[1])
+	var result interface{}
+	switch fl {
+	case flows.UIntType:
+		result = a.(uint64) & b.(uint64)
+	case flows.IntType:
+		result = a.(int64) & b.(int64)
+	}
+	f.SetValue(flows.FixType(result, dst), context, f)
+}
+
+func init() {
+	flows.RegisterCustomFunction("bitand", "returns a & b", resolveIntegers, flows.PacketFeature, func() flows.Feature { return &bitandPacket{} }, flows.PacketFeature, flows.PacketFeature)
+	flows.RegisterCustomFunction("bitand", "returns a & b", resolveIntegers, flows.FlowFeature, func() flows.Feature { return &bitandFlow{} }, flows.FlowFeature, flows.FlowFeature)
+}
+
+type bitorPacket struct {
+	flows.MultiBasePacketFeature
+}
+
+func (f *bitorPacket) Event(new interface{}, context *flows.EventContext, src interface{}) {
+	values := f.EventResult(new, src)
+	if values == nil {
+		return
+	}
+
+	dst, fl, a, b := flows.UpConvert(values[0], values[1])
+	var result interface{}
+	switch fl {
+	case flows.UIntType:
+		result = a.(uint64) | b.(uint64)
+	case flows.IntType:
+		result = a.(int64) | b.(int64)
+	}
+	f.SetValue(flows.FixType(result, dst), context, f)
+}
+
+type bitorFlow struct {
+	flows.MultiBaseFlowFeature
+}
+
+func (f *bitorFlow) Stop(reason flows.FlowEndReason, context *flows.EventContext) {
+	values := f.GetValues(context)
+
+	dst, fl, a, b := flows.UpConvert(values[0], values[1])
+	var result interface{}
+	switch fl {
+	case flows.UIntType:
+		result = a.(uint64) | b.(uint64)
+	case flows.IntType:
+		result = a.(int64) | b.(int64)
+	}
+	f.SetValue(flows.FixType(result, dst), context, f)
+}
+
+func init() {
+	flows.RegisterCustomFunction("bitor", "returns a | b", resolveIntegers, flows.PacketFeature, func() flows.Feature { return &bitorPacket{} }, flows.PacketFeature, flows.PacketFeature)
+	flows.RegisterCustomFunction("bitor", "returns a | b", resolveIntegers, flows.FlowFeature, func() flows.Feature { return &bitorFlow{} }, flows.FlowFeature, flows.FlowFeature)
+}
+
+type bitxorPacket struct {
+	flows.MultiBasePacketFeature
+}
+
+func (f *bitxorPacket) Event(new interface{}, context *flows.EventContext, src interface{}) {
+	values := f.EventResult(new, src)
+	if values == nil {
+		return
+	}
+
+	dst, fl, a, b := flows.UpConvert(values[0], values[1])
+	var result interface{}
+	switch fl {
+	case flows.UIntType:
+		result = a.(uint64) ^ b.(uint64)
+	case flows.IntType:
+		result = a.(int64) ^ b.(int64)
+	}
+	f.SetValue(flows.FixType(result, dst), context, f)
+}
+
+type bitxorFlow struct {
+	flows.MultiBaseFlowFeature
+}
+
+func (f *bitxorFlow) Stop(reason flows.FlowEndReason, context *flows.EventContext) {
+	values := f.GetValues(context)
+
+	dst, fl, a, b := flows.UpConvert(values[0], values[1])
+	var result interface{}
+	switch fl {
+	case flows.UIntType:
+		result = a.(uint64) ^ b.(uint64)
+	case flows.IntType:
+		result = a.(int64) ^ b.(int64)
+	}
+	f.SetValue(flows.FixType(result, dst), context, f)
+}
+
+func init() {
+	flows.RegisterCustomFunction("bitxor", "returns a ^ b", resolveIntegers, flows.PacketFeature, func() flows.Feature { return &bitxorPacket{} }, flows.PacketFeature, flows.PacketFeature)
+	flows.RegisterCustomFunction("bitxor", "returns a ^ b", resolveIntegers, flows.FlowFeature, func() flows.Feature { return &bitxorFlow{} }, flows.FlowFeature, flows.FlowFeature)
+}
+
+type shlPacket struct {
+	flows.MultiBasePacketFeature
+}
+
+func (f *shlPacket) Event(new interface{}, context *flows.EventContext, src interface{}) {
+	values := f.EventResult(new, src)
+	if values == nil {
+		return
+	}
+
+	dst, fl, a, b := flows.UpConvert(values[0], values[1])
+	var result interface{}
+	switch fl {
+	case flows.UIntType:
+		result = a.(uint64) << b.(uint64)
+	case flows.IntType:
+		result = a.(int64) << uint64(b.(int64))
+	}
+	f.SetValue(flows.FixType(result, dst), context, f)
+}
+
+type shlFlow struct {
+	flows.MultiBaseFlowFeature
+}
+
+func (f *shlFlow) Stop(reason flows.FlowEndReason, context *flows.EventContext) {
+	values := f.GetValues(context)
+
+	dst, fl, a, b := flows.UpConvert(values[0], values[1])
+	var result interface{}
+	switch fl {
+	case flows.UIntType:
+		result = a.(uint64) << b.(uint64)
+	case flows.IntType:
+		result = a.(int64) << uint64(b.(int64))
+	}
+	f.SetValue(flows.FixType(result, dst), context, f)
+}
+
+func init() {
+	flows.RegisterCustomFunction("shl", "returns a << b", resolveIntegers, flows.PacketFeature, func() flows.Feature { return &shlPacket{} }, flows.PacketFeature, flows.PacketFeature)
+	flows.RegisterCustomFunction("shl", "returns a << b", resolveIntegers, flows.FlowFeature, func() flows.Feature { return &shlFlow{} }, flows.FlowFeature, flows.FlowFeature)
+}
+
+type shrPacket struct {
+	flows.MultiBasePacketFeature
+}
+
+func (f *shrPacket) Event(new interface{}, context *flows.EventContext, src interface{}) {
+	values := f.EventResult(new, src)
+	if values == nil {
+		return
+	}
+
+	dst, fl, a, b := flows.UpConvert(values[0], values[1])
+	var result interface{}
+	switch fl {
+	case flows.UIntType:
+		result = a.(uint64) >> b.(uint64)
+	case flows.IntType:
+		result = a.(int64) >> uint64(b.(int64))
+	}
+	f.SetValue(flows.FixType(result, dst), context, f)
+}
+
+type shrFlow struct {
+	flows.MultiBaseFlowFeature
+}
+
+func (f *shrFlow) Stop(reason flows.FlowEndReason, context *flows.EventContext) {
+	values := f.GetValues(context)
+
+	dst, fl, a, b := flows.UpConvert(values[0], values[1])
+	var result interface{}
+	switch fl {
+	case flows.UIntType:
+		result = a.(uint64) >> b.(uint64)
+	case flows.IntType:
+		result = a.(int64) >> uint64(b.(int64))
+	}
+	f.SetValue(flows.FixType(result, dst), context, f)
+}
+
+func init() {
+	flows.RegisterCustomFunction("shr", "returns a >> b", resolveIntegers, flows.PacketFeature, func() flows.Feature { return &shrPacket{} }, flows.PacketFeature, flows.PacketFeature)
+	flows.RegisterCustomFunction("shr", "returns a >> b", resolveIntegers, flows.FlowFeature, func() flows.Feature { return &shrFlow{} }, flows.FlowFeature, flows.FlowFeature)
+}
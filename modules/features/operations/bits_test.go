@@ -0,0 +1,122 @@
+package operations
+
+import (
+	"strings"
+	"testing"
+
+	ipfix "github.com/CN-TU/go-ipfix"
+	"github.com/chtisgit/go-flows/flows"
+	"github.com/chtisgit/go-flows/packet"
+	"github.com/chtisgit/go-flows/packet_test"
+	"github.com/google/gopacket/layers"
+)
+
+func TestBitwiseOperations(t *testing.T) {
+	table := packet_test.MakeFilteredFeatureTest(t, parseExpressions(t,
+		"accumulate(shr(ipClassOfService, 2))",
+		"accumulate(bitand(ipClassOfService, 0x3))",
+		"accumulate(bitor(ipClassOfService, 1))",
+		"accumulate(bitxor(ipClassOfService, 0xff))",
+		"accumulate(shl(ipClassOfService, 1))",
+		"accumulate(popcount(ipClassOfService))",
+		"bitand(packetTotalCount, 1)",
+		"popcount(packetTotalCount)",
+	), nil, nil, flows.FlowOptions{})
+	table.EventLayers(0,
+		&layers.IPv4{SrcIP: []byte{10, 0, 0, 1}, DstIP: []byte{10, 0, 0, 2}, Protocol: layers.IPProtocolTCP, TOS: 0xb9},
+		&layers.TCP{SrcPort: 1000, DstPort: 80, SYN: true})
+	table.EventLayers(0,
+		&layers.IPv4{SrcIP: []byte{10, 0, 0, 1}, DstIP: []byte{10, 0, 0, 2}, Protocol: layers.IPProtocolTCP, TOS: 0x02},
+		&layers.TCP{SrcPort: 1000, DstPort: 80, SYN: true})
+	table.EventLayers(0,
+		&layers.IPv4{SrcIP: []byte{10, 0, 0, 1}, DstIP: []byte{10, 0, 0, 2}, Protocol: layers.IPProtocolTCP},
+		&layers.TCP{SrcPort: 1000, DstPort: 80, SYN: true})
+	table.Finish(0)
+	table.AssertFeatureList([]packet_test.FeatureLine{
+		{When: 0, Features: []packet_test.FeatureResult{
+			{Name: "accumulate(shr(ipClassOfService,2))", Value: []interface{}{int64(0x2e), int64(0), int64(0)}},
+			{Name: "accumulate(bitand(ipClassOfService,3))", Value: []interface{}{int64(1), int64(2), int64(0)}},
+			{Name: "accumulate(bitor(ipClassOfService,1))", Value: []interface{}{int64(0xb9), int64(3), int64(1)}},
+			{Name: "accumulate(bitxor(ipClassOfService,255))", Value: []interface{}{int64(0x46), int64(0xfd), int64(0xff)}},
+			{Name: "accumulate(shl(ipClassOfService,1))", Value: []interface{}{int64(0x172), int64(4), int64(0)}},
+			{Name: "accumulate(popcount(ipClassOfService))", Value: []interface{}{uint8(5), uint8(1), uint8(0)}},
+			{Name: "bitand(packetTotalCount,1)", Value: int64(1)},
+			{Name: "popcount(packetTotalCount)", Value: uint8(2)},
+		}},
+	})
+}
+
+func TestBitwiseNarrowArguments(t *testing.T) {
+	features := parseExpressions(t, "accumulate(bitand(ipClassOfService, ipTTL))", "bitand(max(ipClassOfService), max(ipTTL))")
+	columns, errs := flows.CheckFeatures(features[1:], nil, nil)
+	if len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	if columns[0].Types[0].Type != ipfix.Unsigned64Type {
+		t.Errorf("Expected unsigned64, but got %s", columns[0].Types[0].Type)
+	}
+	table := packet_test.MakeFilteredFeatureTest(t, features, nil, nil, flows.FlowOptions{})
+	table.EventLayers(0,
+		&layers.IPv4{SrcIP: []byte{10, 0, 0, 1}, DstIP: []byte{10, 0, 0, 2}, Protocol: layers.IPProtocolTCP, TOS: 0xb9, TTL: 0xff},
+		&layers.TCP{SrcPort: 1000, DstPort: 80, SYN: true})
+	table.Finish(0)
+	table.AssertFeatureList([]packet_test.FeatureLine{
+		{When: 0, Features: []packet_test.FeatureResult{
+			{Name: "accumulate(bitand(ipClassOfService,ipTTL))", Value: []interface{}{uint64(185)}},
+			{Name: "bitand(max(ipClassOfService),max(ipTTL))", Value: uint64(185)},
+		}},
+	})
+}
+
+func TestBitwiseTypes(t *testing.T) {
+	pipe, _ := flows.MakeExportPipeline(nil, flows.SortTypeNone, 1)
+	for _, feature := range []string{"bitand(flowStartSeconds, 1)", "shl(sourceIPAddress, 1)", "popcount(ipTotalLength / 2.5)"} {
+		var rl flows.RecordListMaker
+		if err := rl.AppendRecord(parseExpressions(t, feature), nil, nil, pipe, false); err == nil {
+			t.Errorf("%s: expected an error", feature)
+		}
+	}
+}
+
+func TestByteOperations(t *testing.T) {
+	payload := func(data ...byte) []packet.SerializableLayerType {
+		return []packet.SerializableLayerType{
+			&layers.IPv4{SrcIP: []byte{10, 0, 0, 1}, DstIP: []byte{10, 0, 0, 2}, Protocol: layers.IPProtocolUDP},
+			&layers.UDP{BaseLayer: layers.BaseLayer{Payload: data}, SrcPort: 1000, DstPort: 53},
+		}
+	}
+	table := packet_test.MakeFilteredFeatureTest(t, parseExpressions(t,
+		"accumulate(byte_at(_payload, 2))",
+		"accumulate(hex(bytes_slice(_payload, 1, 3)))",
+		"accumulate(u16be(_payload, 0))",
+		"accumulate(u32be(_payload, 1))",
+	), nil, nil, flows.FlowOptions{})
+	table.EventLayers(0, payload(0x12, 0x34, 0x56, 0x78, 0x9a)...)
+	table.EventLayers(0, payload(0xab, 0xcd)...)
+	table.Finish(0)
+	table.AssertFeatureList([]packet_test.FeatureLine{
+		{When: 0, Features: []packet_test.FeatureResult{
+			{Name: "accumulate(byte_at(_payload,2))", Value: []interface{}{uint8(0x56)}},
+			{Name: "accumulate(hex(bytes_slice(_payload,1,3)))", Value: []interface{}{"3456", "cd"}},
+			{Name: "accumulate(u16be(_payload,0))", Value: []interface{}{uint16(0x1234), uint16(0xabcd)}},
+			{Name: "accumulate(u32be(_payload,1))", Value: []interface{}{uint32(0x3456789a)}},
+		}},
+	})
+}
+
+func TestByteArgumentErrors(t *testing.T) {
+	pipe, _ := flows.MakeExportPipeline(nil, flows.SortTypeNone, 1)
+	for _, test := range []struct {
+		expr, err string
+	}{
+		{"accumulate(byte_at(_payload, -1))", "offsets must be non-negative"},
+		{"accumulate(u16be(_payload, 0.5))", "offsets must be non-negative"},
+		{"accumulate(bytes_slice(_payload, 5, 2))", "end (2) must not be less than start (5)"},
+	} {
+		var rl flows.RecordListMaker
+		err := rl.AppendRecord(parseExpressions(t, test.expr), nil, nil, pipe, false)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: expected error containing %q, but got %v", test.expr, test.err, err)
+		}
+	}
+}
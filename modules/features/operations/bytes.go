@@ -0,0 +1,156 @@
+package operations
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+
+	ipfix "github.com/CN-TU/go-ipfix"
+	"github.com/chtisgit/go-flows/flows"
+)
+
+// constantOffset returns the value of a constant offset argument, which was checked by resolveOffsets
+func constantOffset(feature flows.Feature) int {
+	offset, _ := feature.Value().(int64)
+	return int(offset)
+}
+
+// resolveOffsets returns a resolver for the function with the given name, which checks that every argument but the
+// first is a non-negative constant offset and returns ie. If the function has a start and an end offset, the end
+// must not be less than the start.
+func resolveOffsets(name string, ie ipfix.InformationElement) flows.ConstantResolver {
+	return func(args []ipfix.InformationElement, constants []interface{}) (ipfix.InformationElement, error) {
+		offsets := make([]int64, len(constants)-1)
+		for i, constant := range constants[1:] {
+			offset, ok := constant.(int64)
+			if !ok || offset < 0 {
+				return ipfix.InformationElement{}, fmt.Errorf("%s: offsets must be non-negative integer constants, but got %v", name, constant)
+			}
+			offsets[i] = offset
+		}
+		if len(offsets) == 2 && offsets[1] < offsets[0] {
+			return ipfix.InformationElement{}, fmt.Errorf("%s: end (%d) must not be less than start (%d)", name, offsets[1], offsets[0])
+		}
+		return ie, nil
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+type byteAt struct {
+	flows.BaseFeature
+	offset int
+}
+
+// CheckpointState returns nothing, since the offset is a constant argument
+func (f *byteAt) CheckpointState() []interface{} { return nil }
+
+func (f *byteAt) SetArguments(arguments []int, features []flows.Feature) {
+	f.offset = constantOffset(features[arguments[1]])
+}
+
+func (f *byteAt) Event(new interface{}, context *flows.EventContext, src interface{}) {
+	data, ok := textValue(new)
+	if ok && f.offset < len(data) {
+		f.SetValue(data[f.offset], context, f)
+	}
+}
+
+func init() {
+	resolve := resolveOffsets("byte_at", ipfix.NewInformationElement("", 0, 0, ipfix.Unsigned8Type, 0))
+	flows.RegisterConstantFunction("byte_at", "returns byte b of octet array a", resolve, flows.PacketFeature, func() flows.Feature { return &byteAt{} }, flows.PacketFeature, flows.Const)
+	flows.RegisterConstantFunction("byte_at", "returns byte b of octet array a", resolve, flows.FlowFeature, func() flows.Feature { return &byteAt{} }, flows.FlowFeature, flows.Const)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+type bytesSlice struct {
+	flows.BaseFeature
+	start, end int
+}
+
+// CheckpointState returns nothing, since start and end are constant arguments
+func (f *bytesSlice) CheckpointState() []interface{} { return nil }
+
+func (f *bytesSlice) SetArguments(arguments []int, features []flows.Feature) {
+	f.start = constantOffset(features[arguments[1]])
+	f.end = constantOffset(features[arguments[2]])
+}
+
+func (f *bytesSlice) Event(new interface{}, context *flows.EventContext, src interface{}) {
+	data, ok := textValue(new)
+	if !ok {
+		return
+	}
+	start, end := f.start, f.end
+	if end > len(data) {
+		end = len(data)
+	}
+	if start > end {
+		start = end
+	}
+	f.SetValue(data[start:end], context, f)
+}
+
+func init() {
+	resolve := resolveOffsets("bytes_slice", ipfix.NewInformationElement("", 0, 0, ipfix.OctetArrayType, 0))
+	flows.RegisterConstantFunction("bytes_slice", "returns bytes b up to (excluding) c of octet array a", resolve, flows.PacketFeature, func() flows.Feature { return &bytesSlice{} }, flows.PacketFeature, flows.Const, flows.Const)
+	flows.RegisterConstantFunction("bytes_slice", "returns bytes b up to (excluding) c of octet array a", resolve, flows.FlowFeature, func() flows.Feature { return &bytesSlice{} }, flows.FlowFeature, flows.Const, flows.Const)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+type hexString struct {
+	flows.BaseFeature
+}
+
+func (f *hexString) Event(new interface{}, context *flows.EventContext, src interface{}) {
+	if data, ok := textValue(new); ok {
+		f.SetValue(hex.EncodeToString(data), context, f)
+	}
+}
+
+func init() {
+	flows.RegisterTypedFunction("hex", "returns octet array a as hexadecimal string", ipfix.StringType, 0, flows.PacketFeature, func() flows.Feature { return &hexString{} }, flows.PacketFeature)
+	flows.RegisterTypedFunction("hex", "returns octet array a as hexadecimal string", ipfix.StringType, 0, flows.FlowFeature, func() flows.Feature { return &hexString{} }, flows.FlowFeature)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+type bigEndian struct {
+	flows.BaseFeature
+	size   int
+	offset int
+}
+
+// CheckpointState returns nothing, since the offset is a constant argument
+func (f *bigEndian) CheckpointState() []interface{} { return nil }
+
+func (f *bigEndian) SetArguments(arguments []int, features []flows.Feature) {
+	f.offset = constantOffset(features[arguments[1]])
+}
+
+func (f *bigEndian) Event(new interface{}, context *flows.EventContext, src interface{}) {
+	data, ok := textValue(new)
+	if !ok || f.offset+f.size > len(data) {
+		return
+	}
+	data = data[f.offset : f.offset+f.size]
+	switch f.size {
+	case 2:
+		f.SetValue(binary.BigEndian.Uint16(data), context, f)
+	case 4:
+		f.SetValue(binary.BigEndian.Uint32(data), context, f)
+	}
+}
+
+func init() {
+	u16be := func() flows.Feature { return &bigEndian{size: 2} }
+	u32be := func() flows.Feature { return &bigEndian{size: 4} }
+	resolve16 := resolveOffsets("u16be", ipfix.NewInformationElement("", 0, 0, ipfix.Unsigned16Type, 0))
+	resolve32 := resolveOffsets("u32be", ipfix.NewInformationElement("", 0, 0, ipfix.Unsigned32Type, 0))
+	flows.RegisterConstantFunction("u16be", "returns the big endian unsigned16 at offset b of octet array a", resolve16, flows.PacketFeature, u16be, flows.PacketFeature, flows.Const)
+	flows.RegisterConstantFunction("u16be", "returns the big endian unsigned16 at offset b of octet array a", resolve16, flows.FlowFeature, u16be, flows.FlowFeature, flows.Const)
+	flows.RegisterConstantFunction("u32be", "returns the big endian unsigned32 at offset b of octet array a", resolve32, flows.PacketFeature, u32be, flows.PacketFeature, flows.Const)
+	flows.RegisterConstantFunction("u32be", "returns the big endian unsigned32 at offset b of octet array a", resolve32, flows.FlowFeature, u32be, flows.FlowFeature, flows.Const)
+}
@@ -0,0 +1,303 @@
+package operations
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	ipfix "github.com/CN-TU/go-ipfix"
+	"github.com/chtisgit/go-flows/flows"
+)
+
+// eventValues collects the argument values of the current event for packet features, which must also handle
+// arguments that don't emit a value. Arguments without a value are nil. The result is computed in EventEnd for every
+// event.
+type eventValues struct {
+	flows.BaseFeature
+	arguments []flows.Feature
+	values    []interface{}
+	constant  []bool
+	compute   func(values []interface{}) interface{}
+	t         ipfix.Type
+}
+
+// CheckpointState returns nothing, since the argument values are only held during a single event
+func (f *eventValues) CheckpointState() []interface{} { return nil }
+
+func (f *eventValues) SetArguments(arguments []int, features []flows.Feature) {
+	f.arguments = make([]flows.Feature, len(arguments))
+	f.values = make([]interface{}, len(arguments))
+	f.constant = make([]bool, len(arguments))
+	for i, argument := range arguments {
+		f.arguments[i] = features[argument]
+		if features[argument].IsConstant() {
+			f.values[i] = features[argument].Value()
+			f.constant[i] = true
+		}
+	}
+}
+
+func (f *eventValues) SetType(t ipfix.Type) { f.t = t }
+
+func (f *eventValues) Event(new interface{}, context *flows.EventContext, src interface{}) {
+	for i, argument := range f.arguments {
+		if argument == src {
+			f.values[i] = new
+		}
+	}
+}
+
+func (f *eventValues) EventEnd(context *flows.EventContext) {
+	f.SetValue(convertValue(f.t, f.compute(f.values)), context, f)
+	for i := range f.values {
+		if !f.constant[i] {
+			f.values[i] = nil
+		}
+	}
+}
+
+// flowValues computes the result from the argument values at the end of the flow. Arguments without a value are nil.
+type flowValues struct {
+	flows.MultiBaseFlowFeature
+	compute func(values []interface{}) interface{}
+	t       ipfix.Type
+}
+
+// CheckpointState returns nothing, since the argument values are only read at the end of the flow
+func (f *flowValues) CheckpointState() []interface{} { return nil }
+
+func (f *flowValues) SetType(t ipfix.Type) { f.t = t }
+
+func (f *flowValues) Stop(reason flows.FlowEndReason, context *flows.EventContext) {
+	f.SetValue(convertValue(f.t, f.compute(f.GetValues(context))), context, f)
+}
+
+// convertValue converts the result of a function to its resolved type t (e.g. the unsigned16 argument of
+// default(tcpSourcePort, 0) to signed64). Values of types, which castValue can't produce, are returned unchanged.
+func convertValue(t ipfix.Type, value interface{}) interface{} {
+	switch t {
+	case ipfix.Unsigned8Type, ipfix.Unsigned16Type, ipfix.Unsigned32Type, ipfix.Unsigned64Type,
+		ipfix.Signed8Type, ipfix.Signed16Type, ipfix.Signed32Type, ipfix.Signed64Type,
+		ipfix.Float32Type, ipfix.Float64Type, ipfix.BooleanType, ipfix.StringType, ipfix.OctetArrayType:
+		return castValue(t, value)
+	}
+	return value
+}
+
+// registerValueFunction registers the packet and the flow variant of a function, which computes its result from the
+// argument values
+func registerValueFunction(name, description string, resolver flows.TypeResolver, compute func([]interface{}) interface{}, arguments ...flows.FeatureType) {
+	flows.RegisterCustomFunction(name, description, resolver, flows.PacketFeature, func() flows.Feature { return &eventValues{compute: compute} }, arguments...)
+	flows.RegisterCustomFunction(name, description, resolver, flows.FlowFeature, func() flows.Feature { return &flowValues{compute: compute} }, arguments...)
+}
+
+// resolveCommon returns the type that can hold the values of all the arguments
+func resolveCommon(name string, args []ipfix.InformationElement) (ipfix.InformationElement, error) {
+	ret := args[0]
+	for _, arg := range args[1:] {
+		if arg.Type == ret.Type {
+			if arg.Length != ret.Length {
+				ret.Length = ipfix.VariableLength
+			}
+			continue
+		}
+		t := flows.UpConvertTypes(ret.Type, arg.Type)
+		if t == ipfix.IllegalType {
+			return ipfix.InformationElement{}, flows.MakeIncompatibleVariantError("%s: incompatible types %s and %s", name, ret.Type, arg.Type)
+		}
+		ret = ipfix.InformationElement{Type: t}
+	}
+	return ipfix.InformationElement{Type: ret.Type, Length: ret.Length}, nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+func resolveIf(args []ipfix.InformationElement) (ipfix.InformationElement, error) {
+	if len(args) != 3 {
+		return ipfix.InformationElement{}, errors.New("if must have exactly 3 arguments")
+	}
+	if args[0].Type != ipfix.BooleanType {
+		return ipfix.InformationElement{}, flows.MakeIncompatibleVariantError("if: condition must be boolean, but is %s", args[0].Type)
+	}
+	return resolveCommon("if", args[1:])
+}
+
+func computeIf(values []interface{}) interface{} {
+	cond, ok := values[0].(bool)
+	if !ok {
+		return nil
+	}
+	if cond {
+		return values[1]
+	}
+	return values[2]
+}
+
+func init() {
+	registerValueFunction("if", "returns b if a is true, or c otherwise (nil if a has no value)", resolveIf, computeIf, flows.MatchType, flows.MatchType, flows.MatchType)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+func resolveCoalesce(args []ipfix.InformationElement) (ipfix.InformationElement, error) {
+	return resolveCommon("coalesce", args)
+}
+
+func computeCoalesce(values []interface{}) interface{} {
+	for _, value := range values {
+		if value != nil {
+			return value
+		}
+	}
+	return nil
+}
+
+func resolveDefault(args []ipfix.InformationElement) (ipfix.InformationElement, error) {
+	if len(args) != 2 {
+		return ipfix.InformationElement{}, errors.New("default must have exactly 2 arguments")
+	}
+	return resolveCommon("default", args)
+}
+
+func init() {
+	registerValueFunction("coalesce", "returns the first argument that has a value", resolveCoalesce, computeCoalesce, flows.MatchType, flows.Ellipsis)
+	registerValueFunction("default", "returns a, or b if a has no value", resolveDefault, computeCoalesce, flows.MatchType, flows.MatchType)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+func resolveIsNull(args []ipfix.InformationElement) (ipfix.InformationElement, error) {
+	if len(args) != 1 {
+		return ipfix.InformationElement{}, errors.New("is_null must have exactly one argument")
+	}
+	return ipfix.InformationElement{Type: ipfix.BooleanType, Length: 1}, nil
+}
+
+func computeIsNull(values []interface{}) interface{} {
+	return values[0] == nil
+}
+
+func init() {
+	registerValueFunction("is_null", "returns true if a has no value", resolveIsNull, computeIsNull, flows.MatchType)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// castType returns the ipfix type from the type argument of cast
+func castType(arg interface{}) (ipfix.Type, error) {
+	name, ok := arg.(string)
+	if !ok {
+		return ipfix.IllegalType, fmt.Errorf("cast: type must be a string constant (e.g. 'float64'), but is %v", arg)
+	}
+	t := ipfix.NameToType([]byte(name))
+	switch t {
+	case ipfix.Unsigned8Type, ipfix.Unsigned16Type, ipfix.Unsigned32Type, ipfix.Unsigned64Type,
+		ipfix.Signed8Type, ipfix.Signed16Type, ipfix.Signed32Type, ipfix.Signed64Type,
+		ipfix.Float32Type, ipfix.Float64Type, ipfix.BooleanType, ipfix.StringType, ipfix.OctetArrayType:
+		return t, nil
+	}
+	return ipfix.IllegalType, fmt.Errorf("cast: can't cast to '%s'", name)
+}
+
+func resolveCast(args []ipfix.InformationElement, constants []interface{}) (ipfix.InformationElement, error) {
+	if len(args) != 2 {
+		return ipfix.InformationElement{}, errors.New("cast must have exactly 2 arguments")
+	}
+	if constants[0] == nil {
+		return ipfix.InformationElement{}, errors.New("cast: type must be a constant")
+	}
+	t, err := castType(constants[0])
+	if err != nil {
+		return ipfix.InformationElement{}, err
+	}
+	return ipfix.NewInformationElement("", 0, 0, t, 0), nil
+}
+
+// castNumber converts strings, byte slices, and booleans into numbers; returns nil for values that can't be converted
+func castNumber(value interface{}) interface{} {
+	switch v := value.(type) {
+	case float64, float32, int64, int32, int16, int8, int, uint64, uint32, uint16, uint8, uint,
+		flows.DateTimeSeconds, flows.DateTimeMilliseconds, flows.DateTimeMicroseconds, flows.DateTimeNanoseconds:
+		return value
+	case bool:
+		if v {
+			return uint64(1)
+		}
+		return uint64(0)
+	case string, []byte:
+		text, _ := textValue(v)
+		if i, err := strconv.ParseInt(string(text), 0, 64); err == nil {
+			return i
+		}
+		if f, err := strconv.ParseFloat(string(text), 64); err == nil {
+			return f
+		}
+		return nil
+	}
+	return nil
+}
+
+// castValue converts value to type t; returns nil if this is not possible
+func castValue(t ipfix.Type, value interface{}) interface{} {
+	if value == nil {
+		return nil
+	}
+	switch t {
+	case ipfix.StringType:
+		if text, ok := textValue(value); ok {
+			return string(text)
+		}
+		return fmt.Sprint(value)
+	case ipfix.OctetArrayType:
+		if text, ok := textValue(value); ok {
+			return text
+		}
+		return []byte(fmt.Sprint(value))
+	case ipfix.BooleanType:
+		if b, ok := value.(bool); ok {
+			return b
+		}
+		if number := castNumber(value); number != nil {
+			return flows.ToFloat(number) != 0
+		}
+		return nil
+	}
+	number := castNumber(value)
+	if number == nil {
+		return nil
+	}
+	switch t {
+	case ipfix.Unsigned8Type:
+		return uint8(flows.ToUInt(number))
+	case ipfix.Unsigned16Type:
+		return uint16(flows.ToUInt(number))
+	case ipfix.Unsigned32Type:
+		return uint32(flows.ToUInt(number))
+	case ipfix.Unsigned64Type:
+		return flows.ToUInt(number)
+	case ipfix.Signed8Type:
+		return int8(flows.ToInt(number))
+	case ipfix.Signed16Type:
+		return int16(flows.ToInt(number))
+	case ipfix.Signed32Type:
+		return int32(flows.ToInt(number))
+	case ipfix.Signed64Type:
+		return flows.ToInt(number)
+	case ipfix.Float32Type:
+		return float32(flows.ToFloat(number))
+	case ipfix.Float64Type:
+		return flows.ToFloat(number)
+	}
+	return nil
+}
+
+// computeCast returns the value, which is converted to the type resolved from the constant type name
+func computeCast(values []interface{}) interface{} {
+	return values[1]
+}
+
+func init() {
+	const description = "converts b to the ipfix type a (e.g. 'float64', 'unsigned32', 'string')"
+	flows.RegisterConstantFunction("cast", description, resolveCast, flows.PacketFeature, func() flows.Feature { return &eventValues{compute: computeCast} }, flows.Const, flows.MatchType)
+	flows.RegisterConstantFunction("cast", description, resolveCast, flows.FlowFeature, func() flows.Feature { return &flowValues{compute: computeCast} }, flows.Const, flows.MatchType)
+}
@@ -0,0 +1,87 @@
+package operations
+
+import (
+	"testing"
+
+	ipfix "github.com/CN-TU/go-ipfix"
+	"github.com/chtisgit/go-flows/flows"
+	"github.com/chtisgit/go-flows/packet"
+	"github.com/chtisgit/go-flows/packet_test"
+	"github.com/google/gopacket/layers"
+)
+
+func udpPacket(src, dst []byte, sport, dport layers.UDPPort) []packet.SerializableLayerType {
+	return []packet.SerializableLayerType{
+		&layers.IPv4{SrcIP: src, DstIP: dst, Protocol: layers.IPProtocolUDP},
+		&layers.UDP{SrcPort: sport, DstPort: dport},
+	}
+}
+
+func TestConditionalOperations(t *testing.T) {
+	table := packet_test.MakeFilteredFeatureTest(t, parseExpressions(t,
+		"is_null(tcpSequenceNumber)",
+		"default(tcpSequenceNumber, 0)",
+		"coalesce(tcpSequenceNumber, sourceTransportPort)",
+		"if(is_null(tcpSequenceNumber), 'udp', 'tcp')",
+		"cast('float64', packetTotalCount)",
+		"cast('string', destinationTransportPort)",
+		"accumulate(coalesce(tcpControlBits, 0))",
+		"accumulate(is_null(tcpControlBits))",
+		"accumulate(if(destinationTransportPort < 100, 1, 2))",
+	), nil, nil, flows.FlowOptions{})
+	table.EventLayers(0, tcpPacket([]byte{10, 0, 0, 1}, []byte{10, 0, 0, 2}, 1000, 80)...)
+	table.EventLayers(0, udpPacket([]byte{10, 0, 0, 1}, []byte{10, 0, 0, 2}, 1000, 53)...)
+	table.EventLayers(0, udpPacket([]byte{10, 0, 0, 1}, []byte{10, 0, 0, 2}, 1000, 53)...)
+	table.Finish(0)
+	table.AssertLineCount(2)
+	table.AssertFeatureList([]packet_test.FeatureLine{
+		{When: 0, Features: []packet_test.FeatureResult{
+			{Name: "is_null(tcpSequenceNumber)", Value: false},
+			{Name: "default(tcpSequenceNumber,0)", Value: int64(0)},
+			{Name: "coalesce(tcpSequenceNumber,sourceTransportPort)", Value: uint64(0)},
+			{Name: "if(is_null(tcpSequenceNumber),'udp','tcp')", Value: "tcp"},
+			{Name: "cast('float64',packetTotalCount)", Value: 1.0},
+			{Name: "cast('string',destinationTransportPort)", Value: "80"},
+			{Name: "accumulate(coalesce(tcpControlBits,0))", Value: []interface{}{int64(2)}},
+			{Name: "accumulate(is_null(tcpControlBits))", Value: []interface{}{false}},
+			{Name: "accumulate(if(less(destinationTransportPort,100),1,2))", Value: []interface{}{int64(1)}},
+		}},
+		{When: 0, Features: []packet_test.FeatureResult{
+			{Name: "is_null(tcpSequenceNumber)", Value: true},
+			{Name: "default(tcpSequenceNumber,0)", Value: int64(0)},
+			{Name: "coalesce(tcpSequenceNumber,sourceTransportPort)", Value: uint64(1000)},
+			{Name: "if(is_null(tcpSequenceNumber),'udp','tcp')", Value: "udp"},
+			{Name: "cast('float64',packetTotalCount)", Value: 2.0},
+			{Name: "cast('string',destinationTransportPort)", Value: "53"},
+			{Name: "accumulate(coalesce(tcpControlBits,0))", Value: []interface{}{int64(0), int64(0)}},
+			{Name: "accumulate(is_null(tcpControlBits))", Value: []interface{}{true, true}},
+			{Name: "accumulate(if(less(destinationTransportPort,100),1,2))", Value: []interface{}{int64(1), int64(1)}},
+		}},
+	})
+}
+
+func TestConditionalTypes(t *testing.T) {
+	features := parseExpressions(t,
+		"default(tcpSequenceNumber, 0)",
+		"coalesce(tcpSequenceNumber, sourceTransportPort)",
+		"cast('unsigned8', packetTotalCount)",
+	)
+	columns, errs := flows.CheckFeatures(features, nil, nil)
+	if errs != nil {
+		t.Fatal(errs)
+	}
+	for i, expected := range []ipfix.Type{ipfix.Signed64Type, ipfix.Unsigned64Type, ipfix.Unsigned8Type} {
+		if columns[i].Types[0].Type != expected {
+			t.Errorf("Expected %s to be %s, but got %s", columns[i].Name, expected, columns[i].Types[0].Type)
+		}
+	}
+
+	var records flows.RecordListMaker
+	records.Init()
+	if err := records.AppendRecord(features, nil, nil, &flows.ExportPipeline{}, false); err != nil {
+		t.Fatal(err)
+	}
+	if unsupported := records.CheckpointUnsupported(); len(unsupported) != 0 {
+		t.Errorf("Expected checkpoint support, but %v don't support checkpoints", unsupported)
+	}
+}
@@ -0,0 +1,118 @@
+// +build ignore
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"text/template"
+	"time"
+)
+
+// This binary generates the bitwise functions
+//
+// go run gen_bits.go | gofmt > bits_generated.go
+
+type operation struct {
+	Name, Description string
+	// UInt and Int are the expressions for unsigned and signed a and b
+	UInt, Int string
+}
+
+var dual = [...]operation{
+	{Name: "bitand", Description: "returns a & b", UInt: "a.(uint64) & b.(uint64)", Int: "a.(int64) & b.(int64)"},
+	{Name: "bitor", Description: "returns a | b", UInt: "a.(uint64) | b.(uint64)", Int: "a.(int64) | b.(int64)"},
+	{Name: "bitxor", Description: "returns a ^ b", UInt: "a.(uint64) ^ b.(uint64)", Int: "a.(int64) ^ b.(int64)"},
+	{Name: "shl", Description: "returns a << b", UInt: "a.(uint64) << b.(uint64)", Int: "a.(int64) << uint64(b.(int64))"},
+	{Name: "shr", Description: "returns a >> b", UInt: "a.(uint64) >> b.(uint64)", Int: "a.(int64) >> uint64(b.(int64))"},
+}
+
+const heading = `package operations
+
+// Created by gen_bits.go, don't edit manually!
+// Generated at %s
+
+import (
+	"math/bits"
+
+	"github.com/chtisgit/go-flows/flows"
+)
+
+type popcountPacket struct {
+	flows.BaseFeature
+}
+
+func (f *popcountPacket) Event(new interface{}, context *flows.EventContext, src interface{}) {
+	f.SetValue(uint8(bits.OnesCount64(flows.ToUInt(new))), context, f)
+}
+
+type popcountFlow struct {
+	flows.BaseFeature
+}
+
+func (f *popcountFlow) Event(new interface{}, context *flows.EventContext, src interface{}) {
+	if f.Value() == nil {
+		f.SetValue(uint8(bits.OnesCount64(flows.ToUInt(new))), context, f)
+	}
+}
+
+func init() {
+	flows.RegisterCustomFunction("popcount", "returns the number of bits set in a", resolvePopcount, flows.PacketFeature, func() flows.Feature { return &popcountPacket{} }, flows.PacketFeature)
+	flows.RegisterCustomFunction("popcount", "returns the number of bits set in a", resolvePopcount, flows.FlowFeature, func() flows.Feature { return &popcountFlow{} }, flows.FlowFeature)
+}
+`
+
+var dualTmpl = template.Must(template.New("bits").Parse(`
+type {{.Name}}Packet struct {
+	flows.MultiBasePacketFeature
+}
+
+func (f *{{.Name}}Packet) Event(new interface{}, context *flows.EventContext, src interface{}) {
+	values := f.EventResult(new, src)
+	if values == nil {
+		return
+	}
+
+	dst, fl, a, b := flows.UpConvert(values[0], values[1])
+	var result interface{}
+	switch fl {
+	case flows.UIntType:
+		result = {{.UInt}}
+	case flows.IntType:
+		result = {{.Int}}
+	}
+	f.SetValue(flows.FixType(result, dst), context, f)
+}
+
+type {{.Name}}Flow struct {
+	flows.MultiBaseFlowFeature
+}
+
+func (f *{{.Name}}Flow) Stop(reason flows.FlowEndReason, context *flows.EventContext) {
+	values := f.GetValues(context)
+
+	dst, fl, a, b := flows.UpConvert(values[0], values[1])
+	var result interface{}
+	switch fl {
+	case flows.UIntType:
+		result = {{.UInt}}
+	case flows.IntType:
+		result = {{.Int}}
+	}
+	f.SetValue(flows.FixType(result, dst), context, f)
+}
+
+func init() {
+	flows.RegisterCustomFunction("{{.Name}}", "{{.Description}}", resolveIntegers, flows.PacketFeature, func() flows.Feature { return &{{.Name}}Packet{} }, flows.PacketFeature, flows.PacketFeature)
+	flows.RegisterCustomFunction("{{.Name}}", "{{.Description}}", resolveIntegers, flows.FlowFeature, func() flows.Feature { return &{{.Name}}Flow{} }, flows.FlowFeature, flows.FlowFeature)
+}`))
+
+func main() {
+	fmt.Printf(heading, time.Now())
+	for _, operation := range dual {
+		if err := dualTmpl.Execute(os.Stdout, operation); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
@@ -16,6 +16,8 @@ import (
 
 type operation struct {
 	Name, Operator, Description string
+	// Values enables comparison of non-numeric values (ip addresses, strings)
+	Values bool
 }
 
 var comparisons = [...]operation{
@@ -23,7 +25,7 @@ var comparisons = [...]operation{
 	{Name: "leq", Operator: "<=", Description: "returns true if a <= b"},
 	{Name: "less", Operator: "<", Description: "returns true if a < b"},
 	{Name: "greater", Operator: ">", Description: "returns true if a > b"},
-	{Name: "equal", Operator: "==", Description: "returns true if a == b"},
+	{Name: "equal", Operator: "==", Description: "returns true if a == b", Values: true},
 }
 
 const heading = `package operations
@@ -48,7 +50,12 @@ func (f *{{.Name}}Packet) Event(new interface{}, context *flows.EventContext, sr
 	if values == nil {
 		return
 	}
-
+{{if .Values}}
+	if equal, ok := equalValues(values[0], values[1]); ok {
+		f.SetValue(equal, context, f)
+		return
+	}
+{{end}}
 	_, fl, a, b := flows.UpConvert(values[0], values[1])
 	switch fl {
 	case flows.UIntType:
@@ -66,7 +73,12 @@ type {{.Name}}Flow struct {
 
 func (f *{{.Name}}Flow) Stop(reason flows.FlowEndReason, context *flows.EventContext) {
 	values := f.GetValues(context)
-
+{{if .Values}}
+	if equal, ok := equalValues(values[0], values[1]); ok {
+		f.SetValue(equal, context, f)
+		return
+	}
+{{end}}
 	_, fl, a, b := flows.UpConvert(values[0], values[1])
 	switch fl {
 	case flows.UIntType:
@@ -1,11 +1,11 @@
 package operations
 
 // Created by gen_logic.go, don't edit manually!
-// Generated at 2019-05-03 16:04:30.469237292 +0200 CEST m=+0.000798366
+// Generated at 2026-10-19 06:24:18.240475101 +0000 UTC m=+0.000251488
 
 import (
-	"github.com/chtisgit/go-flows/flows"
 	ipfix "github.com/CN-TU/go-ipfix"
+	"github.com/chtisgit/go-flows/flows"
 )
 
 type geqPacket struct {
@@ -194,6 +194,11 @@ func (f *equalPacket) Event(new interface{}, context *flows.EventContext, src in
 		return
 	}
 
+	if equal, ok := equalValues(values[0], values[1]); ok {
+		f.SetValue(equal, context, f)
+		return
+	}
+
 	_, fl, a, b := flows.UpConvert(values[0], values[1])
 	switch fl {
 	case flows.UIntType:
@@ -212,6 +217,11 @@ type equalFlow struct {
 func (f *equalFlow) Stop(reason flows.FlowEndReason, context *flows.EventContext) {
 	values := f.GetValues(context)
 
+	if equal, ok := equalValues(values[0], values[1]); ok {
+		f.SetValue(equal, context, f)
+		return
+	}
+
 	_, fl, a, b := flows.UpConvert(values[0], values[1])
 	switch fl {
 	case flows.UIntType:
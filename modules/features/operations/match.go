@@ -0,0 +1,252 @@
+package operations
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"regexp"
+	"sync"
+
+	ipfix "github.com/CN-TU/go-ipfix"
+	"github.com/chtisgit/go-flows/flows"
+)
+
+// textValue returns the value of strings and byte slices
+func textValue(v interface{}) ([]byte, bool) {
+	switch v := v.(type) {
+	case string:
+		return []byte(v), true
+	case []byte:
+		return v, true
+	}
+	return nil, false
+}
+
+// equalValues compares ip addresses (regardless of their length), and strings or byte slices. ok is false if both
+// values are numbers, which must be compared with flows.UpConvert.
+func equalValues(a, b interface{}) (equal, ok bool) {
+	ipA, isIPA := a.(net.IP)
+	ipB, isIPB := b.(net.IP)
+	if isIPA || isIPB {
+		return isIPA && isIPB && ipA.Equal(ipB), true
+	}
+	textA, isTextA := textValue(a)
+	textB, isTextB := textValue(b)
+	if isTextA || isTextB {
+		return isTextA && isTextB && bytes.Equal(textA, textB), true
+	}
+	return false, false
+}
+
+// equalNumbers compares two numbers
+func equalNumbers(a, b interface{}) bool {
+	_, fl, a, b := flows.UpConvert(a, b)
+	switch fl {
+	case flows.UIntType:
+		return a.(uint64) == b.(uint64)
+	case flows.IntType:
+		return a.(int64) == b.(int64)
+	case flows.FloatType:
+		return a.(float64) == b.(float64)
+	}
+	return false
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+type inSubnet struct {
+	flows.BaseFeature
+	network *net.IPNet
+}
+
+// CheckpointState returns nothing, since the network is a constant argument
+func (f *inSubnet) CheckpointState() []interface{} { return nil }
+
+// booleanIE is the information element returned by the matching operations
+var booleanIE = ipfix.NewInformationElement("", 0, 0, ipfix.BooleanType, 0)
+
+// constantArgument returns the value of the constant second argument of the function with the given name
+func constantArgument(name string, constants []interface{}) (interface{}, error) {
+	if len(constants) != 2 {
+		return nil, fmt.Errorf("%s must have exactly two arguments", name)
+	}
+	if constants[1] == nil {
+		return nil, fmt.Errorf("%s: second argument must be a constant", name)
+	}
+	return constants[1], nil
+}
+
+// subnet returns the network of the constant, which is a network or a single ip address
+func subnet(value interface{}) (*net.IPNet, bool) {
+	switch network := value.(type) {
+	case *net.IPNet:
+		return network, true
+	case net.IP:
+		return &net.IPNet{IP: network, Mask: net.CIDRMask(len(network)*8, len(network)*8)}, true
+	}
+	return nil, false
+}
+
+func resolveInSubnet(args []ipfix.InformationElement, constants []interface{}) (ipfix.InformationElement, error) {
+	value, err := constantArgument("in_subnet", constants)
+	if err != nil {
+		return ipfix.InformationElement{}, err
+	}
+	if _, ok := subnet(value); !ok {
+		return ipfix.InformationElement{}, fmt.Errorf("in_subnet: second argument must be a network (e.g. 10.0.0.0/8), but is %v", value)
+	}
+	return booleanIE, nil
+}
+
+// SetArguments takes the network, which was checked by resolveInSubnet
+func (f *inSubnet) SetArguments(arguments []int, features []flows.Feature) {
+	f.network, _ = subnet(features[arguments[1]].Value())
+}
+
+func (f *inSubnet) Event(new interface{}, context *flows.EventContext, src interface{}) {
+	ip, ok := new.(net.IP)
+	f.SetValue(ok && f.network.Contains(ip), context, f)
+}
+
+func init() {
+	flows.RegisterConstantFunction("in_subnet", "returns true if the ip address a is in network b", resolveInSubnet, flows.PacketFeature, func() flows.Feature { return &inSubnet{} }, flows.PacketFeature, flows.Const)
+	flows.RegisterConstantFunction("in_subnet", "returns true if the ip address a is in network b", resolveInSubnet, flows.FlowFeature, func() flows.Feature { return &inSubnet{} }, flows.FlowFeature, flows.Const)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+type inSet struct {
+	flows.BaseFeature
+	set []interface{}
+}
+
+// CheckpointState returns nothing, since the set is a constant argument
+func (f *inSet) CheckpointState() []interface{} { return nil }
+
+func resolveInSet(args []ipfix.InformationElement, constants []interface{}) (ipfix.InformationElement, error) {
+	value, err := constantArgument("in_set", constants)
+	if err != nil {
+		return ipfix.InformationElement{}, err
+	}
+	if _, ok := value.([]interface{}); !ok {
+		return ipfix.InformationElement{}, fmt.Errorf("in_set: second argument must be a list (e.g. [6, 17]), but is %v", value)
+	}
+	return booleanIE, nil
+}
+
+// SetArguments takes the list, which was checked by resolveInSet
+func (f *inSet) SetArguments(arguments []int, features []flows.Feature) {
+	f.set, _ = features[arguments[1]].Value().([]interface{})
+}
+
+func (f *inSet) Event(new interface{}, context *flows.EventContext, src interface{}) {
+	for _, element := range f.set {
+		equal, ok := equalValues(new, element)
+		if !ok {
+			equal = equalNumbers(new, element)
+		}
+		if equal {
+			f.SetValue(true, context, f)
+			return
+		}
+	}
+	f.SetValue(false, context, f)
+}
+
+func init() {
+	flows.RegisterConstantFunction("in_set", "returns true if a is an element of list b", resolveInSet, flows.PacketFeature, func() flows.Feature { return &inSet{} }, flows.PacketFeature, flows.Const)
+	flows.RegisterConstantFunction("in_set", "returns true if a is an element of list b", resolveInSet, flows.FlowFeature, func() flows.Feature { return &inSet{} }, flows.FlowFeature, flows.Const)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+type startsWith struct {
+	flows.BaseFeature
+	prefix []byte
+}
+
+// CheckpointState returns nothing, since the prefix is a constant argument
+func (f *startsWith) CheckpointState() []interface{} { return nil }
+
+func resolveStartsWith(args []ipfix.InformationElement, constants []interface{}) (ipfix.InformationElement, error) {
+	value, err := constantArgument("startswith", constants)
+	if err != nil {
+		return ipfix.InformationElement{}, err
+	}
+	if _, ok := textValue(value); !ok {
+		return ipfix.InformationElement{}, fmt.Errorf("startswith: second argument must be a string, but is %v", value)
+	}
+	return booleanIE, nil
+}
+
+// SetArguments takes the prefix, which was checked by resolveStartsWith
+func (f *startsWith) SetArguments(arguments []int, features []flows.Feature) {
+	f.prefix, _ = textValue(features[arguments[1]].Value())
+}
+
+func (f *startsWith) Event(new interface{}, context *flows.EventContext, src interface{}) {
+	text, ok := textValue(new)
+	f.SetValue(ok && bytes.HasPrefix(text, f.prefix), context, f)
+}
+
+func init() {
+	flows.RegisterConstantFunction("startswith", "returns true if string a starts with b", resolveStartsWith, flows.PacketFeature, func() flows.Feature { return &startsWith{} }, flows.PacketFeature, flows.Const)
+	flows.RegisterConstantFunction("startswith", "returns true if string a starts with b", resolveStartsWith, flows.FlowFeature, func() flows.Feature { return &startsWith{} }, flows.FlowFeature, flows.Const)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// regexps holds the compiled patterns, since every record needs them
+var regexps sync.Map
+
+type regexMatch struct {
+	flows.BaseFeature
+	regexp *regexp.Regexp
+}
+
+// CheckpointState returns nothing, since the pattern is a constant argument
+func (f *regexMatch) CheckpointState() []interface{} { return nil }
+
+// compileRegexp returns the compiled pattern from regexps or compiles and stores it
+func compileRegexp(pattern string) (*regexp.Regexp, error) {
+	if re, ok := regexps.Load(pattern); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	regexps.Store(pattern, re)
+	return re, nil
+}
+
+func resolveRegexMatch(args []ipfix.InformationElement, constants []interface{}) (ipfix.InformationElement, error) {
+	value, err := constantArgument("regex_match", constants)
+	if err != nil {
+		return ipfix.InformationElement{}, err
+	}
+	pattern, ok := value.(string)
+	if !ok {
+		return ipfix.InformationElement{}, fmt.Errorf("regex_match: second argument must be a string, but is %v", value)
+	}
+	if _, err := compileRegexp(pattern); err != nil {
+		return ipfix.InformationElement{}, fmt.Errorf("regex_match: %s", err)
+	}
+	return booleanIE, nil
+}
+
+// SetArguments takes the pattern, which was compiled by resolveRegexMatch
+func (f *regexMatch) SetArguments(arguments []int, features []flows.Feature) {
+	pattern, _ := features[arguments[1]].Value().(string)
+	f.regexp, _ = compileRegexp(pattern)
+}
+
+func (f *regexMatch) Event(new interface{}, context *flows.EventContext, src interface{}) {
+	text, ok := textValue(new)
+	f.SetValue(ok && f.regexp.Match(text), context, f)
+}
+
+func init() {
+	flows.RegisterConstantFunction("regex_match", "returns true if string a matches the regular expression b", resolveRegexMatch, flows.PacketFeature, func() flows.Feature { return &regexMatch{} }, flows.PacketFeature, flows.Const)
+	flows.RegisterConstantFunction("regex_match", "returns true if string a matches the regular expression b", resolveRegexMatch, flows.FlowFeature, func() flows.Feature { return &regexMatch{} }, flows.FlowFeature, flows.Const)
+}
@@ -0,0 +1,125 @@
+package operations
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/chtisgit/go-flows/flows"
+	_ "github.com/chtisgit/go-flows/modules/features/custom"
+	_ "github.com/chtisgit/go-flows/modules/features/iana"
+	"github.com/chtisgit/go-flows/packet"
+	"github.com/chtisgit/go-flows/packet_test"
+	"github.com/chtisgit/go-flows/spec"
+	"github.com/google/gopacket/layers"
+)
+
+func parseExpressions(t *testing.T, exprs ...string) []interface{} {
+	ret := make([]interface{}, len(exprs))
+	for i, expr := range exprs {
+		var err error
+		if ret[i], err = spec.ParseExpression(expr); err != nil {
+			t.Fatalf("%s: %s", expr, err)
+		}
+	}
+	return ret
+}
+
+func tcpPacket(src, dst []byte, sport, dport layers.TCPPort) []packet.SerializableLayerType {
+	return []packet.SerializableLayerType{
+		&layers.IPv4{SrcIP: src, DstIP: dst, Protocol: layers.IPProtocolTCP},
+		&layers.TCP{SrcPort: sport, DstPort: dport, SYN: true},
+	}
+}
+
+func TestMatchOperations(t *testing.T) {
+	table := packet_test.MakeFilteredFeatureTest(t, parseExpressions(t,
+		"in_subnet(sourceIPAddress, 10.0.0.0/8)",
+		"equal(destinationIPAddress, 192.168.0.1)",
+		"in_set(destinationTransportPort, [80, 443])",
+		"startswith(_tcpFlags, 'S')",
+		"regex_match(_tcpFlags, '^S[AF]*$')",
+	), nil, nil, flows.FlowOptions{})
+	table.EventLayers(0, tcpPacket([]byte{10, 1, 2, 3}, []byte{192, 168, 0, 1}, 1000, 443)...)
+	table.EventLayers(0, tcpPacket([]byte{11, 1, 2, 3}, []byte{192, 168, 0, 2}, 1000, 22)...)
+	table.Finish(0)
+	table.AssertLineCount(2)
+	table.AssertFeatureList([]packet_test.FeatureLine{
+		{When: 0, Features: []packet_test.FeatureResult{
+			{Name: "in_subnet(sourceIPAddress,10.0.0.0/8)", Value: true},
+			{Name: "equal(destinationIPAddress,192.168.0.1)", Value: true},
+			{Name: "in_set(destinationTransportPort,[80, 443])", Value: true},
+			{Name: "startswith(_tcpFlags,'S')", Value: true},
+			{Name: "regex_match(_tcpFlags,'^S[AF]*$')", Value: true},
+		}},
+		{When: 0, Features: []packet_test.FeatureResult{
+			{Name: "in_subnet(sourceIPAddress,10.0.0.0/8)", Value: false},
+			{Name: "equal(destinationIPAddress,192.168.0.1)", Value: false},
+			{Name: "in_set(destinationTransportPort,[80, 443])", Value: false},
+			{Name: "startswith(_tcpFlags,'S')", Value: true},
+			{Name: "regex_match(_tcpFlags,'^S[AF]*$')", Value: true},
+		}},
+	})
+}
+
+func TestFilterAndControlExpressions(t *testing.T) {
+	features := []interface{}{"sourceIPAddress", "packetTotalCount"}
+	for _, test := range []struct {
+		name            string
+		control, filter []string
+	}{
+		{"filter", nil, []string{"in_subnet(sourceIPAddress, 10.0.0.0/8)"}},
+		{"control", []string{"in_set(destinationTransportPort, [80, 443])"}, nil},
+	} {
+		table := packet_test.MakeFilteredFeatureTest(t, features, test.control, test.filter, flows.FlowOptions{})
+		table.EventLayers(0, tcpPacket([]byte{10, 1, 2, 3}, []byte{192, 168, 0, 1}, 1000, 443)...)
+		table.EventLayers(0, tcpPacket([]byte{11, 1, 2, 3}, []byte{192, 168, 0, 1}, 1000, 22)...)
+		table.EventLayers(0, tcpPacket([]byte{10, 1, 2, 3}, []byte{192, 168, 0, 1}, 1000, 443)...)
+		table.Finish(0)
+		table.AssertLineCount(1)
+		table.AssertFeatureList([]packet_test.FeatureLine{
+			{When: 0, Features: []packet_test.FeatureResult{
+				{Name: "sourceIPv4Address", Value: net.IP{10, 1, 2, 3}},
+				{Name: "packetTotalCount", Value: uint64(2)},
+			}},
+		})
+	}
+}
+
+func TestExpressionTypes(t *testing.T) {
+	pipe, _ := flows.MakeExportPipeline(nil, flows.SortTypeNone, 1)
+	for _, test := range []struct {
+		control, filter []string
+		err             string
+	}{
+		{[]string{"packetTotalCount + 1"}, nil, "control expression must be boolean"},
+		{nil, []string{"sourceIPAddress"}, "couldn't find filter feature 'sourceIPAddress'"},
+		{nil, []string{"ipTotalLength * 2"}, "must be boolean"},
+		{nil, []string{"in_subnet(sourceIPAddress,"}, "unexpected end of expression"},
+	} {
+		var rl flows.RecordListMaker
+		err := rl.AppendRecord([]interface{}{"packetTotalCount"}, test.control, test.filter, pipe, false)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%v %v: expected error containing %q, but got %v", test.control, test.filter, test.err, err)
+		}
+	}
+}
+
+func TestMatchArgumentErrors(t *testing.T) {
+	pipe, _ := flows.MakeExportPipeline(nil, flows.SortTypeNone, 1)
+	for _, test := range []struct {
+		expr, err string
+	}{
+		{"in_subnet(sourceIPAddress, 'abc')", "second argument must be a network"},
+		{"in_set(destinationTransportPort, 80)", "second argument must be a list"},
+		{"startswith(_tcpFlags, 1)", "second argument must be a string"},
+		{"regex_match(_tcpFlags, '(')", "regex_match: error parsing regexp"},
+		{"regex_match(_tcpFlags, 1)", "second argument must be a string"},
+	} {
+		var rl flows.RecordListMaker
+		err := rl.AppendRecord(parseExpressions(t, test.expr), nil, nil, pipe, false)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: expected error containing %q, but got %v", test.expr, test.err, err)
+		}
+	}
+}
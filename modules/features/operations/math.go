@@ -1,6 +1,9 @@
 package operations
 
-import "github.com/chtisgit/go-flows/flows"
+import (
+	ipfix "github.com/CN-TU/go-ipfix"
+	"github.com/chtisgit/go-flows/flows"
+)
 
 type addPacketFlow struct {
 	flows.BaseFeature
@@ -38,6 +41,49 @@ func init() {
 
 ////////////////////////////////////////////////////////////////////////////////
 
+type runningSumPacket struct {
+	flows.BaseFeature
+	current interface{}
+}
+
+func (f *runningSumPacket) Start(context *flows.EventContext) {
+	f.BaseFeature.Start(context)
+	f.current = uint64(0)
+}
+
+func (f *runningSumPacket) Event(new interface{}, context *flows.EventContext, src interface{}) {
+	_, fl, a, b := flows.UpConvert(f.current, new)
+	switch fl {
+	case flows.UIntType:
+		f.current = a.(uint64) + b.(uint64)
+	case flows.IntType:
+		f.current = a.(int64) + b.(int64)
+	case flows.FloatType:
+		f.current = a.(float64) + b.(float64)
+	}
+	f.SetValue(f.current, context, f)
+}
+
+// CheckpointState returns the sum
+func (f *runningSumPacket) CheckpointState() []interface{} { return []interface{}{&f.current} }
+
+// resolveRunningSum returns a 64 bit type, since the sum would overflow the type of the argument
+func resolveRunningSum(args []ipfix.InformationElement) (ipfix.InformationElement, error) {
+	switch args[0].Type {
+	case ipfix.Float32Type, ipfix.Float64Type:
+		return ipfix.NewInformationElement("", 0, 0, ipfix.Float64Type, 0), nil
+	case ipfix.Signed8Type, ipfix.Signed16Type, ipfix.Signed32Type, ipfix.Signed64Type:
+		return ipfix.NewInformationElement("", 0, 0, ipfix.Signed64Type, 0), nil
+	}
+	return ipfix.NewInformationElement("", 0, 0, ipfix.Unsigned64Type, 0), nil
+}
+
+func init() {
+	flows.RegisterCustomFunction("running_sum", "returns ∑ a of the packets up to the current one", resolveRunningSum, flows.PacketFeature, func() flows.Feature { return &runningSumPacket{} }, flows.PacketFeature)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
 type multiplyPacketFlow struct {
 	flows.BaseFeature
 	current interface{}
@@ -72,3 +118,46 @@ func init() {
 }
 
 ////////////////////////////////////////////////////////////////////////////////
+
+func scaleSampling(value interface{}, context *flows.EventContext) interface{} {
+	rate := context.Flow().Table().SamplingRate
+	if rate <= 1 {
+		return value
+	}
+	dst, fl, a, b := flows.UpConvert(value, rate)
+	var result interface{}
+	switch fl {
+	case flows.UIntType:
+		result = a.(uint64) * b.(uint64)
+	case flows.IntType:
+		result = a.(int64) * b.(int64)
+	case flows.FloatType:
+		result = a.(float64) * b.(float64)
+	}
+	return flows.FixType(result, dst)
+}
+
+type scaleSamplingPacket struct {
+	flows.BaseFeature
+}
+
+func (f *scaleSamplingPacket) Event(new interface{}, context *flows.EventContext, src interface{}) {
+	f.SetValue(scaleSampling(new, context), context, f)
+}
+
+type scaleSamplingFlow struct {
+	flows.BaseFeature
+}
+
+func (f *scaleSamplingFlow) Event(new interface{}, context *flows.EventContext, src interface{}) {
+	if f.Value() == nil {
+		f.SetValue(scaleSampling(new, context), context, f)
+	}
+}
+
+func init() {
+	flows.RegisterFunction("scale_sampling", "returns a multiplied with the sampling rate", flows.PacketFeature, func() flows.Feature { return &scaleSamplingPacket{} }, flows.PacketFeature)
+	flows.RegisterFunction("scale_sampling", "returns a multiplied with the sampling rate", flows.FlowFeature, func() flows.Feature { return &scaleSamplingFlow{} }, flows.FlowFeature)
+}
+
+////////////////////////////////////////////////////////////////////////////////
@@ -0,0 +1,39 @@
+package operations
+
+import (
+	"testing"
+
+	"github.com/chtisgit/go-flows/flows"
+	"github.com/chtisgit/go-flows/packet_test"
+	"github.com/google/gopacket/layers"
+)
+
+func TestRunningSumCheckpoint(t *testing.T) {
+	table := packet_test.MakeFilteredFeatureTest(t, parseExpressions(t, "packetTotalCount"), []string{"running_sum(ipTotalLength) > 100"}, nil, flows.FlowOptions{})
+	for i := 0; i < 3; i++ {
+		if i == 2 {
+			table.Resume()
+		}
+		table.EventLayers(flows.DateTimeNanoseconds(i), &layers.IPv4{SrcIP: []byte{10, 0, 0, 1}, DstIP: []byte{10, 0, 0, 2}, Protocol: layers.IPProtocolUDP, Length: 40}, &layers.UDP{SrcPort: 1000, DstPort: 53})
+	}
+	table.Finish(3)
+	table.AssertFeatureList([]packet_test.FeatureLine{
+		{When: 3, Features: []packet_test.FeatureResult{{Name: "packetTotalCount", Value: uint64(3)}}},
+	})
+}
+
+func TestScaleSampling(t *testing.T) {
+	features := parseExpressions(t, "accumulate(scale_sampling(ipTotalLength))", "scale_sampling(octetTotalCount)", "scale_sampling(mean(ipTotalLength))")
+	table := packet_test.MakeFilteredFeatureTest(t, features, nil, nil, flows.FlowOptions{SamplingRate: 10})
+	for i, length := range []uint16{40, 60} {
+		table.EventLayers(flows.DateTimeNanoseconds(i), &layers.IPv4{SrcIP: []byte{10, 0, 0, 1}, DstIP: []byte{10, 0, 0, 2}, Protocol: layers.IPProtocolUDP, Length: length}, &layers.UDP{SrcPort: 1000, DstPort: 53})
+	}
+	table.Finish(2)
+	table.AssertFeatureList([]packet_test.FeatureLine{
+		{When: 2, Features: []packet_test.FeatureResult{
+			{Name: "accumulate(scale_sampling(ipTotalLength))", Value: []interface{}{uint64(400), uint64(600)}},
+			{Name: "scale_sampling(octetTotalCount)", Value: uint64(1000)},
+			{Name: "scale_sampling(mean(ipTotalLength))", Value: 500.0},
+		}},
+	})
+}
@@ -15,6 +15,8 @@ type get struct {
 	index, current int64
 }
 
+func (f *get) CheckpointState() []interface{} { return []interface{}{&f.current} }
+
 func (f *get) SetArguments(arguments []int, features []flows.Feature) {
 	f.index = flows.ToInt(features[arguments[0]].Value())
 }
@@ -48,6 +50,8 @@ type count struct {
 	count uint64
 }
 
+func (f *count) CheckpointState() []interface{} { return []interface{}{&f.count} }
+
 func (f *count) Start(context *flows.EventContext) {
 	f.BaseFeature.Start(context)
 	f.count = 0
@@ -74,6 +78,8 @@ type mean struct {
 	count uint64
 }
 
+func (f *mean) CheckpointState() []interface{} { return []interface{}{&f.total, &f.count} }
+
 func (f *mean) Start(context *flows.EventContext) {
 	f.BaseFeature.Start(context)
 	f.total = 0
@@ -190,6 +196,8 @@ type stdev struct {
 	mean, m2 float64
 }
 
+func (f *stdev) CheckpointState() []interface{} { return []interface{}{&f.count, &f.mean, &f.m2} }
+
 func (f *stdev) Start(context *flows.EventContext) {
 	f.BaseFeature.Start(context)
 	f.count = 0
@@ -225,6 +233,10 @@ type variance struct {
 	mean, m2 float64
 }
 
+func (f *variance) CheckpointState() []interface{} {
+	return []interface{}{&f.count, &f.mean, &f.m2}
+}
+
 func (f *variance) Start(context *flows.EventContext) {
 	f.BaseFeature.Start(context)
 	f.count = 0
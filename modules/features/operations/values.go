@@ -12,6 +12,8 @@ type slice struct {
 	start, stop, current int64
 }
 
+func (f *slice) CheckpointState() []interface{} { return []interface{}{&f.current} }
+
 func (f *slice) SetArguments(arguments []int, features []flows.Feature) {
 	f.start = flows.ToInt(features[arguments[0]].Value())
 	f.stop = flows.ToInt(features[arguments[1]].Value())
@@ -0,0 +1,168 @@
+package script
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"math/big"
+
+	"go.starlark.net/starlark"
+)
+
+// kinds of starlark values in checkpoints
+const (
+	savedNone = iota
+	savedBool
+	savedInt
+	savedFloat
+	savedString
+	savedList
+	savedTuple
+	savedDict
+	savedSet
+)
+
+// savedValue is the representation of a starlark value in checkpoints. Dicts are saved as alternating keys and values.
+type savedValue struct {
+	Kind     uint8
+	Bool     bool
+	Int      *big.Int
+	Float    float64
+	String   string
+	Elements []savedValue
+}
+
+func saveElements(kind uint8, values []starlark.Value) (savedValue, error) {
+	ret := savedValue{Kind: kind, Elements: make([]savedValue, len(values))}
+	for i, value := range values {
+		var err error
+		if ret.Elements[i], err = saveValue(value); err != nil {
+			return savedValue{}, err
+		}
+	}
+	return ret, nil
+}
+
+// saveValue converts a starlark value to its checkpoint representation. Only the builtin data types are supported.
+func saveValue(value starlark.Value) (savedValue, error) {
+	switch value := value.(type) {
+	case starlark.NoneType:
+		return savedValue{Kind: savedNone}, nil
+	case starlark.Bool:
+		return savedValue{Kind: savedBool, Bool: bool(value)}, nil
+	case starlark.Int:
+		return savedValue{Kind: savedInt, Int: value.BigInt()}, nil
+	case starlark.Float:
+		return savedValue{Kind: savedFloat, Float: float64(value)}, nil
+	case starlark.String:
+		return savedValue{Kind: savedString, String: string(value)}, nil
+	case *starlark.List:
+		values := make([]starlark.Value, value.Len())
+		for i := range values {
+			values[i] = value.Index(i)
+		}
+		return saveElements(savedList, values)
+	case starlark.Tuple:
+		return saveElements(savedTuple, value)
+	case *starlark.Dict:
+		var values []starlark.Value
+		for _, item := range value.Items() {
+			values = append(values, item[0], item[1])
+		}
+		return saveElements(savedDict, values)
+	case *starlark.Set:
+		var values []starlark.Value
+		iter := value.Iterate()
+		defer iter.Done()
+		var element starlark.Value
+		for iter.Next(&element) {
+			values = append(values, element)
+		}
+		return saveElements(savedSet, values)
+	}
+	return savedValue{}, fmt.Errorf("can't save values of type %s in checkpoints", value.Type())
+}
+
+// value converts the checkpoint representation back to a starlark value
+func (s savedValue) value() (starlark.Value, error) {
+	elements := make([]starlark.Value, len(s.Elements))
+	for i := range s.Elements {
+		var err error
+		if elements[i], err = s.Elements[i].value(); err != nil {
+			return nil, err
+		}
+	}
+	switch s.Kind {
+	case savedNone:
+		return starlark.None, nil
+	case savedBool:
+		return starlark.Bool(s.Bool), nil
+	case savedInt:
+		if s.Int == nil {
+			return starlark.MakeInt(0), nil
+		}
+		return starlark.MakeBigInt(s.Int), nil
+	case savedFloat:
+		return starlark.Float(s.Float), nil
+	case savedString:
+		return starlark.String(s.String), nil
+	case savedList:
+		return starlark.NewList(elements), nil
+	case savedTuple:
+		return starlark.Tuple(elements), nil
+	case savedDict:
+		dict := starlark.NewDict(len(elements) / 2)
+		for i := 0; i+1 < len(elements); i += 2 {
+			if err := dict.SetKey(elements[i], elements[i+1]); err != nil {
+				return nil, err
+			}
+		}
+		return dict, nil
+	case savedSet:
+		set := starlark.NewSet(len(elements))
+		for _, element := range elements {
+			if err := set.Insert(element); err != nil {
+				return nil, err
+			}
+		}
+		return set, nil
+	}
+	return nil, fmt.Errorf("unknown kind %d of saved value", s.Kind)
+}
+
+// savedState is the state dict of a script in checkpoints
+type savedState struct {
+	f *scriptState
+}
+
+func (s *savedState) GobEncode() ([]byte, error) {
+	var state starlark.Value = starlark.None
+	if s.f.state != nil {
+		state = s.f.state
+	}
+	saved, err := saveValue(state)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", s.f.script.name, err)
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(saved); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (s *savedState) GobDecode(data []byte) error {
+	var saved savedValue
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&saved); err != nil {
+		return err
+	}
+	state, err := saved.value()
+	if err != nil {
+		return fmt.Errorf("%s: %s", s.f.script.name, err)
+	}
+	s.f.state = nil
+	if dict, ok := state.(*starlark.Dict); ok {
+		s.f.state = dict
+	}
+	return nil
+}
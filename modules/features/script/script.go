@@ -0,0 +1,319 @@
+// Package script contains features implemented as Starlark scripts, which can be loaded at runtime with Load.
+//
+// A script defines one feature, which is named after the file (e.g. payloadEntropy.star defines payloadEntropy), and
+// consists of the following declarations:
+//
+//	type = "float64"                  # ipfix type of the feature (required)
+//	description = "..."               # shown in the feature list (optional)
+//	level = "flow"                    # "flow" (one value per flow; default) or "packet" (one value per event)
+//	arguments = 0                     # number of packet feature arguments (default 0)
+//
+//	def start(state): ...             # called at flow start (optional)
+//	def event(state, packet): ...     # called for every packet (required)
+//	def stop(state): ...              # called at flow end; returns the value of flow features (required for flow level)
+//
+// state is a dict holding the per flow state. Scripts without arguments receive the packet in event; its fields are
+// read-only and can only be accessed during event (see packetValue for the available fields). Scripts with arguments
+// are used like functions (e.g. {"myScript": ["ipTotalLength"]}) and receive the argument values instead of the
+// packet (event(state, a, b, ...)). Packet level scripts return the value for the current event from event; None
+// results in no value. For checkpoints, state may only hold None, bools, numbers, strings, lists, tuples, dicts, and
+// sets.
+//
+// If a script fails or returns a value that can't be converted to its type, the flow gets no value for the feature and
+// the script isn't called anymore for this flow. Only the first error of a script is logged.
+package script
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+
+	ipfix "github.com/CN-TU/go-ipfix"
+	"github.com/chtisgit/go-flows/flows"
+	"go.starlark.net/resolve"
+	"go.starlark.net/starlark"
+)
+
+func init() {
+	// feature computations need floats and loops
+	resolve.AllowFloat = true
+	resolve.AllowLambda = true
+	resolve.AllowNestedDef = true
+	resolve.AllowRecursion = true
+	resolve.AllowSet = true
+}
+
+// script holds a loaded script, which is shared (frozen) between all the feature instances
+type script struct {
+	name               string
+	t                  ipfix.Type
+	arguments          int
+	flow               bool
+	start, event, stop starlark.Callable
+	errors             uint64 // number of failed script calls; accessed atomically
+}
+
+// report logs the first error of the script. Later errors are only counted, since a broken script usually fails in
+// every flow.
+func (s *script) report(err error) {
+	if e, ok := err.(*starlark.EvalError); ok {
+		err = fmt.Errorf("%s", e.Backtrace())
+	}
+	if atomic.AddUint64(&s.errors, 1) == 1 {
+		log.Printf("%s: script error (the flow gets no value; further errors aren't reported): %s", s.name, err)
+	}
+}
+
+// Load loads the feature script in the given file and registers its feature
+func Load(filename string) error {
+	name := strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename))
+	thread := &starlark.Thread{Name: name}
+	globals, err := starlark.ExecFile(thread, filename, nil, nil)
+	if err != nil {
+		if e, ok := err.(*starlark.EvalError); ok {
+			return fmt.Errorf("%s", e.Backtrace())
+		}
+		return err
+	}
+	globals.Freeze()
+
+	s := &script{name: name, flow: true}
+	var typeName, description, level string
+	if err := global(globals, "type", &typeName, true); err != nil {
+		return fmt.Errorf("%s: %s", filename, err)
+	}
+	if s.t = ipfix.NameToType([]byte(typeName)); s.t == ipfix.IllegalType {
+		return fmt.Errorf("%s: unknown type '%s'", filename, typeName)
+	}
+	if err := global(globals, "description", &description, false); err != nil {
+		return fmt.Errorf("%s: %s", filename, err)
+	}
+	if description == "" {
+		description = "script " + filename
+	}
+	if err := global(globals, "level", &level, false); err != nil {
+		return fmt.Errorf("%s: %s", filename, err)
+	}
+	switch level {
+	case "", "flow":
+	case "packet":
+		s.flow = false
+	default:
+		return fmt.Errorf("%s: level must be flow or packet, but is '%s'", filename, level)
+	}
+	if err := global(globals, "arguments", &s.arguments, false); err != nil {
+		return fmt.Errorf("%s: %s", filename, err)
+	}
+	if s.arguments < 0 {
+		return fmt.Errorf("%s: arguments must not be negative", filename)
+	}
+	for _, fn := range []struct {
+		name     string
+		dst      *starlark.Callable
+		required bool
+	}{
+		{"start", &s.start, false},
+		{"event", &s.event, true},
+		{"stop", &s.stop, s.flow},
+	} {
+		if err := global(globals, fn.name, fn.dst, fn.required); err != nil {
+			return fmt.Errorf("%s: %s", filename, err)
+		}
+	}
+
+	ret := flows.FlowFeature
+	if !s.flow {
+		ret = flows.PacketFeature
+	}
+	if s.arguments == 0 {
+		flows.RegisterFeature(ipfix.NewInformationElement(name, 0, 0, s.t, 0), description, ret, func() flows.Feature { return &packetScript{scriptState: scriptState{script: s}} }, flows.RawPacket)
+		return nil
+	}
+	arguments := make([]flows.FeatureType, s.arguments)
+	for i := range arguments {
+		arguments[i] = flows.PacketFeature
+	}
+	flows.RegisterTypedFunction(name, description, s.t, 0, ret, func() flows.Feature { return &functionScript{scriptState: scriptState{script: s}} }, arguments...)
+	return nil
+}
+
+// global stores the global variable name in dst
+func global(globals starlark.StringDict, name string, dst interface{}, required bool) error {
+	value, ok := globals[name]
+	if !ok {
+		if required {
+			return fmt.Errorf("missing %s", name)
+		}
+		return nil
+	}
+	switch dst := dst.(type) {
+	case *string:
+		if s, ok := value.(starlark.String); ok {
+			*dst = string(s)
+			return nil
+		}
+	case *int:
+		if i, err := starlark.AsInt32(value); err == nil {
+			*dst = i
+			return nil
+		}
+	case *starlark.Callable:
+		if fn, ok := value.(starlark.Callable); ok {
+			*dst = fn
+			return nil
+		}
+	}
+	return fmt.Errorf("%s has wrong type %s", name, value.Type())
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// scriptState holds the per flow state of a script feature. After an error, the script isn't called anymore for the
+// flow, which gets no value.
+type scriptState struct {
+	script *script
+	thread *starlark.Thread
+	state  *starlark.Dict
+	failed bool
+}
+
+// call calls the given script function. Returns false if the function failed now or before in this flow.
+func (f *scriptState) call(fn starlark.Callable, args ...starlark.Value) (starlark.Value, bool) {
+	if f.failed {
+		return nil, false
+	}
+	ret, err := starlark.Call(f.thread, fn, args, nil)
+	if err != nil {
+		f.fail(err)
+		return nil, false
+	}
+	return ret, true
+}
+
+// value converts the returned value to the type of the script; returns nil for None and values that can't be
+// converted
+func (f *scriptState) value(v starlark.Value) interface{} {
+	if v == starlark.None {
+		return nil
+	}
+	ret, err := convert(v, f.script.t)
+	if err != nil {
+		f.fail(err)
+		return nil
+	}
+	return ret
+}
+
+// fail reports the error and stops calling the script for the flow
+func (f *scriptState) fail(err error) {
+	f.failed = true
+	f.script.report(err)
+}
+
+// CheckpointState returns the state dict, which is saved as structured value (see savedValue)
+func (f *scriptState) CheckpointState() []interface{} {
+	return []interface{}{&savedState{f}}
+}
+
+func (f *scriptState) startScript() {
+	if f.thread == nil {
+		f.thread = &starlark.Thread{Name: f.script.name}
+	}
+	f.state = starlark.NewDict(0)
+	f.failed = false
+	if f.script.start != nil {
+		f.call(f.script.start, f.state)
+	}
+}
+
+// flowState returns the state dict; flows restored from checkpoints only have the state, but no thread
+func (f *scriptState) flowState() *starlark.Dict {
+	if f.thread == nil {
+		f.thread = &starlark.Thread{Name: f.script.name}
+	}
+	if f.state == nil {
+		f.state = starlark.NewDict(0)
+	}
+	return f.state
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// packetScript is a script without arguments, which gets the packets
+type packetScript struct {
+	flows.BaseFeature
+	scriptState
+	packet packetValue
+}
+
+func (f *packetScript) Start(context *flows.EventContext) {
+	f.BaseFeature.Start(context)
+	f.startScript()
+}
+
+func (f *packetScript) Event(new interface{}, context *flows.EventContext, src interface{}) {
+	f.packet.set(new, context)
+	ret, ok := f.call(f.script.event, f.flowState(), &f.packet)
+	f.packet.set(nil, nil)
+	if ok && !f.script.flow {
+		if value := f.value(ret); value != nil {
+			f.SetValue(value, context, f)
+		}
+	}
+}
+
+func (f *packetScript) Stop(reason flows.FlowEndReason, context *flows.EventContext) {
+	if !f.script.flow {
+		return
+	}
+	if ret, ok := f.call(f.script.stop, f.flowState()); ok {
+		if value := f.value(ret); value != nil {
+			f.SetValue(value, context, f)
+		}
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// functionScript is a script with arguments
+type functionScript struct {
+	flows.MultiBasePacketFeature
+	scriptState
+}
+
+func (f *functionScript) Start(context *flows.EventContext) {
+	f.MultiBasePacketFeature.Start(context)
+	f.startScript()
+}
+
+func (f *functionScript) Event(new interface{}, context *flows.EventContext, src interface{}) {
+	values := f.EventResult(new, src)
+	if values == nil {
+		return
+	}
+	// the called function owns the arguments (e.g. builtins may keep the tuple), so every call needs a new tuple
+	args := make(starlark.Tuple, len(values)+1)
+	args[0] = f.flowState()
+	for i, value := range values {
+		args[i+1] = toStarlark(value)
+	}
+	ret, ok := f.call(f.script.event, args...)
+	if ok && !f.script.flow {
+		if value := f.value(ret); value != nil {
+			f.SetValue(value, context, f)
+		}
+	}
+}
+
+func (f *functionScript) Stop(reason flows.FlowEndReason, context *flows.EventContext) {
+	if !f.script.flow {
+		return
+	}
+	if ret, ok := f.call(f.script.stop, f.flowState()); ok {
+		if value := f.value(ret); value != nil {
+			f.SetValue(value, context, f)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package script
+
+import (
+	"bytes"
+	"encoding/gob"
+	"math"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/chtisgit/go-flows/flows"
+	_ "github.com/chtisgit/go-flows/modules/features/iana"
+	_ "github.com/chtisgit/go-flows/modules/features/operations"
+	"github.com/chtisgit/go-flows/packet_test"
+	"github.com/chtisgit/go-flows/spec"
+	"github.com/google/gopacket/layers"
+	"go.starlark.net/starlark"
+)
+
+var loadOnce sync.Once
+
+func loadScripts(t *testing.T) {
+	loadOnce.Do(func() {
+		for _, file := range []string{"testdata/synCount.star", "testdata/scriptPorts.star", "testdata/scriptMaxRatio.star", "../../../examples/payloadPrintable.star"} {
+			if err := Load(filepath.FromSlash(file)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestScripts(t *testing.T) {
+	loadScripts(t)
+	features := []interface{}{"synCount", "payloadPrintable"}
+	for _, expr := range []string{"accumulate(scriptPorts)", "scriptMaxRatio(destinationTransportPort, 4)"} {
+		feature, err := spec.ParseExpression(expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		features = append(features, feature)
+	}
+	table := packet_test.MakeFilteredFeatureTest(t, features, nil, nil, flows.FlowOptions{})
+	client, server := []byte{10, 0, 0, 1}, []byte{10, 0, 0, 2}
+	table.EventLayers(0, &layers.IPv4{SrcIP: client, DstIP: server, Protocol: layers.IPProtocolTCP}, &layers.TCP{SrcPort: 1000, DstPort: 80, SYN: true})
+	table.EventLayers(0, &layers.IPv4{SrcIP: server, DstIP: client, Protocol: layers.IPProtocolTCP}, &layers.TCP{SrcPort: 80, DstPort: 1000, SYN: true, ACK: true})
+	table.EventLayers(0, &layers.IPv4{SrcIP: client, DstIP: server, Protocol: layers.IPProtocolTCP}, &layers.TCP{SrcPort: 1000, DstPort: 80, SYN: true})
+	table.EventLayers(0, &layers.IPv4{SrcIP: client, DstIP: server, Protocol: layers.IPProtocolUDP}, &layers.UDP{BaseLayer: layers.BaseLayer{Payload: []byte("ab\x00\xff")}, SrcPort: 1000, DstPort: 53})
+	table.Finish(0)
+	table.AssertFeatureList([]packet_test.FeatureLine{
+		{When: 0, Features: []packet_test.FeatureResult{
+			{Name: "synCount", Value: uint64(2)},
+			{Name: "payloadPrintable", Value: nil},
+			{Name: "accumulate(scriptPorts)", Value: nil},
+			{Name: "scriptMaxRatio(destinationTransportPort,4)", Value: 250.0},
+		}},
+		{When: 0, Features: []packet_test.FeatureResult{
+			{Name: "synCount", Value: uint64(0)},
+			{Name: "payloadPrintable", Value: 0.5},
+			{Name: "accumulate(scriptPorts)", Value: []interface{}{"10.0.0.1:1000>10.0.0.2:53"}},
+			{Name: "scriptMaxRatio(destinationTransportPort,4)", Value: 13.25},
+		}},
+	})
+}
+
+func TestRuntimeErrors(t *testing.T) {
+	for _, file := range []string{"testdata/scriptFails.star", "testdata/scriptBadValue.star"} {
+		if err := Load(filepath.FromSlash(file)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	feature, err := spec.ParseExpression("accumulate(scriptBadValue)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	table := packet_test.MakeFilteredFeatureTest(t, []interface{}{"scriptFails", feature}, nil, nil, flows.FlowOptions{})
+	client, server := []byte{10, 0, 0, 1}, []byte{10, 0, 0, 2}
+	table.EventLayers(0, &layers.IPv4{SrcIP: client, DstIP: server, Protocol: layers.IPProtocolTCP}, &layers.TCP{SrcPort: 1000, DstPort: 80, SYN: true})
+	table.EventLayers(0, &layers.IPv4{SrcIP: client, DstIP: server, Protocol: layers.IPProtocolTCP}, &layers.TCP{SrcPort: 1000, DstPort: 80, ACK: true})
+	table.EventLayers(0, &layers.IPv4{SrcIP: client, DstIP: server, Protocol: layers.IPProtocolUDP}, &layers.UDP{SrcPort: 1000, DstPort: 53})
+	table.EventLayers(0, &layers.IPv4{SrcIP: client, DstIP: server, Protocol: layers.IPProtocolUDP}, &layers.UDP{SrcPort: 1000, DstPort: 53})
+	table.Finish(0)
+	table.AssertFeatureList([]packet_test.FeatureLine{
+		{When: 0, Features: []packet_test.FeatureResult{
+			{Name: "scriptFails", Value: nil},
+			{Name: "accumulate(scriptBadValue)", Value: []interface{}{uint64(6), uint64(6)}},
+		}},
+		{When: 0, Features: []packet_test.FeatureResult{
+			{Name: "scriptFails", Value: uint64(2)},
+			{Name: "accumulate(scriptBadValue)", Value: nil},
+		}},
+	})
+}
+
+func TestLoadErrors(t *testing.T) {
+	for _, test := range []struct {
+		file, err string
+	}{
+		{"noStop", "missing stop"},
+		{"badType", "unknown type 'float'"},
+		{"badLevel", "level must be flow or packet"},
+		{"syntaxError", "syntaxError.star:4:1: got newline, want ':'"},
+		{"missing", "no such file"},
+	} {
+		err := Load(filepath.Join("testdata", test.file+".star"))
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: expected error containing %q, but got %v", test.file, test.err, err)
+		}
+	}
+}
+
+func TestCheckpointState(t *testing.T) {
+	s := &script{name: "test"}
+	f := &scriptState{script: s}
+	f.startScript()
+	f.state.SetKey(starlark.String("n"), starlark.MakeInt(3))
+	f.state.SetKey(starlark.String("big"), starlark.MakeUint64(1<<63).Mul(starlark.MakeInt(4)))
+	f.state.SetKey(starlark.String("floats"), starlark.Tuple{starlark.Float(math.Inf(1)), starlark.Float(math.NaN()), starlark.Float(1.5)})
+	f.state.SetKey(starlark.String("seen"), starlark.NewList([]starlark.Value{starlark.String("a\x00b"), starlark.None, starlark.True}))
+	set := starlark.NewSet(1)
+	set.Insert(starlark.MakeInt(80))
+	f.state.SetKey(starlark.String("ports"), set)
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(f.CheckpointState()[0]); err != nil {
+		t.Fatal(err)
+	}
+	restored := &scriptState{script: s}
+	if err := gob.NewDecoder(&buf).Decode(restored.CheckpointState()[0]); err != nil {
+		t.Fatal(err)
+	}
+	// NaN != NaN, so the representations are compared
+	if got, want := restored.flowState().String(), f.state.String(); got != want {
+		t.Errorf("restored state is %s, but should be %s", got, want)
+	}
+
+	f.state.SetKey(starlark.String("fn"), starlark.NewBuiltin("fn", nil))
+	if err := gob.NewEncoder(&buf).Encode(f.CheckpointState()[0]); err == nil {
+		t.Error("Expected an error for a function in the state")
+	}
+}
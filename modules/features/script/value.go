@@ -0,0 +1,250 @@
+package script
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+
+	ipfix "github.com/CN-TU/go-ipfix"
+	"github.com/chtisgit/go-flows/flows"
+	"github.com/chtisgit/go-flows/packet"
+	"github.com/google/gopacket/layers"
+	"go.starlark.net/starlark"
+)
+
+// packetValue provides read-only access to the decoded fields of the current packet:
+//
+//	time             timestamp in nanoseconds
+//	forward          true, if the packet is in forward direction
+//	length           length of the captured packet
+//	ip_length        length of the network layer (header + payload)
+//	payload_length   length of the payload
+//	proto            protocol number
+//	source_ip        source address as string
+//	destination_ip   destination address as string
+//	ttl              ttl (IPv4) or hop limit (IPv6)
+//	source_port      source port (TCP, UDP) or None
+//	destination_port destination port (TCP, UDP) or None
+//	tcp_flags        TCP flags (FIN = 0x01, ..., CWR = 0x80, NS = 0x100) or None
+//	payload          application layer as string of bytes
+type packetValue struct {
+	buffer  packet.Buffer
+	context *flows.EventContext
+}
+
+var packetAttributes = []string{
+	"time", "forward", "length", "ip_length", "payload_length", "proto", "source_ip", "destination_ip", "ttl",
+	"source_port", "destination_port", "tcp_flags", "payload",
+}
+
+func init() {
+	sort.Strings(packetAttributes)
+}
+
+func (p *packetValue) set(buffer interface{}, context *flows.EventContext) {
+	p.buffer, _ = buffer.(packet.Buffer)
+	p.context = context
+}
+
+func (p *packetValue) String() string        { return "<packet>" }
+func (p *packetValue) Type() string          { return "packet" }
+func (p *packetValue) Freeze()               {}
+func (p *packetValue) Truth() starlark.Bool  { return starlark.True }
+func (p *packetValue) Hash() (uint32, error) { return 0, errors.New("unhashable type: packet") }
+func (p *packetValue) AttrNames() []string   { return packetAttributes }
+
+func (p *packetValue) Attr(name string) (starlark.Value, error) {
+	if p.buffer == nil {
+		return nil, errors.New("packet can only be used during event")
+	}
+	switch name {
+	case "time":
+		return starlark.MakeUint64(uint64(p.buffer.Timestamp())), nil
+	case "forward":
+		return starlark.Bool(p.context.Forward()), nil
+	case "length":
+		return starlark.MakeInt(p.buffer.Metadata().Length), nil
+	case "ip_length":
+		return starlark.MakeInt(p.buffer.NetworkLayerLength()), nil
+	case "payload_length":
+		return starlark.MakeInt(p.buffer.PayloadLength()), nil
+	case "proto":
+		return starlark.MakeInt(int(p.buffer.Proto())), nil
+	case "source_ip", "destination_ip", "ttl":
+		switch ip := p.buffer.NetworkLayer().(type) {
+		case *layers.IPv4:
+			return ipAttribute(name, ip.SrcIP, ip.DstIP, ip.TTL), nil
+		case *layers.IPv6:
+			return ipAttribute(name, ip.SrcIP, ip.DstIP, ip.HopLimit), nil
+		}
+		return starlark.None, nil
+	case "source_port", "destination_port", "tcp_flags":
+		switch transport := p.buffer.TransportLayer().(type) {
+		case *layers.TCP:
+			if name == "tcp_flags" {
+				return starlark.MakeInt(tcpFlags(transport)), nil
+			}
+			return portAttribute(name, uint16(transport.SrcPort), uint16(transport.DstPort)), nil
+		case *layers.UDP:
+			if name == "tcp_flags" {
+				return starlark.None, nil
+			}
+			return portAttribute(name, uint16(transport.SrcPort), uint16(transport.DstPort)), nil
+		}
+		return starlark.None, nil
+	case "payload":
+		if transport := p.buffer.TransportLayer(); transport != nil {
+			return starlark.String(transport.LayerPayload()), nil
+		}
+		return starlark.String(""), nil
+	}
+	return nil, nil
+}
+
+func ipAttribute(name string, src, dst net.IP, ttl uint8) starlark.Value {
+	switch name {
+	case "source_ip":
+		return starlark.String(src.String())
+	case "destination_ip":
+		return starlark.String(dst.String())
+	}
+	return starlark.MakeInt(int(ttl))
+}
+
+func portAttribute(name string, src, dst uint16) starlark.Value {
+	if name == "source_port" {
+		return starlark.MakeInt(int(src))
+	}
+	return starlark.MakeInt(int(dst))
+}
+
+func tcpFlags(tcp *layers.TCP) int {
+	ret := 0
+	for i, flag := range []bool{tcp.FIN, tcp.SYN, tcp.RST, tcp.PSH, tcp.ACK, tcp.URG, tcp.ECE, tcp.CWR, tcp.NS} {
+		if flag {
+			ret |= 1 << uint(i)
+		}
+	}
+	return ret
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// toStarlark converts a feature value to a starlark value
+func toStarlark(value interface{}) starlark.Value {
+	switch value := value.(type) {
+	case nil:
+		return starlark.None
+	case bool:
+		return starlark.Bool(value)
+	case string:
+		return starlark.String(value)
+	case []byte:
+		return starlark.String(value)
+	case net.IP:
+		return starlark.String(value.String())
+	case float32:
+		return starlark.Float(value)
+	case float64:
+		return starlark.Float(value)
+	case int64, int32, int16, int8, int:
+		return starlark.MakeInt64(flows.ToInt(value))
+	case []interface{}:
+		ret := make([]starlark.Value, len(value))
+		for i, element := range value {
+			ret[i] = toStarlark(element)
+		}
+		return starlark.NewList(ret)
+	case uint64, uint32, uint16, uint8, uint, flows.DateTimeSeconds, flows.DateTimeMilliseconds,
+		flows.DateTimeMicroseconds, flows.DateTimeNanoseconds:
+		return starlark.MakeUint64(flows.ToUInt(value))
+	}
+	return starlark.String(fmt.Sprint(value))
+}
+
+// convert converts a starlark value to the given ipfix type
+func convert(value starlark.Value, t ipfix.Type) (interface{}, error) {
+	switch t {
+	case ipfix.BooleanType:
+		return bool(value.Truth()), nil
+	case ipfix.StringType:
+		if s, ok := value.(starlark.String); ok {
+			return string(s), nil
+		}
+		return value.String(), nil
+	case ipfix.OctetArrayType:
+		if s, ok := value.(starlark.String); ok {
+			return []byte(s), nil
+		}
+	case ipfix.Ipv4AddressType, ipfix.Ipv6AddressType:
+		if s, ok := value.(starlark.String); ok {
+			if ip := net.ParseIP(string(s)); ip != nil {
+				if t == ipfix.Ipv4AddressType {
+					ip = ip.To4()
+				}
+				if ip != nil {
+					return ip, nil
+				}
+			}
+		}
+	case ipfix.Float32Type, ipfix.Float64Type:
+		if f, ok := starlark.AsFloat(value); ok {
+			if t == ipfix.Float32Type {
+				return float32(f), nil
+			}
+			return f, nil
+		}
+	default:
+		var i int64
+		var u uint64
+		switch value := value.(type) {
+		case starlark.Int:
+			var ok bool
+			if u, ok = value.Uint64(); !ok {
+				if i, ok = value.Int64(); !ok {
+					return nil, fmt.Errorf("%s is out of range for %s", value, t)
+				}
+				u = uint64(i)
+			} else {
+				i = int64(u)
+			}
+		case starlark.Float:
+			i = int64(value)
+			u = uint64(i)
+		case starlark.Bool:
+			if value {
+				i, u = 1, 1
+			}
+		default:
+			return nil, fmt.Errorf("can't convert %s to %s", value.Type(), t)
+		}
+		switch t {
+		case ipfix.Unsigned8Type:
+			return uint8(u), nil
+		case ipfix.Unsigned16Type:
+			return uint16(u), nil
+		case ipfix.Unsigned32Type:
+			return uint32(u), nil
+		case ipfix.Unsigned64Type:
+			return u, nil
+		case ipfix.Signed8Type:
+			return int8(i), nil
+		case ipfix.Signed16Type:
+			return int16(i), nil
+		case ipfix.Signed32Type:
+			return int32(i), nil
+		case ipfix.Signed64Type:
+			return i, nil
+		case ipfix.DateTimeSecondsType:
+			return flows.DateTimeSeconds(u), nil
+		case ipfix.DateTimeMillisecondsType:
+			return flows.DateTimeMilliseconds(u), nil
+		case ipfix.DateTimeMicrosecondsType:
+			return flows.DateTimeMicroseconds(u), nil
+		case ipfix.DateTimeNanosecondsType:
+			return flows.DateTimeNanoseconds(u), nil
+		}
+	}
+	return nil, fmt.Errorf("can't convert %s to %s", value.Type(), t)
+}
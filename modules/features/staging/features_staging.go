@@ -3,6 +3,7 @@ package staging
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"strings"
 
@@ -155,6 +156,10 @@ type _consecutiveSeconds struct {
 	lastTime flows.DateTimeNanoseconds
 }
 
+func (f *_consecutiveSeconds) CheckpointState() []interface{} {
+	return []interface{}{&f.count, &f.lastTime}
+}
+
 func (f *_consecutiveSeconds) Start(context *flows.EventContext) {
 	f.BaseFeature.Start(context)
 	f.lastTime = 0
@@ -199,6 +204,10 @@ type _activeForSeconds struct {
 	lastTime flows.DateTimeNanoseconds
 }
 
+func (f *_activeForSeconds) CheckpointState() []interface{} {
+	return []interface{}{&f.count, &f.lastTime}
+}
+
 func (f *_activeForSeconds) Start(context *flows.EventContext) {
 	f.lastTime = 0
 	f.count = 0
@@ -233,6 +242,8 @@ type _tcpOptionsFirstPacket struct {
 	done bool
 }
 
+func (f *_tcpOptionsFirstPacket) CheckpointState() []interface{} { return []interface{}{&f.done} }
+
 func (f *_tcpOptionsFirstPacket) Start(context *flows.EventContext) {
 	f.done = false
 }
@@ -264,6 +275,8 @@ type _tcpTimestampFirstPacket struct {
 	done bool
 }
 
+func (f *_tcpTimestampFirstPacket) CheckpointState() []interface{} { return []interface{}{&f.done} }
+
 func (f *_tcpTimestampFirstPacket) Start(context *flows.EventContext) {
 	f.BaseFeature.Start(context)
 	f.done = false
@@ -297,6 +310,10 @@ type _tcpOptionDataFirstPacket struct {
 	done bool
 }
 
+func (f *_tcpOptionDataFirstPacket) CheckpointState() []interface{} {
+	return []interface{}{&f.done}
+}
+
 func (f *_tcpOptionDataFirstPacket) Start(context *flows.EventContext) {
 	f.BaseFeature.Start(context)
 	f.done = false
@@ -333,6 +350,10 @@ type _tcpTimestampsPerSeconds struct {
 	times      []uint32
 }
 
+func (f *_tcpTimestampsPerSeconds) CheckpointState() []interface{} {
+	return []interface{}{&f.timestamps, &f.times}
+}
+
 func (f *_tcpTimestampsPerSeconds) Event(new interface{}, context *flows.EventContext, src interface{}) {
 	tcp := features.GetTCP(new)
 	if tcp != nil {
@@ -382,6 +403,9 @@ type _label struct {
 
 func (f *_label) Event(new interface{}, context *flows.EventContext, src interface{}) {
 	label := new.(packet.Buffer).Label()
+	if named, ok := label.(packet.NamedLabel); ok {
+		label = named[""]
+	}
 	if label != nil {
 		f.SetValue(label, context, f)
 	}
@@ -393,6 +417,127 @@ func init() {
 
 ////////////////////////////////////////////////////////////////////////////////
 
+type _namedLabel struct {
+	flows.BaseFeature
+	name string
+}
+
+// CheckpointState returns nothing, since the name is a constant argument
+func (f *_namedLabel) CheckpointState() []interface{} { return nil }
+
+func resolveNamedLabel(args []ipfix.InformationElement, constants []interface{}) (ipfix.InformationElement, error) {
+	if _, ok := constants[0].(string); !ok {
+		return ipfix.InformationElement{}, fmt.Errorf("__label: name must be a string, but is %v", constants[0])
+	}
+	return ipfix.NewInformationElement("", 0, 0, ipfix.OctetArrayType, 0), nil
+}
+
+// SetArguments takes the name, which was checked by resolveNamedLabel
+func (f *_namedLabel) SetArguments(arguments []int, features []flows.Feature) {
+	f.name, _ = features[arguments[0]].Value().(string)
+}
+
+func (f *_namedLabel) Event(new interface{}, context *flows.EventContext, src interface{}) {
+	if named, ok := new.(packet.Buffer).Label().(packet.NamedLabel); ok {
+		if label := named[f.name]; label != nil {
+			f.SetValue(label, context, f)
+		}
+	}
+}
+
+func init() {
+	flows.RegisterConstantInputFunction("__label", "label of the packet from the label source with name a (see label -name)", resolveNamedLabel, flows.PacketFeature, func() flows.Feature { return &_namedLabel{} }, flows.Const)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// flowLabel aggregates the labels of the packets in a flow. Labels are compared by their string representation, since
+// label sources can return any type (e.g. []string).
+type flowLabel struct {
+	flows.BaseFeature
+	mode string
+	// labels holds the distinct labels in the order of appearance, counts the number of packets for every label
+	labels []interface{}
+	counts []uint64
+	index  map[string]int
+}
+
+// CheckpointState returns the seen labels; the index is rebuilt from them
+func (f *flowLabel) CheckpointState() []interface{} {
+	return []interface{}{&f.labels, &f.counts}
+}
+
+func (f *flowLabel) SetArguments(arguments []int, features []flows.Feature) {
+	f.mode = features[arguments[1]].Value().(string)
+}
+
+func (f *flowLabel) Start(context *flows.EventContext) {
+	f.BaseFeature.Start(context)
+	f.labels = nil
+	f.counts = nil
+	f.index = make(map[string]int)
+}
+
+func (f *flowLabel) Event(new interface{}, context *flows.EventContext, src interface{}) {
+	if f.index == nil {
+		f.index = make(map[string]int, len(f.labels))
+		for i, label := range f.labels {
+			f.index[fmt.Sprint(label)] = i
+		}
+	}
+	key := fmt.Sprint(new)
+	if i, ok := f.index[key]; ok {
+		f.counts[i]++
+		return
+	}
+	f.index[key] = len(f.labels)
+	f.labels = append(f.labels, new)
+	f.counts = append(f.counts, 1)
+}
+
+func (f *flowLabel) Stop(reason flows.FlowEndReason, context *flows.EventContext) {
+	if len(f.labels) == 0 {
+		return
+	}
+	switch f.mode {
+	case "first":
+		f.SetValue(f.labels[0], context, f)
+	case "majority":
+		max := 0
+		for i, count := range f.counts {
+			if count > f.counts[max] {
+				max = i
+			}
+		}
+		f.SetValue(f.labels[max], context, f)
+	case "distinct":
+		f.SetValue(f.labels, context, f)
+	}
+}
+
+func resolveFlowLabel(args []ipfix.InformationElement, constants []interface{}) (ipfix.InformationElement, error) {
+	if len(args) != 2 {
+		return ipfix.InformationElement{}, errors.New("flowLabel must have exactly two arguments")
+	}
+	mode := constants[1]
+	if mode == nil {
+		return ipfix.InformationElement{}, errors.New("flowLabel: mode must be a constant")
+	}
+	switch mode {
+	case "first", "majority":
+		return args[0], nil
+	case "distinct":
+		return ipfix.NewBasicList("flowLabel", args[0], 0), nil
+	}
+	return ipfix.InformationElement{}, fmt.Errorf("flowLabel: mode must be 'first', 'majority', or 'distinct', but is %v", mode)
+}
+
+func init() {
+	flows.RegisterConstantFunction("flowLabel", "label of the flow from the packet labels in a (e.g. __label); b is 'first' (label of the first labeled packet), 'majority' (most common label; the first one on ties), or 'distinct' (list of the different labels)", resolveFlowLabel, flows.FlowFeature, func() flows.Feature { return &flowLabel{} }, flows.PacketFeature, flows.Const)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
 type _flowKey struct {
 	flows.BaseFeature
 }
@@ -405,3 +550,56 @@ func (f *_flowKey) Event(new interface{}, context *flows.EventContext, src inter
 func init() {
 	flows.RegisterTemporaryFeature("__flowKey", "string go-flows uses as a key", ipfix.StringType, 0, flows.PacketFeature, func() flows.Feature { return &_flowKey{} }, flows.RawPacket)
 }
+
+////////////////////////////////////////////////////////////////////////////////
+
+// hostnameFromDNS returns the hostname of an address learned from preceding DNS responses (see -passiveDNS). The flow
+// variant returns the first name seen in the flow for the source or destination address of the flow.
+type hostnameFromDNS struct {
+	flows.BaseFeature
+	destination bool
+	flow        bool
+}
+
+// CheckpointState returns nothing, since the direction is fixed
+func (f *hostnameFromDNS) CheckpointState() []interface{} { return nil }
+
+func (f *hostnameFromDNS) Event(new interface{}, context *flows.EventContext, src interface{}) {
+	if f.flow && f.Value() != nil {
+		return
+	}
+	source, destination := new.(packet.Buffer).Hostnames()
+	if f.flow && !context.Forward() {
+		source, destination = destination, source
+	}
+	name := source
+	if f.destination {
+		name = destination
+	}
+	if name != "" {
+		f.SetValue(name, context, f)
+	}
+}
+
+// resolveHostname returns a resolver for the hostname features, which fails if passive DNS isn't used
+func resolveHostname(ie ipfix.InformationElement) flows.TypeResolver {
+	return func([]ipfix.InformationElement) (ipfix.InformationElement, error) {
+		if !packet.PassiveDNSEnabled() {
+			return ipfix.InformationElement{}, fmt.Errorf("%s: passive DNS is disabled (use -passiveDNS)", ie.Name)
+		}
+		return ie, nil
+	}
+}
+
+func init() {
+	for _, destination := range []bool{false, true} {
+		name, description := "sourceHostnameFromDNS", "hostname of the source address learned from DNS responses (needs -passiveDNS)"
+		if destination {
+			name, description = "destinationHostnameFromDNS", "hostname of the destination address learned from DNS responses (needs -passiveDNS)"
+		}
+		resolve := resolveHostname(ipfix.NewInformationElement(name, 0, 0, ipfix.StringType, 0))
+		d := destination
+		flows.RegisterCustomFunction(name, description+"; first name of the flow", resolve, flows.FlowFeature, func() flows.Feature { return &hostnameFromDNS{destination: d, flow: true} }, flows.RawPacket)
+		flows.RegisterCustomFunction(name, description, resolve, flows.PacketFeature, func() flows.Feature { return &hostnameFromDNS{destination: d} }, flows.RawPacket)
+	}
+}
@@ -0,0 +1,157 @@
+package staging
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/chtisgit/go-flows/flows"
+	"github.com/chtisgit/go-flows/packet"
+	"github.com/chtisgit/go-flows/packet_test"
+	"github.com/chtisgit/go-flows/spec"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+func TestFlowLabel(t *testing.T) {
+	var features []interface{}
+	for _, expr := range []string{
+		"flowLabel(__label, 'first')",
+		"flowLabel(__label, 'majority')",
+		"flowLabel(__label, 'distinct')",
+	} {
+		feature, err := spec.ParseExpression(expr)
+		if err != nil {
+			t.Fatalf("%s: %s", expr, err)
+		}
+		features = append(features, feature)
+	}
+	table := packet_test.MakeFilteredFeatureTest(t, features, nil, nil, flows.FlowOptions{})
+
+	udp := func() []packet.SerializableLayerType {
+		return []packet.SerializableLayerType{&layers.UDP{SrcPort: 1, DstPort: 2}}
+	}
+	table.EventLayers(0, udp()...)
+	table.EventLabeledLayers(1, "benign", udp()...)
+	table.EventLabeledLayers(2, "attack", udp()...)
+	table.Resume()
+	table.EventLabeledLayers(3, "attack", udp()...)
+	table.EventLabeledLayers(4, "benign", udp()...)
+	table.EventLabeledLayers(5, "attack", udp()...)
+	table.Finish(10)
+
+	table.AssertLineCount(1)
+	table.AssertFeatureValue("flowLabel(__label,'first')", "benign")
+	table.AssertFeatureValue("flowLabel(__label,'majority')", "attack")
+	table.AssertFeatureValue("flowLabel(__label,'distinct')", []interface{}{"benign", "attack"})
+}
+
+func TestFlowLabelCheckpoint(t *testing.T) {
+	feature, err := spec.ParseExpression("flowLabel(__label, 'distinct')")
+	if err != nil {
+		t.Fatal(err)
+	}
+	table := packet_test.MakeFilteredFeatureTest(t, []interface{}{feature}, nil, nil, flows.FlowOptions{})
+
+	udp := []packet.SerializableLayerType{&layers.UDP{SrcPort: 1, DstPort: 2}}
+	table.EventLabeledLayers(1, []string{"1", "attack"}, udp...)
+	table.EventLabeledLayers(2, "benign", udp...)
+	table.Resume()
+	table.EventLabeledLayers(3, []string{"1", "attack"}, udp...)
+	table.Finish(10)
+
+	table.AssertLineCount(1)
+	table.AssertFeatureValue("flowLabel(__label,'distinct')", []interface{}{[]string{"1", "attack"}, "benign"})
+}
+
+func TestFlowLabelMode(t *testing.T) {
+	feature, err := spec.ParseExpression("flowLabel(__label, 'last')")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pipe, _ := flows.MakeExportPipeline(nil, flows.SortTypeNone, 1)
+	var rl flows.RecordListMaker
+	if err := rl.AppendRecord([]interface{}{feature}, nil, nil, pipe, false); err == nil {
+		t.Error("expected an error for an unknown mode")
+	}
+}
+
+func TestNamedLabelName(t *testing.T) {
+	feature, err := spec.ParseExpression("flowLabel(__label(5), 'first')")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pipe, _ := flows.MakeExportPipeline(nil, flows.SortTypeNone, 1)
+	var rl flows.RecordListMaker
+	if err := rl.AppendRecord([]interface{}{feature}, nil, nil, pipe, false); err == nil || !strings.Contains(err.Error(), "name must be a string") {
+		t.Errorf("Expected an error for a label name, which isn't a string, but got %v", err)
+	}
+}
+
+func TestNamedLabel(t *testing.T) {
+	var features []interface{}
+	for _, expr := range []string{
+		"flowLabel(__label, 'distinct')",
+		"flowLabel(__label('attack'), 'distinct')",
+		"flowLabel(__label('device'), 'distinct')",
+	} {
+		feature, err := spec.ParseExpression(expr)
+		if err != nil {
+			t.Fatalf("%s: %s", expr, err)
+		}
+		features = append(features, feature)
+	}
+	table := packet_test.MakeFilteredFeatureTest(t, features, nil, nil, flows.FlowOptions{})
+
+	udp := &layers.UDP{SrcPort: 1, DstPort: 2}
+	table.EventLabeledLayers(1, packet.NamedLabel{"attack": "dos", "device": "camera", "": "benign"}, udp)
+	table.EventLabeledLayers(2, packet.NamedLabel{"attack": "scan"}, udp)
+	table.EventLabeledLayers(3, nil, udp)
+	table.Finish(10)
+
+	table.AssertLineCount(1)
+	table.AssertFeatureValue("flowLabel(__label,'distinct')", []interface{}{"benign"})
+	table.AssertFeatureValue("flowLabel(__label('attack'),'distinct')", []interface{}{"dos", "scan"})
+	table.AssertFeatureValue("flowLabel(__label('device'),'distinct')", []interface{}{"camera"})
+}
+
+func TestHostnameFromDNS(t *testing.T) {
+	defer packet.SetPassiveDNSEnabled(packet.PassiveDNSEnabled())
+	packet.SetPassiveDNSEnabled(false)
+	pipe, _ := flows.MakeExportPipeline(nil, flows.SortTypeNone, 1)
+	var rl flows.RecordListMaker
+	if err := rl.AppendRecord([]interface{}{"destinationHostnameFromDNS"}, nil, nil, pipe, false); err == nil || !strings.Contains(err.Error(), "passive DNS is disabled") {
+		t.Errorf("Expected an error without passive DNS, but got %v", err)
+	}
+	packet.SetPassiveDNSEnabled(true)
+
+	table := packet_test.MakeFeatureTest(t, []string{"sourceHostnameFromDNS", "destinationHostnameFromDNS"}, flows.FlowFeature, flows.FlowOptions{})
+	table.EnablePassiveDNS(0)
+
+	dns := &layers.DNS{
+		QR:        true,
+		Questions: []layers.DNSQuestion{{Name: []byte("www.example.com"), Type: layers.DNSTypeA, Class: layers.DNSClassIN}},
+		Answers:   []layers.DNSResourceRecord{{Name: []byte("www.example.com"), Type: layers.DNSTypeA, Class: layers.DNSClassIN, TTL: 60, IP: net.IP{1, 2, 3, 4}}},
+	}
+	buf := gopacket.NewSerializeBuffer()
+	if err := dns.SerializeTo(buf, gopacket.SerializeOptions{FixLengths: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	client, resolver, server := net.IP{10, 0, 0, 1}, net.IP{10, 0, 0, 53}, net.IP{1, 2, 3, 4}
+	table.EventLayers(1, &layers.IPv4{SrcIP: resolver, DstIP: client, Protocol: layers.IPProtocolUDP}, &layers.UDP{BaseLayer: layers.BaseLayer{Payload: buf.Bytes()}, SrcPort: 53, DstPort: 1000})
+	table.EventLayers(2, &layers.IPv4{SrcIP: client, DstIP: server, Protocol: layers.IPProtocolTCP}, &layers.TCP{SrcPort: 1000, DstPort: 443, SYN: true})
+	table.EventLayers(3, &layers.IPv4{SrcIP: server, DstIP: client, Protocol: layers.IPProtocolTCP}, &layers.TCP{SrcPort: 443, DstPort: 1000, SYN: true, ACK: true})
+	table.Finish(10)
+
+	table.AssertFeatureList([]packet_test.FeatureLine{
+		{When: 10, Features: []packet_test.FeatureResult{
+			{Name: "sourceHostnameFromDNS", Value: nil},
+			{Name: "destinationHostnameFromDNS", Value: nil},
+		}},
+		{When: 10, Features: []packet_test.FeatureResult{
+			{Name: "sourceHostnameFromDNS", Value: nil},
+			{Name: "destinationHostnameFromDNS", Value: "www.example.com"},
+		}},
+	})
+}
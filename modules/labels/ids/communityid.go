@@ -0,0 +1,107 @@
+package ids
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"net"
+
+	"github.com/google/gopacket/layers"
+)
+
+// icmpEquivalents maps ICMP request/response types to their counterpart (used for making ICMP bidirectional)
+var icmpEquivalents = map[uint8]uint16{
+	uint8(layers.ICMPv4TypeEchoRequest):         uint16(layers.ICMPv4TypeEchoReply),
+	uint8(layers.ICMPv4TypeEchoReply):           uint16(layers.ICMPv4TypeEchoRequest),
+	uint8(layers.ICMPv4TypeTimestampRequest):    uint16(layers.ICMPv4TypeTimestampReply),
+	uint8(layers.ICMPv4TypeTimestampReply):      uint16(layers.ICMPv4TypeTimestampRequest),
+	uint8(layers.ICMPv4TypeInfoRequest):         uint16(layers.ICMPv4TypeInfoReply),
+	uint8(layers.ICMPv4TypeInfoReply):           uint16(layers.ICMPv4TypeInfoRequest),
+	uint8(layers.ICMPv4TypeRouterSolicitation):  uint16(layers.ICMPv4TypeRouterAdvertisement),
+	uint8(layers.ICMPv4TypeRouterAdvertisement): uint16(layers.ICMPv4TypeRouterSolicitation),
+	uint8(layers.ICMPv4TypeAddressMaskRequest):  uint16(layers.ICMPv4TypeAddressMaskReply),
+	uint8(layers.ICMPv4TypeAddressMaskReply):    uint16(layers.ICMPv4TypeAddressMaskRequest),
+}
+
+var icmpv6Equivalents = map[uint8]uint16{
+	128: 129, 129: 128, // echo
+	130: 131, 131: 130, // multicast listener query/report
+	133: 134, 134: 133, // router solicitation/advertisement
+	135: 136, 136: 135, // neighbor solicitation/advertisement
+	139: 140, 140: 139, // node information query/response
+	144: 145, 145: 144, // home agent address discovery
+}
+
+// hasPorts returns true if the ports (or ICMP type and code) are part of the Community ID for this protocol
+func hasPorts(proto uint8) bool {
+	switch layers.IPProtocol(proto) {
+	case layers.IPProtocolTCP, layers.IPProtocolUDP, layers.IPProtocolSCTP, layers.IPProtocolICMPv4, layers.IPProtocolICMPv6:
+		return true
+	}
+	return false
+}
+
+// communityID returns the Community ID (version 1, see https://github.com/corelight/community-id-spec) of the given
+// flow. For ICMP, sport and dport must be the ICMP type and code.
+func communityID(seed uint16, src, dst net.IP, proto uint8, sport, dport uint16) string {
+	if ip := src.To4(); ip != nil {
+		src = ip
+	}
+	if ip := dst.To4(); ip != nil {
+		dst = ip
+	}
+
+	oneWay := false
+	var equivalents map[uint8]uint16
+	switch layers.IPProtocol(proto) {
+	case layers.IPProtocolICMPv4:
+		equivalents = icmpEquivalents
+	case layers.IPProtocolICMPv6:
+		equivalents = icmpv6Equivalents
+	}
+	if equivalents != nil {
+		if equivalent, ok := equivalents[uint8(sport)]; ok {
+			dport = equivalent
+		} else {
+			oneWay = true
+		}
+	}
+
+	if !oneWay {
+		switch compareIP(src, dst) {
+		case 1:
+			src, dst, sport, dport = dst, src, dport, sport
+		case 0:
+			if sport > dport {
+				sport, dport = dport, sport
+			}
+		}
+	}
+
+	data := make([]byte, 0, 2+len(src)+len(dst)+2+4)
+	data = append(data, byte(seed>>8), byte(seed))
+	data = append(data, src...)
+	data = append(data, dst...)
+	data = append(data, proto, 0)
+	if hasPorts(proto) {
+		var ports [4]byte
+		binary.BigEndian.PutUint16(ports[:2], sport)
+		binary.BigEndian.PutUint16(ports[2:], dport)
+		data = append(data, ports[:]...)
+	}
+	hash := sha1.Sum(data)
+	return "1:" + base64.StdEncoding.EncodeToString(hash[:])
+}
+
+// compareIP compares two addresses of the same family bytewise
+func compareIP(a, b net.IP) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		switch {
+		case a[i] < b[i]:
+			return -1
+		case a[i] > b[i]:
+			return 1
+		}
+	}
+	return 0
+}
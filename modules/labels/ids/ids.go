@@ -0,0 +1,466 @@
+// Package ids contains a label source, which labels packets with the alerts of intrusion detection systems (Suricata
+// EVE json logs and zeek notice logs).
+package ids
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chtisgit/go-flows/flows"
+	"github.com/chtisgit/go-flows/packet"
+	"github.com/chtisgit/go-flows/util"
+	"github.com/google/gopacket/layers"
+)
+
+// alert fields, which can be used as label
+var alertFields = []string{"signature_id", "signature", "category", "severity"}
+
+// tuple is the 5-tuple of a flow
+type tuple struct {
+	src, dst     net.IP
+	proto        uint8
+	sport, dport uint16
+}
+
+type alert struct {
+	// communityID is true, if the Community ID was provided by the log (false if it was calculated from the 5-tuple)
+	communityID bool
+	start, end  flows.DateTimeNanoseconds
+	// severity is used for choosing between multiple alerts (lower is more severe)
+	severity int
+	fields   map[string]string
+}
+
+// conn is a connection from a zeek conn log
+type conn struct {
+	tuple       tuple
+	communityID string
+	start, end  flows.DateTimeNanoseconds
+}
+
+type idsLabels struct {
+	id        string
+	field     string
+	match     string
+	tolerance flows.DateTimeNanoseconds
+	seed      uint16
+	alerts    map[string][]*alert
+}
+
+func (il *idsLabels) ID() string {
+	return il.id
+}
+
+func (il *idsLabels) Init() {
+}
+
+// matches returns true if the packet at time when belongs to the alert
+func (il *idsLabels) matches(a *alert, when flows.DateTimeNanoseconds) bool {
+	switch il.match {
+	case "communityid":
+		return a.communityID
+	case "auto":
+		if a.communityID {
+			return true
+		}
+	}
+	return when >= a.start-il.tolerance && when <= a.end+il.tolerance
+}
+
+func (il *idsLabels) GetLabel(packet packet.Buffer) (interface{}, error) {
+	var t tuple
+	switch ip := packet.NetworkLayer().(type) {
+	case *layers.IPv4:
+		t.src, t.dst = ip.SrcIP, ip.DstIP
+	case *layers.IPv6:
+		t.src, t.dst = ip.SrcIP, ip.DstIP
+	default:
+		return nil, nil
+	}
+	t.proto = packet.Proto()
+	if transport := packet.TransportLayer(); transport != nil && hasPorts(t.proto) {
+		src, dst := transport.TransportFlow().Endpoints()
+		if raw := src.Raw(); len(raw) == 2 {
+			t.sport = uint16(raw[0])<<8 | uint16(raw[1])
+		}
+		if raw := dst.Raw(); len(raw) == 2 {
+			if t.proto == uint8(layers.IPProtocolICMPv4) || t.proto == uint8(layers.IPProtocolICMPv6) {
+				// icmp: type and code
+				t.sport, t.dport = uint16(raw[0]), uint16(raw[1])
+			} else {
+				t.dport = uint16(raw[0])<<8 | uint16(raw[1])
+			}
+		}
+	}
+	alerts := il.alerts[il.communityID(t)]
+	when := packet.Timestamp()
+	var ret *alert
+	for _, a := range alerts {
+		if (ret == nil || a.severity < ret.severity) && il.matches(a, when) {
+			ret = a
+		}
+	}
+	if ret == nil {
+		return nil, nil
+	}
+	if value, ok := ret.fields[il.field]; ok {
+		return value, nil
+	}
+	return nil, nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+var protocols = map[string]uint8{
+	"icmp":      uint8(layers.IPProtocolICMPv4),
+	"tcp":       uint8(layers.IPProtocolTCP),
+	"udp":       uint8(layers.IPProtocolUDP),
+	"icmpv6":    uint8(layers.IPProtocolICMPv6),
+	"ipv6-icmp": uint8(layers.IPProtocolICMPv6),
+	"sctp":      uint8(layers.IPProtocolSCTP),
+}
+
+func parseProto(value string) (uint8, error) {
+	if proto, ok := protocols[strings.ToLower(value)]; ok {
+		return proto, nil
+	}
+	proto, err := strconv.ParseUint(value, 10, 8)
+	if err != nil {
+		return 0, fmt.Errorf("invalid protocol '%s'", value)
+	}
+	return uint8(proto), nil
+}
+
+// parseTime parses RFC 3339 times (EVE uses +0000 as zone) or unix timestamps in seconds (zeek)
+func parseTime(value string) (flows.DateTimeNanoseconds, error) {
+	for _, layout := range []string{"2006-01-02T15:04:05.999999999-0700", time.RFC3339Nano} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return flows.DateTimeNanoseconds(t.UnixNano()), nil
+		}
+	}
+	return parseSeconds(value)
+}
+
+// parseSeconds parses seconds with optional fraction
+func parseSeconds(value string) (flows.DateTimeNanoseconds, error) {
+	parts := strings.SplitN(value, ".", 2)
+	seconds, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time '%s'", value)
+	}
+	ret := flows.DateTimeNanoseconds(seconds) * flows.SecondsInNanoseconds
+	if len(parts) == 2 {
+		fraction := (parts[1] + "000000000")[:9]
+		nanoseconds, err := strconv.ParseUint(fraction, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid time '%s'", value)
+		}
+		ret += flows.DateTimeNanoseconds(nanoseconds)
+	}
+	return ret, nil
+}
+
+// parseTuple reads the 5-tuple from the given fields (source address, source port, destination address, destination
+// port, protocol). ok is false if the record doesn't contain the addresses.
+func parseTuple(r record, fields ...string) (ret tuple, ok bool, err error) {
+	src, ok1 := r.get(fields[0])
+	dst, ok2 := r.get(fields[2])
+	proto, ok3 := r.get(fields[4])
+	if !ok1 || !ok2 || !ok3 {
+		return
+	}
+	if ret.src = net.ParseIP(src); ret.src == nil {
+		err = fmt.Errorf("invalid address '%s'", src)
+		return
+	}
+	if ret.dst = net.ParseIP(dst); ret.dst == nil {
+		err = fmt.Errorf("invalid address '%s'", dst)
+		return
+	}
+	if ret.proto, err = parseProto(proto); err != nil {
+		return
+	}
+	for i, port := range []*uint16{&ret.sport, &ret.dport} {
+		value, found := r.get(fields[1+2*i])
+		if !found {
+			continue
+		}
+		var p uint64
+		if p, err = strconv.ParseUint(value, 10, 16); err != nil {
+			err = fmt.Errorf("invalid port '%s'", value)
+			return
+		}
+		*port = uint16(p)
+	}
+	ok = true
+	return
+}
+
+// loader reads the alerts of the log files
+type loader struct {
+	labels  *idsLabels
+	notices []record
+	conns   map[string]*conn
+}
+
+func (l *loader) add(a *alert, communityID string, t tuple) {
+	a.communityID = communityID != ""
+	if communityID == "" {
+		communityID = l.labels.communityID(t)
+	}
+	l.labels.alerts[communityID] = append(l.labels.alerts[communityID], a)
+}
+
+func (il *idsLabels) communityID(t tuple) string {
+	return communityID(il.seed, t.src, t.dst, t.proto, t.sport, t.dport)
+}
+
+// eve reads an alert from a Suricata EVE log
+func (l *loader) eve(r record) error {
+	if typ, _ := r.get("event_type"); typ != "alert" {
+		return nil
+	}
+	t, ok, err := parseTuple(r, "src_ip", "src_port", "dest_ip", "dest_port", "proto")
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("alert without addresses")
+	}
+	if t.proto == uint8(layers.IPProtocolICMPv4) || t.proto == uint8(layers.IPProtocolICMPv6) {
+		t.sport, t.dport = 0, 0
+		for i, port := range []*uint16{&t.sport, &t.dport} {
+			if value, ok := r.get([]string{"icmp_type", "icmp_code"}[i]); ok {
+				p, err := strconv.ParseUint(value, 10, 8)
+				if err != nil {
+					return fmt.Errorf("invalid icmp type or code '%s'", value)
+				}
+				*port = uint16(p)
+			}
+		}
+	}
+	timestamp, _ := r.get("timestamp")
+	when, err := parseTime(timestamp)
+	if err != nil {
+		return err
+	}
+	a := &alert{start: when, end: when, severity: math.MaxInt32, fields: make(map[string]string)}
+	for _, field := range alertFields {
+		if value, ok := r.get("alert." + field); ok {
+			a.fields[field] = value
+		}
+	}
+	if severity, ok := a.fields["severity"]; ok {
+		if a.severity, err = strconv.Atoi(severity); err != nil {
+			return fmt.Errorf("invalid severity '%s'", severity)
+		}
+	}
+	communityID, _ := r.get("community_id")
+	l.add(a, communityID, t)
+	return nil
+}
+
+// conn reads a connection from a zeek conn log
+func (l *loader) conn(r record) error {
+	uid, _ := r.get("uid")
+	t, ok, err := parseTuple(r, "id.orig_h", "id.orig_p", "id.resp_h", "id.resp_p", "proto")
+	if err != nil || !ok || uid == "" {
+		return err
+	}
+	ts, _ := r.get("ts")
+	c := &conn{tuple: t}
+	if c.start, err = parseTime(ts); err != nil {
+		return err
+	}
+	c.end = c.start
+	if duration, ok := r.get("duration"); ok {
+		d, err := parseSeconds(duration)
+		if err != nil {
+			return err
+		}
+		c.end += d
+	}
+	c.communityID, _ = r.get("community_id")
+	l.conns[uid] = c
+	return nil
+}
+
+// notice reads a notice from a zeek notice log; the connection is taken from the conn log if available
+func (l *loader) notice(r record) error {
+	note, _ := r.get("note")
+	ts, _ := r.get("ts")
+	when, err := parseTime(ts)
+	if err != nil {
+		return err
+	}
+	a := &alert{start: when, end: when, severity: math.MaxInt32, fields: map[string]string{"signature": note}}
+	if i := strings.Index(note, "::"); i >= 0 {
+		a.fields["category"] = note[:i]
+	}
+	var communityID string
+	uid, _ := r.get("uid")
+	c, known := l.conns[uid]
+	if known {
+		a.start, a.end = c.start, c.end
+		communityID = c.communityID
+	}
+	t, ok, err := parseTuple(r, "id.orig_h", "id.orig_p", "id.resp_h", "id.resp_p", "proto")
+	if err != nil {
+		return err
+	}
+	if !ok {
+		if !known {
+			// notices without connection (e.g. scans) can't be matched to packets
+			return nil
+		}
+		t = c.tuple
+	}
+	l.add(a, communityID, t)
+	return nil
+}
+
+func (l *loader) load(files []string) error {
+	for _, file := range files {
+		err := readLog(file, func(r record, path string) error {
+			if path == "" {
+				// json: guess the log type from the fields
+				if _, ok := r.get("event_type"); ok {
+					path = "eve"
+				} else if _, ok := r.get("note"); ok {
+					path = "notice"
+				} else if _, ok := r.get("conn_state"); ok {
+					path = "conn"
+				}
+			}
+			switch path {
+			case "eve":
+				return l.eve(r)
+			case "notice":
+				l.notices = append(l.notices, r)
+			case "conn":
+				return l.conn(r)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	// notices are handled after all the conn logs are known
+	for _, r := range l.notices {
+		if err := l.notice(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func newidsLabels(args []string) (arguments []string, ret util.Module, err error) {
+	set := flag.NewFlagSet("ids", flag.ExitOnError)
+	set.Usage = func() { idsLabelsHelp("ids") }
+	field := set.String("field", "signature", "Alert field used as label: signature_id, signature, category, or severity")
+	match := set.String("match", "auto", "Match alerts by communityid, by 5-tuple and time (tuple), or auto (communityid if the alert has one)")
+	tolerance := set.Duration("tolerance", time.Second, "Maximum time difference between packets and alerts for matching by 5-tuple")
+	seed := set.Uint("seed", 0, "Community ID seed")
+
+	set.Parse(args)
+	arguments = set.Args()
+
+	var files []string
+	for len(arguments) > 0 {
+		if arguments[0] == "--" {
+			arguments = arguments[1:]
+			break
+		}
+		files = append(files, arguments[0])
+		arguments = arguments[1:]
+	}
+
+	if len(files) == 0 {
+		return nil, nil, errors.New("ids labels needs at least one log file")
+	}
+	valid := false
+	for _, f := range alertFields {
+		valid = valid || f == *field
+	}
+	if !valid {
+		return nil, nil, fmt.Errorf("unknown alert field '%s'", *field)
+	}
+	switch *match {
+	case "auto", "communityid", "tuple":
+	default:
+		return nil, nil, fmt.Errorf("unknown match mode '%s'", *match)
+	}
+	if *seed > math.MaxUint16 {
+		return nil, nil, errors.New("seed must be less than 65536")
+	}
+
+	labels := &idsLabels{
+		id:        fmt.Sprint("idslabel|", *field, "|", strings.Join(files, ";")),
+		field:     *field,
+		match:     *match,
+		tolerance: flows.DateTimeNanoseconds(*tolerance),
+		seed:      uint16(*seed),
+		alerts:    make(map[string][]*alert),
+	}
+	l := &loader{labels: labels, conns: make(map[string]*conn)}
+	if err := l.load(files); err != nil {
+		return nil, nil, err
+	}
+	ret = labels
+	return
+}
+
+func idsLabelsHelp(name string) {
+	fmt.Fprintf(os.Stderr, `
+The %s label source labels packets with alerts from Suricata EVE json logs
+(event_type alert) and zeek notice logs (tab separated or json). Zeek conn logs
+provide the connections of notices, which only have an uid. If further
+commands need to be provided, then "--" can be used to stop the file list.
+
+Alerts are matched to packets by Community ID, if the log contains one (e.g.
+community-id enabled in Suricata), or by 5-tuple (either direction) and time.
+Alerts of Suricata match packets within -tolerance of the alert time; notices
+with a known connection match the packets of the connection (start and end
+time from the conn log, +/- tolerance). If several alerts match, the one with
+the highest severity (lowest number) is used.
+
+The label is one of the alert fields (as string):
+  signature_id  Suricata signature id
+  signature     Suricata signature or zeek note (e.g. Scan::Port_Scan)
+  category      Suricata category or zeek note module (e.g. Scan)
+  severity      Suricata severity (1 = high)
+
+Use several ids labels with names for more than one field, e.g.
+  label -name signature %s -field signature eve.json --
+  label -name severity %s -field severity eve.json --
+and the features __label("signature") and __label("severity"). The flow label
+can be derived with flowLabel (e.g. flowLabel(__label("signature"), 'first')).
+
+Usage:
+  label %s [-field name] [-match mode] [-tolerance duration] [-seed n] a.json [b.log] [..] [--]
+
+Flags:
+  -field string
+    	Alert field used as label (default "signature")
+  -match string
+    	Match alerts by communityid, by 5-tuple and time (tuple), or auto
+    	(communityid if the alert has one) (default "auto")
+  -tolerance duration
+    	Maximum time difference between packets and alerts for matching by
+    	5-tuple (default 1s)
+  -seed uint
+    	Community ID seed (default 0)
+`, name, name, name, name)
+}
+
+func init() {
+	packet.RegisterLabel("ids", "Label packets with IDS alerts (Suricata EVE, zeek notice).", newidsLabels, idsLabelsHelp)
+}
@@ -0,0 +1,139 @@
+package ids
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/chtisgit/go-flows/flows"
+	"github.com/chtisgit/go-flows/packet"
+	"github.com/google/gopacket/layers"
+)
+
+func TestCommunityID(t *testing.T) {
+	for _, test := range []struct {
+		seed         uint16
+		src, dst     string
+		proto        uint8
+		sport, dport uint16
+		id           string
+	}{
+		// from the Community ID specification
+		{0, "128.232.110.120", "66.35.250.204", 6, 34855, 80, "1:LQU9qZlK+B5F3KDmev6m5PMibrg="},
+		{0, "66.35.250.204", "128.232.110.120", 6, 80, 34855, "1:LQU9qZlK+B5F3KDmev6m5PMibrg="},
+		{1, "128.232.110.120", "66.35.250.204", 6, 34855, 80, "1:3V71V58M3Ksw/yuFALMcW0LAHvc="},
+		{0, "192.168.0.89", "192.168.0.1", 1, 8, 0, "1:X0snYXpgwiv9TZtqg64sgzUn6Dk="},
+		{0, "192.168.0.1", "192.168.0.89", 1, 0, 0, "1:X0snYXpgwiv9TZtqg64sgzUn6Dk="},
+	} {
+		id := communityID(test.seed, net.ParseIP(test.src), net.ParseIP(test.dst), test.proto, test.sport, test.dport)
+		if id != test.id {
+			t.Errorf("%s:%d -> %s:%d (%d): expected %s, but got %s", test.src, test.sport, test.dst, test.dport, test.proto, test.id, id)
+		}
+	}
+}
+
+func makeLabels(t *testing.T, args ...string) *idsLabels {
+	_, ret, err := newidsLabels(args)
+	if err != nil {
+		t.Fatalf("Couldn't create labels: %s", err)
+	}
+	return ret.(*idsLabels)
+}
+
+func at(t string) flows.DateTimeNanoseconds {
+	ret, err := time.Parse(time.RFC3339Nano, t)
+	if err != nil {
+		panic(err)
+	}
+	return flows.DateTimeNanoseconds(ret.UnixNano())
+}
+
+func tcp(src, dst string, sport, dport layers.TCPPort) []packet.SerializableLayerType {
+	return []packet.SerializableLayerType{
+		&layers.IPv4{SrcIP: net.ParseIP(src).To4(), DstIP: net.ParseIP(dst).To4(), Protocol: layers.IPProtocolTCP},
+		&layers.TCP{SrcPort: sport, DstPort: dport},
+	}
+}
+
+func udp(src, dst string, sport, dport layers.UDPPort) []packet.SerializableLayerType {
+	return []packet.SerializableLayerType{
+		&layers.IPv4{SrcIP: net.ParseIP(src).To4(), DstIP: net.ParseIP(dst).To4(), Protocol: layers.IPProtocolUDP},
+		&layers.UDP{SrcPort: sport, DstPort: dport},
+	}
+}
+
+type labelTest struct {
+	name   string
+	when   flows.DateTimeNanoseconds
+	layers []packet.SerializableLayerType
+	label  interface{}
+}
+
+func checkLabels(t *testing.T, labels *idsLabels, tests []labelTest) {
+	for _, test := range tests {
+		label, err := labels.GetLabel(packet.BufferFromLayers(test.when, test.layers...))
+		if err != nil {
+			t.Fatalf("%s: unexpected error %s", test.name, err)
+		}
+		if label != test.label {
+			t.Errorf("%s: expected label %v, but got %v", test.name, test.label, label)
+		}
+	}
+}
+
+func TestEVE(t *testing.T) {
+	checkLabels(t, makeLabels(t, "testdata/eve.json"), []labelTest{
+		{"alert", at("2017-07-07T10:59:59.6Z"), tcp("10.0.0.66", "10.0.0.5", 1234, 80), "ET WEB Test"},
+		{"severity", at("2017-07-07T11:00:00.9Z"), tcp("10.0.0.5", "10.0.0.66", 80, 1234), "ET WEB Response"},
+		{"tolerance", at("2017-07-07T11:00:02.5Z"), tcp("10.0.0.66", "10.0.0.5", 1234, 80), nil},
+		{"other port", at("2017-07-07T11:00:00.5Z"), tcp("10.0.0.66", "10.0.0.5", 1235, 80), nil},
+		{"community id", at("2017-07-08T00:00:00Z"), udp("10.0.0.8", "10.0.0.7", 53, 5353), "ET DNS Test"},
+	})
+
+	checkLabels(t, makeLabels(t, "-field", "severity", "-tolerance", "5s", "testdata/eve.json"), []labelTest{
+		{"tolerance", at("2017-07-07T10:59:55.6Z"), tcp("10.0.0.66", "10.0.0.5", 1234, 80), "2"},
+		{"severity", at("2017-07-07T11:00:00.5Z"), tcp("10.0.0.66", "10.0.0.5", 1234, 80), "1"},
+	})
+
+	checkLabels(t, makeLabels(t, "-field", "signature_id", "-match", "tuple", "testdata/eve.json"), []labelTest{
+		{"alert", at("2017-07-07T10:59:59.6Z"), tcp("10.0.0.66", "10.0.0.5", 1234, 80), "2000001"},
+		{"community id", at("2017-07-08T00:00:00Z"), udp("10.0.0.8", "10.0.0.7", 53, 5353), nil},
+		{"time", at("2017-07-07T12:00:00Z"), udp("10.0.0.8", "10.0.0.7", 53, 5353), "2000003"},
+	})
+
+	checkLabels(t, makeLabels(t, "-field", "category", "-match", "communityid", "testdata/eve.json"), []labelTest{
+		{"alert", at("2017-07-07T11:00:00.5Z"), tcp("10.0.0.66", "10.0.0.5", 1234, 80), nil},
+		{"community id", at("2017-07-08T00:00:00Z"), udp("10.0.0.8", "10.0.0.7", 53, 5353), "Potentially Bad Traffic"},
+	})
+}
+
+func TestZeek(t *testing.T) {
+	checkLabels(t, makeLabels(t, "testdata/notice.log", "testdata/conn.log"), []labelTest{
+		{"connection start", at("2017-07-07T11:00:00Z"), tcp("10.0.0.9", "192.168.1.1", 4444, 22), "SSH::Password_Guessing"},
+		{"reverse", at("2017-07-07T11:00:05Z"), tcp("192.168.1.1", "10.0.0.9", 22, 4444), "SSH::Password_Guessing"},
+		{"after connection", at("2017-07-07T11:00:12Z"), tcp("10.0.0.9", "192.168.1.1", 4444, 22), nil},
+	})
+
+	checkLabels(t, makeLabels(t, "-field", "category", "testdata/notice.log", "testdata/conn.log"), []labelTest{
+		{"category", at("2017-07-07T11:00:00Z"), tcp("10.0.0.9", "192.168.1.1", 4444, 22), "SSH"},
+	})
+
+	// without the conn log, the notice can't be matched
+	checkLabels(t, makeLabels(t, "testdata/notice.log"), []labelTest{
+		{"connection start", at("2017-07-07T11:00:00Z"), tcp("10.0.0.9", "192.168.1.1", 4444, 22), nil},
+	})
+}
+
+func TestIDSErrors(t *testing.T) {
+	for _, args := range [][]string{
+		{},
+		{"testdata/missing.json"},
+		{"-field", "foo", "testdata/eve.json"},
+		{"-match", "foo", "testdata/eve.json"},
+		{"-seed", "65536", "testdata/eve.json"},
+	} {
+		if _, _, err := newidsLabels(args); err == nil {
+			t.Errorf("%v: expected an error", args)
+		}
+	}
+}
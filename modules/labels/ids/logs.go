@@ -0,0 +1,158 @@
+package ids
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// record is a single log line; get returns the value of a field (nested json objects are accessed with "a.b")
+type record interface {
+	get(field string) (string, bool)
+}
+
+// jsonRecord is a line from a json log (EVE or zeek json)
+type jsonRecord map[string]interface{}
+
+func (r jsonRecord) get(field string) (string, bool) {
+	value, ok := r[field]
+	if !ok {
+		// zeek uses dots in field names, while EVE uses nested objects
+		i := strings.Index(field, ".")
+		if i < 0 {
+			return "", false
+		}
+		nested, ok := r[field[:i]].(map[string]interface{})
+		if !ok {
+			return "", false
+		}
+		return jsonRecord(nested).get(field[i+1:])
+	}
+	switch value := value.(type) {
+	case nil:
+		return "", false
+	case string:
+		return value, true
+	case json.Number:
+		return value.String(), true
+	}
+	return fmt.Sprint(value), true
+}
+
+// zeekRecord is a line from a zeek tab separated log
+type zeekRecord struct {
+	fields  map[string]int
+	values  []string
+	unset   string
+	empty   string
+	logPath string
+}
+
+func (r *zeekRecord) get(field string) (string, bool) {
+	i, ok := r.fields[field]
+	if !ok || i >= len(r.values) || r.values[i] == r.unset || r.values[i] == r.empty {
+		return "", false
+	}
+	return r.values[i], true
+}
+
+// readLog calls fn for every record in the given file, which can be a json log (one object per line) or a zeek log
+// (tab separated with header). path is the zeek log path (e.g. notice) or empty for json.
+func readLog(filename string, fn func(r record, path string) error) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	reader := bufio.NewReader(f)
+	start, err := reader.Peek(1)
+	if err != nil {
+		if err == io.EOF {
+			return nil
+		}
+		return err
+	}
+	if start[0] == '{' {
+		err = readJSON(reader, fn)
+	} else {
+		err = readZeek(reader, fn)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %s", filename, err)
+	}
+	return nil
+}
+
+func readJSON(r io.Reader, fn func(r record, path string) error) error {
+	decoder := json.NewDecoder(r)
+	decoder.UseNumber()
+	for line := 1; ; line++ {
+		var rec jsonRecord
+		if err := decoder.Decode(&rec); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return fmt.Errorf("record %d: %s", line, err)
+		}
+		if err := fn(rec, ""); err != nil {
+			return fmt.Errorf("record %d: %s", line, err)
+		}
+	}
+}
+
+func readZeek(r io.Reader, fn func(r record, path string) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1024*1024)
+	separator := "\t"
+	rec := &zeekRecord{unset: "-", empty: "(empty)"}
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if text == "" {
+			continue
+		}
+		if text[0] == '#' {
+			if strings.HasPrefix(text, "#separator ") {
+				s, err := strconv.Unquote(`"` + strings.TrimPrefix(text, "#separator ") + `"`)
+				if err != nil {
+					return fmt.Errorf("line %d: invalid separator", line)
+				}
+				separator = s
+				continue
+			}
+			header := strings.Split(text, separator)
+			switch header[0] {
+			case "#fields":
+				rec.fields = make(map[string]int, len(header)-1)
+				for i, field := range header[1:] {
+					rec.fields[field] = i
+				}
+			case "#path":
+				if len(header) > 1 {
+					rec.logPath = header[1]
+				}
+			case "#unset_field":
+				if len(header) > 1 {
+					rec.unset = header[1]
+				}
+			case "#empty_field":
+				if len(header) > 1 {
+					rec.empty = header[1]
+				}
+			}
+			continue
+		}
+		if rec.fields == nil {
+			return fmt.Errorf("line %d: missing #fields header", line)
+		}
+		current := *rec
+		current.values = strings.Split(text, separator)
+		if err := fn(&current, rec.logPath); err != nil {
+			return fmt.Errorf("line %d: %s", line, err)
+		}
+	}
+	return scanner.Err()
+}
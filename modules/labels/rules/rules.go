@@ -0,0 +1,492 @@
+package rules
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chtisgit/go-flows/flows"
+	"github.com/chtisgit/go-flows/packet"
+	"github.com/chtisgit/go-flows/util"
+	"github.com/google/gopacket/layers"
+)
+
+// portRange is an inclusive range of ports
+type portRange struct {
+	low, high uint16
+}
+
+var anyPort = portRange{0, math.MaxUint16}
+
+func (p portRange) contains(port uint16) bool {
+	return port >= p.low && port <= p.high
+}
+
+// rule assigns label to every packet matching all of its attributes; unset attributes match everything
+type rule struct {
+	src, dst     *net.IPNet
+	sport, dport portRange
+	proto        int // -1 for any protocol
+	start, end   flows.DateTimeNanoseconds
+	label        string
+	priority     int
+}
+
+func matchNet(n *net.IPNet, ip net.IP) bool {
+	return n == nil || n.Contains(ip)
+}
+
+func (r *rule) matches(src, dst net.IP, sport, dport uint16, proto uint8, when flows.DateTimeNanoseconds) bool {
+	if r.proto >= 0 && uint8(r.proto) != proto {
+		return false
+	}
+	if when < r.start || (r.end != 0 && when > r.end) {
+		return false
+	}
+	return matchNet(r.src, src) && matchNet(r.dst, dst) && r.sport.contains(sport) && r.dport.contains(dport)
+}
+
+// prefix is a network in 16 byte form (IPv4 networks as IPv4-mapped IPv6 networks)
+type prefix struct {
+	addr [net.IPv6len]byte
+	bits int
+}
+
+func makePrefix(ip net.IP, bits int) (ret prefix) {
+	mask := net.CIDRMask(bits, 8*net.IPv6len)
+	for i, b := range ip.To16() {
+		ret.addr[i] = b & mask[i]
+	}
+	ret.bits = bits
+	return
+}
+
+// netPrefix returns the prefix of a network
+func netPrefix(n *net.IPNet) prefix {
+	ones, bits := n.Mask.Size()
+	if bits == 8*net.IPv4len {
+		ones += 8 * (net.IPv6len - net.IPv4len)
+	}
+	return makePrefix(n.IP, ones)
+}
+
+// ruleIndex finds the rules, whose source or destination network contains an address. Rules are indexed by their
+// source network, or by their destination network if they don't have a source network. Rules without networks must
+// be checked for every packet.
+type ruleIndex struct {
+	src, dst map[prefix][]int
+	bits     []int // distinct prefix lengths of the indexed networks
+	any      []int
+}
+
+func makeRuleIndex(rules []rule) ruleIndex {
+	ret := ruleIndex{src: make(map[prefix][]int), dst: make(map[prefix][]int)}
+	seen := make(map[int]bool)
+	add := func(index map[prefix][]int, n *net.IPNet, i int) {
+		p := netPrefix(n)
+		index[p] = append(index[p], i)
+		if !seen[p.bits] {
+			seen[p.bits] = true
+			ret.bits = append(ret.bits, p.bits)
+		}
+	}
+	for i := range rules {
+		switch {
+		case rules[i].src != nil:
+			add(ret.src, rules[i].src, i)
+		case rules[i].dst != nil:
+			add(ret.dst, rules[i].dst, i)
+		default:
+			ret.any = append(ret.any, i)
+		}
+	}
+	return ret
+}
+
+// candidates calls f with the index of every rule that might match a packet from src to dst
+func (r *ruleIndex) candidates(src, dst net.IP, f func(int)) {
+	for _, i := range r.any {
+		f(i)
+	}
+	for _, bits := range r.bits {
+		for _, i := range r.src[makePrefix(src, bits)] {
+			f(i)
+		}
+		for _, i := range r.dst[makePrefix(dst, bits)] {
+			f(i)
+		}
+	}
+}
+
+type rulesLabels struct {
+	id            string
+	rules         []rule
+	index         ruleIndex
+	bidirectional bool
+	def           interface{}
+}
+
+func (rl *rulesLabels) ID() string {
+	return rl.id
+}
+
+func (rl *rulesLabels) Init() {
+}
+
+func (rl *rulesLabels) GetLabel(packet packet.Buffer) (interface{}, error) {
+	var src, dst net.IP
+	switch ip := packet.NetworkLayer().(type) {
+	case *layers.IPv4:
+		src, dst = ip.SrcIP, ip.DstIP
+	case *layers.IPv6:
+		src, dst = ip.SrcIP, ip.DstIP
+	default:
+		return rl.def, nil
+	}
+	var sport, dport uint16
+	switch transport := packet.TransportLayer().(type) {
+	case *layers.TCP:
+		sport, dport = uint16(transport.SrcPort), uint16(transport.DstPort)
+	case *layers.UDP:
+		sport, dport = uint16(transport.SrcPort), uint16(transport.DstPort)
+	}
+	proto := packet.Proto()
+	when := packet.Timestamp()
+	// rules are sorted by priority; the first matching one wins
+	best := -1
+	check := func(i int) {
+		if best >= 0 && i >= best {
+			return
+		}
+		r := &rl.rules[i]
+		if r.matches(src, dst, sport, dport, proto, when) ||
+			(rl.bidirectional && r.matches(dst, src, dport, sport, proto, when)) {
+			best = i
+		}
+	}
+	rl.index.candidates(src, dst, check)
+	if rl.bidirectional {
+		rl.index.candidates(dst, src, check)
+	}
+	if best < 0 {
+		return rl.def, nil
+	}
+	return rl.rules[best].label, nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+var protocols = map[string]int{
+	"icmp":   int(layers.IPProtocolICMPv4),
+	"tcp":    int(layers.IPProtocolTCP),
+	"udp":    int(layers.IPProtocolUDP),
+	"icmpv6": int(layers.IPProtocolICMPv6),
+	"sctp":   int(layers.IPProtocolSCTP),
+}
+
+// isAny returns true if the field value matches everything
+func isAny(value string) bool {
+	return value == "" || value == "*"
+}
+
+func parseNet(value string) (*net.IPNet, error) {
+	if isAny(value) {
+		return nil, nil
+	}
+	if !strings.Contains(value, "/") {
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid address '%s'", value)
+		}
+		if ip4 := ip.To4(); ip4 != nil {
+			return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+	}
+	_, ret, err := net.ParseCIDR(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid network '%s'", value)
+	}
+	return ret, nil
+}
+
+func parsePort(value string) (uint16, error) {
+	port, err := strconv.ParseUint(strings.TrimSpace(value), 10, 16)
+	if err != nil {
+		return 0, fmt.Errorf("invalid port '%s'", value)
+	}
+	return uint16(port), nil
+}
+
+func parsePorts(value string) (ret portRange, err error) {
+	if isAny(value) {
+		return anyPort, nil
+	}
+	parts := strings.SplitN(value, "-", 2)
+	if ret.low, err = parsePort(parts[0]); err != nil {
+		return
+	}
+	ret.high = ret.low
+	if len(parts) == 2 {
+		if ret.high, err = parsePort(parts[1]); err != nil {
+			return
+		}
+		if ret.high < ret.low {
+			err = fmt.Errorf("invalid port range '%s'", value)
+		}
+	}
+	return
+}
+
+func parseProto(value string) (int, error) {
+	if isAny(value) {
+		return -1, nil
+	}
+	if proto, ok := protocols[strings.ToLower(value)]; ok {
+		return proto, nil
+	}
+	proto, err := strconv.ParseUint(value, 10, 8)
+	if err != nil {
+		return 0, fmt.Errorf("invalid protocol '%s'", value)
+	}
+	return int(proto), nil
+}
+
+// parseTime parses RFC 3339 times or unix timestamps in seconds (with optional fraction)
+func parseTime(value string) (flows.DateTimeNanoseconds, error) {
+	if isAny(value) {
+		return 0, nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return flows.DateTimeNanoseconds(t.UnixNano()), nil
+	}
+	parts := strings.SplitN(value, ".", 2)
+	seconds, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time '%s'", value)
+	}
+	ret := flows.DateTimeNanoseconds(seconds) * flows.SecondsInNanoseconds
+	if len(parts) == 2 {
+		fraction := (parts[1] + "000000000")[:9]
+		nanoseconds, err := strconv.ParseUint(fraction, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid time '%s'", value)
+		}
+		ret += flows.DateTimeNanoseconds(nanoseconds)
+	}
+	return ret, nil
+}
+
+// makeRule creates a rule from the given field values
+func makeRule(fields map[string]string) (ret rule, err error) {
+	ret.proto = -1
+	ret.sport, ret.dport = anyPort, anyPort
+	for name, value := range fields {
+		value = strings.TrimSpace(value)
+		switch strings.ToLower(name) {
+		case "src":
+			ret.src, err = parseNet(value)
+		case "dst":
+			ret.dst, err = parseNet(value)
+		case "sport":
+			ret.sport, err = parsePorts(value)
+		case "dport":
+			ret.dport, err = parsePorts(value)
+		case "proto":
+			ret.proto, err = parseProto(value)
+		case "start":
+			ret.start, err = parseTime(value)
+		case "end":
+			ret.end, err = parseTime(value)
+		case "label":
+			ret.label = value
+		case "priority":
+			if !isAny(value) {
+				ret.priority, err = strconv.Atoi(value)
+				if err != nil {
+					err = fmt.Errorf("invalid priority '%s'", value)
+				}
+			}
+		default:
+			err = fmt.Errorf("unknown field '%s'", name)
+		}
+		if err != nil {
+			return
+		}
+	}
+	if ret.label == "" {
+		err = errors.New("missing label")
+	}
+	if ret.end != 0 && ret.end < ret.start {
+		err = errors.New("end is before start")
+	}
+	return
+}
+
+// readCSV reads the field values of rules from a csv file with header
+func readCSV(r io.Reader) ([]map[string]string, error) {
+	reader := csv.NewReader(r)
+	reader.Comment = '#'
+	header, err := reader.Read()
+	if err != nil {
+		return nil, err
+	}
+	var ret []map[string]string
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return ret, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		fields := make(map[string]string, len(header))
+		for i, name := range header {
+			fields[strings.TrimSpace(name)] = record[i]
+		}
+		ret = append(ret, fields)
+	}
+}
+
+// readJSON reads the field values of rules from a json array of objects
+func readJSON(r io.Reader) ([]map[string]string, error) {
+	decoder := json.NewDecoder(r)
+	decoder.UseNumber()
+	var records []map[string]interface{}
+	if err := decoder.Decode(&records); err != nil {
+		return nil, err
+	}
+	ret := make([]map[string]string, len(records))
+	for i, record := range records {
+		ret[i] = make(map[string]string, len(record))
+		for name, value := range record {
+			if value == nil {
+				ret[i][name] = ""
+			} else {
+				ret[i][name] = fmt.Sprint(value)
+			}
+		}
+	}
+	return ret, nil
+}
+
+// loadRules reads the rules from the given file (json for .json files, csv otherwise)
+func loadRules(filename string) ([]rule, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var records []map[string]string
+	if strings.ToLower(filepath.Ext(filename)) == ".json" {
+		records, err = readJSON(f)
+	} else {
+		records, err = readCSV(f)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", filename, err)
+	}
+	ret := make([]rule, len(records))
+	for i, record := range records {
+		if ret[i], err = makeRule(record); err != nil {
+			return nil, fmt.Errorf("%s: rule %d: %s", filename, i+1, err)
+		}
+	}
+	return ret, nil
+}
+
+func newrulesLabels(args []string) (arguments []string, ret util.Module, err error) {
+	set := flag.NewFlagSet("rules", flag.ExitOnError)
+	set.Usage = func() { rulesLabelsHelp("rules") }
+	bidirectional := set.Bool("bidirectional", false, "Rules also match packets in reverse direction")
+	def := set.String("default", "", "Label of packets that don't match any rule")
+
+	set.Parse(args)
+	arguments = set.Args()
+
+	var files []string
+	for len(arguments) > 0 {
+		if arguments[0] == "--" {
+			arguments = arguments[1:]
+			break
+		}
+		files = append(files, arguments[0])
+		arguments = arguments[1:]
+	}
+
+	if len(files) == 0 {
+		return nil, nil, errors.New("rules labels needs at least one rule file")
+	}
+
+	var rules []rule
+	for _, file := range files {
+		fileRules, err := loadRules(file)
+		if err != nil {
+			return nil, nil, err
+		}
+		rules = append(rules, fileRules...)
+	}
+	sort.SliceStable(rules, func(i, j int) bool {
+		return rules[i].priority > rules[j].priority
+	})
+
+	labels := &rulesLabels{
+		id:            fmt.Sprint("rulelabel|", strings.Join(files, ";")),
+		rules:         rules,
+		index:         makeRuleIndex(rules),
+		bidirectional: *bidirectional,
+	}
+	if *def != "" {
+		labels.def = *def
+	}
+	ret = labels
+	return
+}
+
+func rulesLabelsHelp(name string) {
+	fmt.Fprintf(os.Stderr, `
+The %s label source assigns labels to packets with rules read from one or
+more csv or json (file extension .json) files. If further commands need to be
+provided, then "--" can be used to stop the file list.
+
+Every rule consists of the following fields:
+  src, dst      source/destination address or network (e.g. 10.0.0.0/8)
+  sport, dport  source/destination port or inclusive range (e.g. 1024-65535)
+  proto         protocol number or name (icmp, tcp, udp, icmpv6, sctp)
+  start, end    inclusive time interval as RFC 3339 time or unix timestamp in
+                seconds (e.g. 2017-07-07T11:00:00Z or 1499425200.5)
+  label         the label (required)
+  priority      integer; if several rules match, the one with the highest
+                priority wins (default 0). Ties are resolved by file order.
+
+Empty fields or * match everything. csv files must start with a header naming
+the fields (in any order); json files must contain an array of objects.
+The label is a string. Packets without a matching rule get no label or the
+default label.
+
+Usage:
+  label %s [-bidirectional] [-default label] a.csv [b.json] [..] [--]
+
+Flags:
+  -bidirectional
+    	Rules also match packets in reverse direction (swapped addresses
+    	and ports)
+  -default string
+    	Label of packets that don't match any rule
+`, name, name)
+}
+
+func init() {
+	packet.RegisterLabel("rules", "Assign labels with rules based on addresses, ports, protocol, and time.", newrulesLabels, rulesLabelsHelp)
+}
@@ -0,0 +1,157 @@
+package rules
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/chtisgit/go-flows/flows"
+	"github.com/chtisgit/go-flows/packet"
+	"github.com/google/gopacket/layers"
+)
+
+func makeLabels(t *testing.T, args ...string) *rulesLabels {
+	_, ret, err := newrulesLabels(args)
+	if err != nil {
+		t.Fatalf("Couldn't create labels: %s", err)
+	}
+	return ret.(*rulesLabels)
+}
+
+func at(t string) flows.DateTimeNanoseconds {
+	ret, err := time.Parse(time.RFC3339, t)
+	if err != nil {
+		panic(err)
+	}
+	return flows.DateTimeNanoseconds(ret.UnixNano())
+}
+
+func ip(src, dst string, proto layers.IPProtocol) packet.SerializableLayerType {
+	if ip := net.ParseIP(src); ip.To4() == nil {
+		return &layers.IPv6{SrcIP: ip, DstIP: net.ParseIP(dst), NextHeader: proto}
+	}
+	return &layers.IPv4{SrcIP: net.ParseIP(src).To4(), DstIP: net.ParseIP(dst).To4(), Protocol: proto}
+}
+
+func tcp(src, dst string, sport, dport layers.TCPPort) []packet.SerializableLayerType {
+	return []packet.SerializableLayerType{ip(src, dst, layers.IPProtocolTCP), &layers.TCP{SrcPort: sport, DstPort: dport}}
+}
+
+func udp(src, dst string, sport, dport layers.UDPPort) []packet.SerializableLayerType {
+	return []packet.SerializableLayerType{ip(src, dst, layers.IPProtocolUDP), &layers.UDP{SrcPort: sport, DstPort: dport}}
+}
+
+func TestRules(t *testing.T) {
+	labels := makeLabels(t, "testdata/rules.csv", "testdata/rules.json")
+
+	for _, test := range []struct {
+		name   string
+		when   flows.DateTimeNanoseconds
+		layers []packet.SerializableLayerType
+		label  interface{}
+	}{
+		{"network", at("2017-07-07T10:00:00Z"), tcp("10.0.0.66", "10.0.0.7", 1234, 22), "portscan"},
+		{"priority", at("2017-07-07T11:05:00Z"), tcp("10.0.0.66", "10.0.0.5", 1234, 80), "web-attack"},
+		{"before interval", at("2017-07-07T10:59:59Z"), tcp("10.0.0.66", "10.0.0.5", 1234, 80), "portscan"},
+		{"end inclusive", at("2017-07-07T11:10:00Z"), tcp("10.0.0.66", "10.0.0.5", 1234, 80), "web-attack"},
+		{"after interval", at("2017-07-07T11:10:01Z"), tcp("10.0.0.66", "10.0.0.5", 1234, 80), "portscan"},
+		{"protocol", at("2017-07-07T10:00:00Z"), udp("10.0.0.66", "10.0.0.7", 1234, 22), nil},
+		{"reverse", at("2017-07-07T10:00:00Z"), tcp("10.0.0.7", "10.0.0.66", 22, 1234), nil},
+		{"wildcard", at("2017-07-07T10:00:00Z"), udp("10.1.1.1", "8.8.8.8", 1234, 53), "dns"},
+		{"port range", 0, udp("2001:db8::1", "2001:db9::1", 5000, 1500), "tunnel"},
+		{"outside port range", 0, udp("2001:db8::1", "2001:db9::1", 5000, 3000), nil},
+		{"unix time", at("2017-07-07T11:01:00Z"), tcp("10.0.0.1", "192.168.1.1", 1234, 22), "ssh"},
+		{"unix time fraction", at("2017-07-07T11:01:00Z") + 500*flows.MillisecondsInNanoseconds, tcp("10.0.0.1", "192.168.1.1", 1234, 22), "ssh"},
+		{"after unix time", at("2017-07-07T11:01:01Z"), tcp("10.0.0.1", "192.168.1.1", 1234, 22), nil},
+	} {
+		label, err := labels.GetLabel(packet.BufferFromLayers(test.when, test.layers...))
+		if err != nil {
+			t.Fatalf("%s: unexpected error %s", test.name, err)
+		}
+		if label != test.label {
+			t.Errorf("%s: expected label %v, but got %v", test.name, test.label, label)
+		}
+	}
+}
+
+func TestRulesOptions(t *testing.T) {
+	labels := makeLabels(t, "-bidirectional", "-default", "benign", "testdata/rules.csv", "--", "next")
+
+	when := at("2017-07-07T10:00:00Z")
+	for _, test := range []struct {
+		name   string
+		layers []packet.SerializableLayerType
+		label  interface{}
+	}{
+		{"forward", tcp("10.0.0.66", "10.0.0.7", 1234, 22), "portscan"},
+		{"reverse", tcp("10.0.0.7", "10.0.0.66", 22, 1234), "portscan"},
+		{"default", tcp("10.0.0.7", "10.0.0.8", 22, 1234), "benign"},
+	} {
+		label, _ := labels.GetLabel(packet.BufferFromLayers(when, test.layers...))
+		if label != test.label {
+			t.Errorf("%s: expected label %v, but got %v", test.name, test.label, label)
+		}
+	}
+}
+
+func TestRulesErrors(t *testing.T) {
+	for _, args := range [][]string{
+		{},
+		{"testdata/missing.csv"},
+		{"testdata/broken.csv"},
+		{"testdata/unknown.json"},
+	} {
+		if _, _, err := newrulesLabels(args); err == nil {
+			t.Errorf("%v: expected an error", args)
+		}
+	}
+}
+
+func TestRuleIndex(t *testing.T) {
+	// in order of priority
+	var rules []rule
+	for i, fields := range []map[string]string{
+		{"src": "10.1.2.3", "dst": "192.168.0.0/16", "label": "a"},
+		{"dst": "10.1.0.0/16", "label": "b"},
+		{"src": "10.0.0.0/8", "label": "c"},
+		{"proto": "udp", "label": "d"},
+		{"src": "2001:db8::/32", "label": "e"},
+		{"dst": "::/0", "label": "f"},
+	} {
+		r, err := makeRule(fields)
+		if err != nil {
+			t.Fatalf("rule %d: %s", i, err)
+		}
+		rules = append(rules, r)
+	}
+	for _, bidirectional := range []bool{false, true} {
+		labels := &rulesLabels{rules: rules, index: makeRuleIndex(rules), bidirectional: bidirectional}
+		for _, addresses := range [][2]string{
+			{"10.1.2.3", "192.168.1.1"},
+			{"192.168.1.1", "10.1.2.3"},
+			{"10.2.0.1", "10.1.0.1"},
+			{"172.16.0.1", "10.1.0.1"},
+			{"172.16.0.1", "172.16.0.2"},
+			{"2001:db8::1", "2001:db9::1"},
+			{"2001:db9::1", "2001:db8::1"},
+		} {
+			src, dst := net.ParseIP(addresses[0]), net.ParseIP(addresses[1])
+			for proto, packetLayers := range map[layers.IPProtocol][]packet.SerializableLayerType{
+				layers.IPProtocolTCP: tcp(addresses[0], addresses[1], 1, 2),
+				layers.IPProtocolUDP: udp(addresses[0], addresses[1], 1, 2),
+			} {
+				var expected interface{}
+				for _, r := range rules {
+					if r.matches(src, dst, 1, 2, uint8(proto), 0) || (bidirectional && r.matches(dst, src, 2, 1, uint8(proto), 0)) {
+						expected = r.label
+						break
+					}
+				}
+				label, _ := labels.GetLabel(packet.BufferFromLayers(0, packetLayers...))
+				if label != expected {
+					t.Errorf("%v (bidirectional %t, protocol %d): expected label %v, but got %v", addresses, bidirectional, proto, expected, label)
+				}
+			}
+		}
+	}
+}
@@ -30,8 +30,16 @@ type Buffer interface {
 	Proto() uint8
 	// Label returns the label of this packet, if one ones set
 	Label() interface{}
+	// Hostnames returns the names of the source and destination address learned from preceding DNS responses or empty
+	// strings if they are unknown (see PassiveDNS)
+	Hostnames() (source, destination string)
+	// HostStats returns the aggregates of the source and destination endpoint over the host window including this
+	// packet or nil if they aren't computed (see HostAggregates)
+	HostStats() (source, destination *HostStats)
 	// PacketNr returns the the number of this packet
 	PacketNr() uint64
+	// LinkType returns the link type of the captured data (e.g. for writing the packet to a pcap file)
+	LinkType() layers.LinkType
 	//// Convenience functions for packet size calculations
 	//// ------------------------------------------------------------------
 	// LinkLayerLength returns the length of the link layer (=header + payload) or 0 if there is no link layer
@@ -48,8 +56,8 @@ type Buffer interface {
 	Recycle()
 	//// Internal interface - don't use (necessa)
 	//// ------------------------------------------------------------------
-	// SetInfo sets the flowkey and the packet direction
-	SetInfo(string, bool)
+	// SetInfo sets the flowkey and the packet direction. The key is copied into the buffer.
+	SetInfo([]byte, bool)
 
 	decode() bool
 }
@@ -57,7 +65,8 @@ type Buffer interface {
 type packetBuffer struct {
 	inUse       int32
 	owner       *multiPacketBuffer
-	key         string
+	key         []byte
+	hash        uint64
 	time        flows.DateTimeNanoseconds
 	buffer      []byte
 	first       gopacket.LayerType
@@ -78,6 +87,11 @@ type packetBuffer struct {
 	failure     gopacket.ErrorLayer
 	ci          gopacket.PacketMetadata
 	label       interface{}
+	srcName     string
+	dstName     string
+	srcHost     HostStats
+	dstHost     HostStats
+	capture     *Capture
 	ip6headers  int
 	refcnt      int
 	packetnr    uint64
@@ -86,8 +100,22 @@ type packetBuffer struct {
 	proto       uint8
 	forward     bool
 	resize      bool
+	hosts       bool
+	state       bufferState
 }
 
+// bufferState holds the result of decoding and key computation for a packet
+type bufferState uint8
+
+const (
+	// bufferOK means the packet was decoded and has a valid flow key
+	bufferOK bufferState = iota
+	// bufferDecodeError means the packet couldn't be decoded
+	bufferDecodeError
+	// bufferKeyError means the key function rejected this packet
+	bufferKeyError
+)
+
 // SerializableLayerType holds a packet layer, which can be serialized. This is needed for feature testing
 type SerializableLayerType interface {
 	gopacket.SerializableLayer
@@ -209,6 +237,13 @@ func BufferFromLayers(when flows.DateTimeNanoseconds, layerList ...SerializableL
 	return pb
 }
 
+// LabeledBufferFromLayers creates a new Buffer like BufferFromLayers, which has the given label. Used for testing.
+func LabeledBufferFromLayers(when flows.DateTimeNanoseconds, label interface{}, layerList ...SerializableLayerType) Buffer {
+	pb := BufferFromLayers(when, layerList...).(*packetBuffer)
+	pb.label = label
+	return pb
+}
+
 func (pb *packetBuffer) SetWindow(w uint64) {
 	pb.window = w
 }
@@ -247,6 +282,8 @@ func (pb *packetBuffer) assign(data []byte, ci gopacket.CaptureInfo, lt gopacket
 	pb.tcp.Payload = nil
 	pb.proto = 0
 	pb.ip6headers = 0
+	pb.srcName, pb.dstName = "", ""
+	pb.hosts = false
 	pb.refcnt = 1
 	dlen := len(data)
 	if pb.resize && cap(pb.buffer) < dlen {
@@ -281,10 +318,14 @@ func (pb *packetBuffer) Recycle() {
 	pb.owner.free(1)
 }
 
-func (pb *packetBuffer) Key() string {
+func (pb *packetBuffer) Key() []byte {
 	return pb.key
 }
 
+func (pb *packetBuffer) KeyHash() uint64 {
+	return pb.hash
+}
+
 func (pb *packetBuffer) Timestamp() flows.DateTimeNanoseconds {
 	return pb.time
 }
@@ -293,8 +334,9 @@ func (pb *packetBuffer) LowToHigh() bool {
 	return pb.forward
 }
 
-func (pb *packetBuffer) SetInfo(key string, forward bool) {
-	pb.key = key
+func (pb *packetBuffer) SetInfo(key []byte, forward bool) {
+	pb.key = append(pb.key[:0], key...)
+	pb.hash = flows.HashKey(pb.key)
 	pb.forward = forward
 }
 
@@ -363,6 +405,30 @@ func (pb *packetBuffer) ErrorLayer() gopacket.ErrorLayer             { return ni
 func (pb *packetBuffer) Data() []byte                                { return pb.buffer }
 func (pb *packetBuffer) Metadata() *gopacket.PacketMetadata          { return &pb.ci }
 func (pb *packetBuffer) Label() interface{}                          { return pb.label }
+func (pb *packetBuffer) Hostnames() (string, string)                 { return pb.srcName, pb.dstName }
+
+func (pb *packetBuffer) HostStats() (*HostStats, *HostStats) {
+	if !pb.hosts {
+		return nil, nil
+	}
+	return &pb.srcHost, &pb.dstHost
+}
+
+// linkTypes maps the base layer types of the sources to link types
+var linkTypes = map[gopacket.LayerType]layers.LinkType{
+	layers.LayerTypeEthernet: layers.LinkTypeEthernet,
+	layers.LayerTypeLinuxSLL: layers.LinkTypeLinuxSLL,
+	layers.LayerTypeIPv4:     layers.LinkTypeIPv4,
+	layers.LayerTypeIPv6:     layers.LinkTypeIPv6,
+	LayerTypeIPv46:           layers.LinkTypeRaw,
+}
+
+func (pb *packetBuffer) LinkType() layers.LinkType {
+	if lt, ok := linkTypes[pb.first]; ok {
+		return lt
+	}
+	return layers.LinkTypeRaw
+}
 
 func (pb *packetBuffer) LinkLayerLength() int {
 	if eth, ok := pb.link.(*layers.Ethernet); ok && eth.Length != 0 {
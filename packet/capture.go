@@ -0,0 +1,221 @@
+package packet
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"sync/atomic"
+
+	"github.com/chtisgit/go-flows/flows"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// Default limits of the ring buffers of Capture
+const (
+	// DefaultCapturePackets is the default number of packets in the ring buffer of a flow
+	DefaultCapturePackets = 16
+	// DefaultCaptureBytes is the default number of bytes in the ring buffer of a flow
+	DefaultCaptureBytes = 64 * 1024
+	// DefaultCaptureMemory is the default number of bytes held by the ring buffers of all flows together
+	DefaultCaptureMemory = 256 * 1024 * 1024
+)
+
+// CapturedPacket is a packet of a captured flow
+type CapturedPacket struct {
+	CaptureInfo gopacket.CaptureInfo
+	Data        []byte
+	LinkType    layers.LinkType
+	Label       interface{}
+}
+
+// CaptureWriter writes the packets of captured flows (e.g. to a pcapng file). Calls are serialized by Capture.
+type CaptureWriter interface {
+	// WritePackets writes the packets of the flow with the given id (the value of the flowId feature). The data of the
+	// packets must not be used after WritePackets returned.
+	WritePackets(flow uint64, packets []CapturedPacket) error
+	// Close flushes and closes the output
+	Close() error
+}
+
+// CaptureConfig configures Capture
+type CaptureConfig struct {
+	// Writer writes the captured packets
+	Writer CaptureWriter
+	// Packets is the maximum number of packets in the ring buffer of a flow. 0 means DefaultCapturePackets.
+	Packets int
+	// Bytes is the maximum number of bytes in the ring buffer of a flow. 0 means DefaultCaptureBytes.
+	Bytes int
+	// Memory is the maximum number of bytes held by the ring buffers of all flows. If the limit is reached, the oldest
+	// packets of the ring buffer receiving a new packet are dropped. 0 means DefaultCaptureMemory.
+	Memory int64
+}
+
+// Capture writes the packets of the flows, which are selected by the control expressions and filters of the flow
+// specification (see flows.EventContext.Selected). Until a flow is selected, its last packets are kept in a ring
+// buffer. As soon as a packet selects the flow, the ring buffer and every following packet of the flow is written.
+//
+// Packets are annotated with the capture by Event; the packet flows handle the ring buffers.
+type Capture struct {
+	// buffered is accessed atomically and must stay at the beginning for alignment
+	buffered int64
+	writer   CaptureWriter
+	packets  int
+	bytes    int
+	memory   int64
+	warning  sync.Once
+	mutex    sync.Mutex
+	err      error
+}
+
+// NewCapture returns a new capture with the given configuration
+func NewCapture(config CaptureConfig) (*Capture, error) {
+	if config.Writer == nil {
+		return nil, errors.New("capture needs a writer")
+	}
+	if config.Packets < 0 || config.Bytes < 0 || config.Memory < 0 {
+		return nil, errors.New("capture limits must not be negative")
+	}
+	if config.Packets == 0 {
+		config.Packets = DefaultCapturePackets
+	}
+	if config.Bytes == 0 {
+		config.Bytes = DefaultCaptureBytes
+	}
+	if config.Memory == 0 {
+		config.Memory = DefaultCaptureMemory
+	}
+	return &Capture{
+		writer:  config.Writer,
+		packets: config.Packets,
+		bytes:   config.Bytes,
+		memory:  config.Memory,
+	}, nil
+}
+
+// Event annotates the packet with this capture. Packets without annotation are never captured.
+func (c *Capture) Event(buffer Buffer) {
+	buffer.(*packetBuffer).capture = c
+}
+
+// Buffered returns the number of bytes held by the ring buffers of all flows
+func (c *Capture) Buffered() int64 {
+	return atomic.LoadInt64(&c.buffered)
+}
+
+// Close closes the writer. Must be called after every flow was exported or dropped. Returns the first error of the
+// writer.
+func (c *Capture) Close() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	err := c.writer.Close()
+	if c.err != nil {
+		return c.err
+	}
+	return err
+}
+
+// write writes the packets of the given flow. After an error, nothing is written anymore.
+func (c *Capture) write(id uint64, packets []CapturedPacket) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.err == nil {
+		c.err = c.writer.WritePackets(id, packets)
+	}
+}
+
+// flowCapture holds the capture state of a flow. It is embedded into the packet flows, which implement
+// flows.FlowWithEventEnd and flows.FlowWithDestroy with it.
+type flowCapture struct {
+	capture   *Capture
+	ring      []CapturedPacket
+	ringBytes int
+	triggered bool
+}
+
+// capturedPacket returns a CapturedPacket referencing the data of buffer
+func capturedPacket(buffer *packetBuffer) CapturedPacket {
+	ci := buffer.ci.CaptureInfo
+	ci.CaptureLength = len(buffer.buffer)
+	return CapturedPacket{
+		CaptureInfo: ci,
+		Data:        buffer.buffer,
+		LinkType:    buffer.LinkType(),
+		Label:       buffer.label,
+	}
+}
+
+// captureFlowID returns the same value as the flowId feature
+func captureFlowID(flow flows.Flow) uint64 {
+	id := flow.ID() & 0x00FFFFFFFFFFFFFF
+	return id | uint64(flow.Table().ID())<<56
+}
+
+// EventEnd writes the packet, if the flow is selected, or keeps it in the ring buffer otherwise
+func (f *flowCapture) EventEnd(event flows.Event, context *flows.EventContext) {
+	buffer, ok := event.(*packetBuffer)
+	if !ok || buffer.capture == nil {
+		return
+	}
+	f.capture = buffer.capture
+	if !f.triggered && context.Selected() {
+		f.triggered = true
+		f.capture.write(captureFlowID(context.Flow()), f.ring)
+		f.release()
+	}
+	if f.triggered {
+		f.capture.write(captureFlowID(context.Flow()), []CapturedPacket{capturedPacket(buffer)})
+		return
+	}
+	p := capturedPacket(buffer)
+	p.Data = append([]byte(nil), p.Data...)
+	f.push(p)
+}
+
+// Destroy releases the ring buffer
+func (f *flowCapture) Destroy() {
+	f.release()
+}
+
+// reset forgets the capture state (e.g. for a new connection reusing the flow)
+func (f *flowCapture) reset() {
+	f.release()
+	f.triggered = false
+}
+
+// release empties the ring buffer
+func (f *flowCapture) release() {
+	if f.capture != nil {
+		atomic.AddInt64(&f.capture.buffered, -int64(f.ringBytes))
+	}
+	f.ring = nil
+	f.ringBytes = 0
+}
+
+// drop removes the oldest packet from the ring buffer
+func (f *flowCapture) drop() {
+	size := len(f.ring[0].Data)
+	f.ringBytes -= size
+	atomic.AddInt64(&f.capture.buffered, -int64(size))
+	f.ring[0] = CapturedPacket{}
+	f.ring = f.ring[1:]
+}
+
+// push adds a packet to the ring buffer and drops the oldest packets exceeding the limits
+func (f *flowCapture) push(p CapturedPacket) {
+	c := f.capture
+	f.ring = append(f.ring, p)
+	f.ringBytes += len(p.Data)
+	total := atomic.AddInt64(&c.buffered, int64(len(p.Data)))
+	for len(f.ring) > c.packets || f.ringBytes > c.bytes {
+		f.drop()
+	}
+	if total > c.memory {
+		c.warning.Do(func() {
+			log.Printf("capture: the ring buffers reached the memory limit of %d bytes; dropping packets", c.memory)
+		})
+		for len(f.ring) > 0 && atomic.LoadInt64(&c.buffered) > c.memory {
+			f.drop()
+		}
+	}
+}
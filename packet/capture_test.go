@@ -0,0 +1,78 @@
+package packet
+
+import (
+	"testing"
+
+	"github.com/chtisgit/go-flows/flows"
+	"github.com/google/gopacket/layers"
+)
+
+type nopCaptureWriter struct {
+	packets int
+}
+
+func (w *nopCaptureWriter) WritePackets(flow uint64, packets []CapturedPacket) error {
+	w.packets += len(packets)
+	return nil
+}
+
+func (w *nopCaptureWriter) Close() error { return nil }
+
+func TestCaptureRing(t *testing.T) {
+	capture, err := NewCapture(CaptureConfig{Writer: &nopCaptureWriter{}, Packets: 3, Bytes: 100, Memory: 150})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &flowCapture{capture: capture}
+	b := &flowCapture{capture: capture}
+	for i := 0; i < 4; i++ {
+		a.push(CapturedPacket{Data: make([]byte, 30)})
+	}
+	if len(a.ring) != 3 || a.ringBytes != 90 {
+		t.Errorf("expected 3 packets with 90 bytes, but got %d packets with %d bytes", len(a.ring), a.ringBytes)
+	}
+	b.push(CapturedPacket{Data: make([]byte, 50)})
+	b.push(CapturedPacket{Data: make([]byte, 20)})
+	if len(b.ring) != 1 || b.ringBytes != 20 {
+		t.Errorf("expected 1 packet with 20 bytes, but got %d packets with %d bytes", len(b.ring), b.ringBytes)
+	}
+	b.push(CapturedPacket{Data: make([]byte, 101)})
+	if len(b.ring) != 0 {
+		t.Errorf("expected a packet exceeding the ring size to be dropped, but got %d packets", len(b.ring))
+	}
+	a.Destroy()
+	b.Destroy()
+	if n := capture.Buffered(); n != 0 {
+		t.Errorf("expected empty ring buffers, but %d bytes are buffered", n)
+	}
+}
+
+func TestCaptureRemovedFlow(t *testing.T) {
+	writer := &nopCaptureWriter{}
+	capture, err := NewCapture(CaptureConfig{Writer: writer})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// without records, the flows are never selected and removed after every packet without being stopped
+	var records flows.RecordListMaker
+	records.Init()
+	table := flows.NewFlowTable(records, NewFlow, flows.FlowOptions{ActiveTimeout: flows.SecondsInNanoseconds * 3600}, false, 0)
+	selector := benchmarkSelector()
+	for i := 0; i < 3; i++ {
+		buffer := BufferFromLayers(flows.DateTimeNanoseconds(i),
+			&layers.IPv4{SrcIP: []byte{10, 0, 0, byte(i)}, DstIP: []byte{10, 1, 0, 1}, Protocol: layers.IPProtocolTCP},
+			&layers.TCP{SrcPort: 1024, DstPort: 80},
+		).(*packetBuffer)
+		buffer.buffer = make([]byte, 60)
+		capture.Event(buffer)
+		key, fw, _ := selector.Key(buffer)
+		buffer.SetInfo(key, fw)
+		table.Event(buffer)
+	}
+	if n := capture.Buffered(); n != 0 {
+		t.Errorf("expected the ring buffers of removed flows to be released, but %d bytes are buffered", n)
+	}
+	if writer.packets != 0 {
+		t.Errorf("expected no written packets, but got %d", writer.packets)
+	}
+}
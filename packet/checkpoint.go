@@ -0,0 +1,191 @@
+package packet
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync/atomic"
+
+	"github.com/chtisgit/go-flows/flows"
+)
+
+// A checkpoint file is a gob stream consisting of a checkpointHeader followed by the checkpoint of every flow table
+// (see flows.FlowTable.Checkpoint) as byte slice. The header holds the position of the sampler, so a resumed run
+// samples the same packets.
+
+const checkpointVersion = 1
+
+type checkpointHeader struct {
+	Version int
+	Tables  int
+	Sampler samplerState
+}
+
+func writeCheckpoint(w io.Writer, tables [][]byte, sampler samplerState) error {
+	enc := gob.NewEncoder(w)
+	if err := enc.Encode(checkpointHeader{Version: checkpointVersion, Tables: len(tables), Sampler: sampler}); err != nil {
+		return err
+	}
+	for _, table := range tables {
+		if err := enc.Encode(table); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkpointTables(tables []*flows.FlowTable) ([][]byte, error) {
+	ret := make([][]byte, len(tables))
+	for i, table := range tables {
+		var buf bytes.Buffer
+		if err := table.Checkpoint(gob.NewEncoder(&buf)); err != nil {
+			return nil, fmt.Errorf("table %d: %s", i, err)
+		}
+		ret[i] = buf.Bytes()
+	}
+	return ret, nil
+}
+
+func restoreTables(r io.Reader, tables []*flows.FlowTable, restore flows.FlowRestorer) (samplerState, error) {
+	dec := gob.NewDecoder(r)
+	var header checkpointHeader
+	if err := dec.Decode(&header); err != nil {
+		return samplerState{}, err
+	}
+	if header.Version != checkpointVersion {
+		return samplerState{}, fmt.Errorf("unsupported checkpoint version %d", header.Version)
+	}
+	if header.Tables != len(tables) {
+		return samplerState{}, fmt.Errorf("checkpoint contains %d flow tables, but %d are used", header.Tables, len(tables))
+	}
+	for i, table := range tables {
+		var data []byte
+		if err := dec.Decode(&data); err != nil {
+			return samplerState{}, err
+		}
+		if err := table.Restore(gob.NewDecoder(bytes.NewReader(data)), restore); err != nil {
+			return samplerState{}, fmt.Errorf("table %d: %s", i, err)
+		}
+	}
+	return header.Sampler, nil
+}
+
+// saveFile writes a file with the given name, without destroying an already existing file in case of errors
+func saveFile(name string, write func(io.Writer) error) error {
+	tmp := name + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, name)
+}
+
+// SaveCheckpoint writes the state of every flow and the sampler to the file with the given name. Must only be called
+// after the engine finished (see Finish). The flows are still held by the table afterwards (see EventTable.Drop).
+func (input *Engine) SaveCheckpoint(name string) error {
+	return saveFile(name, func(w io.Writer) error {
+		tables, err := checkpointTables(input.flowtable.getTables())
+		if err != nil {
+			return err
+		}
+		return writeCheckpoint(w, tables, input.samplerState())
+	})
+}
+
+// Resume restores the flows of every table and the sampler from a checkpoint. The flows are created with restore.
+// Must be called after SetSampling and before Run.
+func (input *Engine) Resume(r io.Reader, restore flows.FlowRestorer) error {
+	state, err := restoreTables(r, input.flowtable.getTables(), restore)
+	if err != nil {
+		return err
+	}
+	if input.sampler != nil {
+		input.sampler.restore(state)
+	}
+	return nil
+}
+
+// Drop removes every flow from the tables without exporting them
+func (bt baseTable) Drop() {
+	for _, table := range bt.tables {
+		table.Drop()
+	}
+}
+
+// checkpointRequest travels with the packet buffers to the flow tables. Every table saves its state into its own slot
+// after handling the buffer; the file is written after every table is done. If the buffer doesn't reach every table
+// (e.g. because the tables were shut down), the request is aborted and no file is written.
+type checkpointRequest struct {
+	name      string
+	tables    [][]byte
+	errs      []error
+	sampler   samplerState
+	remaining int32
+	done      chan struct{}
+	aborted   chan struct{}
+}
+
+func newCheckpointRequest(name string, tables int, sampler samplerState) *checkpointRequest {
+	return &checkpointRequest{
+		name:      name,
+		tables:    make([][]byte, tables),
+		errs:      make([]error, tables),
+		sampler:   sampler,
+		remaining: int32(tables),
+		done:      make(chan struct{}),
+		aborted:   make(chan struct{}),
+	}
+}
+
+// save must be called from the goroutine handling the flow table with the given id
+func (req *checkpointRequest) save(id int, table *flows.FlowTable) {
+	var buf bytes.Buffer
+	req.errs[id] = table.Checkpoint(gob.NewEncoder(&buf))
+	req.tables[id] = buf.Bytes()
+	if atomic.AddInt32(&req.remaining, -1) == 0 {
+		close(req.done)
+	}
+}
+
+// finished returns true if every table saved its state
+func (req *checkpointRequest) finished() bool {
+	return atomic.LoadInt32(&req.remaining) == 0
+}
+
+// abort stops waiting for the tables. Must only be called after the flow tables were shut down.
+func (req *checkpointRequest) abort() {
+	close(req.aborted)
+}
+
+// write waits for every table to finish and writes the checkpoint file
+func (req *checkpointRequest) write() {
+	select {
+	case <-req.done:
+	case <-req.aborted:
+		if !req.finished() {
+			log.Printf("Error writing checkpoint: %d flow table(s) didn't save their state\n", atomic.LoadInt32(&req.remaining))
+			return
+		}
+	}
+	for i, err := range req.errs {
+		if err != nil {
+			log.Printf("Error writing checkpoint: table %d: %s\n", i, err)
+			return
+		}
+	}
+	if err := saveFile(req.name, func(w io.Writer) error { return writeCheckpoint(w, req.tables, req.sampler) }); err != nil {
+		log.Println("Error writing checkpoint: ", err)
+	}
+}
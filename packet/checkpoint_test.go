@@ -0,0 +1,133 @@
+package packet
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/chtisgit/go-flows/flows"
+	"github.com/google/gopacket"
+)
+
+// blockingSource delivers the packets of testSource and blocks afterwards until Stop is called
+type blockingSource struct {
+	testSource
+	stop chan struct{}
+}
+
+func (s *blockingSource) Stop() { close(s.stop) }
+
+func (s *blockingSource) ReadPacket() (lt gopacket.LayerType, data []byte, ci gopacket.CaptureInfo, skipped uint64, filtered uint64, err error) {
+	if s.pos >= len(s.data) {
+		<-s.stop
+		err = io.EOF
+		return
+	}
+	return s.testSource.ReadPacket()
+}
+
+func makeCheckpointEngine(source Source, sampling Sampling) *Engine {
+	var records flows.RecordListMaker
+	records.Init()
+	selector := MakeDynamicKeySelector([]string{"sourceIPAddress", "sourceTransportPort"}, false, false)
+	table := NewFlowTable(2, records, NewFlow, flows.FlowOptions{ActiveTimeout: 3600 * flows.SecondsInNanoseconds}, 0, selector, true)
+	var sources Sources
+	sources.Append(source)
+	engine := NewEngine(0, 1, table, nil, sources, nil)
+	engine.SetSampling(sampling)
+	return engine
+}
+
+// finishWithin fails the test if Finish doesn't return in time
+func finishWithin(t *testing.T, engine *Engine) {
+	done := make(chan struct{})
+	go func() {
+		engine.Finish()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Finish didn't return")
+	}
+}
+
+func TestCheckpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sampling := Sampling{Mode: SampleCount, Rate: 3}
+
+	// the last sampled packet (38ms) requests a checkpoint, which is carried out at EOF
+	name := filepath.Join(dir, "eof")
+	engine := makeCheckpointEngine(&testSource{data: makeTestPackets(t, 40)}, sampling)
+	engine.CheckpointEvery(name, 10*flows.MillisecondsInNanoseconds)
+	engine.Run()
+	finishWithin(t, engine)
+	if _, err := os.Stat(name); err != nil {
+		t.Fatalf("Expected the checkpoint at EOF to be written: %s", err)
+	}
+	// the last packet was sampled out, which must be part of the checkpoint written after Finish
+	if err := engine.SaveCheckpoint(name); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resumed := makeCheckpointEngine(&testSource{}, sampling)
+	err = resumed.Resume(bufio.NewReader(f), RestoreFlow)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state := resumed.sampler.state(); state != (samplerState{Count: 1}) {
+		t.Errorf("Expected sampler state {Count:1} after resume, but got %+v", state)
+	}
+	resumed.Finish()
+
+	// cancellation while the request waits in a partially filled batch
+	name = filepath.Join(dir, "stop")
+	source := &blockingSource{testSource: testSource{data: makeTestPackets(t, 39)}, stop: make(chan struct{})}
+	engine = makeCheckpointEngine(source, sampling)
+	engine.CheckpointEvery(name, 10*flows.MillisecondsInNanoseconds)
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		engine.Stop()
+	}()
+	engine.Run()
+	finishWithin(t, engine)
+	if _, err := os.Stat(name); err != nil {
+		t.Errorf("Expected the checkpoint after Stop to be written: %s", err)
+	}
+
+	// a request, which doesn't reach every table, must not block
+	req := newCheckpointRequest(filepath.Join(dir, "aborted"), 2, samplerState{})
+	req.save(0, engine.flowtable.getTables()[0])
+	req.abort()
+	req.write()
+	if _, err := os.Stat(filepath.Join(dir, "aborted")); err == nil {
+		t.Error("Expected no checkpoint for an aborted request")
+	}
+}
+
+func TestRandomSamplingRestore(t *testing.T) {
+	sampling := Sampling{Mode: SampleRandom, Rate: 4, Seed: 7}
+	s := newSampler(sampling)
+	for i := 0; i < 100; i++ {
+		s.packet()
+	}
+	restored := newSampler(sampling)
+	restored.restore(s.state())
+	for i := 0; i < 100; i++ {
+		if s.packet() != restored.packet() {
+			t.Fatalf("Restored sampler selected a different packet %d", i)
+		}
+	}
+}
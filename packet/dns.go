@@ -0,0 +1,150 @@
+package packet
+
+import (
+	"sync/atomic"
+
+	"github.com/chtisgit/go-flows/flows"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// dnsPruneInterval is the period (packet time) in which expired mappings are removed
+const dnsPruneInterval = 60 * flows.SecondsInNanoseconds
+
+// dnsKey identifies a mapping; client is the zero endpoint for mappings learned from any client
+type dnsKey struct {
+	client  gopacket.Endpoint
+	address gopacket.Endpoint
+}
+
+type dnsEntry struct {
+	name    string
+	expires flows.DateTimeNanoseconds
+}
+
+// PassiveDNS learns address to hostname mappings from DNS responses (A and AAAA answers) and annotates packets with the
+// names of their addresses (see Buffer.Hostnames). The name is the one the client asked for (i.e. before following
+// CNAMEs). Mappings are valid for the TTL of the answer plus a grace period. The name looked up by the same client is
+// preferred; otherwise the latest mapping learned from any client is used.
+//
+// PassiveDNS must see the packets in packet order. The engine does this before the packets are distributed over the
+// flow tables, which means that the mappings are shared by every table.
+type PassiveDNS struct {
+	grace     flows.DateTimeNanoseconds
+	entries   map[dnsKey]dnsEntry
+	nextPrune flows.DateTimeNanoseconds
+	dns       layers.DNS
+}
+
+// passiveDNSEnabled is accessed atomically (see SetPassiveDNSEnabled)
+var passiveDNSEnabled int32
+
+// SetPassiveDNSEnabled declares whether passive DNS is used. Features using the learned hostnames fail type resolution
+// without it, since they would never have a value. pipeline.Build enables it for pipelines with passive DNS before the
+// feature specifications are compiled.
+func SetPassiveDNSEnabled(enabled bool) {
+	var value int32
+	if enabled {
+		value = 1
+	}
+	atomic.StoreInt32(&passiveDNSEnabled, value)
+}
+
+// PassiveDNSEnabled returns true if passive DNS is used (see SetPassiveDNSEnabled)
+func PassiveDNSEnabled() bool {
+	return atomic.LoadInt32(&passiveDNSEnabled) != 0
+}
+
+// NewPassiveDNS returns a new passive DNS table. Mappings are kept for the TTL of the DNS answer plus grace.
+func NewPassiveDNS(grace flows.DateTimeNanoseconds) *PassiveDNS {
+	return &PassiveDNS{
+		grace:   grace,
+		entries: make(map[dnsKey]dnsEntry),
+	}
+}
+
+// Event annotates the packet with the names of its addresses and learns the mappings if it is a DNS response
+func (p *PassiveDNS) Event(buffer Buffer) {
+	pb := buffer.(*packetBuffer)
+	pb.srcName, pb.dstName = "", ""
+	if pb.network == nil {
+		return
+	}
+	now := pb.Timestamp()
+	if now > p.nextPrune {
+		p.prune(now)
+		p.nextPrune = now + dnsPruneInterval
+	}
+
+	src, dst := pb.network.NetworkFlow().Endpoints()
+	pb.srcName = p.lookup(dst, src, now)
+	pb.dstName = p.lookup(src, dst, now)
+
+	var payload []byte
+	switch transport := pb.transport.(type) {
+	case *layers.UDP:
+		if transport.SrcPort != 53 {
+			return
+		}
+		payload = transport.LayerPayload()
+	case *layers.TCP:
+		if transport.SrcPort != 53 {
+			return
+		}
+		// only messages contained in a single segment are supported
+		payload = transport.LayerPayload()
+		if len(payload) < 2 || int(payload[0])<<8|int(payload[1]) != len(payload)-2 {
+			return
+		}
+		payload = payload[2:]
+	default:
+		return
+	}
+	if len(payload) == 0 || p.dns.DecodeFromBytes(payload, gopacket.NilDecodeFeedback) != nil {
+		return
+	}
+	if !p.dns.QR || p.dns.ResponseCode != layers.DNSResponseCodeNoErr {
+		return
+	}
+	p.learn(dst, now)
+}
+
+// learn stores the mappings of the decoded response to the given client
+func (p *PassiveDNS) learn(client gopacket.Endpoint, now flows.DateTimeNanoseconds) {
+	for _, answer := range p.dns.Answers {
+		if answer.Class != layers.DNSClassIN || (answer.Type != layers.DNSTypeA && answer.Type != layers.DNSTypeAAAA) || answer.IP == nil {
+			continue
+		}
+		name := answer.Name
+		if len(p.dns.Questions) > 0 {
+			name = p.dns.Questions[0].Name
+		}
+		entry := dnsEntry{
+			name:    string(name),
+			expires: now + flows.DateTimeNanoseconds(answer.TTL)*flows.SecondsInNanoseconds + p.grace,
+		}
+		address := layers.NewIPEndpoint(answer.IP)
+		p.entries[dnsKey{client, address}] = entry
+		p.entries[dnsKey{address: address}] = entry
+	}
+}
+
+// lookup returns the name of address as seen by client or "" if there is none
+func (p *PassiveDNS) lookup(client, address gopacket.Endpoint, now flows.DateTimeNanoseconds) string {
+	if entry, ok := p.entries[dnsKey{client, address}]; ok && entry.expires >= now {
+		return entry.name
+	}
+	if entry, ok := p.entries[dnsKey{address: address}]; ok && entry.expires >= now {
+		return entry.name
+	}
+	return ""
+}
+
+// prune removes the expired mappings
+func (p *PassiveDNS) prune(now flows.DateTimeNanoseconds) {
+	for key, entry := range p.entries {
+		if entry.expires < now {
+			delete(p.entries, key)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package packet
+
+import (
+	"net"
+	"testing"
+
+	"github.com/chtisgit/go-flows/flows"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+func dnsResponse(t *testing.T, question string, ttl uint32, addresses ...string) []byte {
+	dns := &layers.DNS{
+		ID:        1,
+		QR:        true,
+		Questions: []layers.DNSQuestion{{Name: []byte(question), Type: layers.DNSTypeA, Class: layers.DNSClassIN}},
+	}
+	// CNAME answers must be ignored
+	dns.Answers = append(dns.Answers, layers.DNSResourceRecord{Name: []byte(question), Type: layers.DNSTypeCNAME, Class: layers.DNSClassIN, TTL: ttl, CNAME: []byte("cdn.example.net")})
+	for _, address := range addresses {
+		ip := net.ParseIP(address)
+		rr := layers.DNSResourceRecord{Name: []byte("cdn.example.net"), Type: layers.DNSTypeAAAA, Class: layers.DNSClassIN, TTL: ttl, IP: ip}
+		if ip4 := ip.To4(); ip4 != nil {
+			rr.Type = layers.DNSTypeA
+			rr.IP = ip4
+		}
+		dns.Answers = append(dns.Answers, rr)
+	}
+	buf := gopacket.NewSerializeBuffer()
+	if err := dns.SerializeTo(buf, gopacket.SerializeOptions{FixLengths: true}); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func ipLayer(src, dst string) SerializableLayerType {
+	s, d := net.ParseIP(src), net.ParseIP(dst)
+	if s.To4() != nil {
+		return &layers.IPv4{SrcIP: s.To4(), DstIP: d.To4(), Protocol: layers.IPProtocolUDP}
+	}
+	return &layers.IPv6{SrcIP: s, DstIP: d, NextHeader: layers.IPProtocolUDP}
+}
+
+func TestPassiveDNS(t *testing.T) {
+	dns := NewPassiveDNS(flows.SecondsInNanoseconds)
+	s := flows.SecondsInNanoseconds
+
+	for _, test := range []struct {
+		name     string
+		when     flows.DateTimeNanoseconds
+		layers   []SerializableLayerType
+		src, dst string
+	}{
+		{"response", 0, []SerializableLayerType{ipLayer("10.0.0.53", "10.0.0.1"), &layers.UDP{BaseLayer: layers.BaseLayer{Payload: dnsResponse(t, "www.example.com", 10, "1.2.3.4", "2a00::1")}, SrcPort: 53, DstPort: 1000}}, "", ""},
+		{"client", 1 * s, []SerializableLayerType{ipLayer("10.0.0.1", "1.2.3.4"), &layers.UDP{SrcPort: 1000, DstPort: 443}}, "", "www.example.com"},
+		{"server", 1 * s, []SerializableLayerType{ipLayer("1.2.3.4", "10.0.0.1"), &layers.UDP{SrcPort: 443, DstPort: 1000}}, "www.example.com", ""},
+		{"ipv6", 2 * s, []SerializableLayerType{ipLayer("fe80::1", "2a00::1"), &layers.UDP{SrcPort: 1000, DstPort: 443}}, "", "www.example.com"},
+		{"other client response", 3 * s, []SerializableLayerType{ipLayer("10.0.0.53", "10.0.0.2"), &layers.UDP{BaseLayer: layers.BaseLayer{Payload: dnsResponse(t, "other.example.com", 100, "1.2.3.4")}, SrcPort: 53, DstPort: 1000}}, "", ""},
+		{"same client preferred", 4 * s, []SerializableLayerType{ipLayer("10.0.0.1", "1.2.3.4"), &layers.UDP{SrcPort: 1000, DstPort: 443}}, "", "www.example.com"},
+		{"other client", 4 * s, []SerializableLayerType{ipLayer("10.0.0.2", "1.2.3.4"), &layers.UDP{SrcPort: 1000, DstPort: 443}}, "", "other.example.com"},
+		{"unknown client", 4 * s, []SerializableLayerType{ipLayer("10.0.0.3", "1.2.3.4"), &layers.UDP{SrcPort: 1000, DstPort: 443}}, "", "other.example.com"},
+		{"grace", 11 * s, []SerializableLayerType{ipLayer("fe80::1", "2a00::1"), &layers.UDP{SrcPort: 1000, DstPort: 443}}, "", "www.example.com"},
+		{"expired", 12 * s, []SerializableLayerType{ipLayer("fe80::1", "2a00::1"), &layers.UDP{SrcPort: 1000, DstPort: 443}}, "", ""},
+		{"fallback after expiry", 12 * s, []SerializableLayerType{ipLayer("10.0.0.1", "1.2.3.4"), &layers.UDP{SrcPort: 1000, DstPort: 443}}, "", "other.example.com"},
+		{"tcp response", 13 * s, []SerializableLayerType{ipLayer("10.0.0.53", "10.0.0.1"), &layers.TCP{BaseLayer: layers.BaseLayer{Payload: append([]byte{0, byte(len(dnsResponse(t, "tcp.example.com", 10, "5.6.7.8")))}, dnsResponse(t, "tcp.example.com", 10, "5.6.7.8")...)}, SrcPort: 53, DstPort: 1000}}, "", ""},
+		{"tcp", 14 * s, []SerializableLayerType{ipLayer("10.0.0.1", "5.6.7.8"), &layers.TCP{SrcPort: 1000, DstPort: 443}}, "", "tcp.example.com"},
+		{"query is ignored", 15 * s, []SerializableLayerType{ipLayer("10.0.0.53", "10.0.0.1"), &layers.UDP{BaseLayer: layers.BaseLayer{Payload: []byte{0, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}}, SrcPort: 53, DstPort: 1000}}, "", ""},
+		{"prune", 200 * s, []SerializableLayerType{ipLayer("10.0.0.1", "1.2.3.4"), &layers.UDP{SrcPort: 1000, DstPort: 443}}, "", ""},
+	} {
+		buffer := BufferFromLayers(test.when, test.layers...)
+		dns.Event(buffer)
+		if src, dst := buffer.Hostnames(); src != test.src || dst != test.dst {
+			t.Errorf("%s: expected hostnames %q/%q, but got %q/%q", test.name, test.src, test.dst, src, dst)
+		}
+	}
+	if len(dns.entries) != 0 {
+		t.Errorf("expected every mapping to be pruned, but %d are left", len(dns.entries))
+	}
+}
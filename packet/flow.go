@@ -7,11 +7,13 @@ import (
 
 type tcpFlow struct {
 	flows.BaseFlow
-	srcFIN, dstFIN, dstACK, srcACK bool
+	flowCapture
+	tracker tcpTracker
 }
 
 type uniFlow struct {
 	flows.BaseFlow
+	flowCapture
 }
 
 // NewFlow creates a new flow based on a given event, table, key, context, and flow-id
@@ -31,34 +33,73 @@ func NewFlow(event flows.Event, table *flows.FlowTable, key string, lowToHigh bo
 	return ret
 }
 
+// RestoreFlow creates an empty flow of the given kind for restoring it from a checkpoint (see flows.FlowRestorer)
+func RestoreFlow(kind string) flows.Flow {
+	switch kind {
+	case "tcp":
+		return new(tcpFlow)
+	case "uni":
+		return new(uniFlow)
+	}
+	return nil
+}
+
+func (flow *uniFlow) CheckpointKind() string         { return "uni" }
+func (flow *uniFlow) CheckpointState() []interface{} { return nil }
+
+func (flow *tcpFlow) CheckpointKind() string { return "tcp" }
+func (flow *tcpFlow) CheckpointState() []interface{} {
+	return []interface{}{&flow.tracker}
+}
+
+var timerTCPLinger = flows.RegisterTimer()
+
+// RestoreTimer restores the linger timer from a checkpoint (see flows.TimerRestorer)
+func (flow *tcpFlow) RestoreTimer(id flows.TimerID, expires flows.DateTimeNanoseconds) bool {
+	if id != timerTCPLinger {
+		return false
+	}
+	flow.AddTimer(timerTCPLinger, flow.lingerEvent, expires)
+	return true
+}
+
+func (flow *tcpFlow) TCPState() TCPState { return flow.tracker.State }
+
+func (flow *tcpFlow) TCPHistory() string { return string(flow.tracker.History) }
+
+func (flow *tcpFlow) TCPClientIsSource() bool { return flow.tracker.ClientForward }
+
+func (flow *tcpFlow) lingerEvent(expires, now flows.DateTimeNanoseconds) {
+	flow.ExportWithoutContext(flows.FlowEndReasonEnd, expires, now)
+}
+
 func (flow *tcpFlow) Event(event flows.Event, context *flows.EventContext) {
+	buffer := event.(Buffer)
+	tcp := buffer.TransportLayer().(*layers.TCP)
+	if flow.tracker.newConnection(tcp) {
+		flow.RemoveTimer(timerTCPLinger)
+		flow.Restart(flows.FlowEndReasonEnd, event, context, context.When())
+		flow.tracker = tcpTracker{}
+		flow.flowCapture.reset()
+	}
+	if flow.tracker.State == TCPStateNone && tcp.SYN && tcp.ACK {
+		// the server answered first -> the client is the source of the flow
+		flow.Reverse(context)
+	}
+	flow.tracker.update(tcp, context.Forward(), buffer.PayloadLength())
 	flow.BaseFlow.Event(event, context)
 	if !flow.Active() {
 		return
 	}
-	buffer := event.(Buffer)
-	tcp := buffer.TransportLayer().(*layers.TCP)
-	if tcp.RST {
+	switch flow.tracker.State {
+	case TCPStateClosed:
 		flow.Export(flows.FlowEndReasonEnd, context, context.When())
-		return
-	}
-	if context.Forward() {
-		if tcp.FIN {
-			flow.srcFIN = true
-		}
-		if flow.dstFIN && tcp.ACK {
-			flow.dstACK = true
-		}
-	} else {
-		if tcp.FIN {
-			flow.dstFIN = true
-		}
-		if flow.srcFIN && tcp.ACK {
-			flow.srcACK = true
+	case TCPStateTimeWait:
+		linger := flow.Table().TCPLinger
+		if linger == 0 {
+			flow.Export(flows.FlowEndReasonEnd, context, context.When())
+		} else if !flow.HasTimer(timerTCPLinger) {
+			flow.AddTimer(timerTCPLinger, flow.lingerEvent, context.When()+linger)
 		}
 	}
-
-	if flow.srcFIN && flow.srcACK && flow.dstFIN && flow.dstACK {
-		flow.Export(flows.FlowEndReasonEnd, context, context.When())
-	}
 }
@@ -0,0 +1,159 @@
+package packet
+
+import (
+	"math"
+	"math/bits"
+)
+
+const (
+	// hllPrecision is the number of hash bits used for the register index (standard error ≈ 1.04/√2^p ≈ 3%)
+	hllPrecision = 10
+	hllRegisters = 1 << hllPrecision
+	// hllSparseMax is the number of distinct hashes kept exactly before switching to registers
+	hllSparseMax = 64
+)
+
+// hll is a HyperLogLog sketch for estimating the number of distinct values. Small sets are stored exactly as list of
+// hashes, which keeps the memory of rarely used sketches low.
+type hll struct {
+	sparse []uint64
+	dense  []uint8
+}
+
+// reset removes every value from the sketch
+func (h *hll) reset() {
+	h.sparse = h.sparse[:0]
+	h.dense = nil
+}
+
+// contains returns true if the sketch holds a small set containing the hash
+func (h *hll) contains(hash uint64) bool {
+	for _, v := range h.sparse {
+		if v == hash {
+			return true
+		}
+	}
+	return false
+}
+
+// add adds the value with the given hash. Returns true if the sketch changed.
+func (h *hll) add(hash uint64) bool {
+	if h.dense == nil {
+		if h.contains(hash) {
+			return false
+		}
+		if len(h.sparse) < hllSparseMax {
+			h.sparse = append(h.sparse, hash)
+			return true
+		}
+		h.makeDense()
+	}
+	return hllAdd(h.dense, hash)
+}
+
+// makeDense switches the sketch from the list of hashes to registers
+func (h *hll) makeDense() {
+	h.dense = make([]uint8, hllRegisters)
+	for _, v := range h.sparse {
+		hllAdd(h.dense, v)
+	}
+	h.sparse = nil
+}
+
+// merge adds every value of other to the sketch
+func (h *hll) merge(other *hll) {
+	for _, v := range other.sparse {
+		h.add(v)
+	}
+	if other.dense == nil {
+		return
+	}
+	if h.dense == nil {
+		h.makeDense()
+	}
+	for i, v := range other.dense {
+		if v > h.dense[i] {
+			h.dense[i] = v
+		}
+	}
+}
+
+// hllAdd adds the hash to the registers. Returns true if a register changed.
+func hllAdd(registers []uint8, hash uint64) bool {
+	index := hash >> (64 - hllPrecision)
+	rank := uint8(bits.LeadingZeros64(hash<<hllPrecision|1<<(hllPrecision-1))) + 1
+	if rank > registers[index] {
+		registers[index] = rank
+		return true
+	}
+	return false
+}
+
+// hllUnion returns the estimated number of distinct values added to any of the sketches. The result is exact, as long
+// as every sketch holds a small set. scratch must hold hllRegisters bytes.
+func hllUnion(sketches []*hll, scratch []uint8) uint64 {
+	dense := false
+	for _, h := range sketches {
+		dense = dense || h.dense != nil
+	}
+	if !dense {
+		// the hashes of a single sketch are distinct, so only the hashes of the preceding sketches need to be checked
+		ret := uint64(0)
+		for i, h := range sketches {
+		HASHES:
+			for _, v := range h.sparse {
+				for _, preceding := range sketches[:i] {
+					if preceding.contains(v) {
+						continue HASHES
+					}
+				}
+				ret++
+			}
+		}
+		return ret
+	}
+
+	for i := range scratch {
+		scratch[i] = 0
+	}
+	for _, h := range sketches {
+		for _, v := range h.sparse {
+			hllAdd(scratch, v)
+		}
+		for i, v := range h.dense {
+			if v > scratch[i] {
+				scratch[i] = v
+			}
+		}
+	}
+	sum := 0.0
+	zeros := 0
+	for _, v := range scratch {
+		sum += math.Ldexp(1, -int(v))
+		if v == 0 {
+			zeros++
+		}
+	}
+	m := float64(hllRegisters)
+	estimate := 0.7213 / (1 + 1.079/m) * m * m / sum
+	if estimate <= 2.5*m && zeros > 0 {
+		// linear counting for small cardinalities
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return uint64(estimate + 0.5)
+}
+
+// hashBytes returns a 64 bit hash of data (FNV-1a with a final mix, since HyperLogLog needs well distributed bits)
+func hashBytes(data []byte) uint64 {
+	hash := uint64(14695981039346656037)
+	for _, b := range data {
+		hash ^= uint64(b)
+		hash *= 1099511628211
+	}
+	hash ^= hash >> 33
+	hash *= 0xff51afd7ed558ccd
+	hash ^= hash >> 33
+	hash *= 0xc4ceb9fe1a85ec53
+	hash ^= hash >> 33
+	return hash
+}
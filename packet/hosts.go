@@ -0,0 +1,330 @@
+package packet
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/chtisgit/go-flows/flows"
+	"github.com/google/gopacket/layers"
+)
+
+// HostStats holds the aggregates of an endpoint over the host window (see HostAggregates)
+type HostStats struct {
+	// PacketsSent is the number of packets sent by the endpoint
+	PacketsSent uint64
+	// PacketsReceived is the number of packets received by the endpoint
+	PacketsReceived uint64
+	// OctetsSent is the number of bytes (IP total length) sent by the endpoint
+	OctetsSent uint64
+	// OctetsReceived is the number of bytes (IP total length) received by the endpoint
+	OctetsReceived uint64
+	// DestinationPorts is the estimated number of distinct destination ports (TCP and UDP) of the sent packets
+	DestinationPorts uint64
+	// Destinations is the estimated number of distinct destination addresses of the sent packets
+	Destinations uint64
+	// Sources is the estimated number of distinct source addresses of the received packets
+	Sources uint64
+	// Connections is the estimated number of distinct connections (protocol, addresses, and ports if the protocol has
+	// ports) the endpoint initiated: TCP packets only count with SYN and without ACK, packets of other protocols (e.g.
+	// UDP or ICMP) always count.
+	Connections uint64
+	// ConnectionRate is Connections per second of the window
+	ConnectionRate float64
+}
+
+// HostAggregatesConfig configures HostAggregates
+type HostAggregatesConfig struct {
+	// Window is the length of the window the aggregates are computed over
+	Window flows.DateTimeNanoseconds
+	// Slots is the number of parts the window is split into. The window slides by Window/Slots, i.e. a single slot
+	// results in tumbling windows. 0 means 6 slots.
+	Slots int
+	// Key is the list of fields identifying an endpoint: address, mac, or vlan. Empty means address.
+	Key []string
+	// Prefix4 and Prefix6 are the prefix lengths the IPv4 and IPv6 addresses are truncated to for the key (0 means
+	// the whole address).
+	Prefix4, Prefix6 int
+}
+
+// hostCounters are the counters of HostStats, which are summed over the slots
+type hostCounters struct {
+	packetsSent, packetsReceived, octetsSent, octetsReceived uint64
+}
+
+const (
+	hllDestinationPorts = iota
+	hllDestinations
+	hllSources
+	hllConnections
+	hllCount
+)
+
+type hostSlot struct {
+	index    int64 // absolute number of the slot (time / slot length)
+	counters hostCounters
+	sketches [hllCount]hll
+}
+
+type hostEntry struct {
+	slots    []hostSlot
+	lastSlot int64 // slot of the latest packet
+	// previous holds the union of the sketches of the window without the slot distinctFor, which only changes when
+	// the window moves
+	previous [hllCount]hll
+	// distinct counts of the last query, which are valid, while the sketch didn't change and the window didn't move
+	distinct    [hllCount]uint64
+	distinctFor int64
+	dirty       [hllCount]bool
+}
+
+// HostAggregates computes aggregates (see HostStats) per endpoint over sliding or tumbling windows across all flows,
+// and annotates the packets with the aggregates of their source and destination (see Buffer.HostStats). Distinct
+// counts are estimated with HyperLogLog. The aggregates include the annotated packet and are based on packet time.
+//
+// HostAggregates must see the packets in packet order. The engine does this before the packets are distributed over
+// the flow tables, which means that the aggregates contain the packets of every table.
+type HostAggregates struct {
+	slotLength flows.DateTimeNanoseconds
+	slots      int
+	seconds    float64 // window length
+	fields     []string
+	prefix4    int
+	prefix6    int
+	entries    map[string]*hostEntry
+	nextPrune  int64
+	key        []byte
+	ports      [4]byte
+	hash       []byte
+	union      [2]*hll
+	scratch    []uint8
+}
+
+// NewHostAggregates returns new host aggregates with the given configuration
+func NewHostAggregates(config HostAggregatesConfig) (*HostAggregates, error) {
+	if config.Window <= 0 {
+		return nil, fmt.Errorf("host window must be positive")
+	}
+	if config.Slots == 0 {
+		config.Slots = 6
+	}
+	if config.Slots < 0 || flows.DateTimeNanoseconds(config.Slots) > config.Window {
+		return nil, fmt.Errorf("number of host window slots must be between 1 and the window length in nanoseconds")
+	}
+	if len(config.Key) == 0 {
+		config.Key = []string{"address"}
+	}
+	for _, field := range config.Key {
+		switch field {
+		case "address", "mac", "vlan":
+		default:
+			return nil, fmt.Errorf("unknown host key field %s (must be one of address, mac, or vlan)", field)
+		}
+	}
+	if config.Prefix4 < 0 || config.Prefix4 > 32 || config.Prefix6 < 0 || config.Prefix6 > 128 {
+		return nil, fmt.Errorf("host prefix lengths must be between 0 and 32 (IPv4) or 128 (IPv6)")
+	}
+	if config.Prefix4 == 0 {
+		config.Prefix4 = 32
+	}
+	if config.Prefix6 == 0 {
+		config.Prefix6 = 128
+	}
+	return &HostAggregates{
+		slotLength: config.Window / flows.DateTimeNanoseconds(config.Slots),
+		slots:      config.Slots,
+		seconds:    float64(config.Window) / float64(flows.SecondsInNanoseconds),
+		fields:     config.Key,
+		prefix4:    config.Prefix4,
+		prefix6:    config.Prefix6,
+		entries:    make(map[string]*hostEntry),
+		scratch:    make([]uint8, hllRegisters),
+	}, nil
+}
+
+// appendKey appends the key of the endpoint (source or destination) of the packet to key
+func (h *HostAggregates) appendKey(key []byte, pb *packetBuffer, address []byte, source bool) []byte {
+	for _, field := range h.fields {
+		switch field {
+		case "address":
+			prefix := h.prefix4
+			if len(address) == 16 {
+				prefix = h.prefix6
+			}
+			start := len(key)
+			key = append(key, address...)
+			masked := key[start:]
+			for i := range masked {
+				switch bits := prefix - i*8; {
+				case bits <= 0:
+					masked[i] = 0
+				case bits < 8:
+					masked[i] &= byte(0xff) << uint(8-bits)
+				}
+			}
+			key = append(key, byte(prefix))
+		case "mac":
+			if eth, ok := pb.link.(*layers.Ethernet); ok {
+				if source {
+					key = append(key, eth.SrcMAC...)
+				} else {
+					key = append(key, eth.DstMAC...)
+				}
+			} else {
+				key = append(key, 0, 0, 0, 0, 0, 0)
+			}
+		case "vlan":
+			vlan := uint16(0)
+			if len(pb.dot1q) > 0 {
+				vlan = pb.dot1q[0].VLANIdentifier
+			}
+			key = append(key, byte(vlan>>8), byte(vlan))
+		}
+	}
+	return key
+}
+
+// entry returns the entry of the endpoint with the slot for the given slot index, which is reset if it is outdated
+func (h *HostAggregates) entry(pb *packetBuffer, address []byte, source bool, index int64) (*hostEntry, *hostSlot) {
+	h.key = h.appendKey(h.key[:0], pb, address, source)
+	entry, ok := h.entries[string(h.key)]
+	if !ok {
+		entry = &hostEntry{slots: make([]hostSlot, h.slots)}
+		for i := range entry.slots {
+			entry.slots[i].index = -1
+		}
+		h.entries[string(h.key)] = entry
+	}
+	entry.lastSlot = index
+	slot := &entry.slots[index%int64(h.slots)]
+	if slot.index != index {
+		slot.index = index
+		slot.counters = hostCounters{}
+		for i := range slot.sketches {
+			slot.sketches[i].reset()
+		}
+		// the previous slots need to be merged again
+		entry.distinctFor = -1
+	}
+	return entry, slot
+}
+
+// add adds the value consisting of the given parts to the sketch
+func (h *HostAggregates) add(entry *hostEntry, slot *hostSlot, sketch int, parts ...[]byte) {
+	h.hash = h.hash[:0]
+	for _, part := range parts {
+		h.hash = append(h.hash, part...)
+	}
+	if slot.sketches[sketch].add(hashBytes(h.hash)) {
+		entry.dirty[sketch] = true
+	}
+}
+
+// stats computes the aggregates of the entry for the window ending with the slot index
+func (h *HostAggregates) stats(entry *hostEntry, index int64, stats *HostStats) {
+	var counters hostCounters
+	for i := range entry.slots {
+		slot := &entry.slots[i]
+		if slot.index > index-int64(h.slots) && slot.index <= index {
+			counters.packetsSent += slot.counters.packetsSent
+			counters.packetsReceived += slot.counters.packetsReceived
+			counters.octetsSent += slot.counters.octetsSent
+			counters.octetsReceived += slot.counters.octetsReceived
+		}
+	}
+	if entry.distinctFor != index {
+		for sketch := range entry.previous {
+			previous := &entry.previous[sketch]
+			previous.reset()
+			for i := range entry.slots {
+				slot := &entry.slots[i]
+				if slot.index > index-int64(h.slots) && slot.index < index {
+					previous.merge(&slot.sketches[sketch])
+				}
+			}
+			entry.dirty[sketch] = true
+		}
+		entry.distinctFor = index
+	}
+	// only the sketches of the current slot change, which are merged with the previous slots
+	current := &entry.slots[index%int64(h.slots)]
+	for sketch := range entry.distinct {
+		if entry.dirty[sketch] {
+			h.union[0], h.union[1] = &entry.previous[sketch], &current.sketches[sketch]
+			entry.distinct[sketch] = hllUnion(h.union[:], h.scratch)
+			entry.dirty[sketch] = false
+		}
+	}
+	*stats = HostStats{
+		PacketsSent:      counters.packetsSent,
+		PacketsReceived:  counters.packetsReceived,
+		OctetsSent:       counters.octetsSent,
+		OctetsReceived:   counters.octetsReceived,
+		DestinationPorts: entry.distinct[hllDestinationPorts],
+		Destinations:     entry.distinct[hllDestinations],
+		Sources:          entry.distinct[hllSources],
+		Connections:      entry.distinct[hllConnections],
+		ConnectionRate:   float64(entry.distinct[hllConnections]) / h.seconds,
+	}
+}
+
+// prune removes the entries without packets in the current window
+func (h *HostAggregates) prune(index int64) {
+	for key, entry := range h.entries {
+		if entry.lastSlot <= index-int64(h.slots) {
+			delete(h.entries, key)
+		}
+	}
+}
+
+// Event adds the packet to the aggregates of its source and destination and annotates it with the resulting aggregates
+func (h *HostAggregates) Event(buffer Buffer) {
+	pb := buffer.(*packetBuffer)
+	pb.hosts = false
+	if pb.network == nil {
+		return
+	}
+	index := int64(pb.Timestamp() / h.slotLength)
+	if index >= h.nextPrune {
+		h.prune(index)
+		h.nextPrune = index + int64(h.slots)
+	}
+
+	srcEndpoint, dstEndpoint := pb.network.NetworkFlow().Endpoints()
+	src, dst := srcEndpoint.Raw(), dstEndpoint.Raw()
+	length := uint64(pb.NetworkLayerLength())
+	proto := [1]byte{pb.proto}
+	var ports []byte
+	connection := true
+	switch transport := pb.transport.(type) {
+	case *layers.TCP:
+		ports = h.ports[:]
+		binary.BigEndian.PutUint16(ports[0:2], uint16(transport.SrcPort))
+		binary.BigEndian.PutUint16(ports[2:4], uint16(transport.DstPort))
+		connection = transport.SYN && !transport.ACK
+	case *layers.UDP:
+		ports = h.ports[:]
+		binary.BigEndian.PutUint16(ports[0:2], uint16(transport.SrcPort))
+		binary.BigEndian.PutUint16(ports[2:4], uint16(transport.DstPort))
+	}
+
+	source, sourceSlot := h.entry(pb, src, true, index)
+	sourceSlot.counters.packetsSent++
+	sourceSlot.counters.octetsSent += length
+	if ports != nil {
+		h.add(source, sourceSlot, hllDestinationPorts, proto[:], ports[2:4])
+	}
+	h.add(source, sourceSlot, hllDestinations, dst)
+	if connection {
+		h.add(source, sourceSlot, hllConnections, proto[:], src, dst, ports)
+	}
+
+	destination, destinationSlot := h.entry(pb, dst, false, index)
+	destinationSlot.counters.packetsReceived++
+	destinationSlot.counters.octetsReceived += length
+	h.add(destination, destinationSlot, hllSources, src)
+
+	// source and destination can be the same entry, so the stats are computed after both updates
+	h.stats(source, index, &pb.srcHost)
+	h.stats(destination, index, &pb.dstHost)
+	pb.hosts = true
+}
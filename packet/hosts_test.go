@@ -0,0 +1,143 @@
+package packet
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+
+	"github.com/chtisgit/go-flows/flows"
+	"github.com/google/gopacket/layers"
+)
+
+func TestHLL(t *testing.T) {
+	scratch := make([]uint8, hllRegisters)
+	var a, b hll
+	data := make([]byte, 8)
+	for i := 0; i < 50; i++ {
+		binary.BigEndian.PutUint64(data, uint64(i))
+		a.add(hashBytes(data))
+		a.add(hashBytes(data))
+		binary.BigEndian.PutUint64(data, uint64(i+25))
+		b.add(hashBytes(data))
+	}
+	if n := hllUnion([]*hll{&a, &b}, scratch); n != 75 {
+		t.Errorf("expected exact count 75 for small sets, but got %d", n)
+	}
+	for _, n := range []int{100, 1000, 100000} {
+		var h hll
+		for i := 0; i < n; i++ {
+			binary.BigEndian.PutUint64(data, uint64(i))
+			h.add(hashBytes(data))
+		}
+		estimate := hllUnion([]*hll{&h}, scratch)
+		if e := math.Abs(float64(estimate)-float64(n)) / float64(n); e > 0.1 {
+			t.Errorf("expected estimate close to %d, but got %d", n, estimate)
+		}
+	}
+	var merged hll
+	merged.merge(&a)
+	merged.merge(&b)
+	// more than hllSparseMax values switch to registers
+	if n := hllUnion([]*hll{&merged}, scratch); math.Abs(float64(n)-75)/75 > 0.1 {
+		t.Errorf("expected about 75 values after merging, but got %d", n)
+	}
+	if allocs := testing.AllocsPerRun(10, func() { hllUnion([]*hll{&a, &b}, scratch) }); allocs != 0 {
+		t.Errorf("expected no allocations for the union of small sets, but got %v", allocs)
+	}
+	a.reset()
+	if n := hllUnion([]*hll{&a}, scratch); n != 0 {
+		t.Errorf("expected empty sketch after reset, but got %d", n)
+	}
+}
+
+func TestHostAggregates(t *testing.T) {
+	s := flows.SecondsInNanoseconds
+	hosts, err := NewHostAggregates(HostAggregatesConfig{Window: 10 * s, Slots: 2, Prefix4: 24})
+	if err != nil {
+		t.Fatal(err)
+	}
+	event := func(when flows.DateTimeNanoseconds, src, dst string, port layers.TCPPort, syn bool) (*HostStats, *HostStats) {
+		ip := ipLayer(src, dst).(*layers.IPv4)
+		ip.Protocol = layers.IPProtocolTCP
+		ip.Length = 40
+		buffer := BufferFromLayers(when, ip, &layers.TCP{SrcPort: 1000, DstPort: port, SYN: syn})
+		hosts.Event(buffer)
+		return buffer.HostStats()
+	}
+
+	for i := 0; i < 20; i++ {
+		event(s, "10.0.0.1", "10.0.1.1", layers.TCPPort(i), true)
+	}
+	// the reply doesn't count as connection and 10.0.0.2 shares the /24 with 10.0.0.1
+	src, dst := event(2*s, "10.0.1.1", "10.0.0.2", 1000, false)
+	if expected := (HostStats{PacketsSent: 1, PacketsReceived: 20, OctetsSent: 40, OctetsReceived: 800, DestinationPorts: 1, Destinations: 1, Sources: 1}); *src != expected {
+		t.Errorf("expected source %+v, but got %+v", expected, *src)
+	}
+	if expected := (HostStats{PacketsSent: 20, PacketsReceived: 1, OctetsSent: 800, OctetsReceived: 40, DestinationPorts: 20, Destinations: 1, Sources: 1, Connections: 20, ConnectionRate: 2}); *dst != expected {
+		t.Errorf("expected destination %+v, but got %+v", expected, *dst)
+	}
+
+	// the second slot still contains the first packets
+	src, _ = event(9*s, "10.0.0.1", "10.0.2.1", 80, true)
+	if src.PacketsSent != 21 || src.DestinationPorts != 21 || src.Destinations != 2 || src.Connections != 21 {
+		t.Errorf("expected the packets of both slots, but got %+v", *src)
+	}
+	// the first slot left the window
+	src, _ = event(10*s, "10.0.0.1", "10.0.2.1", 80, true)
+	if src.PacketsSent != 2 || src.DestinationPorts != 1 || src.Destinations != 1 || src.Connections != 1 {
+		t.Errorf("expected the packets of the last slot, but got %+v", *src)
+	}
+	// both addresses are in the same /24
+	event(30*s, "10.0.3.1", "10.0.3.2", 80, true)
+	if len(hosts.entries) != 1 {
+		t.Errorf("expected the inactive hosts to be removed, but got %d hosts", len(hosts.entries))
+	}
+
+	buffer := BufferFromLayers(0, ipLayer("10.0.0.1", "10.0.0.2"))
+	if src, dst := buffer.HostStats(); src != nil || dst != nil {
+		t.Error("expected no aggregates without HostAggregates")
+	}
+	if _, err := NewHostAggregates(HostAggregatesConfig{Window: s, Key: []string{"port"}}); err == nil {
+		t.Error("expected an error for an unknown key field")
+	}
+}
+
+func TestHostConnections(t *testing.T) {
+	hosts, err := NewHostAggregates(HostAggregatesConfig{Window: 10 * flows.SecondsInNanoseconds})
+	if err != nil {
+		t.Fatal(err)
+	}
+	event := func(layerList ...SerializableLayerType) *HostStats {
+		buffer := BufferFromLayers(0, layerList...)
+		hosts.Event(buffer)
+		src, _ := buffer.HostStats()
+		return src
+	}
+	tcp := func(port layers.TCPPort, syn, ack bool) *HostStats {
+		ip := ipLayer("10.0.0.1", "10.0.0.2").(*layers.IPv4)
+		ip.Protocol = layers.IPProtocolTCP
+		return event(ip, &layers.TCP{SrcPort: 1000, DstPort: port, SYN: syn, ACK: ack})
+	}
+	udp := func(port layers.UDPPort) *HostStats {
+		return event(ipLayer("10.0.0.1", "10.0.0.3"), &layers.UDP{SrcPort: 1000, DstPort: port})
+	}
+
+	// only TCP packets with SYN and without ACK count
+	for _, expected := range []struct {
+		stats       *HostStats
+		connections uint64
+	}{
+		{tcp(80, true, false), 1},
+		{tcp(80, false, true), 1},
+		{tcp(443, true, true), 1},
+		{tcp(443, true, false), 2},
+		// every UDP packet counts, the same ports are the same connection
+		{udp(53), 3},
+		{udp(53), 3},
+		{udp(123), 4},
+	} {
+		if expected.stats.Connections != expected.connections {
+			t.Errorf("expected %d connections, but got %d", expected.connections, expected.stats.Connections)
+		}
+	}
+}
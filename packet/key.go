@@ -3,7 +3,6 @@ package packet
 import (
 	"bytes"
 	"fmt"
-	"strings"
 )
 
 type keyBuilder struct {
@@ -15,8 +14,19 @@ func (k keyBuilder) make() KeyFunc {
 	return k.spec.make(k.name)
 }
 
-// MakeDynamicKeySelector creates a selector function from a dynamic key definition
-func MakeDynamicKeySelector(key []string, bidirectional, allowZero bool) (ret DynamicKeySelector) {
+// MakeDynamicKeySelector creates a selector function from a dynamic key definition. Panics if the key definition is
+// invalid (see NewDynamicKeySelector).
+func MakeDynamicKeySelector(key []string, bidirectional, allowZero bool) DynamicKeySelector {
+	ret, err := NewDynamicKeySelector(key, bidirectional, allowZero)
+	if err != nil {
+		panic(err.Error())
+	}
+	return ret
+}
+
+// NewDynamicKeySelector creates a selector function from a dynamic key definition. Returns an error for unknown or
+// duplicate keys.
+func NewDynamicKeySelector(key []string, bidirectional, allowZero bool) (ret DynamicKeySelector, err error) {
 	ret.noZero = !allowZero
 	if len(key) == 0 {
 		ret.empty = true
@@ -49,7 +59,7 @@ MAIN:
 		spec, ok := stringMatcher[key[i]]
 		if ok {
 			if used[spec.id] {
-				panic(fmt.Sprintf("Key '%s' used twice", key[i]))
+				return ret, fmt.Errorf("Key '%s' used twice", key[i])
 			}
 			used[spec.id] = true
 			keys[i].spec = spec
@@ -63,7 +73,7 @@ MAIN:
 		for _, spec := range regexpMatcher {
 			if spec.match.MatchString(key[i]) {
 				if used[spec.id] {
-					panic(fmt.Sprintf("Key '%s' used twice", key[i]))
+					return ret, fmt.Errorf("Key '%s' used twice", key[i])
 				}
 				used[spec.id] = true
 				keys[i].spec = spec
@@ -75,7 +85,7 @@ MAIN:
 				continue MAIN
 			}
 		}
-		panic(fmt.Sprintf("Unknown key_feature '%s'", key[i]))
+		return ret, fmt.Errorf("Unknown key_feature '%s'", key[i])
 	}
 
 	done := make(map[int]bool, len(key))
@@ -108,6 +118,7 @@ MAIN:
 	}
 
 	ret.bidirectional = bidirectional
+	ret.scratch = new(keyScratch)
 
 	return
 }
@@ -121,6 +132,21 @@ type DynamicKeySelector struct {
 	bidirectional bool
 	fivetuple     bool
 	empty         bool
+	scratch       *keyScratch
+}
+
+// keyScratch holds the temporary buffers needed for computing a key
+type keyScratch struct {
+	source      [1024]byte
+	destination [1024]byte
+	uni         [2048]byte
+	key         [4096]byte
+}
+
+// clone returns a copy of the selector with its own scratch buffers. Use this for calling Key concurrently.
+func (selector DynamicKeySelector) clone() DynamicKeySelector {
+	selector.scratch = new(keyScratch)
+	return selector
 }
 
 func sourceIPAddressKey(packet Buffer, scratch, scratchNoSort []byte) (int, int) {
@@ -192,17 +218,21 @@ func init() {
 	fivetupleMust = []int{srcIP, dstIP, proto, srcPort, dstPort}
 }
 
-var scratchSourceKey [1024]byte
-var scratchDestinationKey [1024]byte
-var scratchUniKey [2048]byte
-var emptyKey string
+var emptyKey []byte
 
 // Key computes a key according to the given selector. Returns key, isForward, ok
-// This function _must not_ be called concurrently.
-func (selector *DynamicKeySelector) Key(packet Buffer) (string, bool, bool) {
+// The returned key is only valid until the next call to Key, since the storage is reused.
+// This function _must not_ be called concurrently on the same selector (see clone).
+func (selector *DynamicKeySelector) Key(packet Buffer) ([]byte, bool, bool) {
 	if selector.empty {
 		return emptyKey, true, true
 	}
+	if selector.scratch == nil {
+		selector.scratch = new(keyScratch)
+	}
+	scratchSourceKey := selector.scratch.source[:]
+	scratchDestinationKey := selector.scratch.destination[:]
+	scratchUniKey := selector.scratch.uni[:]
 
 	if !selector.bidirectional {
 		i := 0
@@ -213,7 +243,7 @@ func (selector *DynamicKeySelector) Key(packet Buffer) (string, bool, bool) {
 			}
 			i += a + b
 		}
-		return string(scratchUniKey[:i]), true, true
+		return scratchUniKey[:i], true, true
 	}
 
 	forward := true
@@ -247,20 +277,19 @@ func (selector *DynamicKeySelector) Key(packet Buffer) (string, bool, bool) {
 		uni += a + b
 	}
 
-	builder := strings.Builder{}
-	builder.Grow(source + destination + uni)
+	key := selector.scratch.key[:0]
 
 	s := scratchSourceKey[:source]
 	d := scratchDestinationKey[:destination]
 	if bytes.Compare(s, d) > 0 {
 		forward = false
-		builder.Write(d)
-		builder.Write(s)
+		key = append(key, d...)
+		key = append(key, s...)
 	} else {
-		builder.Write(s)
-		builder.Write(d)
+		key = append(key, s...)
+		key = append(key, d...)
 	}
-	builder.Write(scratchUniKey[:uni])
+	key = append(key, scratchUniKey[:uni]...)
 
-	return builder.String(), forward, true
+	return key, forward, true
 }
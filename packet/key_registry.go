@@ -6,6 +6,8 @@ import (
 	"regexp"
 	"sort"
 	"strings"
+
+	"github.com/chtisgit/go-flows/util"
 )
 
 // KeyFunc is a function that writes the flow key for a single property
@@ -54,6 +56,7 @@ type baseKey struct {
 	t           KeyType
 	layer       KeyLayer
 	description string
+	provider    string
 	id          int
 	pair        int
 }
@@ -107,46 +110,69 @@ var keyPairID = 1
 
 // RegisterKeyPair registers the given key ids as a source/destination pair
 func RegisterKeyPair(a, b int) {
-	if a < 0 || a > len(keyRegistry) {
-		panic(fmt.Sprintf("Key with id %d not registered", a))
-	}
-	if b < 0 || b > len(keyRegistry) {
-		panic(fmt.Sprintf("Key with id %d not registered", b))
-	}
-	first := keyRegistry[a]
-	second := keyRegistry[b]
-	if first.getLayer() != second.getLayer() {
-		panic(fmt.Sprintf("Key layers of %d and %d don't match!", a, b))
-	}
-	if !((first.getType() == KeyTypeSource && second.getType() == KeyTypeDestination) ||
-		(second.getType() == KeyTypeSource && first.getType() == KeyTypeDestination)) {
-		panic(fmt.Sprintf("One of %d and %d must be source and one destination", a, b))
-	}
-	first.setPair(keyPairID)
-	second.setPair(keyPairID)
-	keyPairID++
+	util.Register(func() {
+		if a < 0 || a >= len(keyRegistry) || keyRegistry[a] == nil {
+			panic(fmt.Sprintf("Key with id %d not registered", a))
+		}
+		if b < 0 || b >= len(keyRegistry) || keyRegistry[b] == nil {
+			panic(fmt.Sprintf("Key with id %d not registered", b))
+		}
+		first := keyRegistry[a]
+		second := keyRegistry[b]
+		if first.getLayer() != second.getLayer() {
+			panic(fmt.Sprintf("Key layers of %d and %d don't match!", a, b))
+		}
+		if !((first.getType() == KeyTypeSource && second.getType() == KeyTypeDestination) ||
+			(second.getType() == KeyTypeSource && first.getType() == KeyTypeDestination)) {
+			panic(fmt.Sprintf("One of %d and %d must be source and one destination", a, b))
+		}
+		first.setPair(keyPairID)
+		second.setPair(keyPairID)
+		keyPairID++
+	})
 }
 
-// RegisterRegexpKey registers a regex key function
-func RegisterRegexpKey(name, description string, t KeyType, layer KeyLayer, make MakeKeyFunc) int {
-	if keyNames[name] {
-		panic(fmt.Sprintf("Key with name '%s' registered twice", name))
+// registerKey reserves an id for a key with the given names and adds the key created by make. Adding the key is
+// postponed for plugins (see util.Register); keys of incompatible plugins leave an empty slot in the registry.
+func registerKey(names []string, make func(id int) keySpecification) int {
+	checkNames := func() {
+		for _, name := range names {
+			if keyNames[name] {
+				panic(fmt.Sprintf("Key with name '%s' registered twice", name))
+			}
+		}
 	}
-	keyNames[name] = true
+	checkNames()
 	id := len(keyRegistry)
-	keyRegistry = append(keyRegistry, &regexpKey{
-		baseKey: baseKey{
-			keyfunc:     make,
-			t:           t,
-			description: description,
-			layer:       layer,
-			id:          id,
-		},
-		match: regexp.MustCompile(name),
+	keyRegistry = append(keyRegistry, nil)
+	key := make(id)
+	util.Register(func() {
+		checkNames()
+		for _, name := range names {
+			keyNames[name] = true
+		}
+		keyRegistry[id] = key
 	})
 	return id
 }
 
+// RegisterRegexpKey registers a regex key function
+func RegisterRegexpKey(name, description string, t KeyType, layer KeyLayer, make MakeKeyFunc) int {
+	return registerKey([]string{name}, func(id int) keySpecification {
+		return &regexpKey{
+			baseKey: baseKey{
+				keyfunc:     make,
+				t:           t,
+				description: description,
+				provider:    util.Provider(),
+				layer:       layer,
+				id:          id,
+			},
+			match: regexp.MustCompile(name),
+		}
+	})
+}
+
 // RegisterStringKey registers a regex key function
 func RegisterStringKey(name string, description string, t KeyType, layer KeyLayer, make MakeKeyFunc) int {
 	return RegisterStringsKey([]string{name}, description, t, layer, make)
@@ -154,24 +180,19 @@ func RegisterStringKey(name string, description string, t KeyType, layer KeyLaye
 
 // RegisterStringsKey registers a regex key function
 func RegisterStringsKey(name []string, description string, t KeyType, layer KeyLayer, make MakeKeyFunc) int {
-	for _, name := range name {
-		if keyNames[name] {
-			panic(fmt.Sprintf("Key with name '%s' registered twice", name))
+	return registerKey(name, func(id int) keySpecification {
+		return &stringKey{
+			baseKey: baseKey{
+				keyfunc:     make,
+				t:           t,
+				description: description,
+				provider:    util.Provider(),
+				layer:       layer,
+				id:          id,
+			},
+			match: name,
 		}
-		keyNames[name] = true
-	}
-	id := len(keyRegistry)
-	keyRegistry = append(keyRegistry, &stringKey{
-		baseKey: baseKey{
-			keyfunc:     make,
-			t:           t,
-			description: description,
-			layer:       layer,
-			id:          id,
-		},
-		match: name,
 	})
-	return id
 }
 
 // ListKeys writes a list of keys to w
@@ -185,9 +206,9 @@ func ListKeys(w io.Writer) {
 	for _, key := range keyRegistry {
 		switch k := key.(type) {
 		case *regexpKey:
-			list = append(list, desc{k.match.String(), k.description})
+			list = append(list, desc{k.match.String(), util.DescribeProvider(k.description, k.provider)})
 		case *stringKey:
-			list = append(list, desc{strings.Join(k.match, "|"), k.description})
+			list = append(list, desc{strings.Join(k.match, "|"), util.DescribeProvider(k.description, k.provider)})
 		}
 	}
 
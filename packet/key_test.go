@@ -17,6 +17,7 @@ func BenchmarkDynamicFiveTuple4(b *testing.B) {
 			"protocolIdentifier",
 			"sourceTransportPort",
 			"destinationTransportPort"}, true, false)
+	b.ReportAllocs()
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		key.Key(buffer4)
@@ -34,6 +35,7 @@ func BenchmarkDynamicFiveTuple6(b *testing.B) {
 			"protocolIdentifier",
 			"sourceTransportPort",
 			"destinationTransportPort"}, true, false)
+	b.ReportAllocs()
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		key.Key(buffer6)
@@ -1,14 +1,17 @@
 package packet
 
 import (
+	"encoding/gob"
 	"io"
+	"strings"
 
 	"github.com/chtisgit/go-flows/util"
 )
 
 const labelName = "label"
 
-// Label represents a generic packet label
+// Label represents a generic packet label. Label values of types other than the builtin ones must be registered with
+// gob.Register to support checkpoints (e.g. flowLabel keeps labels as flow state).
 type Label interface {
 	util.Module
 	// GetLabel returns the label for the provided packet
@@ -32,6 +35,75 @@ RETRY:
 	goto RETRY
 }
 
+// NamedLabel is the label of a packet, if named labels are used (see NamedLabels). It holds the labels of the label
+// sources by name. Label sources without a label for the packet are missing.
+type NamedLabel map[string]interface{}
+
+func init() {
+	// label types of the builtin label sources, which can end up in checkpoints
+	gob.Register([]string{})
+	gob.Register(NamedLabel{})
+}
+
+// NamedLabels evaluates every named label source for every packet and combines the labels into a NamedLabel. Label
+// sources with the same name are tried one after another like Labels. Label sources without a name use the name "".
+type NamedLabels struct {
+	names  []string
+	labels []Labels
+}
+
+// Add adds the label source with the given name
+func (n *NamedLabels) Add(name string, label Label) {
+	for i := range n.names {
+		if n.names[i] == name {
+			n.labels[i] = append(n.labels[i], label)
+			return
+		}
+	}
+	n.names = append(n.names, name)
+	n.labels = append(n.labels, Labels{label})
+}
+
+// ID returns the ids of the label sources with their names
+func (n *NamedLabels) ID() string {
+	var ids []string
+	for i, labels := range n.labels {
+		for _, label := range labels {
+			ids = append(ids, n.names[i]+"="+label.ID())
+		}
+	}
+	return "named|" + strings.Join(ids, ";")
+}
+
+// Init initializes the label sources
+func (n *NamedLabels) Init() {
+	for _, labels := range n.labels {
+		for _, label := range labels {
+			label.Init()
+		}
+	}
+}
+
+// GetLabel returns the labels of all the names as NamedLabel, or nil if there is no label for the packet. It never
+// returns io.EOF, since every name is exhausted independently.
+func (n *NamedLabels) GetLabel(packet Buffer) (interface{}, error) {
+	var ret NamedLabel
+	for i := range n.labels {
+		label := n.labels[i].GetLabel(packet)
+		if label == nil {
+			continue
+		}
+		if ret == nil {
+			ret = make(NamedLabel, len(n.names))
+		}
+		ret[n.names[i]] = label
+	}
+	if ret == nil {
+		return nil, nil
+	}
+	return ret, nil
+}
+
 // RegisterLabel registers an label (see module system in util)
 func RegisterLabel(name, desc string, new util.ModuleCreator, help util.ModuleHelp) {
 	util.RegisterModule(labelName, name, desc, new, help)
@@ -0,0 +1,47 @@
+package packet
+
+import (
+	"io"
+	"reflect"
+	"testing"
+)
+
+// listLabel returns the given labels one after another and io.EOF afterwards
+type listLabel struct {
+	labels []interface{}
+}
+
+func (l *listLabel) ID() string { return "list" }
+func (l *listLabel) Init()      {}
+
+func (l *listLabel) GetLabel(packet Buffer) (interface{}, error) {
+	if len(l.labels) == 0 {
+		return nil, io.EOF
+	}
+	ret := l.labels[0]
+	l.labels = l.labels[1:]
+	return ret, nil
+}
+
+func TestNamedLabels(t *testing.T) {
+	var named NamedLabels
+	named.Add("attack", &listLabel{[]interface{}{"dos", nil}})
+	named.Add("device", &listLabel{[]interface{}{"camera", nil, "printer"}})
+	named.Add("attack", &listLabel{[]interface{}{"scan"}})
+	named.Add("", &listLabel{[]interface{}{1}})
+
+	for i, expected := range []interface{}{
+		NamedLabel{"attack": "dos", "device": "camera", "": 1},
+		nil,
+		NamedLabel{"attack": "scan", "device": "printer"},
+		nil,
+	} {
+		label, err := named.GetLabel(nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if !reflect.DeepEqual(label, expected) {
+			t.Errorf("packet %d: expected label %v, but got %v", i, expected, label)
+		}
+	}
+}
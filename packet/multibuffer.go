@@ -110,12 +110,13 @@ func (mpb *multiPacketBuffer) Pop(buffer *shallowMultiPacketBuffer, low func(int
 }
 
 type shallowMultiPacketBuffer struct {
-	buffers   []*packetBuffer
-	owner     *shallowMultiPacketBufferRing
-	rindex    int
-	windex    int
-	timestamp flows.DateTimeNanoseconds
-	expire    bool
+	buffers    []*packetBuffer
+	owner      *shallowMultiPacketBufferRing
+	rindex     int
+	windex     int
+	timestamp  flows.DateTimeNanoseconds
+	expire     bool
+	checkpoint *checkpointRequest
 }
 
 func newShallowMultiPacketBuffer(size int, owner *shallowMultiPacketBufferRing) *shallowMultiPacketBuffer {
@@ -136,6 +137,12 @@ func (smpb *shallowMultiPacketBuffer) full() bool {
 func (smpb *shallowMultiPacketBuffer) reset() {
 	smpb.rindex = 0
 	smpb.windex = 0
+	smpb.checkpoint = nil
+}
+
+// rewind resets the read position to the beginning, so the batch can be read again
+func (smpb *shallowMultiPacketBuffer) rewind() {
+	smpb.rindex = 0
 }
 
 func (smpb *shallowMultiPacketBuffer) push(buffer *packetBuffer) bool {
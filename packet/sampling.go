@@ -0,0 +1,180 @@
+package packet
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+
+	"github.com/CN-TU/go-ipfix"
+)
+
+// SamplingMode specifies how packets are selected for processing
+type SamplingMode int
+
+const (
+	// SampleNone processes every packet
+	SampleNone SamplingMode = iota
+	// SampleCount processes every Nth packet (systematic count-based sampling)
+	SampleCount
+	// SampleRandom processes every packet with a probability of 1/N (uniform probabilistic sampling)
+	SampleRandom
+	// SampleFlow processes every packet of 1/N of the flows. Flows are selected with a hash of the flow key, which
+	// means that either all or no packets of a flow are processed.
+	SampleFlow
+)
+
+var samplingModes = map[string]SamplingMode{
+	"none":   SampleNone,
+	"count":  SampleCount,
+	"random": SampleRandom,
+	"flow":   SampleFlow,
+}
+
+// Sampling holds the sampling parameters
+type Sampling struct {
+	// Mode is the sampling method
+	Mode SamplingMode
+	// Rate is N in 1-in-N sampling
+	Rate uint64
+	// Seed is the seed for random sampling and the initializer for the flow hash
+	Seed uint64
+}
+
+// ParseSampling parses a sampling specification of the form mode:N[:seed] (e.g. count:100 for processing every 100th packet)
+func ParseSampling(spec string) (ret Sampling, err error) {
+	if spec == "" || spec == "none" {
+		return Sampling{Mode: SampleNone, Rate: 1}, nil
+	}
+	parts := strings.Split(spec, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return ret, fmt.Errorf("sampling must be of the form mode:N[:seed], but got '%s'", spec)
+	}
+	var ok bool
+	if ret.Mode, ok = samplingModes[parts[0]]; !ok || ret.Mode == SampleNone {
+		return ret, fmt.Errorf("unknown sampling mode '%s' (must be count, random, or flow)", parts[0])
+	}
+	if ret.Rate, err = strconv.ParseUint(parts[1], 10, 64); err != nil || ret.Rate == 0 {
+		return ret, fmt.Errorf("sampling rate must be a positive integer, but got '%s'", parts[1])
+	}
+	if len(parts) == 3 {
+		if ret.Seed, err = strconv.ParseUint(parts[2], 10, 64); err != nil {
+			return ret, fmt.Errorf("sampling seed must be an integer, but got '%s'", parts[2])
+		}
+	}
+	return ret, nil
+}
+
+// Scale returns the factor counters must be multiplied with to estimate the unsampled value
+func (s Sampling) Scale() uint64 {
+	if s.Mode == SampleNone || s.Rate == 0 {
+		return 1
+	}
+	return s.Rate
+}
+
+// Metadata returns information elements and values describing the sampling process (RFC5477) for exporters.
+// Returns nil if no sampling is used.
+func (s Sampling) Metadata() (ies []ipfix.InformationElement, values []interface{}) {
+	add := func(name string, value interface{}) {
+		ie, err := ipfix.GetInformationElement(name)
+		if err != nil {
+			panic(err)
+		}
+		ies = append(ies, ie)
+		values = append(values, value)
+	}
+	switch s.Mode {
+	case SampleNone:
+		return nil, nil
+	case SampleCount:
+		add("selectorAlgorithm", uint16(1)) // Systematic count-based Sampling
+		add("samplingPacketInterval", uint32(1))
+		add("samplingPacketSpace", uint32(s.Rate-1))
+	case SampleRandom:
+		add("selectorAlgorithm", uint16(4)) // Uniform probabilistic Sampling
+	case SampleFlow:
+		add("hashInitialiserValue", s.Seed)
+		add("hashOutputRangeMin", uint64(0))
+		add("hashOutputRangeMax", s.Rate-1)
+		add("hashSelectedRangeMin", uint64(0))
+		add("hashSelectedRangeMax", uint64(0))
+	}
+	add("samplingProbability", 1/float64(s.Rate))
+	return
+}
+
+// sampler decides which packets get processed. Must only be used from a single go routine.
+type sampler struct {
+	Sampling
+	count uint64
+	drawn uint64
+	rand  *rand.Rand
+}
+
+// samplerState is the position of a sampler in the packet stream, which is part of checkpoints. Fields are exported
+// for gob.
+type samplerState struct {
+	Count uint64
+	Drawn uint64
+}
+
+func newSampler(s Sampling) *sampler {
+	return &sampler{
+		Sampling: s,
+		rand:     rand.New(rand.NewSource(int64(s.Seed))),
+	}
+}
+
+// packet returns true, if the current packet must be processed. This does not handle flow sampling.
+func (s *sampler) packet() bool {
+	switch s.Mode {
+	case SampleCount:
+		s.count++
+		if s.count == s.Rate {
+			s.count = 0
+			return true
+		}
+		return false
+	case SampleRandom:
+		s.drawn++
+		return s.rand.Uint64()%s.Rate == 0
+	}
+	return true
+}
+
+func (s *sampler) state() samplerState {
+	return samplerState{Count: s.count, Drawn: s.drawn}
+}
+
+// restore continues sampling at the given position. The random generator can't be serialized and is advanced to
+// the position instead.
+func (s *sampler) restore(state samplerState) {
+	s.count = state.Count
+	if s.Mode == SampleCount && s.count >= s.Rate {
+		s.count = 0
+	}
+	s.rand.Seed(int64(s.Seed))
+	s.drawn = 0
+	if s.Mode == SampleRandom {
+		for s.drawn < state.Drawn {
+			s.rand.Uint64()
+			s.drawn++
+		}
+	}
+}
+
+// flow returns true, if the packet with the given key hash must be processed. This only handles flow sampling.
+func (s *sampler) flow(hash uint64) bool {
+	if s.Mode != SampleFlow {
+		return true
+	}
+	// the flow tables already use the hash for partitioning and lookup -> mix it again
+	h := hash ^ s.Seed
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h%s.Rate == 0
+}
@@ -0,0 +1,60 @@
+package packet
+
+import (
+	"testing"
+
+	"github.com/chtisgit/go-flows/flows"
+)
+
+func TestParseSampling(t *testing.T) {
+	good := map[string]Sampling{
+		"":             {Mode: SampleNone, Rate: 1},
+		"count:10":     {Mode: SampleCount, Rate: 10},
+		"random:5:42":  {Mode: SampleRandom, Rate: 5, Seed: 42},
+		"flow:100:123": {Mode: SampleFlow, Rate: 100, Seed: 123},
+	}
+	for spec, expected := range good {
+		s, err := ParseSampling(spec)
+		if err != nil {
+			t.Errorf("ParseSampling(%q) failed: %s", spec, err)
+		} else if s != expected {
+			t.Errorf("ParseSampling(%q) = %+v, expected %+v", spec, s, expected)
+		}
+	}
+	for _, spec := range []string{"count", "count:0", "count:x", "none:5", "foo:5", "flow:5:1:2"} {
+		if _, err := ParseSampling(spec); err == nil {
+			t.Errorf("ParseSampling(%q) should have failed", spec)
+		}
+	}
+}
+
+func TestCountSampling(t *testing.T) {
+	s := newSampler(Sampling{Mode: SampleCount, Rate: 4})
+	selected := 0
+	for i := 0; i < 100; i++ {
+		if s.packet() {
+			selected++
+		}
+	}
+	if selected != 25 {
+		t.Errorf("Expected 25 selected packets, but got %d", selected)
+	}
+}
+
+func TestFlowSampling(t *testing.T) {
+	s := newSampler(Sampling{Mode: SampleFlow, Rate: 8, Seed: 1})
+	selected := 0
+	for i := 0; i < 8000; i++ {
+		hash := flows.HashKey([]byte{byte(i >> 8), byte(i)})
+		first := s.flow(hash)
+		if s.flow(hash) != first {
+			t.Fatal("Flow sampling must select every packet of a flow")
+		}
+		if first {
+			selected++
+		}
+	}
+	if selected < 800 || selected > 1200 {
+		t.Errorf("Expected about 1000 selected flows, but got %d", selected)
+	}
+}
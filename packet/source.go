@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"sync"
+	"sync/atomic"
 
 	"github.com/chtisgit/go-flows/flows"
 	"github.com/google/gopacket"
@@ -35,42 +37,84 @@ const (
 
 // see multibuffer.go for an explanation of rings, buffers, batches
 
-// Stats holds number of packets, skipped packets, and filtered packets
+// Stats holds number of packets, skipped packets, filtered packets, and packets removed by sampling
 type Stats struct {
-	packets          uint64
-	skipped          uint64
-	filtered         uint64
-	maxBuffers       int
-	buffersAllocated int
-	buffersReleased  int
+	// Packets is the number of packets read from the sources
+	Packets uint64
+	// Skipped is the number of packets skipped by the sources
+	Skipped uint64
+	// Filtered is the number of packets rejected by filters
+	Filtered uint64
+	// Sampled is the number of packets removed by packet sampling
+	Sampled uint64
+	// MaxBuffers is the peak number of packet buffers in use
+	MaxBuffers int
+	// BuffersAllocated is the number of allocated packet buffers
+	BuffersAllocated int
+	// BuffersReleased is the number of freed packet buffers
+	BuffersReleased int
 }
 
 // Engine holds and manages buffers, sources, filters and forwards packets to the flowtable
 type Engine struct {
+	// progress is accessed atomically and must stay at the beginning for alignment
+	progress    progress
 	empty       *multiPacketBuffer
 	todecode    *shallowMultiPacketBufferRing
 	current     *shallowMultiPacketBuffer
+	forward     *shallowMultiPacketBuffer
+	discard     *shallowMultiPacketBuffer
 	packetStats Stats
 	full        int
 	plen        int
+	decoders    int
 	flowtable   EventTable
 	done        chan struct{}
 	sources     Sources
 	filters     Filters
 	labels      Labels
+	checkpoint  checkpointSchedule
+	sampler     *sampler
+	dns         *PassiveDNS
+	hosts       *HostAggregates
+	capture     *Capture
+	firstPacket func(flows.DateTimeNanoseconds)
+	err         error
+}
+
+// progress holds the number of packets and the packet time of the last batch handed to the decoders
+type progress struct {
+	packets uint64
+	time    int64
+}
+
+// checkpointSchedule holds the settings for periodic checkpoints
+type checkpointSchedule struct {
+	name     string
+	interval flows.DateTimeNanoseconds
+	next     flows.DateTimeNanoseconds
+	pending  sync.WaitGroup
+	requests []*checkpointRequest
 }
 
 // NewEngine initializes a new packet handling engine.
 // Packets of plen size are handled (0 means automatic). Packets are read from sources, filtered with filter, and forwarded to flowtable. Labels are assigned to the packets from the labels provider.
-func NewEngine(plen int, flowtable EventTable, filters Filters, sources Sources, labels Labels) *Engine {
+// Decoding and key computation is carried out by decoders parallel workers. Labeling and forwarding to the flowtable happens in packet order.
+func NewEngine(plen int, decoders int, flowtable EventTable, filters Filters, sources Sources, labels Labels) *Engine {
 	prealloc := plen
 	if plen == 0 {
 		prealloc = 1500
 	}
+	if decoders < 1 {
+		decoders = 1
+	}
 	ret := &Engine{
 		empty:     newMultiPacketBuffer(batchSize, prealloc, plen == 0),
-		todecode:  newShallowMultiPacketBufferRing(fullBuffers, batchSize),
+		todecode:  newShallowMultiPacketBufferRing(fullBuffers+decoders-1, batchSize),
+		forward:   newShallowMultiPacketBuffer(batchSize, nil),
+		discard:   newShallowMultiPacketBuffer(batchSize, nil),
 		plen:      plen,
+		decoders:  decoders,
 		flowtable: flowtable,
 		done:      make(chan struct{}),
 		sources:   sources,
@@ -78,49 +122,138 @@ func NewEngine(plen int, flowtable EventTable, filters Filters, sources Sources,
 		labels:    labels,
 	}
 
+	if decoders == 1 {
+		go ret.decode()
+	} else {
+		go ret.decodeParallel()
+	}
+
+	ret.current, _ = ret.todecode.popEmpty()
+
+	return ret
+}
+
+// decodeBatch decodes every packet in the batch and calculates the flow keys. This can be run concurrently for
+// different batches, as long as every worker uses its own selector. The result is stored in the packets and
+// needs to be handled with dispatchBatch in packet order.
+func decodeBatch(multibuffer *shallowMultiPacketBuffer, selector *DynamicKeySelector) {
+	for {
+		buffer := multibuffer.read()
+		if buffer == nil {
+			break
+		}
+		if !buffer.decode() {
+			buffer.state = bufferDecodeError
+			continue
+		}
+		key, fw, ok := selector.Key(buffer)
+		if ok {
+			buffer.SetInfo(key, fw)
+			buffer.state = bufferOK
+		} else {
+			buffer.state = bufferKeyError
+		}
+	}
+	multibuffer.rewind()
+}
+
+// dispatchBatch labels the packets of a decoded batch and forwards them to the flowtable. Must be called in packet order.
+func (input *Engine) dispatchBatch(multibuffer *shallowMultiPacketBuffer) {
+	stats := input.flowtable.getDecodeStats()
+	input.forward.setTimestamp(multibuffer.Timestamp())
+	for {
+		buffer := multibuffer.read()
+		if buffer == nil {
+			break
+		}
+		if buffer.state == bufferDecodeError {
+			stats.decodeError++
+			input.discard.push(buffer)
+			continue
+		}
+		buffer.label = input.labels.GetLabel(buffer)
+		if input.dns != nil {
+			input.dns.Event(buffer)
+		}
+		if input.hosts != nil {
+			input.hosts.Event(buffer)
+		}
+		if input.capture != nil {
+			input.capture.Event(buffer)
+		}
+		if buffer.state == bufferKeyError {
+			stats.keyError++
+			input.discard.push(buffer)
+			continue
+		}
+		if input.sampler != nil && !input.sampler.flow(buffer.KeyHash()) {
+			stats.sampled++
+			input.discard.push(buffer)
+			continue
+		}
+		input.forward.push(buffer)
+	}
+	input.forward.checkpoint = multibuffer.checkpoint
+	multibuffer.recycleEmpty()
+	input.flowtable.event(input.forward)
+	input.forward.reset()
+	input.discard.recycle()
+}
+
+// decode handles decoding, labeling, and forwarding in a single go routine
+func (input *Engine) decode() {
+	defer close(input.done)
+	selector := input.flowtable.getSelector()
+	for {
+		multibuffer, ok := input.todecode.popFull()
+		if !ok {
+			return
+		}
+		decodeBatch(multibuffer, &selector)
+		input.dispatchBatch(multibuffer)
+	}
+}
+
+// decodeParallel distributes batches round robin over decoder go routines and collects them in the same order.
+// This keeps the packet order intact for labeling and the flowtables.
+func (input *Engine) decodeParallel() {
+	defer close(input.done)
+	todo := make([]chan *shallowMultiPacketBuffer, input.decoders)
+	decoded := make([]chan *shallowMultiPacketBuffer, input.decoders)
+	for i := range todo {
+		todo[i] = make(chan *shallowMultiPacketBuffer, 1)
+		decoded[i] = make(chan *shallowMultiPacketBuffer, 1)
+		go func(in, out chan *shallowMultiPacketBuffer, selector DynamicKeySelector) {
+			defer close(out)
+			for multibuffer := range in {
+				decodeBatch(multibuffer, &selector)
+				out <- multibuffer
+			}
+		}(todo[i], decoded[i], input.flowtable.getSelector().clone())
+	}
+
 	go func() {
-		defer close(ret.done)
-		discard := newShallowMultiPacketBuffer(batchSize, nil)
-		forward := newShallowMultiPacketBuffer(batchSize, nil)
-		stats := flowtable.getDecodeStats()
-		selector := flowtable.getSelector()
-		labels := ret.labels
-		for {
-			multibuffer, ok := ret.todecode.popFull()
+		defer func() {
+			for _, c := range todo {
+				close(c)
+			}
+		}()
+		for i := 0; ; i = (i + 1) % len(todo) {
+			multibuffer, ok := input.todecode.popFull()
 			if !ok {
 				return
 			}
-			forward.setTimestamp(multibuffer.Timestamp())
-			for {
-				buffer := multibuffer.read()
-				if buffer == nil {
-					break
-				}
-				if !buffer.decode() {
-					stats.decodeError++
-					discard.push(buffer)
-				} else {
-					buffer.label = labels.GetLabel(buffer)
-					key, fw, ok := selector.Key(buffer)
-					if ok {
-						buffer.SetInfo(key, fw)
-						forward.push(buffer)
-					} else {
-						stats.keyError++
-						discard.push(buffer)
-					}
-				}
-			}
-			multibuffer.recycleEmpty()
-			flowtable.event(forward)
-			forward.reset()
-			discard.recycle()
+			todo[i] <- multibuffer
 		}
 	}()
 
-	ret.current, _ = ret.todecode.popEmpty()
-
-	return ret
+	for i := 0; ; i = (i + 1) % len(decoded) {
+		multibuffer, ok := <-decoded[i]
+		if !ok {
+			return
+		}
+		input.dispatchBatch(multibuffer)
+	}
 }
 
 // PrintStats writes the packet statistics to w
@@ -130,11 +263,12 @@ func (input *Engine) PrintStats(w io.Writer) {
 	overall: %d
 	skipped: %d
 	filtered: %d
+	sampled out: %d
 Buffer statistics:
 	peak: %d
 	allocated: %d
 	freed: %d
-`, input.packetStats.packets, input.packetStats.skipped, input.packetStats.filtered, input.packetStats.maxBuffers, input.packetStats.buffersAllocated, input.packetStats.buffersReleased)
+`, input.packetStats.Packets, input.packetStats.Skipped, input.packetStats.Filtered, input.packetStats.Sampled, input.packetStats.MaxBuffers, input.packetStats.BuffersAllocated, input.packetStats.BuffersReleased)
 }
 
 // Finish submits eventual partially filled buffers, flushes the packet handling pipeline and waits for everything to finish.
@@ -146,6 +280,79 @@ func (input *Engine) Finish() {
 	<-input.done
 
 	input.flowtable.flush()
+	// requests of batches that didn't reach every table would wait forever
+	for _, req := range input.checkpoint.requests {
+		req.abort()
+	}
+	input.checkpoint.pending.Wait()
+	input.checkpoint.requests = nil
+}
+
+// CheckpointEvery writes a checkpoint of the flow tables to the file with the given name every interval (in packet time).
+// Must be called before Run.
+func (input *Engine) CheckpointEvery(name string, interval flows.DateTimeNanoseconds) {
+	input.checkpoint.name = name
+	input.checkpoint.interval = interval
+}
+
+// SetSampling configures packet or flow sampling. Packet sampling happens after filtering; flow sampling after the flow
+// key was calculated. Must be called before Run.
+func (input *Engine) SetSampling(sampling Sampling) {
+	if sampling.Mode == SampleNone {
+		input.sampler = nil
+		return
+	}
+	input.sampler = newSampler(sampling)
+}
+
+// SetPassiveDNS enables learning hostnames from DNS responses with the given table (see PassiveDNS). Packets are
+// annotated before flow sampling, so responses of sampled out flows are still used. Must be called before Run.
+func (input *Engine) SetPassiveDNS(dns *PassiveDNS) {
+	input.dns = dns
+}
+
+// SetHostAggregates enables computing the aggregates of the endpoints over all flows (see HostAggregates). Like
+// SetPassiveDNS, packets are annotated before flow sampling. Must be called before Run.
+func (input *Engine) SetHostAggregates(hosts *HostAggregates) {
+	input.hosts = hosts
+}
+
+// SetCapture enables writing the packets of selected flows (see Capture). Must be called before Run.
+func (input *Engine) SetCapture(capture *Capture) {
+	input.capture = capture
+}
+
+// OnFirstPacket calls f with the timestamp of the first packet read from the sources, before the packet is processed.
+// f is called from Run. Must be called before Run.
+func (input *Engine) OnFirstPacket(f func(flows.DateTimeNanoseconds)) {
+	input.firstPacket = f
+}
+
+// requestCheckpoint attaches a checkpoint request to the current batch, which is carried out by the flow tables after
+// every packet of the batch was handled.
+func (input *Engine) requestCheckpoint() {
+	req := newCheckpointRequest(input.checkpoint.name, len(input.flowtable.getTables()), input.samplerState())
+	input.current.checkpoint = req
+	requests := input.checkpoint.requests[:0]
+	for _, pending := range input.checkpoint.requests {
+		if !pending.finished() {
+			requests = append(requests, pending)
+		}
+	}
+	input.checkpoint.requests = append(requests, req)
+	input.checkpoint.pending.Add(1)
+	go func() {
+		defer input.checkpoint.pending.Done()
+		req.write()
+	}()
+}
+
+// samplerState returns the position of the sampler for checkpoints
+func (input *Engine) samplerState() samplerState {
+	if input.sampler == nil {
+		return samplerState{}
+	}
+	return input.sampler.state()
 }
 
 // starved gets executed if we couldn't get batchSizes empty packets in one go
@@ -175,15 +382,15 @@ func (input *Engine) starved(have int, max int) {
 		fmt.Println("too small ", have, max, todecode.buffers, todecode.packets, table, alloc)
 	}
 	if alloc {
-		input.packetStats.buffersAllocated += batchSize
+		input.packetStats.BuffersAllocated += batchSize
 		input.empty.replenish()
 	}
 }
 
 // ok gets executed if we could successfully get batchSizes empty packets in one go
 func (input *Engine) ok(have int, max int) {
-	if max > input.packetStats.maxBuffers {
-		input.packetStats.maxBuffers = max
+	if max > input.packetStats.MaxBuffers {
+		input.packetStats.MaxBuffers = max
 	}
 	if have > freeMark {
 		input.full++
@@ -193,7 +400,7 @@ func (input *Engine) ok(have int, max int) {
 			fmt.Println("     high ", have, max, todecode.buffers, todecode.packets, table, input.full > releaseMark)
 		}
 		if input.full > releaseMark {
-			input.packetStats.buffersReleased += batchSize
+			input.packetStats.BuffersReleased += batchSize
 			input.empty.release()
 			input.full = 0
 		}
@@ -204,7 +411,7 @@ func (input *Engine) ok(have int, max int) {
 
 // Run reads all the packets from the sources and forwards those to the flowtable
 func (input *Engine) Run() (time flows.DateTimeNanoseconds) {
-	var npackets, nskipped, nfiltered uint64
+	var npackets, nskipped, nfiltered, nsampled uint64
 	var lastTime flows.DateTimeNanoseconds
 	warned := false
 
@@ -225,7 +432,12 @@ func (input *Engine) Run() (time flows.DateTimeNanoseconds) {
 			if err == io.EOF {
 				break
 			}
-			log.Fatal("Error reading packet: ", err)
+			input.err = fmt.Errorf("error reading packet: %s", err)
+			break
+		}
+		if input.firstPacket != nil {
+			input.firstPacket(flows.DateTimeNanoseconds(ci.Timestamp.UnixNano()))
+			input.firstPacket = nil
 		}
 		npackets += skipped + filtered + 1
 		nskipped += skipped
@@ -236,6 +448,11 @@ func (input *Engine) Run() (time flows.DateTimeNanoseconds) {
 			continue
 		}
 
+		if input.sampler != nil && !input.sampler.packet() {
+			nsampled++
+			continue
+		}
+
 		if input.current.empty() {
 			input.empty.Pop(input.current, input.starved, input.ok)
 		}
@@ -246,21 +463,48 @@ func (input *Engine) Run() (time flows.DateTimeNanoseconds) {
 			warned = true
 		}
 		lastTime = time
+		if input.checkpoint.interval != 0 && time > input.checkpoint.next {
+			if input.checkpoint.next != 0 {
+				input.requestCheckpoint()
+			}
+			input.checkpoint.next = time + input.checkpoint.interval
+		}
 		if input.current.full() {
 			input.current.setTimestamp(time)
 			input.current.finalize()
+			atomic.StoreUint64(&input.progress.packets, npackets)
+			atomic.StoreInt64(&input.progress.time, int64(time))
 			var ok bool
 			if input.current, ok = input.todecode.popEmpty(); !ok {
 				break
 			}
 		}
 	}
-	input.packetStats.packets = npackets
-	input.packetStats.filtered = nfiltered
-	input.packetStats.skipped = nskipped
+	input.packetStats.Packets = npackets
+	input.packetStats.Filtered = nfiltered
+	input.packetStats.Skipped = nskipped
+	input.packetStats.Sampled = nsampled
+	atomic.StoreUint64(&input.progress.packets, npackets)
+	atomic.StoreInt64(&input.progress.time, int64(lastTime))
 	return
 }
 
+// Err returns the error that stopped Run, or nil if the sources were exhausted or stopped
+func (input *Engine) Err() error {
+	return input.err
+}
+
+// Progress returns the number of packets read and the packet time. This is updated after every batch of packets and
+// can be called concurrently to Run.
+func (input *Engine) Progress() (packets uint64, time flows.DateTimeNanoseconds) {
+	return atomic.LoadUint64(&input.progress.packets), flows.DateTimeNanoseconds(atomic.LoadInt64(&input.progress.time))
+}
+
+// Stats returns the packet statistics. Must only be called after Finish.
+func (input *Engine) Stats() Stats {
+	return input.packetStats
+}
+
 // Stop cancels the whole process and stops packet input
 func (input *Engine) Stop() {
 	input.sources.Stop()
@@ -0,0 +1,131 @@
+package packet
+
+import (
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/chtisgit/go-flows/flows"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+type testSource struct {
+	data [][]byte
+	pos  int
+}
+
+func (s *testSource) ID() string { return "test" }
+func (s *testSource) Init()      {}
+func (s *testSource) Stop()      {}
+
+func (s *testSource) ReadPacket() (lt gopacket.LayerType, data []byte, ci gopacket.CaptureInfo, skipped uint64, filtered uint64, err error) {
+	if s.pos >= len(s.data) {
+		err = io.EOF
+		return
+	}
+	data = s.data[s.pos]
+	ci.Timestamp = time.Unix(0, int64(s.pos)*int64(time.Millisecond))
+	ci.CaptureLength = len(data)
+	ci.Length = len(data)
+	s.pos++
+	return layers.LayerTypeIPv4, data, ci, 0, 0, nil
+}
+
+type testLabel struct {
+	last    uint64
+	ordered bool
+}
+
+func (l *testLabel) ID() string { return "test" }
+func (l *testLabel) Init()      {}
+
+func (l *testLabel) GetLabel(packet Buffer) (interface{}, error) {
+	if packet.PacketNr() <= l.last {
+		l.ordered = false
+	}
+	l.last = packet.PacketNr()
+	return packet.PacketNr(), nil
+}
+
+type testTable struct {
+	baseTable
+	stats   decodeStats
+	packets map[string][]uint64
+	labels  bool
+}
+
+func (t *testTable) EOF(flows.DateTimeNanoseconds) {}
+func (t *testTable) PrintStats(io.Writer)          {}
+func (t *testTable) usage() []bufferUsage          { return make([]bufferUsage, 1) }
+func (t *testTable) flush()                        {}
+func (t *testTable) getDecodeStats() *decodeStats  { return &t.stats }
+
+func (t *testTable) event(buffer *shallowMultiPacketBuffer) {
+	for {
+		b := buffer.read()
+		if b == nil {
+			break
+		}
+		if b.Label() != b.PacketNr() {
+			t.labels = false
+		}
+		key := string(b.Key())
+		t.packets[key] = append(t.packets[key], b.PacketNr())
+		b.Recycle()
+	}
+}
+
+func makeTestPackets(t *testing.T, n int) [][]byte {
+	ret := make([][]byte, n)
+	for i := range ret {
+		buf := gopacket.NewSerializeBuffer()
+		ip := &layers.IPv4{Version: 4, IHL: 5, TTL: 64, Protocol: layers.IPProtocolUDP, SrcIP: []byte{10, 0, 0, byte(i % 7)}, DstIP: []byte{10, 0, 1, 1}}
+		udp := &layers.UDP{SrcPort: layers.UDPPort(1000 + i%13), DstPort: 53}
+		udp.SetNetworkLayerForChecksum(ip)
+		if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true}, ip, udp, gopacket.Payload([]byte{1, 2, 3})); err != nil {
+			t.Fatal(err)
+		}
+		ret[i] = append([]byte(nil), buf.Bytes()...)
+	}
+	return ret
+}
+
+func TestEngineDecodersKeepOrder(t *testing.T) {
+	const npackets = 5 * batchSize
+	data := makeTestPackets(t, npackets)
+	for _, decoders := range []int{1, 4} {
+		table := &testTable{
+			baseTable: baseTable{selector: MakeDynamicKeySelector([]string{"sourceIPAddress", "sourceTransportPort"}, false, false)},
+			packets:   make(map[string][]uint64),
+			labels:    true,
+		}
+		var sources Sources
+		sources.Append(&testSource{data: data})
+		label := &testLabel{ordered: true}
+		engine := NewEngine(0, decoders, table, nil, sources, Labels{label})
+		engine.Run()
+		engine.Finish()
+
+		if !label.ordered {
+			t.Errorf("decoders=%d: labels were not requested in packet order", decoders)
+		}
+		if !table.labels {
+			t.Errorf("decoders=%d: packets got the wrong label", decoders)
+		}
+		total := 0
+		for key, nrs := range table.packets {
+			total += len(nrs)
+			for i := 1; i < len(nrs); i++ {
+				if nrs[i] <= nrs[i-1] {
+					t.Fatalf("decoders=%d: packets of flow %x out of order", decoders, key)
+				}
+			}
+		}
+		if total != npackets {
+			t.Errorf("decoders=%d: expected %d packets, but got %d", decoders, npackets, total)
+		}
+		engine.PrintStats(ioutil.Discard)
+	}
+}
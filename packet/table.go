@@ -13,6 +13,7 @@ import (
 type decodeStats struct {
 	decodeError uint64
 	keyError    uint64
+	sampled     uint64
 }
 
 // EventTable represents a flow table that can handle multiple events in one go
@@ -21,15 +22,19 @@ type EventTable interface {
 	EOF(flows.DateTimeNanoseconds)
 	// Print table statistics to the given writer
 	PrintStats(io.Writer)
+	// Drop removes all the flows without exporting them
+	Drop()
 	usage() []bufferUsage
 	event(buffer *shallowMultiPacketBuffer)
 	flush()
 	getDecodeStats() *decodeStats
 	getSelector() DynamicKeySelector
+	getTables() []*flows.FlowTable
 }
 
 type baseTable struct {
 	selector DynamicKeySelector
+	tables   []*flows.FlowTable
 	autoGC   bool
 }
 
@@ -37,9 +42,12 @@ func (bt baseTable) getSelector() DynamicKeySelector {
 	return bt.selector
 }
 
+func (bt baseTable) getTables() []*flows.FlowTable {
+	return bt.tables
+}
+
 type parallelFlowTable struct {
 	baseTable
-	tables      []*flows.FlowTable
 	expirewg    sync.WaitGroup
 	buffers     []*shallowMultiPacketBufferRing
 	tmp         []*shallowMultiPacketBuffer
@@ -66,7 +74,8 @@ func (sft *singleFlowTable) PrintStats(w io.Writer) {
 		`Decode statistics:
 	decode errors: %d
 	key function rejects: %d
-`, sft.decodeStats.decodeError, sft.decodeStats.keyError)
+	flow sampling rejects: %d
+`, sft.decodeStats.decodeError, sft.decodeStats.keyError, sft.decodeStats.sampled)
 	fmt.Fprintf(w,
 		`Table statistics:
 	flows: %d
@@ -85,12 +94,13 @@ func (sft *singleFlowTable) event(buffer *shallowMultiPacketBuffer) {
 		expire = true
 		sft.nextExpire = current + sft.expireTime
 	}
-	if buffer.empty() && !expire {
+	if buffer.empty() && !expire && buffer.checkpoint == nil {
 		return
 	}
 	b, _ := sft.buffer.popEmpty()
 	buffer.Copy(b)
 	b.expire = expire
+	b.checkpoint = buffer.checkpoint
 	b.timestamp = current
 	b.finalize()
 }
@@ -124,6 +134,7 @@ func NewFlowTable(num int, features flows.RecordListMaker, newflow flows.FlowCre
 			table:      flows.NewFlowTable(features, newflow, options, selector.fivetuple && options.TCPExpiry, 0),
 			expireTime: expire,
 		}
+		ret.tables = []*flows.FlowTable{ret.table}
 		ret.buffer = newShallowMultiPacketBufferRing(fullBuffers, batchSize)
 		ret.done = make(chan struct{})
 		go func() {
@@ -146,6 +157,9 @@ func NewFlowTable(num int, features flows.RecordListMaker, newflow flows.FlowCre
 						go runtime.GC()
 					}
 				}
+				if buffer.checkpoint != nil {
+					buffer.checkpoint.save(0, t)
+				}
 				buffer.recycle()
 			}
 		}()
@@ -154,9 +168,9 @@ func NewFlowTable(num int, features flows.RecordListMaker, newflow flows.FlowCre
 	if num > 256 {
 		panic("Maximum of 256 tables allowed")
 	}
+	bt.tables = make([]*flows.FlowTable, num)
 	ret := &parallelFlowTable{
 		baseTable:   bt,
-		tables:      make([]*flows.FlowTable, num),
 		buffers:     make([]*shallowMultiPacketBufferRing, num),
 		usageBuffer: make([]bufferUsage, num),
 		tmp:         make([]*shallowMultiPacketBuffer, num),
@@ -168,6 +182,7 @@ func NewFlowTable(num int, features flows.RecordListMaker, newflow flows.FlowCre
 		t := flows.NewFlowTable(features, newflow, options, selector.fivetuple && options.TCPExpiry, uint8(i))
 		ret.tables[i] = t
 		ret.wg.Add(1)
+		id := i
 		go func() {
 			defer ret.wg.Done()
 			for buffer := range c.full {
@@ -187,6 +202,9 @@ func NewFlowTable(num int, features flows.RecordListMaker, newflow flows.FlowCre
 						ret.expirewg.Done()
 					}
 				}
+				if buffer.checkpoint != nil {
+					buffer.checkpoint.save(id, t)
+				}
 				buffer.recycle()
 			}
 		}()
@@ -206,7 +224,8 @@ func (pft *parallelFlowTable) PrintStats(w io.Writer) {
 		`Decode statistics:
 	decode errors: %d
 	key function rejects: %d
-`, pft.decodeStats.decodeError, pft.decodeStats.keyError)
+	flow sampling rejects: %d
+`, pft.decodeStats.decodeError, pft.decodeStats.keyError, pft.decodeStats.sampled)
 	fmt.Fprintln(w, "Table statistics:")
 	var sumPackets, sumFlows uint64
 	for _, table := range pft.tables {
@@ -226,7 +245,7 @@ func (pft *parallelFlowTable) getDecodeStats() *decodeStats {
 	return &pft.decodeStats
 }
 
-// event partitions all packets from buffer over the tables based on fnvHash of the flow key.
+// event partitions all packets from buffer over the tables based on the hash of the flow key.
 // Expiry is carried out in every table at about the same time (a flag in the buffer is set - after this buffer is handle the table does expiry).
 // event waits for every table to finish expiry and does GC afterwards - this hurts concurrency a bit, but improves memory usage.
 func (pft *parallelFlowTable) event(buffer *shallowMultiPacketBuffer) {
@@ -237,7 +256,7 @@ func (pft *parallelFlowTable) event(buffer *shallowMultiPacketBuffer) {
 		expire = true
 		pft.nextExpire = current + pft.expireTime
 	}
-	if buffer.empty() && !expire {
+	if buffer.empty() && !expire && buffer.checkpoint == nil {
 		return
 	}
 
@@ -246,13 +265,14 @@ func (pft *parallelFlowTable) event(buffer *shallowMultiPacketBuffer) {
 		tmp[i], _ = buf.popEmpty()
 		tmp[i].timestamp = current
 		tmp[i].expire = expire
+		tmp[i].checkpoint = buffer.checkpoint
 	}
 	for {
 		b := buffer.read()
 		if b == nil {
 			break
 		}
-		h := fnvHash(b.Key()) % uint64(len(tmp))
+		h := b.KeyHash() % uint64(len(tmp))
 		tmp[h].push(b)
 	}
 	for _, buf := range pft.tmp {
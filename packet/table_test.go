@@ -0,0 +1,80 @@
+package packet
+
+import (
+	"testing"
+
+	"github.com/chtisgit/go-flows/flows"
+	"github.com/google/gopacket/layers"
+)
+
+const benchmarkFlows = 1024
+
+// benchmarkBuffers returns packets belonging to benchmarkFlows different flows
+func benchmarkBuffers() []Buffer {
+	ret := make([]Buffer, 4*benchmarkFlows)
+	for i := range ret {
+		flow := i % benchmarkFlows
+		ret[i] = BufferFromLayers(flows.DateTimeNanoseconds(i),
+			&layers.IPv4{SrcIP: []byte{10, 0, byte(flow >> 8), byte(flow)}, DstIP: []byte{10, 1, 0, 1}, Protocol: layers.IPProtocolTCP},
+			&layers.TCP{SrcPort: layers.TCPPort(1024 + flow), DstPort: 80},
+		)
+	}
+	return ret
+}
+
+func benchmarkSelector() DynamicKeySelector {
+	return MakeDynamicKeySelector(
+		[]string{"sourceIPAddress",
+			"destinationIPAddress",
+			"protocolIdentifier",
+			"sourceTransportPort",
+			"destinationTransportPort"}, true, false)
+}
+
+type benchmarkFlow struct {
+	flows.BaseFlow
+}
+
+func (f *benchmarkFlow) Event(flows.Event, *flows.EventContext) {}
+
+func newBenchmarkFlow(event flows.Event, table *flows.FlowTable, key string, lowToHigh bool, context *flows.EventContext, id uint64) flows.Flow {
+	ret := new(benchmarkFlow)
+	ret.Init(table, key, lowToHigh, context, id)
+	return ret
+}
+
+// BenchmarkFlowLookupString is the baseline for BenchmarkFlowLookupFlowTable: It keys and looks up packets the way it
+// was done before the flow table was keyed by byte slices, with one string allocation per packet for the key of a
+// map[string]int.
+func BenchmarkFlowLookupString(b *testing.B) {
+	buffers := benchmarkBuffers()
+	selector := benchmarkSelector()
+	table := make(map[string]int)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		buffer := buffers[i%len(buffers)]
+		key, _, _ := selector.Key(buffer)
+		flowKey := string(key)
+		if _, ok := table[flowKey]; !ok {
+			table[flowKey] = len(table)
+		}
+	}
+}
+
+// BenchmarkFlowLookupFlowTable keys packets and forwards them to a flow table. Allocations only happen for new flows.
+func BenchmarkFlowLookupFlowTable(b *testing.B) {
+	buffers := benchmarkBuffers()
+	selector := benchmarkSelector()
+	var records flows.RecordListMaker
+	records.Init()
+	table := flows.NewFlowTable(records, newBenchmarkFlow, flows.FlowOptions{ActiveTimeout: flows.SecondsInNanoseconds * 3600}, false, 0)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		buffer := buffers[i%len(buffers)]
+		key, fw, _ := selector.Key(buffer)
+		buffer.SetInfo(key, fw)
+		table.Event(buffer)
+	}
+}
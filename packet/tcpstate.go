@@ -0,0 +1,176 @@
+package packet
+
+import "github.com/google/gopacket/layers"
+
+// TCPState is the state of a tracked TCP connection
+type TCPState uint8
+
+const (
+	// TCPStateNone means that no packet was seen yet
+	TCPStateNone TCPState = iota
+	// TCPStateSynSent means that the client sent a SYN
+	TCPStateSynSent
+	// TCPStateSynReceived means that the server answered with a SYN+ACK
+	TCPStateSynReceived
+	// TCPStateEstablished means that the handshake is finished, or that the connection was picked up in the middle
+	TCPStateEstablished
+	// TCPStateFinWait means that one side sent a FIN (half-closed connection)
+	TCPStateFinWait
+	// TCPStateClosing means that both sides sent a FIN, but not every FIN was acknowledged
+	TCPStateClosing
+	// TCPStateTimeWait means that both sides sent a FIN, which was acknowledged by the other side
+	TCPStateTimeWait
+	// TCPStateClosed means that the connection was reset
+	TCPStateClosed
+)
+
+var tcpStateNames = [...]string{
+	TCPStateNone:        "NONE",
+	TCPStateSynSent:     "SYN_SENT",
+	TCPStateSynReceived: "SYN_RECEIVED",
+	TCPStateEstablished: "ESTABLISHED",
+	TCPStateFinWait:     "FIN_WAIT",
+	TCPStateClosing:     "CLOSING",
+	TCPStateTimeWait:    "TIME_WAIT",
+	TCPStateClosed:      "CLOSED",
+}
+
+func (s TCPState) String() string {
+	if int(s) < len(tcpStateNames) {
+		return tcpStateNames[s]
+	}
+	return "UNKNOWN"
+}
+
+// TCPConnection is implemented by flows which track the state of a TCP connection. Features can access this via
+// EventContext.Flow().
+type TCPConnection interface {
+	// TCPState returns the current connection state
+	TCPState() TCPState
+	// TCPHistory returns the connection history in the style of Zeek's conn.log: S (SYN), H (SYN+ACK), A (pure ACK),
+	// D (payload), F (FIN), R (RST); uppercase letters for the client, lowercase letters for the server. Every
+	// letter is only recorded once per direction. The history starts with ^, if the first packet was sent by the
+	// server.
+	TCPHistory() string
+	// TCPClientIsSource returns true if the client (the side sending the SYN) is the source of the flow. Tracked
+	// flows are oriented by the client: if the first packet is a SYN+ACK, the flow is reversed, and if no handshake
+	// was seen, the sender of the first packet is the client.
+	TCPClientIsSource() bool
+}
+
+const (
+	tcpClient = 0
+	tcpServer = 1
+)
+
+// tcpTracker holds the state of a TCP connection. Fields are exported for checkpoints.
+type tcpTracker struct {
+	State         TCPState
+	ClientForward bool
+	FinSent       [2]bool
+	FinAcked      [2]bool
+	FinSeq        [2]uint32 // sequence number after the FIN
+	History       []byte
+	HistorySeen   uint16
+}
+
+const tcpHistoryLetters = "SHADFR"
+
+func (t *tcpTracker) history(side int, letter int) {
+	bit := uint16(1) << uint(letter*2+side)
+	if t.HistorySeen&bit != 0 {
+		return
+	}
+	t.HistorySeen |= bit
+	c := tcpHistoryLetters[letter]
+	if side == tcpServer {
+		c += 'a' - 'A'
+	}
+	t.History = append(t.History, c)
+}
+
+// newConnection returns true if the packet is the SYN of a new connection, while the old one is closed
+func (t *tcpTracker) newConnection(tcp *layers.TCP) bool {
+	return tcp.SYN && !tcp.ACK && (t.State == TCPStateTimeWait || t.State == TCPStateClosed)
+}
+
+// seqAfter returns true if a is the same or a later sequence number than b
+func seqAfter(a, b uint32) bool {
+	return int32(a-b) >= 0
+}
+
+// update advances the state with the given packet. forward is true if the packet was sent by the source of the flow.
+func (t *tcpTracker) update(tcp *layers.TCP, forward bool, payload int) {
+	if t.State == TCPStateNone {
+		switch {
+		case tcp.SYN && !tcp.ACK:
+			t.ClientForward = forward
+			t.State = TCPStateSynSent
+		case tcp.SYN && tcp.ACK:
+			t.ClientForward = !forward
+			t.State = TCPStateSynReceived
+			t.History = append(t.History, '^')
+		default:
+			t.ClientForward = forward
+			t.State = TCPStateEstablished
+		}
+	}
+
+	side := tcpServer
+	if forward == t.ClientForward {
+		side = tcpClient
+	}
+	other := 1 - side
+
+	switch {
+	case tcp.SYN && !tcp.ACK:
+		t.history(side, 0)
+	case tcp.SYN && tcp.ACK:
+		t.history(side, 1)
+		if t.State == TCPStateSynSent {
+			t.State = TCPStateSynReceived
+		}
+	case tcp.ACK && !tcp.FIN && !tcp.RST && payload == 0:
+		t.history(side, 2)
+	}
+	if payload > 0 {
+		t.history(side, 3)
+	}
+	if tcp.FIN {
+		t.history(side, 4)
+	}
+	if tcp.RST {
+		t.history(side, 5)
+		t.State = TCPStateClosed
+		return
+	}
+
+	if t.State == TCPStateClosed {
+		return
+	}
+
+	if tcp.ACK && !tcp.SYN && (t.State == TCPStateSynSent || t.State == TCPStateSynReceived) {
+		t.State = TCPStateEstablished
+	}
+
+	if tcp.FIN && !t.FinSent[side] {
+		t.FinSent[side] = true
+		seq := tcp.Seq + uint32(payload) + 1
+		if tcp.SYN {
+			seq++
+		}
+		t.FinSeq[side] = seq
+	}
+	if tcp.ACK && t.FinSent[other] && seqAfter(tcp.Ack, t.FinSeq[other]) {
+		t.FinAcked[other] = true
+	}
+
+	switch {
+	case t.FinAcked[tcpClient] && t.FinAcked[tcpServer]:
+		t.State = TCPStateTimeWait
+	case t.FinSent[tcpClient] && t.FinSent[tcpServer]:
+		t.State = TCPStateClosing
+	case t.FinSent[tcpClient] || t.FinSent[tcpServer]:
+		t.State = TCPStateFinWait
+	}
+}
@@ -2,6 +2,8 @@
 package packet_test
 
 import (
+	"bytes"
+	"encoding/gob"
 	"fmt"
 	"reflect"
 	"strings"
@@ -64,11 +66,26 @@ type TestTable struct {
 	exporter *assertExporter
 	table    *flows.FlowTable
 	pipe     *flows.ExportPipeline
+	records  flows.RecordListMaker
+	opt      flows.FlowOptions
+	dns      *packet.PassiveDNS
+	hosts    *packet.HostAggregates
+	capture  *packet.Capture
 	t        *testing.T
 }
 
 // MakeFeatureTest creates a flow table for testing purposes with the given features, wanted return type, and flow options
 func MakeFeatureTest(t *testing.T, features []string, ft flows.FeatureType, opt flows.FlowOptions) (ret TestTable) {
+	featuresI := make([]interface{}, len(features))
+	for i, feature := range features {
+		featuresI[i] = feature
+	}
+	return MakeFilteredFeatureTest(t, featuresI, nil, nil, opt)
+}
+
+// MakeFilteredFeatureTest creates a flow table for testing purposes with the given features (e.g. from
+// spec.ParseExpression), control and filter features, and flow options
+func MakeFilteredFeatureTest(t *testing.T, features []interface{}, control, filter []string, opt flows.FlowOptions) (ret TestTable) {
 	ret.t = t
 	if opt.ActiveTimeout == 0 {
 		opt.ActiveTimeout = flows.SecondsInNanoseconds * 1800
@@ -77,16 +94,14 @@ func MakeFeatureTest(t *testing.T, features []string, ft flows.FeatureType, opt
 		opt.IdleTimeout = flows.SecondsInNanoseconds * 300
 	}
 	ret.exporter = makeAssertExporter()
-	featuresI := make([]interface{}, len(features))
-	for i, feature := range features {
-		featuresI[i] = feature
-	}
 	var f flows.RecordListMaker
 	ret.pipe, _ = flows.MakeExportPipeline([]flows.Exporter{ret.exporter}, flows.SortTypeNone, 1)
-	if err := f.AppendRecord(featuresI, nil, nil, ret.pipe, false); err != nil {
+	if err := f.AppendRecord(features, control, filter, ret.pipe, false); err != nil {
 		t.Fatalf("Couldn't parse features: %s", err)
 	}
 	f.Init()
+	ret.records = f
+	ret.opt = opt
 	ret.table = flows.NewFlowTable(f, packet.NewFlow, opt, true, 0)
 	ret.selector = packet.MakeDynamicKeySelector([]string{
 		"sourceIPAddress",
@@ -111,11 +126,70 @@ func MakePacketFeatureTest(t *testing.T, feature string) TestTable {
 // EventLayers simulates a packet arriving at the given point in time with the given layers populated
 func (t *TestTable) EventLayers(when flows.DateTimeNanoseconds, layerList ...packet.SerializableLayerType) {
 	data := packet.BufferFromLayers(when, layerList...)
+	if t.dns != nil {
+		t.dns.Event(data)
+	}
+	if t.hosts != nil {
+		t.hosts.Event(data)
+	}
+	if t.capture != nil {
+		t.capture.Event(data)
+	}
+	key, fw, _ := t.selector.Key(data)
+	data.SetInfo(key, fw)
+	t.table.Event(data)
+}
+
+// EventLabeledLayers simulates a packet with the given label arriving at the given point in time with the given layers
+// populated
+func (t *TestTable) EventLabeledLayers(when flows.DateTimeNanoseconds, label interface{}, layerList ...packet.SerializableLayerType) {
+	data := packet.LabeledBufferFromLayers(when, label, layerList...)
+	if t.capture != nil {
+		t.capture.Event(data)
+	}
 	key, fw, _ := t.selector.Key(data)
 	data.SetInfo(key, fw)
 	t.table.Event(data)
 }
 
+// EnablePassiveDNS learns hostnames from the DNS responses in the following packets (see packet.PassiveDNS)
+func (t *TestTable) EnablePassiveDNS(grace flows.DateTimeNanoseconds) {
+	t.dns = packet.NewPassiveDNS(grace)
+}
+
+// EnableHostAggregates computes the host aggregates of the following packets (see packet.HostAggregates)
+func (t *TestTable) EnableHostAggregates(config packet.HostAggregatesConfig) {
+	hosts, err := packet.NewHostAggregates(config)
+	if err != nil {
+		t.t.Fatal(err)
+	}
+	t.hosts = hosts
+}
+
+// EnableCapture writes the packets of the selected flows of the following packets (see packet.Capture). The capture
+// needs to be closed after Finish.
+func (t *TestTable) EnableCapture(config packet.CaptureConfig) *packet.Capture {
+	capture, err := packet.NewCapture(config)
+	if err != nil {
+		t.t.Fatal(err)
+	}
+	t.capture = capture
+	return capture
+}
+
+// Resume simulates a restart with a checkpoint: The flows are written to a checkpoint and restored into a new flow table
+func (t *TestTable) Resume() {
+	var buf bytes.Buffer
+	if err := t.table.Checkpoint(gob.NewEncoder(&buf)); err != nil {
+		t.t.Fatalf("Couldn't write checkpoint: %s", err)
+	}
+	t.table.Drop()
+	t.table = flows.NewFlowTable(t.records, packet.NewFlow, t.opt, true, 0)
+	if err := t.table.Restore(gob.NewDecoder(&buf), packet.RestoreFlow); err != nil {
+		t.t.Fatalf("Couldn't restore checkpoint: %s", err)
+	}
+}
+
 // Finish finalizes all the flows in the table
 func (t *TestTable) Finish(when flows.DateTimeNanoseconds) {
 	t.table.EOF(when)
@@ -166,6 +240,13 @@ func (t *TestTable) AssertFeatureValue(name string, value interface{}) {
 	t.fail(fmt.Sprintf("Couldn't observe %s == %v", name, value))
 }
 
+// AssertLineCount fails the test if the table did not export exactly n lines
+func (t *TestTable) AssertLineCount(n int) {
+	if len(t.exporter.seen) != n {
+		t.fail(fmt.Sprintf("Expected %d result lines, but got %d", n, len(t.exporter.seen)))
+	}
+}
+
 // AssertFeatureList fails the test if the table did not export the given resultset
 func (t *TestTable) AssertFeatureList(result []FeatureLine) {
 	for i, line := range result {
@@ -0,0 +1,610 @@
+// Package pipeline allows embedding go-flows into other programs. A Builder collects flow specifications, exporters,
+// sources, filters, and labels, and creates a Pipeline, which can be run like the go-flows command line tool:
+//
+//	spec, warnings, err := pipeline.LoadSpec("spec.json", spec.FormatAuto, 0)
+//	...
+//	p, err := pipeline.New(pipeline.Config{}).
+//		Features(spec).
+//		Export(exporter).
+//		Source(source).
+//		Build()
+//	...
+//	err = p.Run(ctx)
+//
+// Errors are returned instead of terminating the program. Modules (exporters, sources, ...) are created with the
+// respective Make functions (e.g. flows.MakeExporter, packet.MakeSource) or can be implemented by the embedding
+// program.
+package pipeline
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chtisgit/go-flows/flows"
+	"github.com/chtisgit/go-flows/packet"
+)
+
+// Config holds the settings of a pipeline. Zero values are replaced with the defaults given below.
+type Config struct {
+	// Tables is the number of parallel flow tables (default 1)
+	Tables int
+	// Decoders is the number of parallel packet decoders (default 1)
+	Decoders int
+	// MaxPacketSize is the maximum packet size handled internally; -1 means automatic (default 9000)
+	MaxPacketSize int
+	// ExpireInterval is the period in which expired timers are checked (default 100 seconds)
+	ExpireInterval flows.DateTimeNanoseconds
+	// WindowExpiry expires all flows after a window ended
+	WindowExpiry bool
+	// Sort specifies the output order (default flows.SortTypeNone; the command line tool uses flows.SortTypeStopTime)
+	Sort flows.SortType
+	// ScantFlows speeds up processing, if there are only a few flows, but might increase memory usage a lot
+	ScantFlows bool
+	// Verbose logs the resulting fields and templates of every specification
+	Verbose bool
+	// Sampling configures packet or flow sampling
+	Sampling packet.Sampling
+	// PassiveDNS learns hostnames from DNS responses for features like destinationHostnameFromDNS
+	PassiveDNS bool
+	// PassiveDNSGrace keeps learned hostnames this long after the TTL of the DNS answer expired
+	PassiveDNSGrace flows.DateTimeNanoseconds
+	// HostAggregates computes the aggregates of the endpoints over all flows for features like
+	// sourceHostDestinationPorts (nil = disabled)
+	HostAggregates *packet.HostAggregatesConfig
+	// Capture writes the packets of the flows selected by the control expressions and filters to Capture.Writer (nil =
+	// disabled; see packet.Capture). The writer is closed by Run.
+	Capture *packet.CaptureConfig
+	// WrapExporter gets called for every exporter added with Export and the result is used instead (e.g. for
+	// anonymizing the exported records)
+	WrapExporter func(flows.Exporter) flows.Exporter
+	// Checkpoint is the file the active flows are written to by Suspend
+	Checkpoint string
+	// CheckpointInterval additionally writes the checkpoint with this period (packet time); 0 = only on Suspend
+	CheckpointInterval flows.DateTimeNanoseconds
+	// Resume is a checkpoint file which is restored before processing packets
+	Resume string
+	// Progress gets called every ProgressInterval while Run is executing
+	Progress func(Progress)
+	// ProgressInterval is the interval for Progress (default 1 second)
+	ProgressInterval time.Duration
+	// InputDone gets called after all packets were processed, but before the remaining flows are exported (e.g. for
+	// memory profiling)
+	InputDone func()
+}
+
+// Progress holds the state of a running pipeline
+type Progress struct {
+	// Packets is the number of packets read so far
+	Packets uint64
+	// Time is the time of the last packet
+	Time flows.DateTimeNanoseconds
+}
+
+var (
+	// ErrRunning is returned by Run, if the pipeline is already running or finished
+	ErrRunning = errors.New("pipeline can only be run once")
+	// ErrNoCheckpoint is returned by Suspend, if no checkpoint file is configured
+	ErrNoCheckpoint = errors.New("no checkpoint file configured")
+)
+
+type exportGroup struct {
+	specs     []Spec
+	exporters []flows.Exporter
+	from      int // index of the group whose records are processed or -1 for packets
+}
+
+// Builder creates a Pipeline. Errors are collected and returned by Build.
+type Builder struct {
+	config    Config
+	groups    []*exportGroup
+	exporters map[string]flows.Exporter
+	order     []flows.Exporter
+	sources   packet.Sources
+	filters   packet.Filters
+	labels    packet.Labels
+	named     []namedLabel
+	err       error
+}
+
+type namedLabel struct {
+	name  string
+	label packet.Label
+}
+
+// New returns a builder for a pipeline with the given configuration
+func New(config Config) *Builder {
+	return &Builder{
+		config:    config,
+		exporters: make(map[string]flows.Exporter),
+	}
+}
+
+func (b *Builder) fail(format string, args ...interface{}) *Builder {
+	if b.err == nil {
+		b.err = fmt.Errorf(format, args...)
+	}
+	return b
+}
+
+// Features adds flow specifications. The specifications are exported by the exporters that are added next with
+// Export. Features after Export start a new group of specifications.
+func (b *Builder) Features(specs ...Spec) *Builder {
+	return b.features(-1, specs)
+}
+
+// RecordFeatures adds flow specifications like Features, which process the records exported by the feature group with
+// the given index (counting from 0 in the order of Features and RecordFeatures calls, which are followed by Export)
+// instead of packets. The key features of the specifications are names of fields of the records; the fields are
+// accessed with the function field (e.g. sum(field("octetTotalCount"))). This allows a second aggregation of flows
+// (e.g. host profiles per hour) in a single run. The exporters of the group can't be shared with other groups.
+func (b *Builder) RecordFeatures(from int, specs ...Spec) *Builder {
+	if from < 0 {
+		return b.fail("feature group index must not be negative (is %d)", from)
+	}
+	return b.features(from, specs)
+}
+
+func (b *Builder) features(from int, specs []Spec) *Builder {
+	if len(b.groups) == 0 || len(b.groups[len(b.groups)-1].exporters) != 0 || b.groups[len(b.groups)-1].from != from {
+		b.groups = append(b.groups, &exportGroup{from: from})
+	}
+	group := b.groups[len(b.groups)-1]
+	group.specs = append(group.specs, specs...)
+	return b
+}
+
+// Export adds exporters for the preceding flow specifications. Exporters with the same ID are shared.
+func (b *Builder) Export(exporters ...flows.Exporter) *Builder {
+	if len(b.groups) == 0 {
+		return b.fail("at least one feature specification is needed before an exporter")
+	}
+	group := b.groups[len(b.groups)-1]
+	for _, e := range exporters {
+		if existing, ok := b.exporters[e.ID()]; ok {
+			e = existing
+		} else {
+			if b.config.WrapExporter != nil {
+				e = b.config.WrapExporter(e)
+			}
+			b.exporters[e.ID()] = e
+			b.order = append(b.order, e)
+		}
+		group.exporters = append(group.exporters, e)
+	}
+	return b
+}
+
+// Source adds packet sources. Sources are read one after another.
+func (b *Builder) Source(sources ...packet.Source) *Builder {
+	for _, s := range sources {
+		b.sources.Append(s)
+	}
+	return b
+}
+
+// Filter adds packet filters. All filters must accept a packet.
+func (b *Builder) Filter(filters ...packet.Filter) *Builder {
+	b.filters = append(b.filters, filters...)
+	return b
+}
+
+// Label adds label providers. Labels are used one after another.
+func (b *Builder) Label(labels ...packet.Label) *Builder {
+	b.labels = append(b.labels, labels...)
+	return b
+}
+
+// NamedLabel adds label providers with the given name. As soon as there is a named label, every name is evaluated for
+// every packet and the label of a packet is a packet.NamedLabel. Labels with the same name are used one after another;
+// labels without a name (Label) get the name "".
+func (b *Builder) NamedLabel(name string, labels ...packet.Label) *Builder {
+	for _, label := range labels {
+		b.named = append(b.named, namedLabel{name, label})
+	}
+	return b
+}
+
+// Build checks the configuration, compiles the flow specifications, and returns the resulting pipeline
+func (b *Builder) Build() (*Pipeline, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	if len(b.groups) == 0 {
+		return nil, errors.New("at least one feature specification is needed")
+	}
+	for _, group := range b.groups {
+		if len(group.exporters) == 0 {
+			return nil, errors.New("at least one exporter is needed after every group of feature specifications")
+		}
+	}
+
+	config := b.config
+	if config.Tables == 0 {
+		config.Tables = 1
+	}
+	if config.Tables < 1 || config.Tables > 256 {
+		return nil, fmt.Errorf("number of flow tables must be between 1 and 256 (is %d)", config.Tables)
+	}
+	if config.Decoders == 0 {
+		config.Decoders = 1
+	}
+	if config.Decoders < 1 {
+		return nil, errors.New("need at least one packet decoding worker")
+	}
+	switch {
+	case config.MaxPacketSize == 0:
+		config.MaxPacketSize = 9000
+	case config.MaxPacketSize < 0:
+		config.MaxPacketSize = 0
+	}
+	if config.ExpireInterval == 0 {
+		config.ExpireInterval = 100 * flows.SecondsInNanoseconds
+	}
+	if config.ProgressInterval == 0 {
+		config.ProgressInterval = time.Second
+	}
+	if config.PassiveDNS {
+		packet.SetPassiveDNSEnabled(true)
+	}
+
+	ret := &Pipeline{
+		config:    config,
+		exporters: b.order,
+		sources:   b.sources,
+		filters:   b.filters,
+		labels:    b.labels,
+	}
+	if len(b.named) > 0 {
+		named := &packet.NamedLabels{}
+		for _, label := range b.named {
+			named.Add(label.name, label.label)
+		}
+		for _, label := range b.labels {
+			named.Add("", label)
+		}
+		ret.labels = packet.Labels{named}
+	}
+
+	exporters, stages, err := b.makeStages(config)
+	if err != nil {
+		return nil, err
+	}
+	ret.exporters = ret.exporters[:0:0]
+	for _, exporter := range b.order {
+		if !stagedExporter(b.groups, exporter) {
+			ret.exporters = append(ret.exporters, exporter)
+		}
+	}
+
+	var key []string
+	var bidirectional, allowZero bool
+	first := true
+	for i, group := range b.groups {
+		if group.from >= 0 {
+			source := &ret.records
+			if b.groups[group.from].from >= 0 {
+				source = &stages[group.from].records
+			} else {
+				ret.exporters = append(ret.exporters, stages[i])
+			}
+			if err := stages[i].build(group.specs, exporters[i], source, config); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		pipe, err := flows.MakeExportPipeline(exporters[i], config.Sort, uint(config.Tables))
+		if err != nil {
+			return nil, err
+		}
+		for _, spec := range group.specs {
+			specKey := append([]string(nil), spec.Key...)
+			sort.Strings(specKey)
+			if first {
+				first = false
+				key = specKey
+				bidirectional = spec.Bidirectional
+				allowZero = spec.AllowZero
+				ret.options = spec.Options
+			} else {
+				if !reflect.DeepEqual(key, specKey) {
+					return nil, errors.New("key_features of every flowspec must match")
+				}
+				if bidirectional != spec.Bidirectional {
+					return nil, errors.New("bidirectional of every flow must match")
+				}
+				if allowZero != spec.AllowZero {
+					return nil, errors.New("allowZero of every flow must match")
+				}
+				if !reflect.DeepEqual(ret.options, spec.Options) {
+					return nil, errors.New("timeouts and per packet of every flow must match")
+				}
+			}
+			if err := ret.records.AppendRecord(spec.Features, spec.Control, spec.Filter, pipe, config.Verbose); err != nil {
+				return nil, fmt.Errorf("couldn't parse feature specification: %s", err)
+			}
+		}
+	}
+
+	if ret.selector, err = packet.NewDynamicKeySelector(key, bidirectional, allowZero); err != nil {
+		return nil, err
+	}
+
+	if config.Checkpoint != "" || config.Resume != "" {
+		if unsupported := ret.records.CheckpointUnsupported(); len(unsupported) > 0 {
+			return nil, fmt.Errorf("the following features don't support checkpoints: %s", strings.Join(unsupported, ", "))
+		}
+	}
+
+	if config.HostAggregates != nil {
+		if ret.hosts, err = packet.NewHostAggregates(*config.HostAggregates); err != nil {
+			return nil, err
+		}
+	}
+
+	if config.Capture != nil {
+		if ret.capture, err = packet.NewCapture(*config.Capture); err != nil {
+			return nil, err
+		}
+	}
+
+	ret.options.WindowExpiry = config.WindowExpiry
+	ret.options.SortOutput = config.Sort
+	ret.options.SamplingRate = config.Sampling.Scale()
+
+	return ret, nil
+}
+
+// Pipeline reads packets from the sources and exports the flows. Must be created with a Builder.
+type Pipeline struct {
+	config    Config
+	exporters []flows.Exporter
+	records   flows.RecordListMaker
+	selector  packet.DynamicKeySelector
+	options   flows.FlowOptions
+	sources   packet.Sources
+	filters   packet.Filters
+	labels    packet.Labels
+	table     packet.EventTable
+	stats     packet.Stats
+	hosts     *packet.HostAggregates
+	capture   *packet.Capture
+
+	mutex   sync.Mutex
+	engine  *packet.Engine
+	started bool
+	stop    bool
+	suspend bool
+}
+
+// CallGraph writes the callgraph of the feature specifications in dot representation to w
+func (p *Pipeline) CallGraph(w io.Writer) {
+	p.records.CallGraph(w)
+}
+
+// MetadataUnsupported returns the exporters, which can't export the metadata of the measurement (e.g. the sampling
+// parameters, see flows.MetadataExporter). The metadata is lost for these exporters. Returns nil if there is no
+// metadata.
+func (p *Pipeline) MetadataUnsupported() []flows.Exporter {
+	if ies, _ := p.config.Sampling.Metadata(); ies == nil {
+		return nil
+	}
+	return metadataUnsupported(p.exporters, nil)
+}
+
+func metadataUnsupported(exporters []flows.Exporter, ret []flows.Exporter) []flows.Exporter {
+	for _, exporter := range exporters {
+		if s, ok := exporter.(*stage); ok {
+			ret = metadataUnsupported(s.exporters, ret)
+		} else if _, ok := exporter.(flows.MetadataExporter); !ok {
+			ret = append(ret, exporter)
+		}
+	}
+	return ret
+}
+
+// Stop stops reading packets. Run exports the remaining flows and returns afterwards. Can be called before or while
+// Run is executing.
+func (p *Pipeline) Stop() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.stop = true
+	if p.engine != nil {
+		p.engine.Stop()
+	}
+}
+
+// Suspend stops reading packets like Stop, but Run writes the remaining flows to the configured checkpoint file
+// instead of exporting them.
+func (p *Pipeline) Suspend() error {
+	if p.config.Checkpoint == "" {
+		return ErrNoCheckpoint
+	}
+	p.mutex.Lock()
+	p.suspend = true
+	p.mutex.Unlock()
+	p.Stop()
+	return nil
+}
+
+func (p *Pipeline) suspended() bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.suspend
+}
+
+func (p *Pipeline) resume(engine *packet.Engine) error {
+	f, err := os.Open(p.config.Resume)
+	if err != nil {
+		return fmt.Errorf("couldn't open checkpoint: %s", err)
+	}
+	defer f.Close()
+	if err := engine.Resume(bufio.NewReader(f), packet.RestoreFlow); err != nil {
+		return fmt.Errorf("couldn't restore checkpoint '%s': %s", p.config.Resume, err)
+	}
+	return nil
+}
+
+// finish exports the remaining flows (or writes them to the checkpoint) and shuts down the exporters
+func (p *Pipeline) finish(stopped flows.DateTimeNanoseconds) (err error) {
+	if p.suspended() {
+		if err = p.engine.SaveCheckpoint(p.config.Checkpoint); err != nil {
+			err = fmt.Errorf("couldn't write checkpoint: %s", err)
+			p.table.EOF(stopped)
+		} else {
+			p.table.Drop()
+		}
+	} else {
+		p.table.EOF(stopped)
+	}
+
+	p.records.Flush()
+
+	for _, exporter := range p.exporters {
+		exporter.Finish()
+	}
+
+	if p.capture != nil {
+		if captureErr := p.capture.Close(); captureErr != nil && err == nil {
+			err = fmt.Errorf("couldn't write capture: %s", captureErr)
+		}
+	}
+	return
+}
+
+// Run processes packets until every source is exhausted, Stop or Suspend is called, or ctx is done. Afterwards, the
+// remaining flows are exported and the exporters are finished. Returns the first error encountered.
+func (p *Pipeline) Run(ctx context.Context) error {
+	p.mutex.Lock()
+	if p.started {
+		p.mutex.Unlock()
+		return ErrRunning
+	}
+	p.started = true
+	p.mutex.Unlock()
+
+	for _, exporter := range p.exporters {
+		exporter.Init()
+	}
+
+	p.records.Init()
+	p.records.Clean()
+
+	p.table = packet.NewFlowTable(p.config.Tables, p.records, packet.NewFlow, p.options, p.config.ExpireInterval, p.selector, p.config.ScantFlows)
+
+	engine := packet.NewEngine(p.config.MaxPacketSize, p.config.Decoders, p.table, p.filters, p.sources, p.labels)
+	engine.SetSampling(p.config.Sampling)
+	if p.config.PassiveDNS {
+		engine.SetPassiveDNS(packet.NewPassiveDNS(p.config.PassiveDNSGrace))
+	}
+	if p.hosts != nil {
+		engine.SetHostAggregates(p.hosts)
+	}
+	if p.capture != nil {
+		engine.SetCapture(p.capture)
+	}
+	// the metadata is stamped with the time of the first packet, which is the start of the measurement
+	var metadata func(flows.DateTimeNanoseconds)
+	if ies, values := p.config.Sampling.Metadata(); ies != nil {
+		metadata = func(when flows.DateTimeNanoseconds) {
+			for _, exporter := range p.exporters {
+				if exporter, ok := exporter.(flows.MetadataExporter); ok {
+					exporter.ExportMetadata(ies, values, when)
+				}
+			}
+			metadata = nil
+		}
+		engine.OnFirstPacket(metadata)
+	}
+	if p.config.Checkpoint != "" && p.config.CheckpointInterval != 0 {
+		engine.CheckpointEvery(p.config.Checkpoint, p.config.CheckpointInterval)
+	}
+
+	p.mutex.Lock()
+	p.engine = engine
+	if p.stop {
+		engine.Stop()
+	}
+	p.mutex.Unlock()
+
+	if p.config.Resume != "" {
+		if err := p.resume(engine); err != nil {
+			engine.Finish()
+			p.table.Drop()
+			p.finish(0)
+			return err
+		}
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		var ticks <-chan time.Time
+		if p.config.Progress != nil {
+			ticker := time.NewTicker(p.config.ProgressInterval)
+			defer ticker.Stop()
+			ticks = ticker.C
+		}
+		for {
+			select {
+			case <-ctx.Done():
+				p.Stop()
+				return
+			case <-ticks:
+				packets, when := engine.Progress()
+				p.config.Progress(Progress{Packets: packets, Time: when})
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	stopped := engine.Run()
+	if metadata != nil {
+		// no packet was read
+		metadata(stopped)
+	}
+	close(done)
+	wg.Wait()
+
+	engine.Finish()
+	err := engine.Err()
+	p.stats = engine.Stats()
+
+	if p.config.InputDone != nil {
+		p.config.InputDone()
+	}
+
+	if finishErr := p.finish(stopped); err == nil {
+		err = finishErr
+	}
+	return err
+}
+
+// Stats returns the packet statistics. Must only be called after Run returned.
+func (p *Pipeline) Stats() packet.Stats {
+	return p.stats
+}
+
+// WriteStats writes the packet, decoding, and flow table statistics to w. Must only be called after Run returned.
+func (p *Pipeline) WriteStats(w io.Writer) {
+	p.mutex.Lock()
+	engine := p.engine
+	p.mutex.Unlock()
+	if engine != nil {
+		engine.PrintStats(w)
+	}
+	if p.table != nil {
+		p.table.PrintStats(w)
+	}
+}
@@ -0,0 +1,368 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	ipfix "github.com/CN-TU/go-ipfix"
+	"github.com/chtisgit/go-flows/flows"
+	_ "github.com/chtisgit/go-flows/modules/features/iana"
+	_ "github.com/chtisgit/go-flows/modules/features/operations"
+	"github.com/chtisgit/go-flows/packet"
+	"github.com/chtisgit/go-flows/spec"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+type testSource struct {
+	data  [][]byte
+	pos   int
+	err   error
+	start int64
+}
+
+func (s *testSource) ID() string { return "test" }
+func (s *testSource) Init()      {}
+func (s *testSource) Stop()      {}
+
+func (s *testSource) ReadPacket() (lt gopacket.LayerType, data []byte, ci gopacket.CaptureInfo, skipped uint64, filtered uint64, err error) {
+	if s.pos >= len(s.data) {
+		err = io.EOF
+		if s.err != nil {
+			err = s.err
+		}
+		return
+	}
+	data = s.data[s.pos]
+	ci.Timestamp = time.Unix(0, s.start+int64(s.pos)*int64(time.Millisecond))
+	ci.CaptureLength = len(data)
+	ci.Length = len(data)
+	s.pos++
+	return layers.LayerTypeIPv4, data, ci, 0, 0, nil
+}
+
+type testExporter struct {
+	mutex    sync.Mutex
+	records  [][]interface{}
+	finished bool
+}
+
+func (e *testExporter) ID() string      { return "test" }
+func (e *testExporter) Init()           {}
+func (e *testExporter) Fields([]string) {}
+func (e *testExporter) Finish()         { e.finished = true }
+
+func (e *testExporter) Export(template flows.Template, features []interface{}, when flows.DateTimeNanoseconds) {
+	e.mutex.Lock()
+	e.records = append(e.records, append([]interface{}(nil), features...))
+	e.mutex.Unlock()
+}
+
+func makeTestPackets(t *testing.T, n, flows int) [][]byte {
+	ret := make([][]byte, n)
+	for i := range ret {
+		buf := gopacket.NewSerializeBuffer()
+		ip := &layers.IPv4{Version: 4, IHL: 5, TTL: 64, Protocol: layers.IPProtocolUDP, SrcIP: []byte{10, 0, 0, byte(i % flows)}, DstIP: []byte{10, 0, 1, 1}}
+		udp := &layers.UDP{SrcPort: 1000, DstPort: 53}
+		udp.SetNetworkLayerForChecksum(ip)
+		if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true}, ip, udp); err != nil {
+			t.Fatal(err)
+		}
+		ret[i] = append([]byte(nil), buf.Bytes()...)
+	}
+	return ret
+}
+
+const testSpec = `{
+	"active_timeout": 1800,
+	"idle_timeout": 300,
+	"bidirectional": false,
+	"features": ["sourceIPAddress", "packetTotalCount"],
+	"key_features": ["sourceIPAddress", "destinationIPAddress", "protocolIdentifier", "sourceTransportPort", "destinationTransportPort"]
+}`
+
+func testSpecWithKey(t *testing.T, key ...string) Spec {
+	file, _, err := spec.Parse("test.json", []byte(testSpec), spec.FormatSimple)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ret, err := NewSpec(&file.Flows[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key != nil {
+		ret.Key = key
+	}
+	return ret
+}
+
+func TestRun(t *testing.T) {
+	exporter := &testExporter{}
+	var progress []Progress
+	p, err := New(Config{Tables: 2, Decoders: 2, Progress: func(p Progress) { progress = append(progress, p) }}).
+		Features(testSpecWithKey(t)).
+		Export(exporter).
+		Source(&testSource{data: makeTestPackets(t, 1000, 5)}).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Run(context.Background()); err != ErrRunning {
+		t.Errorf("Expected ErrRunning for second run, but got %v", err)
+	}
+	if !exporter.finished {
+		t.Error("Exporter was not finished")
+	}
+	if len(exporter.records) != 5 {
+		t.Fatalf("Expected 5 flows, but got %d", len(exporter.records))
+	}
+	for _, record := range exporter.records {
+		if record[1] != uint64(200) {
+			t.Errorf("Expected 200 packets per flow, but got %v", record[1])
+		}
+	}
+	if stats := p.Stats(); stats.Packets != 1000 {
+		t.Errorf("Expected 1000 packets in stats, but got %d", stats.Packets)
+	}
+}
+
+type wrappedExporter struct {
+	flows.Exporter
+	records int
+}
+
+func (e *wrappedExporter) Export(template flows.Template, features []interface{}, when flows.DateTimeNanoseconds) {
+	e.records++
+	e.Exporter.Export(template, features, when)
+}
+
+func TestWrapExporter(t *testing.T) {
+	exporter := &testExporter{}
+	var wrapped []*wrappedExporter
+	wrap := func(e flows.Exporter) flows.Exporter {
+		w := &wrappedExporter{Exporter: e}
+		wrapped = append(wrapped, w)
+		return w
+	}
+	p, err := New(Config{WrapExporter: wrap}).
+		Features(testSpecWithKey(t)).
+		Export(exporter).
+		Features(testSpecWithKey(t)).
+		Export(exporter).
+		Source(&testSource{data: makeTestPackets(t, 10, 5)}).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(wrapped) != 1 {
+		t.Fatalf("Expected the shared exporter to be wrapped once, but it was wrapped %d times", len(wrapped))
+	}
+	if wrapped[0].records != 10 || len(exporter.records) != 10 {
+		t.Errorf("Expected 10 records through the wrapper, but got %d (%d exported)", wrapped[0].records, len(exporter.records))
+	}
+}
+
+func TestRunSourceError(t *testing.T) {
+	exporter := &testExporter{}
+	sourceErr := errors.New("broken")
+	p, err := New(Config{}).
+		Features(testSpecWithKey(t)).
+		Export(exporter).
+		Source(&testSource{data: makeTestPackets(t, 10, 2), err: sourceErr}).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Run(context.Background()); err == nil || !strings.Contains(err.Error(), "broken") {
+		t.Errorf("Expected source error, but got %v", err)
+	}
+	if len(exporter.records) != 2 {
+		t.Errorf("Expected the flows to be exported after an error, but got %d", len(exporter.records))
+	}
+}
+
+func TestBuildErrors(t *testing.T) {
+	exporter := &testExporter{}
+	source := &testSource{}
+	tests := map[string]*Builder{
+		"exporter before features": New(Config{}).Export(exporter).Features(testSpecWithKey(t)).Source(source),
+		"missing exporter":         New(Config{}).Features(testSpecWithKey(t)).Source(source),
+		"unknown key":              New(Config{}).Features(testSpecWithKey(t, "foo")).Export(exporter).Source(source),
+		"different keys": New(Config{}).
+			Features(testSpecWithKey(t), testSpecWithKey(t, "sourceIPAddress")).
+			Export(exporter).Source(source),
+		"unknown feature": New(Config{}).
+			Features(Spec{Features: []interface{}{"foo"}, Key: []string{"sourceIPAddress"}}).
+			Export(exporter).Source(source),
+		"records of following group": New(Config{}).
+			RecordFeatures(0, testSpecWithKey(t, "sourceIPAddress")).
+			Export(exporter).Source(source),
+		"unknown record key": New(Config{}).
+			Features(testSpecWithKey(t)).Export(exporter).
+			RecordFeatures(0, testSpecWithKey(t, "protocolIdentifier")).
+			Export(&namedExporter{id: "records"}).Source(source),
+		"invalid host aggregates": New(Config{HostAggregates: &packet.HostAggregatesConfig{}}).
+			Features(testSpecWithKey(t)).Export(exporter).Source(source),
+		"filter of records": New(Config{}).
+			Features(testSpecWithKey(t)).Export(exporter).
+			RecordFeatures(0, Spec{Key: []string{"sourceIPAddress"}, Features: []interface{}{"sourceIPAddress"}, Filter: []string{"sourceIPAddress"}}).
+			Export(&namedExporter{id: "records"}).Source(source),
+	}
+	for name, builder := range tests {
+		if _, err := builder.Build(); err == nil {
+			t.Errorf("%s: Build should have failed", name)
+		}
+	}
+}
+
+type namedExporter struct {
+	testExporter
+	id string
+}
+
+func (e *namedExporter) ID() string { return e.id }
+
+func TestRecordFeatures(t *testing.T) {
+	packets := testSpecWithKey(t)
+	packets.Features = []interface{}{"sourceIPAddress", "destinationIPAddress", "packetTotalCount"}
+	hosts := Spec{
+		Key:     []string{"destinationIPAddress"},
+		Options: flows.FlowOptions{ActiveTimeout: 1800 * flows.SecondsInNanoseconds, IdleTimeout: 300 * flows.SecondsInNanoseconds},
+	}
+	for _, expr := range []string{"field('destinationIPAddress')", "sum(field('packetTotalCount'))", "count(field('sourceIPAddress'))"} {
+		feature, err := spec.ParseExpression(expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hosts.Features = append(hosts.Features, feature)
+	}
+	flowExporter := &namedExporter{id: "flows"}
+	hostExporter := &namedExporter{id: "hosts"}
+	p, err := New(Config{Tables: 2}).
+		Features(packets).
+		Export(flowExporter).
+		RecordFeatures(0, hosts).
+		Export(hostExporter).
+		Source(&testSource{data: makeTestPackets(t, 100, 5)}).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(flowExporter.records) != 5 {
+		t.Errorf("Expected 5 flows, but got %d", len(flowExporter.records))
+	}
+	if !hostExporter.finished {
+		t.Error("Exporter of the records wasn't finished")
+	}
+	if len(hostExporter.records) != 1 {
+		t.Fatalf("Expected 1 aggregated record, but got %d", len(hostExporter.records))
+	}
+	record := hostExporter.records[0]
+	if ip, ok := record[0].(net.IP); !ok || !ip.Equal(net.IP{10, 0, 1, 1}) {
+		t.Errorf("Expected destination 10.0.1.1, but got %v", record[0])
+	}
+	if record[1] != uint64(100) || record[2] != uint64(5) {
+		t.Errorf("Expected 100 packets in 5 flows, but got %v and %v", record[1], record[2])
+	}
+}
+
+func TestRecordPacketFeatures(t *testing.T) {
+	packets := testSpecWithKey(t)
+	packets.Features = []interface{}{"sourceIPAddress", "destinationIPAddress", "packetTotalCount"}
+	hosts := Spec{
+		Key:      []string{"destinationIPAddress"},
+		Features: []interface{}{"destinationIPAddress", "sourceIPAddress"},
+		Options:  flows.FlowOptions{ActiveTimeout: 1800 * flows.SecondsInNanoseconds, IdleTimeout: 300 * flows.SecondsInNanoseconds},
+	}
+	hostExporter := &namedExporter{id: "hosts"}
+	p, err := New(Config{}).
+		Features(packets).
+		Export(&namedExporter{id: "flows"}).
+		RecordFeatures(0, hosts).
+		Export(hostExporter).
+		Source(&testSource{data: makeTestPackets(t, 10, 5)}).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(hostExporter.records) != 1 {
+		t.Fatalf("Expected 1 aggregated record, but got %d", len(hostExporter.records))
+	}
+	record := hostExporter.records[0]
+	if ip, ok := record[0].(net.IP); !ok || !ip.Equal(net.IP{10, 0, 1, 1}) {
+		t.Errorf("Expected destination 10.0.1.1, but got %v", record[0])
+	}
+	if ip, ok := record[1].(net.IP); !ok || !(&net.IPNet{IP: net.IP{10, 0, 0, 0}, Mask: net.CIDRMask(24, 32)}).Contains(ip) {
+		t.Errorf("Expected the source of the first flow, but got %v", record[1])
+	}
+
+	for _, features := range [][]interface{}{{"octetTotalCount"}, {[]interface{}{"field", spec.String("octetTotalCount")}}} {
+		hosts.Features = features
+		_, err := New(Config{}).
+			Features(packets).
+			Export(&namedExporter{id: "flows"}).
+			RecordFeatures(0, hosts).
+			Export(&namedExporter{id: "hosts"}).
+			Source(&testSource{}).
+			Build()
+		if err == nil {
+			t.Errorf("Build with %v should have failed, since the records have no octetTotalCount", features)
+		}
+	}
+}
+
+type metadataExporter struct {
+	namedExporter
+	metadata []interface{}
+	when     flows.DateTimeNanoseconds
+}
+
+func (e *metadataExporter) ExportMetadata(ies []ipfix.InformationElement, values []interface{}, when flows.DateTimeNanoseconds) {
+	e.metadata = values
+	e.when = when
+}
+
+func TestSamplingMetadata(t *testing.T) {
+	exporter := &metadataExporter{namedExporter: namedExporter{id: "metadata"}}
+	plain := &namedExporter{id: "plain"}
+	source := &testSource{data: makeTestPackets(t, 10, 2)}
+	source.start = int64(100 * time.Second)
+	p, err := New(Config{Sampling: packet.Sampling{Mode: packet.SampleCount, Rate: 2}}).
+		Features(testSpecWithKey(t)).
+		Export(exporter, plain).
+		Source(source).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unsupported := p.MetadataUnsupported(); len(unsupported) != 1 || unsupported[0] != plain {
+		t.Errorf("Expected the plain exporter to be reported, but got %v", unsupported)
+	}
+	if err := p.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if exporter.metadata == nil {
+		t.Fatal("Expected sampling metadata")
+	}
+	if exporter.when != 100*flows.SecondsInNanoseconds {
+		t.Errorf("Expected the metadata at the time of the first packet, but got %d", exporter.when)
+	}
+}
@@ -0,0 +1,73 @@
+package pipeline
+
+import (
+	"fmt"
+
+	"github.com/chtisgit/go-flows/flows"
+	"github.com/chtisgit/go-flows/spec"
+)
+
+// Spec holds a single flow specification, i.e. which features are exported for flows with the given key
+type Spec struct {
+	// Features is the list of features to export; either feature names, constants, or calls ([]interface{} with the
+	// operation name as first element)
+	Features []interface{}
+	// Control is the list of control features
+	Control []string
+	// Filter is the list of filter features
+	Filter []string
+	// Key is the list of key features
+	Key []string
+	// Bidirectional specifies if packets in both directions belong to the same flow
+	Bidirectional bool
+	// AllowZero specifies if packets are accepted, where a part of the key is zero
+	AllowZero bool
+	// Options holds the timeouts and further flow options
+	Options flows.FlowOptions
+}
+
+// NewSpec converts a parsed flow specification. Included files and definitions are expanded (see spec.Flow.Expand).
+func NewSpec(flow *spec.Flow) (Spec, error) {
+	features, control, filter, err := flow.Expand()
+	if err != nil {
+		return Spec{}, err
+	}
+	return Spec{
+		Features:      features,
+		Control:       control,
+		Filter:        filter,
+		Key:           flow.KeyFeatures,
+		Bidirectional: flow.Bidirectional,
+		AllowZero:     flow.AllowZero,
+		Options: flows.FlowOptions{
+			ActiveTimeout:  seconds(flow.ActiveTimeout),
+			IdleTimeout:    seconds(flow.IdleTimeout),
+			PerPacket:      flow.PerPacket,
+			TCPExpiry:      flow.TCPExpiry(),
+			TCPLinger:      seconds(flow.TCPLinger),
+			CustomSettings: flow.Extra,
+		},
+	}, nil
+}
+
+func seconds(s float64) flows.DateTimeNanoseconds {
+	return flows.DateTimeNanoseconds(s * float64(flows.SecondsInNanoseconds))
+}
+
+// LoadSpec reads the flow specification with the given index from the file with the given name. Non-fatal problems
+// in the file are returned as warnings.
+func LoadSpec(name string, format spec.Format, selection int) (Spec, []*spec.Error, error) {
+	file, warnings, err := spec.Load(name, format)
+	if err != nil {
+		return Spec{}, warnings, err
+	}
+	flow, err := file.Select(selection)
+	if err != nil {
+		return Spec{}, warnings, fmt.Errorf("%s: %s", name, err)
+	}
+	ret, err := NewSpec(flow)
+	if err != nil {
+		return Spec{}, warnings, fmt.Errorf("%s: %s", name, err)
+	}
+	return ret, warnings, nil
+}
@@ -0,0 +1,215 @@
+package pipeline
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"reflect"
+
+	ipfix "github.com/CN-TU/go-ipfix"
+	"github.com/chtisgit/go-flows/flows"
+)
+
+// stage aggregates the records exported by a feature group again (see Builder.RecordFeatures). The stage is an
+// exporter of the source group, which hands every record as flows.RecordEvent to its own flow table. The flow table
+// exports to the exporters of the group, which are initialized and finished by the stage.
+type stage struct {
+	id        string
+	source    *flows.RecordListMaker
+	records   flows.RecordListMaker
+	key       []string
+	options   flows.FlowOptions
+	expire    flows.DateTimeNanoseconds
+	exporters []flows.Exporter
+	table     *flows.FlowTable
+	fields    map[flows.Template]map[string]int
+	buffer    []byte
+	events    uint64
+	last      flows.DateTimeNanoseconds
+	nextCheck flows.DateTimeNanoseconds
+}
+
+// recordFlow is a flow of records
+type recordFlow struct {
+	flows.BaseFlow
+}
+
+func newRecordFlow(event flows.Event, table *flows.FlowTable, key string, lowToHigh bool, context *flows.EventContext, id uint64) flows.Flow {
+	ret := new(recordFlow)
+	ret.Init(table, key, lowToHigh, context, id)
+	return ret
+}
+
+// makeStages creates the stages of the groups processing records and returns them with the exporters of every group,
+// which include the stages processing the records of the group
+func (b *Builder) makeStages(config Config) ([][]flows.Exporter, []*stage, error) {
+	exporters := make([][]flows.Exporter, len(b.groups))
+	stages := make([]*stage, len(b.groups))
+	for i, group := range b.groups {
+		exporters[i] = append(exporters[i], group.exporters...)
+		if group.from < 0 {
+			continue
+		}
+		if group.from >= i {
+			return nil, nil, fmt.Errorf("feature group %d can only process the records of a preceding group (not %d)", i, group.from)
+		}
+		if config.Checkpoint != "" || config.Resume != "" {
+			return nil, nil, errors.New("checkpoints aren't supported with feature groups processing records")
+		}
+		for _, exporter := range group.exporters {
+			for j, other := range b.groups {
+				if j != i && containsExporter(other.exporters, exporter) {
+					return nil, nil, fmt.Errorf("exporter %s of feature group %d processing records can't be shared with other groups", exporter.ID(), i)
+				}
+			}
+		}
+		stages[i] = &stage{id: fmt.Sprintf("stage%d", i), expire: config.ExpireInterval}
+		exporters[group.from] = append(exporters[group.from], stages[i])
+	}
+	return exporters, stages, nil
+}
+
+func containsExporter(list []flows.Exporter, exporter flows.Exporter) bool {
+	for _, e := range list {
+		if e == exporter {
+			return true
+		}
+	}
+	return false
+}
+
+// stagedExporter returns true if the exporter belongs to a group processing records, i.e. is handled by a stage
+func stagedExporter(groups []*exportGroup, exporter flows.Exporter) bool {
+	for _, group := range groups {
+		if group.from >= 0 && containsExporter(group.exporters, exporter) {
+			return true
+		}
+	}
+	return false
+}
+
+// build compiles the specifications of the stage, which processes the records of source and exports to exporters
+func (s *stage) build(specs []Spec, exporters []flows.Exporter, source *flows.RecordListMaker, config Config) error {
+	pipe, err := flows.MakeExportPipeline(exporters, config.Sort, 1)
+	if err != nil {
+		return err
+	}
+	fields := flows.MakeRecordFields(*source)
+	for i, spec := range specs {
+		if spec.Bidirectional {
+			return errors.New("bidirectional flows aren't supported for records")
+		}
+		for _, name := range spec.Key {
+			if _, ok := fields[name]; !ok {
+				return fmt.Errorf("key feature %s isn't a field of the processed records", name)
+			}
+		}
+		if i == 0 {
+			s.key = spec.Key
+			s.options = spec.Options
+		} else if !reflect.DeepEqual(s.key, spec.Key) || !reflect.DeepEqual(s.options, spec.Options) {
+			return errors.New("key_features, timeouts, and per packet of every flowspec processing the same records must match")
+		}
+		if err := s.records.AppendRecordOfRecords(spec.Features, spec.Control, spec.Filter, fields, pipe, config.Verbose); err != nil {
+			return fmt.Errorf("couldn't parse feature specification: %s", err)
+		}
+	}
+	s.source = source
+	s.exporters = exporters
+	s.options.SortOutput = config.Sort
+	return nil
+}
+
+func (s *stage) ID() string { return s.id }
+
+// Init initializes the exporters and creates the flow table
+func (s *stage) Init() {
+	for _, exporter := range s.exporters {
+		exporter.Init()
+	}
+	s.records.Init()
+	s.records.Clean()
+	s.table = flows.NewFlowTable(s.records, newRecordFlow, s.options, false, 0)
+	s.fields = make(map[flows.Template]map[string]int)
+}
+
+// Fields does nothing, since the names of the fields are looked up by template
+func (s *stage) Fields([]string) {}
+
+// fieldIndex returns the indices of the fields of the given template by name
+func (s *stage) fieldIndex(template flows.Template) map[string]int {
+	if fields, ok := s.fields[template]; ok {
+		return fields
+	}
+	fields := make(map[string]int)
+	for i, name := range s.source.Fields(template.ID()) {
+		fields[name] = i
+	}
+	s.fields[template] = fields
+	return fields
+}
+
+// makeKey returns the flow key of the record consisting of the values of the key fields or false if a key field is
+// missing
+func (s *stage) makeKey(features []interface{}, fields map[string]int) ([]byte, bool) {
+	key := s.buffer[:0]
+	for _, name := range s.key {
+		i, ok := fields[name]
+		if !ok || i >= len(features) || features[i] == nil {
+			return nil, false
+		}
+		var value []byte
+		switch v := features[i].(type) {
+		case []byte:
+			value = v
+		case string:
+			value = []byte(v)
+		default:
+			value = []byte(fmt.Sprint(v))
+		}
+		var length [binary.MaxVarintLen64]byte
+		key = append(key, length[:binary.PutUvarint(length[:], uint64(len(value)))]...)
+		key = append(key, value...)
+	}
+	s.buffer = key
+	return key, true
+}
+
+// Export hands the record to the flow table. Records without every key field are dropped.
+func (s *stage) Export(template flows.Template, features []interface{}, when flows.DateTimeNanoseconds) {
+	fields := s.fieldIndex(template)
+	key, ok := s.makeKey(features, fields)
+	if !ok {
+		return
+	}
+	// records aren't necessarily exported in order (e.g. without sorting), but time must not go backwards
+	if when > s.last {
+		s.last = when
+	}
+	if s.last > s.nextCheck {
+		if s.nextCheck != 0 {
+			s.table.Expire(s.last)
+		}
+		s.nextCheck = s.last + s.expire
+	}
+	s.events++
+	s.table.Event(flows.NewRecordEvent(template, features, fields, key, s.last, s.events))
+}
+
+// ExportMetadata forwards the metadata to the exporters of the stage, which support it
+func (s *stage) ExportMetadata(ies []ipfix.InformationElement, values []interface{}, when flows.DateTimeNanoseconds) {
+	for _, exporter := range s.exporters {
+		if exporter, ok := exporter.(flows.MetadataExporter); ok {
+			exporter.ExportMetadata(ies, values, when)
+		}
+	}
+}
+
+// Finish exports the remaining flows and finishes the exporters
+func (s *stage) Finish() {
+	s.table.EOF(s.last)
+	s.records.Flush()
+	for _, exporter := range s.exporters {
+		exporter.Finish()
+	}
+}
@@ -1,24 +1,28 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
 	"os"
 	"os/signal"
-	"reflect"
 	"runtime/debug"
 	"runtime/pprof"
-	"sort"
 	"strings"
+	"syscall"
 
 	"github.com/chtisgit/go-flows/flows"
+	"github.com/chtisgit/go-flows/modules/exporters/pcap"
+	"github.com/chtisgit/go-flows/modules/features/anonymize"
 	"github.com/chtisgit/go-flows/packet"
+	"github.com/chtisgit/go-flows/pipeline"
+	"github.com/chtisgit/go-flows/spec"
 	"github.com/chtisgit/go-flows/util"
 )
 
 func tableUsage(cmd string, tableset *flag.FlagSet) {
-	main := "features [featureargs] spec.json [features ...] [export type [exportargs]] [filter type [filterargs]] [label type [filterargs]] input type [inputargs] [...]"
+	main := "features [featureargs] spec.json [features ...] [export type [exportargs]] [filter type [filterargs]] [label [-name name] type [labelargs]] input type [inputargs] [...]"
 	switch cmd {
 	case "callgraph":
 		cmdString(fmt.Sprintf("%s %s", cmd, main))
@@ -45,6 +49,11 @@ must accept the packet - otherwise it is ignored. If a filter rejects a
 packet, the remaining filters are not considered.
 
 If multiple labels are specified, they are used like with multiple sources.
+Labels can be given a name with "label -name name type [labelargs]". Then
+every name is evaluated for every packet, labels with the same name are used
+like with multiple sources, and the feature __label("name") returns the label
+of the given name (__label returns the label of the labels without a name).
+Use one __label("name") per name in the features to get one column per label.
 
 At least one feature specification and one exporter is needed.
 
@@ -63,12 +72,17 @@ Examples:
   Export the feature sets a.json and b.json to a.csv and b.csv
     %s %s features a.json export csv a.csv features b.json export b.csv source [sourcetype ...]
 
+  Aggregate the flows of flows.json again per source address (hosts.json
+  with "key_features": ["sourceIPAddress"] and features like
+  "sum(field('octetTotalCount'))") and export both
+    %s %s features flows.json export csv flows.csv features -from 0 hosts.json export csv hosts.csv source [sourcetype ...]
+
   Export the feature sets a.json and b.json to a single common.csv (this
   results in a csv with features from a in the odd lines, and features
   from b in the even lines)
     %s %s features a.json features b.json export common.csv source [sourcetype ...]
 
-`, os.Args[0], cmd, os.Args[0], cmd, os.Args[0], cmd, os.Args[0], cmd)
+`, os.Args[0], cmd, os.Args[0], cmd, os.Args[0], cmd, os.Args[0], cmd, os.Args[0], cmd)
 	flags()
 	fmt.Fprintln(os.Stderr, "\nArgs:")
 	tableset.PrintDefaults()
@@ -79,7 +93,7 @@ func init() {
 	addCommand("callgraph", "Create a callgraph from a flowspecification", parseArguments)
 }
 
-func parseFeatures(cmd string, args []string) (arguments []string, features []interface{}, control, filter, key []string, bidirectional, allowZero bool, opt flows.FlowOptions) {
+func parseFeatures(cmd string, args []string) (arguments []string, flowspec pipeline.Spec, from int) {
 	set := flag.NewFlagSet("features", flag.ExitOnError)
 	set.Usage = func() {
 		fmt.Fprint(os.Stderr, `
@@ -95,6 +109,7 @@ Args:
 	selection := set.Uint("select", 0, "Use nth flow selection (key:nth flow in specification)")
 	v2 := set.Bool("v2", false, "Force v2 format")
 	simple := set.Bool("simple", false, "Treat file as if it only contains the flow specification")
+	fromGroup := set.Int("from", -1, "Process the records exported by the nth feature group (counting from 0) instead of packets; fields of the records are accessed with field(\"name\")")
 	set.Parse(args)
 	if *v2 && *simple {
 		log.Fatalf("Only one of -v2, or -simple can be chosen\n")
@@ -104,45 +119,40 @@ Args:
 	}
 	arguments = set.Args()[1:]
 
-	format := jsonAuto
+	format := spec.FormatAuto
 	switch {
 	case *v2:
-		format = jsonV2
+		format = spec.FormatV2
 	case *simple:
-		format = jsonSimple
+		format = spec.FormatSimple
 	}
 
-	features, control, filter, key, bidirectional, allowZero, opt = decodeJSON(set.Arg(0), format, int(*selection))
-	if features == nil {
-		log.Fatalf("Couldn't parse %s (%d) - features missing\n", set.Arg(0), *selection)
+	from = *fromGroup
+	flowspec, warnings, err := pipeline.LoadSpec(set.Arg(0), format, int(*selection))
+	for _, warning := range warnings {
+		log.Printf("Warning: %s\n", warning)
 	}
-	if key == nil {
-		log.Fatalf("Couldn't parse %s (%d) - flow key missing\n", set.Arg(0), *selection)
+	if err != nil {
+		printSpecError(err)
 	}
 	return
 }
 
-type featureSpec struct {
-	features      []interface{}
-	control       []string
-	filter        []string
-	key           []string
-	bidirectional bool
-	allowZero     bool
-	opt           flows.FlowOptions
-}
-
-type exportedFeatures struct {
-	exporter   []flows.Exporter
-	featureset []featureSpec
+// printSpecError prints all the errors of a specification file and exits
+func printSpecError(err error) {
+	if list, ok := err.(spec.ErrorList); ok {
+		for _, e := range list {
+			log.Printf("%s\n", e)
+		}
+		log.Fatalf("Couldn't parse flow specification (%d errors)\n", len(list))
+	}
+	log.Fatalf("Couldn't parse %s\n", err)
 }
 
-func parseCommandLine(cmd string, args []string) (result []exportedFeatures, exporters map[string]flows.Exporter, filters packet.Filters, sources packet.Sources, labels packet.Labels) {
-	var featureset []featureSpec
+func parseCommandLine(cmd string, args []string, builder *pipeline.Builder) {
 	clear := false
+	features := 0
 	var firstexporter []string
-	exporters = make(map[string]flows.Exporter)
-	var exportset []flows.Exporter
 	var err error
 	for len(args) >= 2 {
 		typ := args[0]
@@ -150,67 +160,68 @@ func parseCommandLine(cmd string, args []string) (result []exportedFeatures, exp
 		switch typ {
 		case "features":
 			if clear {
-				if len(featureset) == 0 {
+				if features == 0 {
 					log.Fatalf("At least one feature is needed for '%s'\n", strings.Join(firstexporter, " "))
 				}
 				firstexporter = nil
 				clear = false
-				result = append(result, exportedFeatures{exportset, featureset})
-				featureset = nil
-				exportset = nil
+				features = 0
+			}
+			var flowspec pipeline.Spec
+			var from int
+			args, flowspec, from = parseFeatures(cmd, args[1:])
+			if from >= 0 {
+				builder.RecordFeatures(from, flowspec)
+			} else {
+				builder.Features(flowspec)
 			}
-			var f featureSpec
-			args, f.features, f.control, f.filter, f.key, f.bidirectional, f.allowZero, f.opt = parseFeatures(cmd, args[1:])
-			featureset = append(featureset, f)
+			features++
 		case "export":
 			if firstexporter == nil {
 				firstexporter = args
 			}
-			if len(args) < 1 {
-				log.Fatalln("Need an export type")
+			if features == 0 && !clear {
+				log.Fatalf("At least one feature is needed for '%s'\n", strings.Join(firstexporter, " "))
 			}
 			var e flows.Exporter
 			args, e, err = flows.MakeExporter(name, args[2:])
 			if err != nil {
 				log.Fatalf("Error creating exporter '%s': %s\n", name, err)
 			}
-			if existing, ok := exporters[e.ID()]; ok {
-				e = existing
-			} else {
-				exporters[e.ID()] = e
-			}
-			exportset = append(exportset, e)
+			builder.Export(e)
 			clear = true
 		case "source":
-			if len(args) < 1 {
-				log.Fatalln("Need a source type")
-			}
 			var s packet.Source
 			args, s, err = packet.MakeSource(name, args[2:])
 			if err != nil {
 				log.Fatalf("Error creating source '%s': %s\n", name, err)
 			}
-			sources.Append(s)
+			builder.Source(s)
 		case "filter":
-			if len(args) < 1 {
-				log.Fatalln("Need a filter type")
-			}
 			var s packet.Filter
 			args, s, err = packet.MakeFilter(name, args[2:])
 			if err != nil {
 				log.Fatalf("Error creating filter '%s': %s\n", name, err)
 			}
-			filters = append(filters, s)
+			builder.Filter(s)
 		case "label":
-			if len(args) < 1 {
-				log.Fatalln("Need a label type")
+			labelName := ""
+			if name == "-name" {
+				if len(args) < 4 {
+					log.Fatalf("label -name needs a name and a label type\n")
+				}
+				labelName, name, args = args[2], args[3], args[2:]
 			}
 			var s packet.Label
 			args, s, err = packet.MakeLabel(name, args[2:])
 			if err != nil {
 				log.Fatalf("Error creating label '%s': %s\n", name, err)
 			}
-			labels = append(labels, s)
+			if labelName == "" {
+				builder.Label(s)
+			} else {
+				builder.NamedLabel(labelName, s)
+			}
 		default:
 			log.Fatalf("Command (features, export, source, label, filter) missing, instead found '%s'\n", strings.Join(args, " "))
 		}
@@ -218,20 +229,16 @@ func parseCommandLine(cmd string, args []string) (result []exportedFeatures, exp
 	if len(args) > 0 {
 		log.Fatalf("Argument at end of input to '%s' is missing!\n", args[0])
 	}
-
-	if clear {
-		if len(featureset) == 0 {
-			log.Fatalf("At least one feature is needed for '%s'\n", strings.Join(firstexporter, " "))
-		}
-		result = append(result, exportedFeatures{exportset, featureset})
+	if !clear {
+		log.Fatalf("At least one exporter is needed!\n")
 	}
-	return
 }
 
 func parseArguments(cmd string, args []string) {
 	set := flag.NewFlagSet("table", flag.ExitOnError)
 	set.Usage = func() { tableUsage(cmd, set) }
 	numProcessing := set.Uint("n", 4, "Number of parallel processing tables")
+	numDecoders := set.Uint("decoders", 1, "Number of parallel packet decoding workers")
 	expireWindow := set.Bool("expireWindow", false, "Expire all flows after every window. Useful if flow key contains a window function")
 	flowExpire := set.Uint("expire", 100, "Check for expired timers with this period in seconds. expire↓ ⇒ memory↓, execution time↑")
 	maxPacket := set.Uint("size", 9000, "Maximum packet size handled internally. 0 = automatic")
@@ -242,6 +249,24 @@ Beware: Worst case performance of start/stop sorting is O(1), while expiry is O(
 Both need an additional O(flow) merge part if multiple tables are used.
 Additionally, stop might lead to very high memory usage (and longer execution times) in case one long lasting flow keeps all other flows from expiring (active/idle timeout!).`)
 	verbose := set.Bool("verbose", false, "Verbose output")
+	checkpoint := set.String("checkpoint", "", "Write the state of the active flows to this file on SIGTERM instead of exporting them")
+	checkpointInterval := set.Uint("checkpointInterval", 0, "Additionally write the checkpoint with this period in seconds (packet time). 0 = only on SIGTERM")
+	resume := set.String("resume", "", "Restore the active flows from this checkpoint file before processing packets")
+	samplingStr := set.String("sampling", "", `Only process a sample of the packets. Format is mode:N[:seed] with mode being
+"count" (every Nth packet), "random" (every packet with probability 1/N), or "flow" (all packets of 1/N of the flows)`)
+	passiveDNS := set.Bool("passiveDNS", false, "Learn hostnames from DNS responses (needed for sourceHostnameFromDNS and destinationHostnameFromDNS)")
+	passiveDNSGrace := set.Uint("passiveDNSGrace", 0, "Keep hostnames learned from DNS responses this many seconds longer than the TTL")
+	hostWindow := set.Uint("hostWindow", 0, "Compute host aggregates over all flows in windows of this many seconds (needed for sourceHost* and destinationHost* features; 0 = disabled)")
+	hostSlots := set.Uint("hostSlots", 6, "Number of slots the host window is split into; the window slides by one slot (1 = tumbling window)")
+	hostKey := set.String("hostKey", "address", "Comma separated list of fields identifying a host: address, mac, vlan")
+	hostPrefix4 := set.Uint("hostPrefix4", 32, "Aggregate IPv4 hosts by this prefix length")
+	hostPrefix6 := set.Uint("hostPrefix6", 128, "Aggregate IPv6 hosts by this prefix length")
+	anonymizeExport := set.Bool("anonymize", false, "Anonymize every IP and MAC address in the exported records and written packets (needs -anonymizeKey)")
+	anonymizePorts := set.Bool("anonymizePorts", false, "Additionally anonymize transport ports in the exported records and written packets")
+	capture := set.String("capture", "", "Write the packets of the flows selected by the control expressions and filters to this pcapng file")
+	capturePackets := set.Uint("capturePackets", packet.DefaultCapturePackets, "Number of packets kept per flow for -capture before the flow is selected")
+	captureBytes := set.Uint("captureBytes", packet.DefaultCaptureBytes, "Number of bytes kept per flow for -capture before the flow is selected")
+	captureMemory := set.Uint("captureMemory", packet.DefaultCaptureMemory>>20, "Maximum memory in MiB used by the packets kept for -capture of all flows")
 
 	set.Parse(args)
 	if set.NArg() == 0 {
@@ -253,127 +278,138 @@ Additionally, stop might lead to very high memory usage (and longer execution ti
 		log.Fatalln("Need at least one flow processing table!")
 	}
 
-	var result []exportedFeatures
-	var exporters map[string]flows.Exporter
-	var filters packet.Filters
-	var sources packet.Sources
-	var labels packet.Labels
-
-	result, exporters, filters, sources, labels = parseCommandLine(cmd, set.Args())
-
-	if len(result) == 0 {
-		log.Fatalf("At least one exporter is needed!\n")
+	if *numDecoders == 0 {
+		log.Fatalln("Need at least one packet decoding worker!")
 	}
 
-	var recordList flows.RecordListMaker
-
-	var key []string
-	var bidirectional, allowZero bool
-	var opts flows.FlowOptions
-	first := true
-
 	sortOrder, err := flows.AtoSort(*sortOrderStr)
 	if err != nil {
 		log.Fatalln(err)
 	}
 
-	for _, featureset := range result {
-		pipeline, err := flows.MakeExportPipeline(featureset.exporter, sortOrder, *numProcessing)
+	sampling, err := packet.ParseSampling(*samplingStr)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	config := pipeline.Config{
+		Tables:             int(*numProcessing),
+		Decoders:           int(*numDecoders),
+		MaxPacketSize:      int(*maxPacket),
+		ExpireInterval:     flows.DateTimeNanoseconds(*flowExpire) * flows.SecondsInNanoseconds,
+		WindowExpiry:       *expireWindow,
+		Sort:               sortOrder,
+		ScantFlows:         *autoGC,
+		Verbose:            *verbose,
+		Sampling:           sampling,
+		PassiveDNS:         *passiveDNS,
+		PassiveDNSGrace:    flows.DateTimeNanoseconds(*passiveDNSGrace) * flows.SecondsInNanoseconds,
+		Checkpoint:         *checkpoint,
+		CheckpointInterval: flows.DateTimeNanoseconds(*checkpointInterval) * flows.SecondsInNanoseconds,
+		Resume:             *resume,
+	}
+	if *maxPacket == 0 {
+		config.MaxPacketSize = -1
+	}
+	if *hostWindow != 0 {
+		config.HostAggregates = &packet.HostAggregatesConfig{
+			Window:  flows.DateTimeNanoseconds(*hostWindow) * flows.SecondsInNanoseconds,
+			Slots:   int(*hostSlots),
+			Key:     strings.Split(*hostKey, ","),
+			Prefix4: int(*hostPrefix4),
+			Prefix6: int(*hostPrefix6),
+		}
+	}
+	if *anonymizeExport || *anonymizePorts {
+		if !anonymize.Loaded() {
+			log.Fatalln("-anonymize needs a key (use -anonymizeKey)")
+		}
+		config.WrapExporter = func(e flows.Exporter) flows.Exporter {
+			return anonymize.WrapExporter(e, *anonymizePorts)
+		}
+	}
+	if *capture != "" {
+		var key *anonymize.CryptoPAn
+		if *anonymizeExport || *anonymizePorts {
+			key = anonymize.Key()
+		}
+		writer, err := pcap.NewCaptureWriter(*capture, key, *anonymizePorts)
 		if err != nil {
-			log.Fatalln(err)
+			log.Fatalln("Couldn't create capture:", err)
 		}
-		for _, feature := range featureset.featureset {
-			sort.Strings(feature.key)
-			if first {
-				first = false
-				key = feature.key
-				bidirectional = feature.bidirectional
-				allowZero = feature.allowZero
-				opts = feature.opt
-			} else {
-				if !reflect.DeepEqual(key, feature.key) {
-					log.Fatalln("key_features of every flowspec must match")
-				}
-				if bidirectional != feature.bidirectional {
-					log.Fatalln("bidirectional of every flow must match")
-				}
-				if allowZero != feature.allowZero {
-					log.Fatalln("allowZero of every flow must match")
-				}
-				if !reflect.DeepEqual(opts, feature.opt) {
-					log.Fatalln("timeouts and per packet of every flow must match")
-				}
+		config.Capture = &packet.CaptureConfig{
+			Writer:  writer,
+			Packets: int(*capturePackets),
+			Bytes:   int(*captureBytes),
+			Memory:  int64(*captureMemory) << 20,
+		}
+	}
+	if *heapprofile != "" {
+		config.InputDone = func() {
+			f, err := os.Create(*heapprofile)
+			if err != nil {
+				log.Fatalln("could not create memory profile: ", err)
 			}
-			if err := recordList.AppendRecord(feature.features, feature.control, feature.filter, pipeline, *verbose); err != nil {
-				log.Fatalf("Couldn't parse feature specification: %s\n", err)
+			if err := pprof.WriteHeapProfile(f); err != nil {
+				log.Fatalln("could not write memory profile: ", err)
 			}
+			f.Close()
 		}
 	}
 
-	keyselector := packet.MakeDynamicKeySelector(key, bidirectional, allowZero)
+	builder := pipeline.New(config)
+	parseCommandLine(cmd, set.Args(), builder)
+
+	p, err := builder.Build()
+	if err != nil {
+		log.Fatalln(err)
+	}
 
 	if cmd == "callgraph" {
-		recordList.CallGraph(os.Stdout)
+		p.CallGraph(os.Stdout)
 		return
 	}
 
-	for _, exporter := range exporters {
-		exporter.Init()
+	for _, exporter := range p.MetadataUnsupported() {
+		log.Printf("Warning: exporter %s can't export the sampling parameters\n", exporter.ID())
 	}
 
-	recordList.Init()
-
 	flows.CleanupFeatures()
 	util.CleanupModules()
-	recordList.Clean()
 
 	if !*autoGC {
 		debug.SetGCPercent(10000000) //We manually call gc after timing out flows; make that optional?
 	}
 
-	opts.WindowExpiry = *expireWindow
-	opts.SortOutput = sortOrder
-
-	flowtable := packet.NewFlowTable(int(*numProcessing), recordList, packet.NewFlow, opts,
-		flows.DateTimeNanoseconds(*flowExpire)*flows.SecondsInNanoseconds, keyselector, *autoGC)
-
-	engine := packet.NewEngine(int(*maxPacket), flowtable, filters, sources, labels)
-
 	cancel := make(chan os.Signal, 1)
 	signal.Notify(cancel, os.Interrupt)
+	if *checkpoint != "" {
+		signal.Notify(cancel, syscall.SIGTERM)
+	}
 
 	go func() {
-		<-cancel
-		log.Println("Canceling...")
-		engine.Stop()
+		if <-cancel == syscall.SIGTERM {
+			log.Println("Suspending...")
+			p.Suspend()
+		} else {
+			log.Println("Canceling...")
+			p.Stop()
+		}
 	}()
 
-	stopped := engine.Run()
+	err = p.Run(context.Background())
 
 	signal.Stop(cancel)
 
-	engine.Finish()
-
-	if *heapprofile != "" {
-		f, err := os.Create(*heapprofile)
-		if err != nil {
-			log.Fatalln("could not create memory profile: ", err)
-		}
-		if err := pprof.WriteHeapProfile(f); err != nil {
-			log.Fatalln("could not write memory profile: ", err)
-		}
-		f.Close()
+	if err != nil {
+		log.Println(err)
 	}
 
-	flowtable.EOF(stopped)
-
-	recordList.Flush()
-
-	for _, exporter := range exporters {
-		exporter.Finish()
-	}
 	if *printStats {
-		engine.PrintStats(os.Stderr)
-		flowtable.PrintStats(os.Stderr)
+		p.WriteStats(os.Stderr)
+	}
+
+	if err != nil {
+		os.Exit(1)
 	}
 }
@@ -0,0 +1,150 @@
+package spec
+
+import (
+	"fmt"
+	"strings"
+)
+
+// aliasOperation is the call name for aliases in JSON ({"as": [feature, "name"]})
+const aliasOperation = "as"
+
+// Alias sets the export name of a feature. Aliases are only allowed directly in the feature list. In expressions
+// this is written as "feature as name".
+type Alias struct {
+	Feature interface{}
+	Name    string
+}
+
+// expander replaces references to definitions
+type expander struct {
+	definitions map[string]interface{}
+}
+
+func (e *expander) collect(flow *Flow) {
+	for _, included := range flow.Included {
+		if included != nil {
+			e.collect(included)
+		}
+	}
+	for name, definition := range flow.Definitions {
+		e.definitions[name] = definition
+	}
+}
+
+// newExpander returns an expander for the definitions of flow and the included files. Definitions in the including
+// file replace the ones from included files.
+func newExpander(flow *Flow) *expander {
+	e := &expander{definitions: make(map[string]interface{})}
+	e.collect(flow)
+	return e
+}
+
+func checkCycle(name string, stack []string) error {
+	for i, s := range stack {
+		if s == name {
+			return fmt.Errorf("definition cycle: %s -> %s", strings.Join(stack[i:], " -> "), name)
+		}
+	}
+	return nil
+}
+
+// expand replaces all the references in feature. top is true for features in the feature list. Top level references
+// are exported with the name of the definition, unless they contain an alias.
+func (e *expander) expand(feature interface{}, top bool, stack []string) (interface{}, error) {
+	switch feature := feature.(type) {
+	case string:
+		definition, ok := e.definitions[feature]
+		if !ok {
+			return feature, nil
+		}
+		if err := checkCycle(feature, stack); err != nil {
+			return nil, err
+		}
+		ret, err := e.expand(definition, top, append(stack, feature))
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := ret.(Alias); top && !ok {
+			ret = Alias{Feature: ret, Name: feature}
+		}
+		return ret, nil
+	case []interface{}:
+		if len(feature) == 0 {
+			return nil, fmt.Errorf("call must not be empty")
+		}
+		ret := make([]interface{}, len(feature))
+		ret[0] = feature[0]
+		for i, arg := range feature[1:] {
+			var err error
+			if ret[i+1], err = e.expand(arg, false, stack); err != nil {
+				return nil, err
+			}
+		}
+		return ret, nil
+	case Alias:
+		if !top {
+			return nil, fmt.Errorf("alias %s is only allowed for exported features", feature.Name)
+		}
+		inner, err := e.expand(feature.Feature, false, stack)
+		if err != nil {
+			return nil, err
+		}
+		return Alias{Feature: inner, Name: feature.Name}, nil
+	}
+	return feature, nil
+}
+
+// name resolves references in control and filter lists, which only allow feature names
+func (e *expander) name(name string, stack []string) (string, error) {
+	definition, ok := e.definitions[name]
+	if !ok {
+		return name, nil
+	}
+	if err := checkCycle(name, stack); err != nil {
+		return "", err
+	}
+	s, ok := definition.(string)
+	if !ok {
+		return "", fmt.Errorf("definition %s is not a feature name and can't be used as control or filter feature", name)
+	}
+	return e.name(s, append(stack, name))
+}
+
+func (e *expander) appendLists(flow *Flow, features []interface{}, control, filter []string) ([]interface{}, []string, []string, error) {
+	for i, included := range flow.Included {
+		if included == nil {
+			return nil, nil, nil, fmt.Errorf("included file %s wasn't loaded", flow.Include[i])
+		}
+		var err error
+		if features, control, filter, err = e.appendLists(included, features, control, filter); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	for _, feature := range flow.Features {
+		expanded, err := e.expand(feature, true, nil)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("feature #%d: %s", len(features)+1, err)
+		}
+		features = append(features, expanded)
+	}
+	for _, list := range []struct {
+		in  []string
+		out *[]string
+	}{{flow.ControlFeatures, &control}, {flow.FilterFeatures, &filter}} {
+		for _, name := range list.in {
+			expanded, err := e.name(name, nil)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			*list.out = append(*list.out, expanded)
+		}
+	}
+	return features, control, filter, nil
+}
+
+// Expand returns the feature, control, and filter lists with the lists of the included files prepended, and with
+// every reference to a definition replaced by the definition. A definition referenced directly in the feature list is
+// exported with the name of the definition.
+func (f *Flow) Expand() (features []interface{}, control, filter []string, err error) {
+	return newExpander(f).appendLists(f, nil, nil, nil)
+}
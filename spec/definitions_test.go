@@ -0,0 +1,97 @@
+package spec
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const definitionsFlow = `{"active_timeout": 1, "idle_timeout": 1, "bidirectional": false, "key_features": [],
+"include": ["testdata/base.json"],
+"definitions": {"packets": "packetDeltaCount", "perPacket": "bytes / packets", "named": "max(bytes) as maxBytes"},
+"features": ["perPacket", "perPacket * 8 as bitsPerPacket", "named", "bytes"]}`
+
+func TestExpand(t *testing.T) {
+	file, _, err := Parse("spec.json", []byte(definitionsFlow), FormatAuto)
+	if err != nil {
+		t.Fatal(err)
+	}
+	features, control, filter, err := file.Flows[0].Expand()
+	if err != nil {
+		t.Fatal(err)
+	}
+	perPacket := []interface{}{"divide", "octetTotalCount", "packetDeltaCount"}
+	expected := []interface{}{
+		"sourceIPAddress",
+		Alias{Feature: perPacket, Name: "perPacket"},
+		Alias{Feature: []interface{}{"multiply", perPacket, int64(8)}, Name: "bitsPerPacket"},
+		Alias{Feature: []interface{}{"max", "octetTotalCount"}, Name: "maxBytes"},
+		Alias{Feature: "octetTotalCount", Name: "bytes"},
+	}
+	if !reflect.DeepEqual(features, expected) {
+		t.Errorf("Expected features\n%#v\nbut got\n%#v", expected, features)
+	}
+	if !reflect.DeepEqual(control, []string{"flowStartNanoseconds"}) || filter != nil {
+		t.Errorf("Unexpected control %v or filter %v", control, filter)
+	}
+
+	encoded, err := file.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, _, err := Parse("spec.json", encoded, FormatAuto)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(file, decoded) {
+		t.Errorf("Round trip mismatch:\n%#v\n%#v", file, decoded)
+	}
+}
+
+func TestDefinitionErrors(t *testing.T) {
+	flow := func(rest string) string {
+		return `{"active_timeout": 1, "idle_timeout": 1, "bidirectional": false, "key_features": [],
+` + rest + `}`
+	}
+	tests := []struct {
+		name   string
+		input  string
+		errors []string
+	}{
+		{"cycle", flow(`"definitions": {"a": "b + 1", "b": "mean(a)"}, "features": ["a"]`),
+			[]string{
+				"spec.json:2:61: features[0]: definition cycle: a -> b -> a",
+				"spec.json:2:22: definitions.a: definition cycle: a -> b -> a",
+				"spec.json:2:36: definitions.b: definition cycle: b -> a -> b",
+			}},
+		{"nested alias", flow(`"features": ["mean(x as y)"]`),
+			[]string{"spec.json:2:22: features[0]: in expression \"mean(x as y)\": expected ',', but found 'as'"}},
+		{"nested alias definition", flow(`"definitions": {"y": "x as z"}, "features": ["mean(y)"]`),
+			[]string{"spec.json:2:46: features[0]: alias z is only allowed for exported features"}},
+		{"control expression", flow(`"definitions": {"y": "mean(x)"}, "features": [], "_control_features": ["y"]`),
+			[]string{"spec.json:2:72: _control_features[0]: definition y is not a feature name and can't be used as control or filter feature"}},
+		{"definition name", flow(`"definitions": {"a-b": "x"}, "features": []`),
+			[]string{"spec.json:2:17: definitions.a-b: definition name a-b must be an identifier"}},
+		{"include cycle", flow(`"include": ["testdata/cycle.json"], "features": []`),
+			[]string{"testdata/cycle.json:2:17: include[0]: include cycle: testdata/cycle.json includes itself"}},
+		{"include key", flow(`"include": ["testdata/timeout.json"], "features": []`),
+			[]string{"testdata/timeout.json:2:5: active_timeout: active_timeout is not allowed in included files"}},
+		{"missing include", flow(`"include": ["testdata/missing.json"], "features": []`),
+			[]string{"spec.json:2:13: include[0]: open testdata/missing.json: no such file or directory"}},
+	}
+	for _, test := range tests {
+		_, _, err := Parse("spec.json", []byte(test.input), FormatAuto)
+		list, ok := err.(ErrorList)
+		if !ok {
+			t.Errorf("%s: expected ErrorList, but got %v", test.name, err)
+			continue
+		}
+		var got []string
+		for _, e := range list {
+			got = append(got, e.Error())
+		}
+		if !reflect.DeepEqual(got, test.errors) {
+			t.Errorf("%s: expected errors\n%s\nbut got\n%s", test.name, strings.Join(test.errors, "\n"), strings.Join(got, "\n"))
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package spec
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Error is an error or warning in a specification file
+type Error struct {
+	// Filename is the name of the file (can be empty)
+	Filename string
+	// Position is the line and column of the offending value
+	Position Position
+	// Path is the JSON path of the offending value, e.g. preprocessing.flows[0].features[3]
+	Path string
+	// Message describes the problem
+	Message string
+}
+
+func (e *Error) Error() string {
+	var sb strings.Builder
+	if e.Filename != "" {
+		sb.WriteString(e.Filename)
+		sb.WriteByte(':')
+	}
+	sb.WriteString(e.Position.String())
+	sb.WriteString(": ")
+	if e.Path != "" {
+		sb.WriteString(e.Path)
+		sb.WriteString(": ")
+	}
+	sb.WriteString(e.Message)
+	return sb.String()
+}
+
+// ErrorList is a list of errors. Parse returns all the errors found in a file as ErrorList.
+type ErrorList []*Error
+
+func (l ErrorList) Error() string {
+	switch len(l) {
+	case 0:
+		return "no errors"
+	case 1:
+		return l[0].Error()
+	}
+	return fmt.Sprintf("%s (and %d more errors)", l[0], len(l)-1)
+}
+
+// Err returns nil if the list is empty, or the list otherwise
+func (l ErrorList) Err() error {
+	if len(l) == 0 {
+		return nil
+	}
+	return l
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func indexPath(path string, i int) string {
+	return fmt.Sprintf("%s[%d]", path, i)
+}
+
+// levenshtein returns the edit distance between a and b
+func levenshtein(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = prev[j-1] + cost
+			if prev[j]+1 < cur[j] {
+				cur[j] = prev[j] + 1
+			}
+			if cur[j-1]+1 < cur[j] {
+				cur[j] = cur[j-1] + 1
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// suggest returns the known key closest to key, or "" if nothing is close enough
+func suggest(key string, known []string) string {
+	best := ""
+	bestDistance := 3
+	for _, k := range known {
+		if strings.EqualFold(k, key) {
+			return k
+		}
+		if d := levenshtein(strings.ToLower(k), strings.ToLower(key)); d < bestDistance {
+			best = k
+			bestDistance = d
+		}
+	}
+	return best
+}
@@ -0,0 +1,435 @@
+package spec
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// String is a string constant in a feature expression. Plain strings in feature lists are feature names.
+type String string
+
+// List is a list of constants in a feature expression (e.g. [6, 17] or ['a', 'b'])
+type List []interface{}
+
+// ExpressionError is an error in a feature expression
+type ExpressionError struct {
+	// Offset is the byte offset in the expression
+	Offset int
+	// Message describes the problem
+	Message string
+}
+
+func (e *ExpressionError) Error() string {
+	return fmt.Sprintf("offset %d: %s", e.Offset, e.Message)
+}
+
+// binary operators by precedence (lowest first) and the corresponding operations
+var binaryOperators = [][]struct {
+	op       string
+	function string
+	variadic bool
+}{
+	{{"||", "or", true}},
+	{{"&&", "and", true}},
+	{{"==", "equal", false}, {"<=", "leq", false}, {">=", "geq", false}, {"<", "less", false}, {">", "greater", false}},
+	{{"+", "add", false}, {"-", "subtract", false}},
+	{{"*", "multiply", false}, {"/", "divide", false}},
+}
+
+type exprTokenKind int
+
+const (
+	tokenEOF exprTokenKind = iota
+	tokenIdent
+	tokenNumber
+	tokenString
+	tokenIP
+	tokenOperator
+)
+
+type exprToken struct {
+	kind   exprTokenKind
+	offset int
+	text   string
+	value  interface{}
+}
+
+func (t exprToken) String() string {
+	switch t.kind {
+	case tokenEOF:
+		return "end of expression"
+	case tokenString:
+		return fmt.Sprintf("string %s", t.text)
+	}
+	return fmt.Sprintf("'%s'", t.text)
+}
+
+type exprParser struct {
+	expr string
+	off  int
+	tok  exprToken
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isIdent(s string) bool {
+	if len(s) == 0 || !isIdentStart(s[0]) {
+		return false
+	}
+	for i := 1; i < len(s); i++ {
+		if !isIdentStart(s[i]) && !isDigit(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func isAddressChar(c byte) bool {
+	return isDigit(c) || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F') || c == ':' || c == '.'
+}
+
+func (p *exprParser) fail(offset int, format string, args ...interface{}) {
+	panic(&ExpressionError{offset, fmt.Sprintf(format, args...)})
+}
+
+// address tries to read an IP address or network at the current offset
+func (p *exprParser) address() bool {
+	end := p.off
+	for end < len(p.expr) && isAddressChar(p.expr[end]) {
+		end++
+	}
+	text := p.expr[p.off:end]
+	if !strings.Contains(text, ":") && strings.Count(text, ".") != 3 {
+		return false
+	}
+	if end < len(p.expr) && p.expr[end] == '/' {
+		end++
+		for end < len(p.expr) && isDigit(p.expr[end]) {
+			end++
+		}
+		text = p.expr[p.off:end]
+		_, network, err := net.ParseCIDR(text)
+		if err != nil {
+			return false
+		}
+		p.tok = exprToken{kind: tokenIP, offset: p.off, text: text, value: network}
+	} else {
+		ip := net.ParseIP(text)
+		if ip == nil {
+			return false
+		}
+		if ip4 := ip.To4(); ip4 != nil {
+			ip = ip4
+		}
+		p.tok = exprToken{kind: tokenIP, offset: p.off, text: text, value: ip}
+	}
+	p.off = end
+	return true
+}
+
+func (p *exprParser) next() {
+	for p.off < len(p.expr) && (p.expr[p.off] == ' ' || p.expr[p.off] == '\t' || p.expr[p.off] == '\n') {
+		p.off++
+	}
+	if p.off >= len(p.expr) {
+		p.tok = exprToken{kind: tokenEOF, offset: p.off}
+		return
+	}
+	start := p.off
+	c := p.expr[start]
+	switch {
+	case isAddressChar(c) && p.address():
+	case isIdentStart(c):
+		for p.off < len(p.expr) && (isIdentStart(p.expr[p.off]) || isDigit(p.expr[p.off])) {
+			p.off++
+		}
+		p.tok = exprToken{kind: tokenIdent, offset: start, text: p.expr[start:p.off]}
+	case isDigit(c) || (c == '.' && p.off+1 < len(p.expr) && isDigit(p.expr[p.off+1])):
+		for p.off < len(p.expr) {
+			c := p.expr[p.off]
+			if isDigit(c) || isIdentStart(c) || c == '.' ||
+				((c == '+' || c == '-') && (p.expr[p.off-1] == 'e' || p.expr[p.off-1] == 'E') && !strings.HasPrefix(p.expr[start:], "0x")) {
+				p.off++
+				continue
+			}
+			break
+		}
+		text := p.expr[start:p.off]
+		p.tok = exprToken{kind: tokenNumber, offset: start, text: text}
+		base := 10
+		digits := text
+		if strings.HasPrefix(text, "0x") || strings.HasPrefix(text, "0X") {
+			base = 16
+			digits = text[2:]
+		}
+		if i, err := strconv.ParseInt(digits, base, 64); err == nil {
+			p.tok.value = i
+		} else if f, err := strconv.ParseFloat(text, 64); err == nil {
+			p.tok.value = f
+		} else {
+			p.fail(start, "invalid number %s", text)
+		}
+	case c == '\'' || c == '"':
+		p.off++
+		var sb strings.Builder
+		for {
+			if p.off >= len(p.expr) {
+				p.fail(start, "unterminated string")
+			}
+			c := p.expr[p.off]
+			if c == p.expr[start] {
+				p.off++
+				break
+			}
+			if c == '\\' {
+				p.off++
+				if p.off >= len(p.expr) {
+					p.fail(start, "unterminated string")
+				}
+				switch e := p.expr[p.off]; e {
+				case 'n':
+					sb.WriteByte('\n')
+				case 't':
+					sb.WriteByte('\t')
+				case '\\', '\'', '"':
+					sb.WriteByte(e)
+				default:
+					p.fail(p.off-1, "unknown escape sequence \\%c", e)
+				}
+				p.off++
+				continue
+			}
+			sb.WriteByte(c)
+			p.off++
+		}
+		p.tok = exprToken{kind: tokenString, offset: start, text: p.expr[start:p.off], value: String(sb.String())}
+	default:
+		for _, op := range []string{"||", "&&", "==", "<=", ">=", "<", ">", "+", "-", "*", "/", "(", ")", "[", "]", ","} {
+			if strings.HasPrefix(p.expr[p.off:], op) {
+				p.off += len(op)
+				p.tok = exprToken{kind: tokenOperator, offset: start, text: op}
+				return
+			}
+		}
+		p.fail(start, "unexpected character %q", c)
+	}
+}
+
+func (p *exprParser) isOperator(op string) bool {
+	return p.tok.kind == tokenOperator && p.tok.text == op
+}
+
+func (p *exprParser) expect(op string) {
+	if !p.isOperator(op) {
+		p.fail(p.tok.offset, "expected '%s', but found %s", op, p.tok)
+	}
+	p.next()
+}
+
+func (p *exprParser) binary(level int) interface{} {
+	if level == len(binaryOperators) {
+		return p.unary()
+	}
+	left := p.binary(level + 1)
+OPERATORS:
+	for {
+		for _, op := range binaryOperators[level] {
+			if !p.isOperator(op.op) {
+				continue
+			}
+			p.next()
+			right := p.binary(level + 1)
+			if call, ok := left.([]interface{}); ok && op.variadic && call[0] == op.function {
+				left = append(call, right)
+			} else {
+				left = []interface{}{op.function, left, right}
+			}
+			continue OPERATORS
+		}
+		return left
+	}
+}
+
+func (p *exprParser) unary() interface{} {
+	if p.isOperator("-") {
+		offset := p.tok.offset
+		p.next()
+		switch operand := p.unary().(type) {
+		case int64:
+			return -operand
+		case float64:
+			return -operand
+		case []interface{}, string:
+			return []interface{}{"subtract", int64(0), operand}
+		default:
+			p.fail(offset, "can't negate %v", operand)
+		}
+	}
+	return p.primary()
+}
+
+func (p *exprParser) primary() interface{} {
+	tok := p.tok
+	switch tok.kind {
+	case tokenNumber, tokenString, tokenIP:
+		p.next()
+		return tok.value
+	case tokenIdent:
+		p.next()
+		switch tok.text {
+		case "true":
+			return true
+		case "false":
+			return false
+		}
+		if !p.isOperator("(") {
+			return tok.text
+		}
+		p.next()
+		call := []interface{}{tok.text}
+		if p.isOperator(")") {
+			p.next()
+			return call
+		}
+		for {
+			call = append(call, p.binary(0))
+			if p.isOperator(")") {
+				p.next()
+				return call
+			}
+			p.expect(",")
+		}
+	case tokenOperator:
+		switch tok.text {
+		case "(":
+			p.next()
+			ret := p.binary(0)
+			p.expect(")")
+			return ret
+		case "[":
+			return p.list()
+		}
+	}
+	p.fail(tok.offset, "unexpected %s", tok)
+	return nil
+}
+
+// list parses a list of constants
+func (p *exprParser) list() List {
+	p.next()
+	ret := List{}
+	if p.isOperator("]") {
+		p.next()
+		return ret
+	}
+	for {
+		offset := p.tok.offset
+		switch element := p.unary().(type) {
+		case []interface{}, string, List:
+			p.fail(offset, "list elements must be constants")
+		default:
+			ret = append(ret, element)
+		}
+		if p.isOperator("]") {
+			p.next()
+			return ret
+		}
+		p.expect(",")
+	}
+}
+
+// ParseExpression parses a feature expression like "(octetTotalCount + 1) / flowDurationMilliseconds" or
+// "mean(forward(ipTotalLength))" into the representation used by Flow.Features:
+//   - identifiers are feature names (string)
+//   - name(args...) is a call ([]interface{} with the name as first element)
+//   - numbers are int64 or float64; true and false are booleans
+//   - 'text' or "text" is a String
+//   - IP addresses (10.0.0.1, ::1) are net.IP, networks (10.0.0.0/8) are *net.IPNet
+//   - [constant, ...] is a List
+//   - binary operators (lowest precedence first): || (or), && (and), == <= >= < > (equal, leq, geq, less,
+//     greater), + and - (add, subtract), * and / (multiply, divide)
+//   - unary minus negates numbers or subtracts from 0
+//   - "expression as name" at the end sets the export name (Alias)
+//
+// Errors are of type *ExpressionError.
+func ParseExpression(expr string) (ret interface{}, err error) {
+	p := &exprParser{expr: expr}
+	defer func() {
+		if r := recover(); r != nil {
+			if e, ok := r.(*ExpressionError); ok {
+				err = e
+				return
+			}
+			panic(r)
+		}
+	}()
+	p.next()
+	ret = p.binary(0)
+	if p.tok.kind == tokenIdent && p.tok.text == aliasOperation {
+		p.next()
+		if p.tok.kind != tokenIdent && p.tok.kind != tokenString {
+			p.fail(p.tok.offset, "expected name after %s, but found %s", aliasOperation, p.tok)
+		}
+		name := p.tok.text
+		if p.tok.kind == tokenString {
+			name = string(p.tok.value.(String))
+		}
+		ret = Alias{Feature: ret, Name: name}
+		p.next()
+	}
+	if p.tok.kind != tokenEOF {
+		p.fail(p.tok.offset, "unexpected %s", p.tok)
+	}
+	return
+}
+
+// FormatConstant returns the expression representation of a constant (e.g. 'text', 10.0.0.0/8, or [1, 2.5]). Values
+// that are not constants are formatted with fmt.
+func FormatConstant(value interface{}) string {
+	switch value := value.(type) {
+	case String:
+		return FormatString(value)
+	case float64:
+		ret := strconv.FormatFloat(value, 'g', -1, 64)
+		if !strings.ContainsAny(ret, ".eEnN") {
+			ret += ".0"
+		}
+		return ret
+	case List:
+		elements := make([]string, len(value))
+		for i, element := range value {
+			elements[i] = FormatConstant(element)
+		}
+		return "[" + strings.Join(elements, ", ") + "]"
+	}
+	return fmt.Sprint(value)
+}
+
+// FormatString returns the expression representation of a String
+func FormatString(s String) string {
+	var sb strings.Builder
+	sb.WriteByte('\'')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '\n':
+			sb.WriteString(`\n`)
+		case '\t':
+			sb.WriteString(`\t`)
+		case '\\', '\'':
+			sb.WriteByte('\\')
+			sb.WriteByte(c)
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	sb.WriteByte('\'')
+	return sb.String()
+}
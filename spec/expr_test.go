@@ -0,0 +1,128 @@
+package spec
+
+import (
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseExpression(t *testing.T) {
+	_, network, _ := net.ParseCIDR("10.0.0.0/8")
+	tests := []struct {
+		expr     string
+		expected interface{}
+	}{
+		{"(octetTotalCount + 1) / flowDurationMilliseconds", []interface{}{"divide", []interface{}{"add", "octetTotalCount", int64(1)}, "flowDurationMilliseconds"}},
+		{"mean(forward(ipTotalLength))", []interface{}{"mean", []interface{}{"forward", "ipTotalLength"}}},
+		{"a + b * c - d", []interface{}{"subtract", []interface{}{"add", "a", []interface{}{"multiply", "b", "c"}}, "d"}},
+		{"a / b / c", []interface{}{"divide", []interface{}{"divide", "a", "b"}, "c"}},
+		{"a < 1 || b >= 2.5 || c && d == true", []interface{}{"or", []interface{}{"less", "a", int64(1)}, []interface{}{"geq", "b", 2.5}, []interface{}{"and", "c", []interface{}{"equal", "d", true}}}},
+		{"-5 + -x", []interface{}{"add", int64(-5), []interface{}{"subtract", int64(0), "x"}}},
+		{"0x10 * 1e3", []interface{}{"multiply", int64(16), 1000.0}},
+		{"apply(max(ipTotalLength), select(less(ipTotalLength, 80)))", []interface{}{"apply", []interface{}{"max", "ipTotalLength"}, []interface{}{"select", []interface{}{"less", "ipTotalLength", int64(80)}}}},
+		{"f()", []interface{}{"f"}},
+		{`concat('it\'s', "a \"b\"")`, []interface{}{"concat", String("it's"), String(`a "b"`)}},
+		{"in_subnet(sourceIPAddress, 10.0.0.0/8)", []interface{}{"in_subnet", "sourceIPAddress", network}},
+		{"equal(destinationIPAddress, 192.168.0.1)", []interface{}{"equal", "destinationIPAddress", net.IP{192, 168, 0, 1}}},
+		{"equal(destinationIPAddress, ::1)", []interface{}{"equal", "destinationIPAddress", net.ParseIP("::1")}},
+		{"equal(destinationIPAddress, fe80::1)", []interface{}{"equal", "destinationIPAddress", net.ParseIP("fe80::1")}},
+		{"in_set(protocolIdentifier, [6, 17, -1, 0.5])", []interface{}{"in_set", "protocolIdentifier", List{int64(6), int64(17), int64(-1), 0.5}}},
+		{"in_set(httpHost, ['a', \"b\", 10.0.0.1])", []interface{}{"in_set", "httpHost", List{String("a"), String("b"), net.IP{10, 0, 0, 1}}}},
+		{"[]", List{}},
+		{"  packetTotalCount  ", "packetTotalCount"},
+	}
+	for _, test := range tests {
+		got, err := ParseExpression(test.expr)
+		if err != nil {
+			t.Errorf("%s: %s", test.expr, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("%s: expected %#v, but got %#v", test.expr, test.expected, got)
+		}
+	}
+}
+
+func TestExpressionErrors(t *testing.T) {
+	tests := []struct {
+		expr   string
+		offset int
+		msg    string
+	}{
+		{"(a + 1", 6, "expected ')', but found end of expression"},
+		{"a + ) / b", 4, "unexpected ')'"},
+		{"f(a b)", 4, "expected ',', but found 'b'"},
+		{"a ! b", 2, "unexpected character '!'"},
+		{"'abc", 0, "unterminated string"},
+		{"1.2.3", 0, "invalid number 1.2.3"},
+		{"a + 'x\\q'", 6, "unknown escape sequence \\q"},
+		{"-'x'", 0, "can't negate x"},
+		{"", 0, "unexpected end of expression"},
+		{"in_set(x, [1, y])", 14, "list elements must be constants"},
+		{"in_set(x, [1, 2)", 15, "expected ',', but found ')'"},
+	}
+	for _, test := range tests {
+		_, err := ParseExpression(test.expr)
+		e, ok := err.(*ExpressionError)
+		if !ok {
+			t.Errorf("%s: expected ExpressionError, but got %v", test.expr, err)
+			continue
+		}
+		if e.Offset != test.offset || e.Message != test.msg {
+			t.Errorf("%s: expected error at %d: %s, but got %s", test.expr, test.offset, test.msg, e)
+		}
+	}
+}
+
+func TestExpressionInSpec(t *testing.T) {
+	file, _, err := Parse("", []byte(`{"active_timeout": 1, "idle_timeout": 1, "bidirectional": false, "key_features": [],
+"features": ["(octetTotalCount + 1) / flowDurationMilliseconds", {"divide": [{"add": ["octetTotalCount", 1]}, "flowDurationMilliseconds"]}, "'text'", "10.0.0.0/8", "in_set(x, [1, 2.0, 'a', ::1, 10.0.0.0/8, true])"]}`), FormatAuto)
+	if err != nil {
+		t.Fatal(err)
+	}
+	features := file.Flows[0].Features
+	if !reflect.DeepEqual(features[0], features[1]) {
+		t.Errorf("Expression and JSON differ: %#v != %#v", features[0], features[1])
+	}
+	encoded, err := file.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, _, err := Parse("", encoded, FormatAuto)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(file, decoded) {
+		t.Errorf("Round trip mismatch:\n%#v\n%#v", file, decoded)
+	}
+
+	// unquoted strings in arguments
+	file, _, err = Parse("", []byte(`{"active_timeout": 1, "idle_timeout": 1, "bidirectional": false, "key_features": [],
+"features": [{"regex_match": ["x", "GET /"]}, {"flowLabel": ["__label", "majority"]}, {"flowLabel": ["__label", "'majority'"]}]}`), FormatAuto)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unquoted := []interface{}{
+		[]interface{}{"regex_match", "x", String("GET /")},
+		[]interface{}{"flowLabel", "__label", "majority"},
+		[]interface{}{"flowLabel", "__label", String("majority")},
+	}
+	if !reflect.DeepEqual(file.Flows[0].Features, unquoted) {
+		t.Errorf("Expected features %#v, but got %#v", unquoted, file.Flows[0].Features)
+	}
+
+	// arguments looking like expressions keep their errors
+	_, _, err = Parse("spec.json", []byte(`{"active_timeout": 1, "idle_timeout": 1, "bidirectional": false, "key_features": [],
+"features": [{"max": ["mean(x"]}]}`), FormatAuto)
+	if err == nil || !strings.Contains(err.Error(), `spec.json:2:30: features[0].max[0]: in expression "mean(x"`) {
+		t.Errorf("Expected the expression error of the argument, but got %v", err)
+	}
+
+	_, _, err = Parse("spec.json", []byte(`{"active_timeout": 1, "idle_timeout": 1, "bidirectional": false, "key_features": [],
+"features": ["x", "mean(\"a\u00e4\" + )"]}`), FormatAuto)
+	expected := `spec.json:2:39: features[1]: in expression "mean(\"aä\" + )": unexpected ')'`
+	if err == nil || err.Error() != expected {
+		t.Errorf("Expected error\n%s\nbut got\n%v", expected, err)
+	}
+}
@@ -0,0 +1,253 @@
+package spec
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// Position is a position in a specification file
+type Position struct {
+	Line   int // starting at 1
+	Column int // starting at 1 (byte count)
+}
+
+func (p Position) String() string {
+	return fmt.Sprintf("%d:%d", p.Line, p.Column)
+}
+
+type nodeKind int
+
+const (
+	nullNode nodeKind = iota
+	boolNode
+	numberNode
+	stringNode
+	arrayNode
+	objectNode
+)
+
+var nodeKindNames = [...]string{
+	nullNode:   "null",
+	boolNode:   "boolean",
+	numberNode: "number",
+	stringNode: "string",
+	arrayNode:  "array",
+	objectNode: "object",
+}
+
+func (k nodeKind) String() string {
+	return nodeKindNames[k]
+}
+
+// node is a JSON value together with its position. encoding/json throws away positions (and the order of object
+// keys), which we need for error messages.
+type node struct {
+	kind   nodeKind
+	offset int
+	value  interface{} // bool, json.Number, or string
+	elems  []*node     // array elements or object values
+	keys   []*node     // object keys (string nodes)
+}
+
+// generic returns the value in the representation encoding/json with UseNumber would return
+func (n *node) generic() interface{} {
+	switch n.kind {
+	case arrayNode:
+		ret := make([]interface{}, len(n.elems))
+		for i, elem := range n.elems {
+			ret[i] = elem.generic()
+		}
+		return ret
+	case objectNode:
+		ret := make(map[string]interface{}, len(n.elems))
+		for i, elem := range n.elems {
+			ret[n.keys[i].value.(string)] = elem.generic()
+		}
+		return ret
+	}
+	return n.value
+}
+
+type syntaxError struct {
+	offset int
+	msg    string
+}
+
+func (e *syntaxError) Error() string {
+	return e.msg
+}
+
+type jsonParser struct {
+	data []byte
+	off  int
+}
+
+// parseJSON parses exactly one JSON value from data. Errors are of type *syntaxError.
+func parseJSON(data []byte) (n *node, err error) {
+	p := &jsonParser{data: data}
+	defer func() {
+		if r := recover(); r != nil {
+			if e, ok := r.(*syntaxError); ok {
+				err = e
+				return
+			}
+			panic(r)
+		}
+	}()
+	n = p.value()
+	p.space()
+	if p.off != len(p.data) {
+		p.fail("unexpected %s after top-level value", p.describe())
+	}
+	return
+}
+
+func (p *jsonParser) fail(format string, args ...interface{}) {
+	panic(&syntaxError{p.off, fmt.Sprintf(format, args...)})
+}
+
+func (p *jsonParser) describe() string {
+	if p.off >= len(p.data) {
+		return "end of input"
+	}
+	return fmt.Sprintf("character %q", p.data[p.off])
+}
+
+func (p *jsonParser) space() {
+	for p.off < len(p.data) {
+		switch p.data[p.off] {
+		case ' ', '\t', '\n', '\r':
+			p.off++
+		default:
+			return
+		}
+	}
+}
+
+func (p *jsonParser) expect(c byte) {
+	p.space()
+	if p.off >= len(p.data) || p.data[p.off] != c {
+		p.fail("expected '%c', but found %s", c, p.describe())
+	}
+	p.off++
+}
+
+func (p *jsonParser) literal(lit string) bool {
+	if len(p.data)-p.off >= len(lit) && string(p.data[p.off:p.off+len(lit)]) == lit {
+		p.off += len(lit)
+		return true
+	}
+	return false
+}
+
+func (p *jsonParser) value() *node {
+	p.space()
+	if p.off >= len(p.data) {
+		p.fail("unexpected end of input")
+	}
+	n := &node{offset: p.off}
+	switch c := p.data[p.off]; {
+	case c == '{':
+		n.kind = objectNode
+		p.off++
+		p.space()
+		if p.off < len(p.data) && p.data[p.off] == '}' {
+			p.off++
+			return n
+		}
+		for {
+			p.space()
+			if p.off >= len(p.data) || p.data[p.off] != '"' {
+				p.fail("expected object key, but found %s", p.describe())
+			}
+			n.keys = append(n.keys, p.value())
+			p.expect(':')
+			n.elems = append(n.elems, p.value())
+			p.space()
+			if p.off < len(p.data) && p.data[p.off] == '}' {
+				p.off++
+				return n
+			}
+			p.expect(',')
+		}
+	case c == '[':
+		n.kind = arrayNode
+		p.off++
+		p.space()
+		if p.off < len(p.data) && p.data[p.off] == ']' {
+			p.off++
+			return n
+		}
+		for {
+			n.elems = append(n.elems, p.value())
+			p.space()
+			if p.off < len(p.data) && p.data[p.off] == ']' {
+				p.off++
+				return n
+			}
+			p.expect(',')
+		}
+	case c == '"':
+		n.kind = stringNode
+		end := p.off + 1
+		for ; end < len(p.data) && p.data[end] != '"'; end++ {
+			if p.data[end] == '\\' {
+				end++
+			}
+		}
+		if end >= len(p.data) {
+			p.fail("unterminated string")
+		}
+		var s string
+		if err := json.Unmarshal(p.data[p.off:end+1], &s); err != nil {
+			p.fail("invalid string: %s", err)
+		}
+		n.value = s
+		p.off = end + 1
+	case c == '-' || (c >= '0' && c <= '9'):
+		n.kind = numberNode
+		end := p.off
+		for ; end < len(p.data); end++ {
+			c := p.data[end]
+			if !(c >= '0' && c <= '9') && c != '-' && c != '+' && c != '.' && c != 'e' && c != 'E' {
+				break
+			}
+		}
+		num := p.data[p.off:end]
+		if !json.Valid(num) {
+			p.fail("invalid number %s", num)
+		}
+		n.value = json.Number(num)
+		p.off = end
+	case p.literal("true"):
+		n.kind = boolNode
+		n.value = true
+	case p.literal("false"):
+		n.kind = boolNode
+		n.value = false
+	case p.literal("null"):
+		n.kind = nullNode
+	default:
+		p.fail("unexpected %s", p.describe())
+	}
+	return n
+}
+
+// lineIndex converts offsets into positions
+type lineIndex []int
+
+func newLineIndex(data []byte) lineIndex {
+	ret := lineIndex{0}
+	for i, c := range data {
+		if c == '\n' {
+			ret = append(ret, i+1)
+		}
+	}
+	return ret
+}
+
+func (l lineIndex) position(offset int) Position {
+	line := sort.Search(len(l), func(i int) bool { return l[i] > offset }) - 1
+	return Position{Line: line + 1, Column: offset - l[line] + 1}
+}
@@ -0,0 +1,186 @@
+package spec
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+)
+
+type objectWriter struct {
+	buf   bytes.Buffer
+	first bool
+	err   error
+}
+
+func newObjectWriter() *objectWriter {
+	w := &objectWriter{first: true}
+	w.buf.WriteByte('{')
+	return w
+}
+
+func (w *objectWriter) add(key string, value interface{}) {
+	if w.err != nil {
+		return
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		w.err = fmt.Errorf("%s: %s", key, err)
+		return
+	}
+	if !w.first {
+		w.buf.WriteByte(',')
+	}
+	w.first = false
+	k, _ := json.Marshal(key)
+	w.buf.Write(k)
+	w.buf.WriteByte(':')
+	w.buf.Write(data)
+}
+
+func (w *objectWriter) addExtra(extra map[string]interface{}) {
+	keys := make([]string, 0, len(extra))
+	for key := range extra {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		w.add(key, extra[key])
+	}
+}
+
+func (w *objectWriter) bytes() ([]byte, error) {
+	if w.err != nil {
+		return nil, w.err
+	}
+	w.buf.WriteByte('}')
+	return w.buf.Bytes(), nil
+}
+
+// encodeFeature converts calls into the {"operation": [args...]} representation and literals into expressions
+func encodeFeature(feature interface{}) (interface{}, error) {
+	switch feature := feature.(type) {
+	case []interface{}:
+		if len(feature) == 0 {
+			return nil, fmt.Errorf("empty call")
+		}
+		name, ok := feature[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("operation name must be a string (got %v)", feature[0])
+		}
+		args, err := encodeFeatures(feature[1:])
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{name: args}, nil
+	case Alias:
+		inner, err := encodeFeature(feature.Feature)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{aliasOperation: []interface{}{inner, feature.Name}}, nil
+	case String, net.IP, *net.IPNet, List:
+		return FormatConstant(feature), nil
+	case nil:
+		return nil, fmt.Errorf("null is not a valid feature")
+	}
+	return feature, nil
+}
+
+func encodeFeatures(features []interface{}) ([]interface{}, error) {
+	ret := make([]interface{}, len(features))
+	for i, feature := range features {
+		var err error
+		if ret[i], err = encodeFeature(feature); err != nil {
+			return nil, err
+		}
+	}
+	return ret, nil
+}
+
+func nonNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
+
+// MarshalJSON encodes the flow specification in the simple format. Known keys come first; extra keys are sorted.
+func (f Flow) MarshalJSON() ([]byte, error) {
+	features, err := encodeFeatures(f.Features)
+	if err != nil {
+		return nil, fmt.Errorf("features: %s", err)
+	}
+	w := newObjectWriter()
+	w.add("active_timeout", f.ActiveTimeout)
+	w.add("idle_timeout", f.IdleTimeout)
+	w.add("bidirectional", f.Bidirectional)
+	w.add("features", features)
+	w.add("key_features", nonNil(f.KeyFeatures))
+	if f.ControlFeatures != nil {
+		w.add("_control_features", f.ControlFeatures)
+	}
+	if f.FilterFeatures != nil {
+		w.add("_filter_features", f.FilterFeatures)
+	}
+	if f.PerPacket {
+		w.add("_per_packet", true)
+	}
+	if f.AllowZero {
+		w.add("_allow_zero", true)
+	}
+	if f.ExpireTCP != nil {
+		w.add("_expire_TCP", *f.ExpireTCP)
+	}
+	if f.TCPLinger != 0 {
+		w.add("_tcp_linger", f.TCPLinger)
+	}
+	if f.Definitions != nil {
+		definitions := make(map[string]interface{}, len(f.Definitions))
+		for name, definition := range f.Definitions {
+			if definitions[name], err = encodeFeature(definition); err != nil {
+				return nil, fmt.Errorf("definitions.%s: %s", name, err)
+			}
+		}
+		w.add("definitions", definitions)
+	}
+	if f.Include != nil {
+		w.add("include", f.Include)
+	}
+	w.addExtra(f.Extra)
+	return w.bytes()
+}
+
+// MarshalJSON encodes the file in the simple format, if possible (no version, a single flow, and no extra keys), or
+// in the v2 format otherwise
+func (f File) MarshalJSON() ([]byte, error) {
+	if f.Version == "" && len(f.Flows) == 1 && f.Extra == nil && f.PreprocessingExtra == nil {
+		return f.Flows[0].MarshalJSON()
+	}
+	version := f.Version
+	if version == "" {
+		version = "v2"
+	}
+	flows := f.Flows
+	if flows == nil {
+		flows = []Flow{}
+	}
+	pre := newObjectWriter()
+	pre.add("flows", flows)
+	pre.addExtra(f.PreprocessingExtra)
+	preprocessing, err := pre.bytes()
+	if err != nil {
+		return nil, err
+	}
+	w := newObjectWriter()
+	w.add("version", version)
+	w.add("preprocessing", json.RawMessage(preprocessing))
+	w.addExtra(f.Extra)
+	return w.bytes()
+}
+
+// Marshal returns the indented JSON representation of the file
+func (f *File) Marshal() ([]byte, error) {
+	return json.MarshalIndent(f, "", "    ")
+}
@@ -0,0 +1,639 @@
+// Package spec parses and writes flow specification files.
+//
+// Two formats are supported: the simple format, which contains exactly one flow specification, and the NTARC v2
+// format, which contains a list of flow specifications in preprocessing.flows. Errors contain the position and JSON
+// path of the offending value; unknown keys in flow specifications result in warnings.
+//
+// simple format:
+//
+//	{
+//		"active_timeout": <Number>,
+//		"idle_timeout": <Number>,
+//		"bidirectional": <bool>,
+//		"features": [...],
+//		"key_features": [...],
+//		"_control_features": [...],
+//		"_filter_features": [...],
+//		"_per_packet": <bool>,
+//		"_allow_zero": <bool>,
+//		"_expire_TCP": <bool>,
+//		"_tcp_linger": <Number>
+//	}
+//
+// timeouts, features, key_features and bidirectional are required.
+// _per_packet, _allow_zero are assumed false if missing.
+// _expire_TCP is assumed true if missing (tcp expire works only if at least the five tuple is present in the key).
+// _tcp_linger is assumed 0 if missing.
+// Further keys are kept in Flow.Extra and can be queried from features.
+//
+// v2 format:
+//
+//	{
+//		"version": "v2",
+//		"preprocessing": {
+//			"flows": [
+//				<simpleformat>
+//			]
+//		}
+//	}
+package spec
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Format specifies the file format of a specification
+type Format int
+
+const (
+	// FormatAuto detects the format from the version key
+	FormatAuto Format = iota
+	// FormatV2 is the NTARC v2 format
+	FormatV2
+	// FormatSimple is the flow specification only
+	FormatSimple
+)
+
+// File is a parsed specification file
+type File struct {
+	// Version is the file format version; empty for the simple format
+	Version string
+	// Flows holds the flow specifications (exactly one for the simple format)
+	Flows []Flow
+	// Extra holds the top level keys of v2 files, which are not interpreted by go-flows
+	Extra map[string]interface{}
+	// PreprocessingExtra holds the keys of the preprocessing section of v2 files besides flows
+	PreprocessingExtra map[string]interface{}
+}
+
+// Flow is a single flow specification
+type Flow struct {
+	// ActiveTimeout is the active timeout in seconds
+	ActiveTimeout float64
+	// IdleTimeout is the idle timeout in seconds
+	IdleTimeout float64
+	// Bidirectional specifies if packets in both directions belong to the same flow
+	Bidirectional bool
+	// Features is the list of features to export. Elements are feature names (string), constants (int64, float64,
+	// bool, String, net.IP, *net.IPNet), or calls ([]interface{} with the operation name as first element and the
+	// arguments following). Strings, which are not plain feature names, are parsed with ParseExpression.
+	Features []interface{}
+	// KeyFeatures is the list of key features
+	KeyFeatures []string
+	// ControlFeatures is the list of control features
+	ControlFeatures []string
+	// FilterFeatures is the list of filter features
+	FilterFeatures []string
+	// PerPacket specifies that every packet is a single flow
+	PerPacket bool
+	// AllowZero specifies if packets are accepted, where a part of the key is zero
+	AllowZero bool
+	// ExpireTCP specifies if tcp expiry is wanted; nil means true
+	ExpireTCP *bool
+	// TCPLinger is the time in seconds a closed tcp connection is kept
+	TCPLinger float64
+	// Definitions holds named features, which can be referenced by name in Features, ControlFeatures, and
+	// FilterFeatures (see Expand)
+	Definitions map[string]interface{}
+	// Include holds the names of included specification files (relative to the including file)
+	Include []string
+	// Included holds the parsed included files in the order of Include. This is filled by Parse and not encoded.
+	Included []*Flow
+	// Extra holds unknown keys (with values as decoded by encoding/json with UseNumber)
+	Extra map[string]interface{}
+}
+
+// TCPExpiry returns if tcp expiry is enabled
+func (f *Flow) TCPExpiry() bool {
+	return f.ExpireTCP == nil || *f.ExpireTCP
+}
+
+var requiredKeys = []string{"active_timeout", "idle_timeout", "bidirectional", "features", "key_features"}
+
+var flowKeyList = append([]string{"_control_features", "_filter_features", "_per_packet", "_allow_zero", "_expire_TCP", "_tcp_linger", "definitions", "include"}, requiredKeys...)
+
+var flowKeys = make(map[string]bool)
+
+func init() {
+	for _, key := range flowKeyList {
+		flowKeys[key] = true
+	}
+}
+
+// includeKeys are the keys allowed in included files
+var includeKeys = map[string]bool{"features": true, "_control_features": true, "_filter_features": true, "definitions": true, "include": true}
+
+// Select returns the flow specification with the given index
+func (f *File) Select(selection int) (*Flow, error) {
+	if selection < 0 || selection >= len(f.Flows) {
+		return nil, fmt.Errorf("only %d flows in the file ⇒ selection must be between 0 and %d (is %d)", len(f.Flows), len(f.Flows)-1, selection)
+	}
+	return &f.Flows[selection], nil
+}
+
+type decoder struct {
+	filename  string
+	data      []byte
+	lines     lineIndex
+	errors    ErrorList
+	warnings  []*Error
+	including map[string]bool // files currently being included (for detecting cycles)
+}
+
+func (d *decoder) newError(n *node, path, format string, args ...interface{}) *Error {
+	return &Error{
+		Filename: d.filename,
+		Position: d.lines.position(n.offset),
+		Path:     path,
+		Message:  fmt.Sprintf(format, args...),
+	}
+}
+
+func (d *decoder) errorf(n *node, path, format string, args ...interface{}) {
+	d.errors = append(d.errors, d.newError(n, path, format, args...))
+}
+
+func (d *decoder) warnf(n *node, path, format string, args ...interface{}) {
+	d.warnings = append(d.warnings, d.newError(n, path, format, args...))
+}
+
+// object calls f for every key in n and reports duplicate keys
+func (d *decoder) object(n *node, path string, f func(key string, keyNode, value *node, path string)) bool {
+	if n.kind != objectNode {
+		d.errorf(n, path, "must be an object (got %s)", n.kind)
+		return false
+	}
+	seen := make(map[string]bool, len(n.keys))
+	for i, keyNode := range n.keys {
+		key := keyNode.value.(string)
+		if seen[key] {
+			d.errorf(keyNode, path, "duplicate key %s", key)
+			continue
+		}
+		seen[key] = true
+		f(key, keyNode, n.elems[i], joinPath(path, key))
+	}
+	return true
+}
+
+func (d *decoder) number(n *node, path string) float64 {
+	if n.kind != numberNode {
+		d.errorf(n, path, "must be a number (got %s)", n.kind)
+		return 0
+	}
+	ret, err := n.value.(json.Number).Float64()
+	if err != nil {
+		d.errorf(n, path, "invalid number: %s", err)
+	}
+	return ret
+}
+
+func (d *decoder) timeout(n *node, path string) float64 {
+	ret := d.number(n, path)
+	if ret < 0 {
+		d.errorf(n, path, "must not be negative")
+	}
+	return ret
+}
+
+func (d *decoder) boolean(n *node, path string) bool {
+	if n.kind != boolNode {
+		d.errorf(n, path, "must be a boolean (got %s)", n.kind)
+		return false
+	}
+	return n.value.(bool)
+}
+
+func (d *decoder) stringArray(n *node, path string) []string {
+	if n.kind != arrayNode {
+		d.errorf(n, path, "must be an array of strings (got %s)", n.kind)
+		return nil
+	}
+	ret := make([]string, 0, len(n.elems))
+	for i, elem := range n.elems {
+		if elem.kind != stringNode {
+			d.errorf(elem, indexPath(path, i), "must be a string (got %s)", elem.kind)
+			continue
+		}
+		ret = append(ret, elem.value.(string))
+	}
+	return ret
+}
+
+func (d *decoder) call(n *node, name string, args []*node, argPath func(int) string) interface{} {
+	if name == aliasOperation {
+		if len(args) != 2 {
+			d.errorf(n, argPath(-1), "%s needs exactly two arguments (feature and name)", aliasOperation)
+			return nil
+		}
+		if args[1].kind != stringNode {
+			d.errorf(args[1], argPath(1), "name must be a string (got %s)", args[1].kind)
+			return nil
+		}
+		return Alias{Feature: d.feature(args[0], argPath(0)), Name: args[1].value.(string)}
+	}
+	ret := make([]interface{}, len(args)+1)
+	ret[0] = name
+	for i, arg := range args {
+		ret[i+1] = d.argument(arg, argPath(i))
+	}
+	return ret
+}
+
+// argument decodes an argument of a call. Strings, which can't be parsed as expressions and don't contain
+// parentheses or quotes, are string constants (e.g. "GET /" or "out.pcapng"), since they can never be features. Names
+// are always features, and other strings are reported as invalid expressions; such constants need quotes (e.g.
+// "'majority'").
+func (d *decoder) argument(n *node, path string) interface{} {
+	if n.kind == stringNode {
+		value := n.value.(string)
+		if _, err := ParseExpression(value); err != nil && !strings.ContainsAny(value, "()'\"") {
+			return String(value)
+		}
+	}
+	return d.feature(n, path)
+}
+
+func (d *decoder) feature(n *node, path string) interface{} {
+	switch n.kind {
+	case stringNode:
+		return d.expression(n, path)
+	case boolNode:
+		return n.value
+	case numberNode:
+		num := n.value.(json.Number)
+		if i, err := num.Int64(); err == nil {
+			return i
+		}
+		if f, err := num.Float64(); err == nil {
+			return f
+		}
+		d.errorf(n, path, "can't decode number %s", num)
+	case nullNode:
+		d.errorf(n, path, "null is not a valid feature")
+	case arrayNode:
+		if len(n.elems) == 0 {
+			d.errorf(n, path, "call must not be empty")
+			return nil
+		}
+		if n.elems[0].kind != stringNode {
+			d.errorf(n.elems[0], indexPath(path, 0), "operation name must be a string (got %s)", n.elems[0].kind)
+			return nil
+		}
+		return d.call(n, n.elems[0].value.(string), n.elems[1:], func(i int) string { return indexPath(path, i+1) })
+	case objectNode:
+		if len(n.keys) != 1 {
+			d.errorf(n, path, "only one key allowed in calls (got %d)", len(n.keys))
+			return nil
+		}
+		name := n.keys[0].value.(string)
+		args := n.elems[0]
+		argsPath := joinPath(path, name)
+		if args.kind != arrayNode {
+			d.errorf(args, argsPath, "call arguments must be an array (got %s)", args.kind)
+			return nil
+		}
+		return d.call(n, name, args.elems, func(i int) string {
+			if i < 0 {
+				return argsPath
+			}
+			return indexPath(argsPath, i)
+		})
+	}
+	return nil
+}
+
+// stringOffset returns the offset in the file of the byte at offset in the decoded string n
+func (d *decoder) stringOffset(n *node, offset int) int {
+	raw := n.offset + 1
+	for decoded := 0; decoded < offset && raw < len(d.data); decoded++ {
+		if d.data[raw] != '\\' {
+			raw++
+			continue
+		}
+		if raw+1 < len(d.data) && d.data[raw+1] == 'u' {
+			r, err := strconv.ParseUint(string(d.data[raw+2:raw+6]), 16, 32)
+			if err == nil {
+				decoded += utf8.RuneLen(rune(r)) - 1
+			}
+			raw += 6
+		} else {
+			raw += 2
+		}
+	}
+	return raw
+}
+
+// expression parses a feature name or an infix expression
+func (d *decoder) expression(n *node, path string) interface{} {
+	expr := n.value.(string)
+	if isIdent(expr) {
+		return expr
+	}
+	ret, err := ParseExpression(expr)
+	if err != nil {
+		e := err.(*ExpressionError)
+		d.errors = append(d.errors, &Error{
+			Filename: d.filename,
+			Position: d.lines.position(d.stringOffset(n, e.Offset)),
+			Path:     path,
+			Message:  fmt.Sprintf("in expression %q: %s", expr, e.Message),
+		})
+		return nil
+	}
+	return ret
+}
+
+func (d *decoder) features(n *node, path string) []interface{} {
+	if n.kind != arrayNode {
+		d.errorf(n, path, "must be an array (got %s)", n.kind)
+		return nil
+	}
+	ret := make([]interface{}, len(n.elems))
+	for i, elem := range n.elems {
+		ret[i] = d.feature(elem, indexPath(path, i))
+	}
+	return ret
+}
+
+func (d *decoder) definitions(n *node, path string) map[string]interface{} {
+	ret := make(map[string]interface{})
+	d.object(n, path, func(key string, keyNode, value *node, path string) {
+		if !isIdent(key) {
+			d.errorf(keyNode, path, "definition name %s must be an identifier", key)
+			return
+		}
+		ret[key] = d.feature(value, path)
+	})
+	return ret
+}
+
+// include parses the included file name
+func (d *decoder) include(n *node, path, name string) *Flow {
+	if !filepath.IsAbs(name) {
+		name = filepath.Join(filepath.Dir(d.filename), name)
+	}
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		d.errorf(n, path, "%s", err)
+		return nil
+	}
+	if d.including[abs] {
+		d.errorf(n, path, "include cycle: %s includes itself", name)
+		return nil
+	}
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		d.errorf(n, path, "%s", err)
+		return nil
+	}
+	sub := &decoder{
+		filename:  name,
+		data:      data,
+		lines:     newLineIndex(data),
+		including: d.including,
+	}
+	d.including[abs] = true
+	defer delete(d.including, abs)
+	root, err := parseJSON(data)
+	if err != nil {
+		e := err.(*syntaxError)
+		d.errors = append(d.errors, &Error{Filename: name, Position: sub.lines.position(e.offset), Message: e.msg})
+		return nil
+	}
+	ret := sub.flow(root, "", true)
+	d.errors = append(d.errors, sub.errors...)
+	d.warnings = append(d.warnings, sub.warnings...)
+	return &ret
+}
+
+// flow decodes a flow specification. Included files only contain features and definitions.
+func (d *decoder) flow(n *node, path string, included bool) (ret Flow) {
+	present := make(map[string]bool)
+	var lists = make(map[string]*node)
+	var definitions *node
+	ok := d.object(n, path, func(key string, keyNode, value *node, path string) {
+		present[key] = true
+		if included && !includeKeys[key] && flowKeys[key] {
+			d.errorf(keyNode, path, "%s is not allowed in included files", key)
+			return
+		}
+		switch key {
+		case "active_timeout":
+			ret.ActiveTimeout = d.timeout(value, path)
+		case "idle_timeout":
+			ret.IdleTimeout = d.timeout(value, path)
+		case "bidirectional":
+			ret.Bidirectional = d.boolean(value, path)
+		case "features":
+			ret.Features = d.features(value, path)
+			lists[key] = value
+		case "key_features":
+			ret.KeyFeatures = d.stringArray(value, path)
+		case "_control_features":
+			ret.ControlFeatures = d.stringArray(value, path)
+			lists[key] = value
+		case "_filter_features":
+			ret.FilterFeatures = d.stringArray(value, path)
+			lists[key] = value
+		case "_per_packet":
+			ret.PerPacket = d.boolean(value, path)
+		case "_allow_zero":
+			ret.AllowZero = d.boolean(value, path)
+		case "_expire_TCP":
+			expire := d.boolean(value, path)
+			ret.ExpireTCP = &expire
+		case "_tcp_linger":
+			ret.TCPLinger = d.timeout(value, path)
+		case "definitions":
+			ret.Definitions = d.definitions(value, path)
+			definitions = value
+		case "include":
+			ret.Include = d.stringArray(value, path)
+			if value.kind != arrayNode || len(ret.Include) != len(value.elems) {
+				return
+			}
+			for i, name := range ret.Include {
+				ret.Included = append(ret.Included, d.include(value.elems[i], indexPath(path, i), name))
+			}
+		default:
+			if ret.Extra == nil {
+				ret.Extra = make(map[string]interface{})
+			}
+			ret.Extra[key] = value.generic()
+			if s := suggest(key, flowKeyList); s != "" {
+				d.warnf(keyNode, path, "unknown key %s (did you mean %s?)", key, s)
+			} else {
+				d.warnf(keyNode, path, "unknown key %s", key)
+			}
+		}
+	})
+	if !ok || included {
+		return
+	}
+	for _, key := range requiredKeys {
+		if !present[key] {
+			d.errorf(n, path, "key %s is required in the flow description, but missing", key)
+		}
+	}
+	if len(d.errors) == 0 {
+		d.checkReferences(&ret, path, lists, definitions)
+	}
+	return
+}
+
+// checkReferences reports invalid references to definitions with the position of the referencing feature
+func (d *decoder) checkReferences(flow *Flow, path string, lists map[string]*node, definitions *node) {
+	e := newExpander(flow)
+	for i, feature := range flow.Features {
+		if _, err := e.expand(feature, true, nil); err != nil {
+			d.errorf(lists["features"].elems[i], indexPath(joinPath(path, "features"), i), "%s", err)
+		}
+	}
+	for _, key := range []string{"_control_features", "_filter_features"} {
+		names := flow.ControlFeatures
+		if key == "_filter_features" {
+			names = flow.FilterFeatures
+		}
+		for i, name := range names {
+			if _, err := e.name(name, nil); err != nil {
+				d.errorf(lists[key].elems[i], indexPath(joinPath(path, key), i), "%s", err)
+			}
+		}
+	}
+	if definitions == nil {
+		return
+	}
+	for i, keyNode := range definitions.keys {
+		name := keyNode.value.(string)
+		if _, err := e.expand(name, true, nil); err != nil {
+			d.errorf(definitions.elems[i], joinPath(joinPath(path, "definitions"), name), "%s", err)
+		}
+	}
+}
+
+func (d *decoder) v2(n *node) (ret File) {
+	d.object(n, "", func(key string, keyNode, value *node, path string) {
+		switch key {
+		case "version":
+			if value.kind != stringNode {
+				d.errorf(value, path, "must be a string (got %s)", value.kind)
+				return
+			}
+			ret.Version = value.value.(string)
+			if !strings.HasPrefix(ret.Version, "v2") {
+				d.errorf(value, path, "unknown file format version '%s'", ret.Version)
+			}
+		case "preprocessing":
+			d.object(value, path, func(key string, keyNode, value *node, path string) {
+				if key != "flows" {
+					if ret.PreprocessingExtra == nil {
+						ret.PreprocessingExtra = make(map[string]interface{})
+					}
+					ret.PreprocessingExtra[key] = value.generic()
+					return
+				}
+				if value.kind != arrayNode {
+					d.errorf(value, path, "must be an array (got %s)", value.kind)
+					return
+				}
+				ret.Flows = make([]Flow, len(value.elems))
+				for i, elem := range value.elems {
+					ret.Flows[i] = d.flow(elem, indexPath(path, i), false)
+				}
+			})
+		default:
+			if ret.Extra == nil {
+				ret.Extra = make(map[string]interface{})
+			}
+			ret.Extra[key] = value.generic()
+		}
+	})
+	if ret.Flows == nil && len(d.errors) == 0 {
+		d.errorf(n, "", "no flows in preprocessing.flows")
+	}
+	return
+}
+
+func hasVersion(n *node) bool {
+	if n.kind != objectNode {
+		return false
+	}
+	for _, key := range n.keys {
+		if key.value.(string) == "version" {
+			return true
+		}
+	}
+	return false
+}
+
+// Parse parses a specification in the given format. filename is used for error messages and as base for included
+// files. If errors are found,
+// the returned error is of type ErrorList and contains all of them. The returned warnings contain problems, which
+// are not fatal, like unknown keys.
+func Parse(filename string, data []byte, format Format) (file *File, warnings []*Error, err error) {
+	d := &decoder{
+		filename:  filename,
+		data:      data,
+		lines:     newLineIndex(data),
+		including: make(map[string]bool),
+	}
+	if filename != "" {
+		if abs, err := filepath.Abs(filename); err == nil {
+			d.including[abs] = true
+		}
+	}
+	root, err := parseJSON(data)
+	if err != nil {
+		e := err.(*syntaxError)
+		return nil, nil, ErrorList{&Error{Filename: filename, Position: d.lines.position(e.offset), Message: e.msg}}
+	}
+
+	switch format {
+	case FormatAuto:
+		if hasVersion(root) {
+			format = FormatV2
+		} else {
+			format = FormatSimple
+		}
+	case FormatV2, FormatSimple:
+	default:
+		return nil, nil, fmt.Errorf("unknown format %d", format)
+	}
+
+	file = &File{}
+	if format == FormatV2 {
+		*file = d.v2(root)
+	} else {
+		file.Flows = []Flow{d.flow(root, "", false)}
+	}
+	if err := d.errors.Err(); err != nil {
+		return nil, d.warnings, err
+	}
+	return file, d.warnings, nil
+}
+
+// Decode reads a specification in the given format from r (see Parse)
+func Decode(filename string, r io.Reader, format Format) (*File, []*Error, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	return Parse(filename, data, format)
+}
+
+// Load reads a specification from the file with the given name (see Parse)
+func Load(filename string, format Format) (*File, []*Error, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	return Parse(filename, data, format)
+}
@@ -0,0 +1,164 @@
+package spec
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const validFlow = `{
+	"active_timeout": 1800,
+	"idle_timeout": 300.5,
+	"bidirectional": true,
+	"features": ["sourceIPAddress", {"mean": ["octetTotalCount"]}, ["add", 1, 2.5], true],
+	"key_features": ["sourceIPAddress"],
+	"_expire_TCP": false,
+	"_tcp_linger": 10,
+	"_custom": {"a": [1, "b"]}
+}`
+
+func TestParseSimple(t *testing.T) {
+	file, warnings, err := Parse("", []byte(validFlow), FormatAuto)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(warnings) != 1 || warnings[0].Error() != "9:2: _custom: unknown key _custom" {
+		t.Errorf("Unexpected warnings %v", warnings)
+	}
+	expire := false
+	expected := Flow{
+		ActiveTimeout: 1800,
+		IdleTimeout:   300.5,
+		Bidirectional: true,
+		Features: []interface{}{
+			"sourceIPAddress",
+			[]interface{}{"mean", "octetTotalCount"},
+			[]interface{}{"add", int64(1), 2.5},
+			true,
+		},
+		KeyFeatures: []string{"sourceIPAddress"},
+		ExpireTCP:   &expire,
+		TCPLinger:   10,
+		Extra:       map[string]interface{}{"_custom": map[string]interface{}{"a": []interface{}{json.Number("1"), "b"}}},
+	}
+	if len(file.Flows) != 1 || !reflect.DeepEqual(file.Flows[0], expected) {
+		t.Errorf("Expected %#v, but got %#v", expected, file.Flows)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	flow := func(features string) string {
+		return `{"active_timeout": 1, "idle_timeout": 1, "bidirectional": false, "key_features": [],
+"features": ` + features + `}`
+	}
+	tests := []struct {
+		name   string
+		input  string
+		format Format
+		errors []string
+	}{
+		{"syntax", "{\n\t\"active_timeout\": 1,\n\t\"idle_timeout\" 2\n}", FormatAuto, []string{"3:17: expected ':', but found character '2'"}},
+		{"trailing comma", `{"features": [1,]}`, FormatAuto, []string{"1:17: unexpected character ']'"}},
+		{"unterminated", `{"features": "abc`, FormatAuto, []string{"1:14: unterminated string"}},
+		{"trailing data", `{} {}`, FormatAuto, []string{"1:4: unexpected character '{' after top-level value"}},
+		{"empty", ``, FormatAuto, []string{"1:1: unexpected end of input"}},
+		{"not an object", `[]`, FormatSimple, []string{"1:1: must be an object (got array)"}},
+		{"missing keys", `{"features": []}`, FormatAuto, []string{
+			"1:1: key active_timeout is required in the flow description, but missing",
+			"1:1: key idle_timeout is required in the flow description, but missing",
+			"1:1: key bidirectional is required in the flow description, but missing",
+			"1:1: key key_features is required in the flow description, but missing",
+		}},
+		{"two keys in call", flow(`["a", {"mean": ["b"], "max": ["b"]}]`), FormatAuto, []string{"2:19: features[1]: only one key allowed in calls (got 2)"}},
+		{"call arguments", flow(`[{"mean": "b"}]`), FormatAuto, []string{"2:23: features[0].mean: call arguments must be an array (got string)"}},
+		{"nested", flow(`[{"mean": [{"apply": ["x", null]}]}]`), FormatAuto, []string{"2:40: features[0].mean[0].apply[1]: null is not a valid feature"}},
+		{"empty call", flow(`[[]]`), FormatAuto, []string{"2:14: features[0]: call must not be empty"}},
+		{"call name", flow(`[[1, 2]]`), FormatAuto, []string{"2:15: features[0][0]: operation name must be a string (got number)"}},
+		{"types", `{"active_timeout": "1", "idle_timeout": -1, "bidirectional": 1, "key_features": ["a", 2], "features": {}, "_allow_zero": null}`, FormatAuto, []string{
+			`1:20: active_timeout: must be a number (got string)`,
+			`1:41: idle_timeout: must not be negative`,
+			`1:62: bidirectional: must be a boolean (got number)`,
+			`1:87: key_features[1]: must be a string (got number)`,
+			`1:103: features: must be an array (got object)`,
+			`1:122: _allow_zero: must be a boolean (got null)`,
+		}},
+		{"duplicate key", flow(`[], "features": []`), FormatAuto, []string{"2:17: duplicate key features"}},
+		{"version", `{"version": "v3"}`, FormatAuto, []string{"1:13: version: unknown file format version 'v3'"}},
+		{"no flows", `{"version": "v2", "preprocessing": {}}`, FormatAuto, []string{"1:1: no flows in preprocessing.flows"}},
+		{"v2 flow", `{"version": "v2", "preprocessing": {"flows": [{"active_timeout": 1, "idle_timeout": 1, "bidirectional": false, "key_features": [], "features": [{}]}]}}`, FormatAuto, []string{
+			"1:145: preprocessing.flows[0].features[0]: only one key allowed in calls (got 0)",
+		}},
+	}
+	for _, test := range tests {
+		_, _, err := Parse("", []byte(test.input), test.format)
+		list, ok := err.(ErrorList)
+		if !ok {
+			t.Errorf("%s: expected ErrorList, but got %v", test.name, err)
+			continue
+		}
+		var got []string
+		for _, e := range list {
+			got = append(got, e.Error())
+		}
+		if !reflect.DeepEqual(got, test.errors) {
+			t.Errorf("%s: expected errors\n%s\nbut got\n%s", test.name, strings.Join(test.errors, "\n"), strings.Join(got, "\n"))
+		}
+	}
+}
+
+func TestUnknownKeyWarning(t *testing.T) {
+	_, warnings, err := Parse("spec.json", []byte(`{"active_timeout": 1, "idle_timeout": 1, "bidirectional": false, "key_features": [], "features": [],
+	"_expire_tcp": false, "_filter_feature": [], "something_else": 1}`), FormatAuto)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, w := range warnings {
+		got = append(got, w.Error())
+	}
+	expected := []string{
+		"spec.json:2:2: _expire_tcp: unknown key _expire_tcp (did you mean _expire_TCP?)",
+		"spec.json:2:24: _filter_feature: unknown key _filter_feature (did you mean _filter_features?)",
+		"spec.json:2:47: something_else: unknown key something_else",
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected warnings\n%s\nbut got\n%s", strings.Join(expected, "\n"), strings.Join(got, "\n"))
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	files, err := filepath.Glob("../examples/*.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	files = append(files, "")
+	for _, name := range files {
+		var data []byte
+		if name == "" {
+			data = []byte(validFlow)
+		} else if data, err = ioutil.ReadFile(name); err != nil {
+			t.Fatal(err)
+		}
+		file, _, err := Parse(name, data, FormatAuto)
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			continue
+		}
+		encoded, err := file.Marshal()
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			continue
+		}
+		decoded, _, err := Parse(name, encoded, FormatAuto)
+		if err != nil {
+			t.Errorf("%s: %s\n%s", name, err, encoded)
+			continue
+		}
+		if !reflect.DeepEqual(file, decoded) {
+			t.Errorf("%s: round trip mismatch:\n%#v\n%#v", name, file, decoded)
+		}
+	}
+}
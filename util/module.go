@@ -23,6 +23,7 @@ type ModuleHelp func(string)
 // ModuleDescription contains name and description of a module
 type ModuleDescription struct {
 	name, desc string
+	provider   string
 }
 
 // Name returns the name of this module
@@ -35,6 +36,11 @@ func (m ModuleDescription) Description() string {
 	return m.desc
 }
 
+// Provider returns the plugin that provided this module, or an empty string for builtin modules
+func (m ModuleDescription) Provider() string {
+	return m.provider
+}
+
 type moduleDefinition struct {
 	ModuleDescription
 	new  ModuleCreator
@@ -46,19 +52,23 @@ var modules = make(map[string]map[string]moduleDefinition)
 // RegisterModule registers a module with given type, name, description, module creator, and help function.
 // Existing modules are overwritten.
 func RegisterModule(typ, name, desc string, new ModuleCreator, help ModuleHelp) {
-	submodule, found := modules[typ]
-	if !found {
-		modules[typ] = make(map[string]moduleDefinition)
-		submodule = modules[typ]
-	}
-	submodule[name] = moduleDefinition{
+	module := moduleDefinition{
 		ModuleDescription: ModuleDescription{
-			name: name,
-			desc: desc,
+			name:     name,
+			desc:     desc,
+			provider: provider,
 		},
 		new:  new,
 		help: help,
 	}
+	Register(func() {
+		submodule, found := modules[typ]
+		if !found {
+			modules[typ] = make(map[string]moduleDefinition)
+			submodule = modules[typ]
+		}
+		submodule[name] = module
+	})
 }
 
 // GetModuleHelp calls the help function of the module identified by typ, name
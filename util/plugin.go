@@ -0,0 +1,174 @@
+package util
+
+import (
+	"debug/buildinfo"
+	"fmt"
+	"path/filepath"
+	"plugin"
+	"runtime/debug"
+	"sort"
+	"strings"
+)
+
+// PluginAPIVersion is the version of the plugin interface (registration functions, module interfaces). It must be
+// increased on every change that breaks plugins built for an older version.
+const PluginAPIVersion = 1
+
+// PluginManifest describes a plugin. Plugins must provide it as exported variable GoFlowsManifest in package main
+// (go-flows-build generates this variable).
+type PluginManifest struct {
+	// APIVersion is the PluginAPIVersion the plugin was built for
+	APIVersion int
+	// Modules lists the modules (import paths or names) contained in the plugin
+	Modules []string
+	// Build describes how and when the plugin was built
+	Build string
+}
+
+// PluginError is returned by LoadPlugin for incompatible plugins and contains every problem that was found
+type PluginError struct {
+	// Plugin is the file name of the plugin
+	Plugin string
+	// Problems lists the incompatibilities
+	Problems []string
+}
+
+func (e *PluginError) Error() string {
+	return fmt.Sprintf("plugin %s is incompatible:\n\t%s", e.Plugin, strings.Join(e.Problems, "\n\t"))
+}
+
+// provider is the plugin which is currently loaded (empty for builtin modules)
+var provider string
+
+// pending holds the registrations of the plugin which is currently loaded
+var pending []func()
+
+// Provider returns the name of the plugin which is currently being loaded, or an empty string for builtin modules.
+// Registration functions use this to record where modules and features come from.
+func Provider() string {
+	return provider
+}
+
+// Register applies a registration (e.g. adding a feature to a registry). Registrations of a plugin are postponed until
+// the plugin is known to be compatible, and are dropped for incompatible plugins.
+func Register(apply func()) {
+	if provider == "" {
+		apply()
+		return
+	}
+	pending = append(pending, apply)
+}
+
+// DescribeProvider appends the providing plugin to a description. Builtin modules (empty provider) are left alone.
+func DescribeProvider(description, provider string) string {
+	if provider == "" {
+		return description
+	}
+	if description == "" {
+		return fmt.Sprintf("(plugin %s)", provider)
+	}
+	return fmt.Sprintf("%s (plugin %s)", description, provider)
+}
+
+// LoadPlugin checks if the plugin in the given file is compatible with this program and loads it. The returned
+// manifest is nil for plugins without manifest. Every module and feature registered by the plugin is marked with the
+// file name of the plugin (see Provider).
+//
+// The Go version and module versions the plugin was built with are checked before loading, since loading an
+// incompatible plugin fails with hard to understand errors, or not at all. The modules and features of the plugin are
+// only registered after the manifest was checked (see Register); nothing is registered if an error is returned.
+func LoadPlugin(path string) (*PluginManifest, error) {
+	name := filepath.Base(path)
+	if host, ok := debug.ReadBuildInfo(); ok {
+		info, err := buildinfo.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't read build information of plugin %s: %s", name, err)
+		}
+		if problems := BuildInfoMismatches(host, info); len(problems) != 0 {
+			return nil, &PluginError{name, problems}
+		}
+	}
+
+	provider, pending = name, nil
+	p, err := plugin.Open(path)
+	registrations := pending
+	provider, pending = "", nil
+	if err != nil {
+		return nil, &PluginError{name, []string{err.Error()}}
+	}
+
+	manifest, err := pluginManifest(p)
+	if err != nil {
+		return nil, &PluginError{name, []string{err.Error()}}
+	}
+	for _, apply := range registrations {
+		apply()
+	}
+	return manifest, nil
+}
+
+// pluginManifest returns the checked manifest of a plugin, or nil if the plugin has no manifest
+func pluginManifest(p *plugin.Plugin) (*PluginManifest, error) {
+	symbol, err := p.Lookup("GoFlowsManifest")
+	if err != nil {
+		return nil, nil
+	}
+	manifest, ok := symbol.(*PluginManifest)
+	if !ok {
+		return nil, fmt.Errorf("GoFlowsManifest has wrong type %T", symbol)
+	}
+	if manifest.APIVersion != PluginAPIVersion {
+		return nil, fmt.Errorf("plugin API version is %d, but go-flows needs %d", manifest.APIVersion, PluginAPIVersion)
+	}
+	return manifest, nil
+}
+
+// BuildInfoMismatches returns the differences between the build information of the program and a plugin, which
+// prevent loading the plugin: Go version, versions of shared modules, and the source revision of the main module.
+func BuildInfoMismatches(host, plugin *debug.BuildInfo) (problems []string) {
+	if host.GoVersion != plugin.GoVersion {
+		problems = append(problems, fmt.Sprintf("plugin was built with %s, but go-flows with %s", plugin.GoVersion, host.GoVersion))
+	}
+
+	var modules []string
+	hostModules := buildModules(host)
+	for path, version := range buildModules(plugin) {
+		if hostVersion, ok := hostModules[path]; ok && hostVersion != version {
+			modules = append(modules, fmt.Sprintf("plugin uses %s %s, but go-flows uses %s", path, version, hostVersion))
+		}
+	}
+	sort.Strings(modules)
+	problems = append(problems, modules...)
+
+	if host.Main.Path == plugin.Main.Path {
+		hostRevision, revision := vcsRevision(host), vcsRevision(plugin)
+		if hostRevision != "" && revision != "" && hostRevision != revision {
+			problems = append(problems, fmt.Sprintf("plugin was built from revision %s, but go-flows from %s", revision, hostRevision))
+		}
+	}
+	return
+}
+
+// buildModules returns the versions of the modules in the build information (after replacements)
+func buildModules(info *debug.BuildInfo) map[string]string {
+	ret := make(map[string]string, len(info.Deps)+1)
+	for _, module := range append([]*debug.Module{&info.Main}, info.Deps...) {
+		if module.Replace != nil {
+			module = module.Replace
+		}
+		if module.Path != "" && module.Version != "" && module.Version != "(devel)" {
+			ret[module.Path] = module.Version
+		}
+	}
+	return ret
+}
+
+// vcsRevision returns the version control revision of the main module
+func vcsRevision(info *debug.BuildInfo) string {
+	for _, setting := range info.Settings {
+		if setting.Key == "vcs.revision" {
+			return setting.Value
+		}
+	}
+	return ""
+}
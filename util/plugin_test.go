@@ -0,0 +1,118 @@
+package util_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"runtime/debug"
+	"strings"
+	"testing"
+
+	"github.com/chtisgit/go-flows/flows"
+	"github.com/chtisgit/go-flows/util"
+)
+
+func TestLoadPlugin(t *testing.T) {
+	if testing.Short() {
+		t.Skip("building a plugin takes too long in short mode")
+	}
+	if testing.CoverMode() != "" {
+		t.Skip("plugins can't be loaded into binaries built with coverage")
+	}
+	if out, err := exec.Command("go", "env", "CGO_ENABLED").Output(); err != nil || strings.TrimSpace(string(out)) != "1" {
+		t.Skip("plugins need cgo")
+	}
+	dir, err := ioutil.TempDir("", "go-flows-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "go-flows.test.defs.so")
+	if out, err := exec.Command("go", "build", "-buildmode=plugin", "-o", path, "./testdata/plugin").CombinedOutput(); err != nil {
+		t.Fatalf("couldn't build plugin: %s\n%s", err, out)
+	}
+	badPath := filepath.Join(dir, "go-flows.bad.defs.so")
+	if out, err := exec.Command("go", "build", "-buildmode=plugin", "-o", badPath, "./testdata/badplugin").CombinedOutput(); err != nil {
+		t.Fatalf("couldn't build plugin: %s\n%s", err, out)
+	}
+
+	if _, err := util.LoadPlugin(badPath); err == nil {
+		t.Error("loading a plugin with the wrong API version should fail")
+	}
+
+	manifest, err := util.LoadPlugin(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest == nil || !reflect.DeepEqual(manifest.Modules, []string{"util/testdata/plugin"}) || manifest.Build != "test" {
+		t.Errorf("wrong manifest %+v", manifest)
+	}
+	if util.Provider() != "" {
+		t.Errorf("provider should be reset after loading, but is %s", util.Provider())
+	}
+
+	var features bytes.Buffer
+	flows.ListFeatures(&features)
+	if !strings.Contains(features.String(), "feature of the test plugin (plugin go-flows.test.defs.so)") {
+		t.Errorf("feature list doesn't contain plugin feature:\n%s", features.String())
+	}
+	if strings.Contains(features.String(), "__testBadPluginFeature") {
+		t.Errorf("feature list contains feature of the incompatible plugin:\n%s", features.String())
+	}
+	exporters, err := flows.ListExporters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, exporter := range exporters {
+		if exporter.Name() == "testBadPluginExporter" {
+			t.Error("exporter of the incompatible plugin was registered")
+		}
+	}
+	for _, exporter := range exporters {
+		if exporter.Name() == "testPluginExporter" {
+			if exporter.Provider() != "go-flows.test.defs.so" {
+				t.Errorf("exporter should be provided by go-flows.test.defs.so, but is provided by '%s'", exporter.Provider())
+			}
+			return
+		}
+	}
+	t.Error("plugin exporter is missing")
+}
+
+func TestBuildInfoMismatches(t *testing.T) {
+	host := &debug.BuildInfo{
+		GoVersion: "go1.12.7",
+		Main:      debug.Module{Path: "github.com/chtisgit/go-flows", Version: "(devel)"},
+		Deps: []*debug.Module{
+			{Path: "github.com/google/gopacket", Version: "v1.1.17"},
+			{Path: "github.com/CN-TU/go-ipfix", Version: "v0.0.0-1", Replace: &debug.Module{Path: "github.com/example/go-ipfix", Version: "v0.0.0-2"}},
+		},
+		Settings: []debug.BuildSetting{{Key: "vcs.revision", Value: "abc"}},
+	}
+	if problems := util.BuildInfoMismatches(host, host); len(problems) != 0 {
+		t.Errorf("identical build information mismatches: %v", problems)
+	}
+
+	plugin := &debug.BuildInfo{
+		GoVersion: "go1.13",
+		Main:      debug.Module{Path: "github.com/chtisgit/go-flows", Version: "(devel)"},
+		Deps: []*debug.Module{
+			{Path: "github.com/google/gopacket", Version: "v1.1.16"},
+			{Path: "github.com/CN-TU/go-ipfix", Version: "v0.0.0-1"},
+			{Path: "github.com/example/go-ipfix", Version: "v0.0.0-2"},
+			{Path: "go.starlark.net", Version: "v0.0.0-3"},
+		},
+		Settings: []debug.BuildSetting{{Key: "vcs.revision", Value: "def"}},
+	}
+	expected := []string{
+		"plugin was built with go1.13, but go-flows with go1.12.7",
+		"plugin uses github.com/google/gopacket v1.1.16, but go-flows uses v1.1.17",
+		"plugin was built from revision def, but go-flows from abc",
+	}
+	if problems := util.BuildInfoMismatches(host, plugin); !reflect.DeepEqual(problems, expected) {
+		t.Errorf("expected mismatches %q, but got %q", expected, problems)
+	}
+}
@@ -0,0 +1,30 @@
+// Test plugin for util.LoadPlugin with an incompatible manifest. None of its registrations must take effect.
+package main
+
+import (
+	"errors"
+
+	ipfix "github.com/CN-TU/go-ipfix"
+	"github.com/chtisgit/go-flows/flows"
+	"github.com/chtisgit/go-flows/util"
+)
+
+type testFeature struct {
+	flows.BaseFeature
+}
+
+func init() {
+	flows.RegisterTemporaryFeature("__testBadPluginFeature", "feature of the incompatible test plugin", ipfix.Unsigned8Type, 0, flows.FlowFeature, func() flows.Feature { return &testFeature{} }, flows.RawPacket)
+	flows.RegisterExporter("testBadPluginExporter", "exporter of the incompatible test plugin", func([]string) ([]string, util.Module, error) {
+		return nil, nil, errors.New("not implemented")
+	}, func(string) {})
+}
+
+// GoFlowsManifest describes this plugin
+var GoFlowsManifest = util.PluginManifest{
+	APIVersion: util.PluginAPIVersion + 1,
+	Modules:    []string{"util/testdata/badplugin"},
+	Build:      "test",
+}
+
+func main() {}
@@ -0,0 +1,30 @@
+// Test plugin for util.LoadPlugin. The manifest is written by hand here; go-flows-build generates it.
+package main
+
+import (
+	"errors"
+
+	ipfix "github.com/CN-TU/go-ipfix"
+	"github.com/chtisgit/go-flows/flows"
+	"github.com/chtisgit/go-flows/util"
+)
+
+type testFeature struct {
+	flows.BaseFeature
+}
+
+func init() {
+	flows.RegisterTemporaryFeature("__testPluginFeature", "feature of the test plugin", ipfix.Unsigned8Type, 0, flows.FlowFeature, func() flows.Feature { return &testFeature{} }, flows.RawPacket)
+	flows.RegisterExporter("testPluginExporter", "exporter of the test plugin", func([]string) ([]string, util.Module, error) {
+		return nil, nil, errors.New("not implemented")
+	}, func(string) {})
+}
+
+// GoFlowsManifest describes this plugin
+var GoFlowsManifest = util.PluginManifest{
+	APIVersion: util.PluginAPIVersion,
+	Modules:    []string{"util/testdata/plugin"},
+	Build:      "test",
+}
+
+func main() {}